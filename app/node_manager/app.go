@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dfuse-io/dmetrics"
@@ -29,13 +30,38 @@ import (
 )
 
 type Config struct {
-	ManagerAPIAddress string
-	StartupDelay      time.Duration
+	ManagerAPIAddress string        `yaml:"manager_api_address"`
+	StartupDelay      time.Duration `yaml:"startup_delay"`
+
+	ReadinessPath string `yaml:"readiness_path"` // readiness check path, served in addition to `/healthz`, defaults to `/healthz`
+}
+
+// LoadConfig reads the YAML or JSON config file at `path` and validates it,
+// see `nodeManager.LoadConfigFile`.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if err := nodeManager.LoadConfigFile(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports all the problems found in the config at once
+func (c *Config) Validate() error {
+	v := &nodeManager.ConfigValidator{}
+	v.Addr("manager_api_address", c.ManagerAPIAddress, true)
+	v.NonNegative("startup_delay", c.StartupDelay)
+	v.Check(c.ReadinessPath == "" || strings.HasPrefix(c.ReadinessPath, "/"), "readiness_path %q must start with `/`", c.ReadinessPath)
+	return v.Err()
 }
 
 type Modules struct {
 	Operator                   *operator.Operator
 	MetricsAndReadinessManager *nodeManager.MetricsAndReadinessManager
+	ReadinessFunc              func() (ready bool, reason string) // optional, chain-specific readiness required in addition to the built-in checks, its reason is served on `/healthz` when not ready
 }
 
 type App struct {
@@ -74,9 +100,14 @@ func (a *App) Run() error {
 		time.Sleep(a.config.StartupDelay)
 	}
 
+	a.modules.Operator.SetDescribeConfig(a.config)
+	if a.modules.ReadinessFunc != nil {
+		a.modules.Operator.SetReadinessFunc(a.modules.ReadinessFunc)
+	}
+
 	a.zlogger.Info("launching operator")
 	go a.modules.MetricsAndReadinessManager.Launch()
-	go a.Shutdown(a.modules.Operator.Launch(a.config.ManagerAPIAddress))
+	go a.Shutdown(a.modules.Operator.Launch(a.config.ManagerAPIAddress, a.modules.Operator.ReadinessPathOption(a.config.ReadinessPath)))
 
 	return nil
 }
@@ -85,7 +116,12 @@ func (a *App) IsReady() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
 
-	url := fmt.Sprintf("http://%s/healthz", a.config.ManagerAPIAddress)
+	readinessPath := a.config.ReadinessPath
+	if readinessPath == "" {
+		readinessPath = operator.DefaultReadinessPath
+	}
+
+	url := fmt.Sprintf("http://%s%s", a.config.ManagerAPIAddress, readinessPath)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		a.zlogger.Warn("unable to build get health request", zap.Error(err))
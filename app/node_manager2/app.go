@@ -16,13 +16,20 @@ package nodemanager
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dfuse-io/dgrpc"
-	"github.com/dfuse-io/dmetrics"
 	nodeManager "github.com/dfuse-io/node-manager"
 	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/dfuse-io/node-manager/mindreader"
@@ -34,26 +41,175 @@ import (
 )
 
 type Config struct {
-	GRPCAddr string
-	HTTPAddr string
+	GRPCAddr string `yaml:"grpc_addr"`
+	HTTPAddr string `yaml:"http_addr"`
+
+	GRPCServiceRegistrationOptional bool `yaml:"grpc_service_registration_optional"` // If true, a failing `Modules.RegisterGRPCService` is logged and mindreader starts without that service instead of failing the app
+
+	ReadinessPath string `yaml:"readiness_path"` // readiness check path, served in addition to `/healthz`, defaults to `/healthz`
 
 	// Backup Flags
-	AutoBackupModulo        int
-	AutoBackupPeriod        time.Duration
-	AutoBackupHostnameMatch string // If non-empty, will only apply autobackup if we have that hostname
+	AutoBackupModulo        int           `yaml:"auto_backup_modulo"`
+	AutoBackupPeriod        time.Duration `yaml:"auto_backup_period"`
+	AutoBackupHostnameMatch string        `yaml:"auto_backup_hostname_match"` // If non-empty, will only apply autobackup if we have that hostname
 
 	// Snapshot Flags
-	AutoSnapshotModulo        int
-	AutoSnapshotPeriod        time.Duration
-	AutoSnapshotHostnameMatch string // If non-empty, will only apply autosnapshot if we have that hostname
+	AutoSnapshotModulo        int           `yaml:"auto_snapshot_modulo"`
+	AutoSnapshotPeriod        time.Duration `yaml:"auto_snapshot_period"`
+	AutoSnapshotHostnameMatch string        `yaml:"auto_snapshot_hostname_match"` // If non-empty, will only apply autosnapshot if we have that hostname
+
+	SnapshotAtBlockTimeBoundary time.Duration `yaml:"snapshot_at_block_time_boundary"` // If non-zero, takes a snapshot at the first block whose timestamp crosses a multiple of it, like each UTC midnight for `24h`
 
 	// Volume Snapshot Flags
-	AutoVolumeSnapshotModulo         int
-	AutoVolumeSnapshotPeriod         time.Duration
-	AutoVolumeSnapshotSpecificBlocks []uint64
+	AutoVolumeSnapshotModulo         int           `yaml:"auto_volume_snapshot_modulo"`
+	AutoVolumeSnapshotPeriod         time.Duration `yaml:"auto_volume_snapshot_period"`
+	AutoVolumeSnapshotSpecificBlocks []uint64      `yaml:"auto_volume_snapshot_specific_blocks"`
+
+	// Cron Schedules, each taking precedence over the corresponding period
+	BackupCron         string `yaml:"backup_cron"`          // If non-empty, takes a backup at each time matching this five fields cron expression, like `0 3 * * *`
+	SnapshotCron       string `yaml:"snapshot_cron"`        // If non-empty, takes a snapshot at each time matching this five fields cron expression
+	VolumeSnapshotCron string `yaml:"volume_snapshot_cron"` // If non-empty, takes a volume snapshot at each time matching this five fields cron expression
+	ScheduleTimezone   string `yaml:"schedule_timezone"`    // IANA time zone (like `America/New_York`) the cron expressions are evaluated in, UTC when empty
+
+	StartupDelay       time.Duration `yaml:"startup_delay"`
+	ConnectionWatchdog bool          `yaml:"connection_watchdog"`
+
+	UpstreamDisconnectGrace time.Duration `yaml:"upstream_disconnect_grace"` // If non-zero, not ready once the connection watchdog reports the upstream node disconnected for longer than that
+	WatchdogLogInterval     time.Duration `yaml:"watchdog_log_interval"`     // If non-zero, the connection watchdog failures repeating the previous one are summarized at most once per interval instead of each being logged
+
+	MaxClockSkew       time.Duration `yaml:"max_clock_skew"`        // If non-zero, the host clock skew is checked every minute, beyond that the head block drift is not trusted for readiness and the node is reported degraded
+	ClockSkewNTPServer string        `yaml:"clock_skew_ntp_server"` // NTP server (`host:port`) the host clock is checked against, defaults to `pool.ntp.org:123`, unused with `Modules.ClockSkewFunc`
+
+	MaxBlocksBehind  uint64 `yaml:"max_blocks_behind"`  // If non-zero, the head block is compared to a reference head every 10s, more blocks behind than that and the node is reported degraded
+	ReferenceHeadURL string `yaml:"reference_head_url"` // Chain API URL of the nodeos instance (upstream node or peer) whose head is the reference for `MaxBlocksBehind`, unused with `Modules.ReferenceHeadFunc`
+
+	MaxNoProgressDuration time.Duration `yaml:"max_no_progress_duration"` // If non-zero, the node is reported degraded when its head block did not advance for that long while it is running
+	RestartOnNoProgress   bool          `yaml:"restart_on_no_progress"`   // If true, the node is also restarted when its head block did not advance for `MaxNoProgressDuration`
+
+	BackupSigningKey     string `yaml:"backup_signing_key"`     // Hex-encoded Ed25519 private key (seed or full key) backup manifests are signed with, requires the operator's backup manifest store
+	BackupVerifyKey      string `yaml:"backup_verify_key"`      // Hex-encoded Ed25519 public key backup manifest signatures are verified against on restore and audit, defaults to the one of `BackupSigningKey`
+	RequireSignedBackups bool   `yaml:"require_signed_backups"` // If true, restores of backups without a validly signed manifest are refused, as are restores of `latest`
+
+	DisabledEndpoints []string `yaml:"disabled_endpoints"` // management API routes not served at all (like `/v1/restore`), by path template, requests to them get a 404
+
+	EnablePprof bool `yaml:"enable_pprof"` // If true, exposes the `net/http/pprof` handlers under `/debug/pprof/` on the management HTTP server
+
+	EnableSignalTriggers bool `yaml:"enable_signal_triggers"` // If true, SIGUSR1 triggers a snapshot and SIGUSR2 a backup
+
+	MetricsLabels map[string]string `yaml:"metrics_labels"` // constant labels (like `chain` or `network`) added to every metric
+
+	StatsDAddr          string        `yaml:"statsd_addr"`           // If non-empty, the key metrics (head block, drift, backup duration, restart count) are also sent to this StatsD server (`host:port`, UDP, with DogStatsD tags)
+	StatsDFlushInterval time.Duration `yaml:"statsd_flush_interval"` // How often the metrics are sent to `StatsDAddr`, defaults to 10s
+
+	NotificationRouting map[string]string `yaml:"notification_routing"` // event category (`node`, `backup`, `chain` or `disk`) to the webhook URL its events are POSTed to, the other categories go to the `Notifier` module if any
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // If non-zero, the process exits once shutdown has taken that long, even if some steps are still pending
+
+	LogRingBufferSize int `yaml:"log_ring_buffer_size"` // If non-zero, keeps that many of the last log entries in memory, served on `GET /v1/logs`
+
+	UploadStartupReport bool `yaml:"upload_startup_report"` // If true, the redacted config this node booted with is written to the operator's backup manifest store under `startup_reports/<hostname>.json` on each boot
+
+	DiagnosticsDir    string `yaml:"diagnostics_dir"`    // If non-empty, a goroutine dump, heap profile and the recent logs are written in a new directory under it when the crash-loop limiter trips
+	UploadDiagnostics bool   `yaml:"upload_diagnostics"` // If true, the diagnostics captured in `DiagnosticsDir` are also written to the operator's backup manifest store under `diagnostics/<hostname>/`
+
+	AuditLogPath string `yaml:"audit_log_path"` // If non-empty, every mutating management API call (backups, restores, resets...) is appended to this file as a JSON line
+
+	MindreaderHostnameMatch string `yaml:"mindreader_hostname_match"` // If non-empty, mindreader only runs if we have that hostname (or one matching it as a regular expression), the node runs alone otherwise
+
+	MindreaderStartBlockNum uint64 `yaml:"mindreader_start_block_num"` // If non-zero, mindreader discards the blocks before this one, set it to the `next_start_block_num` of a retired mindreader's handover
+
+	MindreaderResumeFromStore bool `yaml:"mindreader_resume_from_store"` // If true, mindreader starts right after the highest bundle of the merged blocks store (never below `MindreaderStartBlockNum`), not processing again what it uploaded before a restart
+
+	GRPCReadyAfterFirstBlock bool `yaml:"grpc_ready_after_first_block"` // If true, mindreader's gRPC calls (but health checks) fail with `Unavailable` until it archived its first block, at or after its start block
+
+	MaxBlocksPerSecond float64 `yaml:"max_blocks_per_second"` // If non-zero, mindreader consumes at most that many blocks per second, slowing down the node (like during a backfill against a rate-limited upstream)
+
+	LocalBlocksLogRetention uint64 `yaml:"local_blocks_log_retention"` // If non-zero, the node's blocks log is trimmed to that many blocks below the last uploaded merged bundle (requires mindreader)
+}
 
-	StartupDelay       time.Duration
-	ConnectionWatchdog bool
+// LoadConfig reads the YAML or JSON config file at `path` and validates it,
+// see `nodeManager.LoadConfigFile`.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if err := nodeManager.LoadConfigFile(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports all the problems found in the config at once
+func (c *Config) Validate() error {
+	v := &nodeManager.ConfigValidator{}
+	v.Addr("grpc_addr", c.GRPCAddr, false)
+	v.Addr("http_addr", c.HTTPAddr, true)
+	v.Check(c.ReadinessPath == "" || strings.HasPrefix(c.ReadinessPath, "/"), "readiness_path %q must start with `/`", c.ReadinessPath)
+
+	v.Check(c.AutoBackupModulo >= 0, "auto_backup_modulo cannot be negative")
+	v.Check(c.AutoSnapshotModulo >= 0, "auto_snapshot_modulo cannot be negative")
+	v.Check(c.AutoVolumeSnapshotModulo >= 0, "auto_volume_snapshot_modulo cannot be negative")
+
+	// periods of a second or less are ignored by the operator's schedules
+	v.Check(c.AutoBackupPeriod == 0 || c.AutoBackupPeriod > time.Second, "auto_backup_period must be longer than 1s, got %s", c.AutoBackupPeriod)
+	v.Check(c.AutoSnapshotPeriod == 0 || c.AutoSnapshotPeriod > time.Second, "auto_snapshot_period must be longer than 1s, got %s", c.AutoSnapshotPeriod)
+	v.Check(c.AutoVolumeSnapshotPeriod == 0 || c.AutoVolumeSnapshotPeriod > time.Second, "auto_volume_snapshot_period must be longer than 1s, got %s", c.AutoVolumeSnapshotPeriod)
+	v.Check(c.SnapshotAtBlockTimeBoundary == 0 || c.SnapshotAtBlockTimeBoundary >= time.Minute, "snapshot_at_block_time_boundary must be at least 1m, got %s", c.SnapshotAtBlockTimeBoundary)
+	if location, err := time.LoadLocation(c.ScheduleTimezone); err != nil {
+		v.Check(false, "schedule_timezone %q is not a known time zone", c.ScheduleTimezone)
+	} else {
+		for _, cron := range [][2]string{{"backup_cron", c.BackupCron}, {"snapshot_cron", c.SnapshotCron}, {"volume_snapshot_cron", c.VolumeSnapshotCron}} {
+			if cron[1] != "" {
+				_, err := operator.ParseCronSchedule(cron[1], location)
+				v.Check(err == nil, "%s: %s", cron[0], err)
+			}
+		}
+	}
+	v.NonNegative("startup_delay", c.StartupDelay)
+	v.NonNegative("shutdown_timeout", c.ShutdownTimeout)
+	v.NonNegative("upstream_disconnect_grace", c.UpstreamDisconnectGrace)
+	v.NonNegative("watchdog_log_interval", c.WatchdogLogInterval)
+	v.Addr("statsd_addr", c.StatsDAddr, false)
+	v.NonNegative("statsd_flush_interval", c.StatsDFlushInterval)
+	v.NonNegative("max_clock_skew", c.MaxClockSkew)
+	v.Addr("clock_skew_ntp_server", c.ClockSkewNTPServer, false)
+	if c.ReferenceHeadURL != "" {
+		u, err := url.Parse(c.ReferenceHeadURL)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "reference_head_url %q must be an http(s) URL", c.ReferenceHeadURL)
+	}
+	v.NonNegative("max_no_progress_duration", c.MaxNoProgressDuration)
+	if c.BackupSigningKey != "" {
+		_, err := operator.ParseBackupSigningKey(c.BackupSigningKey)
+		v.Check(err == nil, "backup_signing_key is invalid: %s", err)
+	}
+	if c.BackupVerifyKey != "" {
+		_, err := operator.ParseBackupVerifyKey(c.BackupVerifyKey)
+		v.Check(err == nil, "backup_verify_key is invalid: %s", err)
+	}
+	v.Check(!c.UploadDiagnostics || c.DiagnosticsDir != "", "upload_diagnostics requires diagnostics_dir")
+	v.Check(!c.RequireSignedBackups || c.BackupSigningKey != "" || c.BackupVerifyKey != "", "require_signed_backups requires backup_verify_key or backup_signing_key")
+	for category, webhookURL := range c.NotificationRouting {
+		v.Check(isEventCategory(category), "notification_routing category %q is not one of %v", category, operator.EventCategories)
+		u, err := url.Parse(webhookURL)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "notification_routing URL %q of category %q must be an http(s) URL", webhookURL, category)
+	}
+	v.HostnameMatch("mindreader_hostname_match", c.MindreaderHostnameMatch)
+	v.Check(c.MaxBlocksPerSecond >= 0, "max_blocks_per_second cannot be negative")
+	v.Check(c.LogRingBufferSize >= 0, "log_ring_buffer_size cannot be negative")
+	for _, path := range c.DisabledEndpoints {
+		v.Check(strings.HasPrefix(path, "/"), "disabled_endpoints entry %q must start with `/`", path)
+	}
+	return v.Err()
+}
+
+func isEventCategory(category string) bool {
+	for _, known := range operator.EventCategories {
+		if string(known) == category {
+			return true
+		}
+	}
+	return false
 }
 
 type Modules struct {
@@ -63,6 +219,13 @@ type Modules struct {
 	MindreaderPlugin             *mindreader.MindReaderPlugin
 	RegisterGRPCService          func(server *grpc.Server) error
 	StartFailureHandlerFunc      func()
+	Notifier                     operator.Notifier                  // optional, receives the operator's lifecycle events
+	LogLevel                     *zap.AtomicLevel                   // optional, level of `zlogger`, adjustable at runtime through `/v1/log_level`
+	AuditIdentityFunc            func(r *http.Request) string       // optional, tells who made a management API call in the audit log, see `Config.AuditLogPath`
+	ReadinessFunc                func() (ready bool, reason string) // optional, chain-specific readiness required in addition to the built-in checks, its reason is served on `/healthz` when not ready
+	ClockSkewFunc                func() (time.Duration, error)      // optional, measures the host clock skew (positive when ahead) instead of the NTP server, like against peers, see `Config.MaxClockSkew`
+	ReferenceHeadFunc            func() (uint64, error)             // optional, returns the reference head block instead of querying `Config.ReferenceHeadURL`, like from peers, see `Config.MaxBlocksBehind`
+	BlockLineParser              mindreader.BlockLineParser         // optional, mindreader tracks the head block from the raw console lines, see `mindreader.WithBlockLineParser`
 }
 
 type App struct {
@@ -82,14 +245,32 @@ func New(config *Config, modules *Modules, zlogger *zap.Logger) *App {
 }
 
 func (a *App) Run() error {
-	hasMindreader := a.modules.MindreaderPlugin != nil
-	a.zlogger.Info("running nodeos manager app", zap.Reflect("config", a.config), zap.Bool("mindreader", hasMindreader))
+	if a.config.LogRingBufferSize > 0 {
+		logs := operator.NewLogRingBuffer(a.config.LogRingBufferSize)
+		a.zlogger = logs.Tee(a.zlogger)
+		a.modules.Operator.SetLogRingBuffer(logs)
+	}
+
+	a.zlogger.Info("running nodeos manager app", zap.Reflect("config", a.config), zap.Bool("mindreader", a.modules.MindreaderPlugin != nil))
 
 	hostname, _ := os.Hostname()
 	a.zlogger.Info("retrieved hostname from os", zap.String("hostname", hostname))
 
-	dmetrics.Register(metrics.NodeosMetricset)
-	dmetrics.Register(metrics.Metricset)
+	if a.modules.MindreaderPlugin != nil && a.config.MindreaderHostnameMatch != "" {
+		if err := a.applyMindreaderHostnameMatch(hostname); err != nil {
+			return err
+		}
+	}
+	hasMindreader := a.modules.MindreaderPlugin != nil
+
+	metrics.Register(a.config.MetricsLabels)
+	if a.config.StatsDAddr != "" {
+		sink, err := metrics.NewStatsDSink(a.config.StatsDAddr, a.zlogger)
+		if err != nil {
+			return err
+		}
+		go sink.Run(a.config.StatsDFlushInterval, a.Terminating())
+	}
 
 	if a.config.AutoBackupPeriod != 0 || a.config.AutoBackupModulo != 0 {
 		a.modules.Operator.ConfigureAutoBackup(a.config.AutoBackupPeriod, a.config.AutoBackupModulo, a.config.AutoBackupHostnameMatch, hostname)
@@ -99,11 +280,51 @@ func (a *App) Run() error {
 		a.modules.Operator.ConfigureAutoSnapshot(a.config.AutoSnapshotPeriod, a.config.AutoSnapshotModulo, a.config.AutoSnapshotHostnameMatch, hostname)
 	}
 
+	if a.config.SnapshotAtBlockTimeBoundary != 0 {
+		a.modules.Operator.ConfigureAutoSnapshotAtBlockTime(a.config.SnapshotAtBlockTimeBoundary, a.config.AutoSnapshotHostnameMatch, hostname)
+	}
+
 	if a.config.AutoVolumeSnapshotPeriod != 0 || a.config.AutoVolumeSnapshotModulo != 0 || len(a.config.AutoVolumeSnapshotSpecificBlocks) > 0 {
 		a.modules.Operator.ConfigureAutoVolumeSnapshot(a.config.AutoVolumeSnapshotPeriod, a.config.AutoVolumeSnapshotModulo, a.config.AutoVolumeSnapshotSpecificBlocks)
 	}
 
+	if err := a.configureCronSchedules(hostname); err != nil {
+		return err
+	}
+
+	notifier := a.modules.Notifier
+	if len(a.config.NotificationRouting) != 0 {
+		routes := make(map[operator.EventCategory]operator.Notifier)
+		for category, webhookURL := range a.config.NotificationRouting {
+			routes[operator.EventCategory(category)] = operator.NewWebhookNotifier(webhookURL)
+		}
+		notifier = &operator.RoutingNotifier{Routes: routes, Default: a.modules.Notifier}
+	}
+
+	if notifier != nil {
+		a.modules.Operator.SetNotifier(notifier)
+	}
+
+	a.modules.Operator.SetDescribeConfig(a.config)
+	if a.modules.ReadinessFunc != nil {
+		a.modules.Operator.SetReadinessFunc(a.modules.ReadinessFunc)
+	}
+	a.modules.Operator.ConfigureBlockProgressMonitor(a.config.MaxNoProgressDuration, a.config.RestartOnNoProgress)
+	if err := a.configureBackupSigning(); err != nil {
+		return err
+	}
+	if err := a.modules.Operator.ConfigureCrashDiagnostics(a.config.DiagnosticsDir, a.config.UploadDiagnostics); err != nil {
+		return err
+	}
+	if a.config.UploadStartupReport {
+		go a.uploadStartupReport()
+	}
+
 	a.OnTerminating(func(err error) {
+		if a.config.ShutdownTimeout != 0 {
+			go a.forceExitAfter(a.config.ShutdownTimeout)
+		}
+
 		a.modules.Operator.Shutdown(err)
 		<-a.modules.Operator.Terminated()
 	})
@@ -117,38 +338,339 @@ func (a *App) Run() error {
 		time.Sleep(a.config.StartupDelay)
 	}
 
-	var httpOptions []operator.HTTPOption
+	httpOptions := []operator.HTTPOption{a.modules.Operator.ReadinessPathOption(a.config.ReadinessPath)}
 	if hasMindreader {
+		if a.config.MindreaderStartBlockNum != 0 {
+			a.zlogger.Info("mindreader starting after a handover", zap.Uint64("start_block_num", a.config.MindreaderStartBlockNum))
+			a.modules.MindreaderPlugin.SetStartBlockNum(a.config.MindreaderStartBlockNum)
+		}
+
+		if a.config.MindreaderResumeFromStore {
+			ctx, cancel := context.WithTimeout(context.Background(), resumeFromStoreTimeout)
+			_, err := a.modules.MindreaderPlugin.ResumeFromMergedStore(ctx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("unable to resume mindreader from the merged blocks store: %w", err)
+			}
+		}
+
+		if a.modules.BlockLineParser != nil {
+			a.modules.MindreaderPlugin.SetBlockLineParser(a.modules.BlockLineParser)
+		}
+
+		if a.config.MaxBlocksPerSecond != 0 {
+			a.zlogger.Info("throttling mindreader block processing", zap.Float64("max_blocks_per_second", a.config.MaxBlocksPerSecond))
+			a.modules.MindreaderPlugin.SetMaxBlocksPerSecond(a.config.MaxBlocksPerSecond)
+		}
+
 		if err := a.startMindreader(); err != nil {
 			return fmt.Errorf("unable to start mindreader: %w", err)
 		}
 
+		httpOptions = append(httpOptions, func(r *mux.Router) {
+			r.HandleFunc("/v1/mindreader/flush", a.flushMindreaderHandler).Methods("POST")
+			r.HandleFunc("/v1/mindreader/last_block", a.mindreaderLastBlockHandler).Methods("GET")
+			r.HandleFunc("/v1/mindreader/handover", a.mindreaderHandoverHandler).Methods("POST")
+		})
+
+		a.modules.Operator.AddDescribeSection("mindreader", a.describeMindreader)
+		a.modules.Operator.SetBeforeBlocksLogRotation(a.flushMindreaderBundle)
+
 		if a.modules.MindreaderPlugin.HasContinuityChecker() {
+			a.modules.MindreaderPlugin.OnContinuityGap(func(gap *mindreader.ContinuityGap) {
+				a.modules.Operator.Notify(operator.EventContinuityGap, "mindreader detected a hole in the blocks", map[string]string{
+					"expected_block": strconv.FormatUint(gap.ExpectedBlock, 10),
+					"received_block": strconv.FormatUint(gap.ReceivedBlock, 10),
+				})
+			})
+
 			httpOptions = append(httpOptions, func(r *mux.Router) {
 				r.HandleFunc("/v1/reset_cc", func(w http.ResponseWriter, _ *http.Request) {
 					a.modules.MindreaderPlugin.ResetContinuityChecker()
 					w.Write([]byte("ok"))
-				})
+				}).Methods("POST")
+				r.HandleFunc("/v1/continuity_gaps", func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode(a.modules.MindreaderPlugin.ContinuityGaps())
+				}).Methods("GET")
 			})
 		}
 	}
 
+	if a.modules.LogLevel != nil {
+		httpOptions = append(httpOptions, operator.WithLogLevelHandler(*a.modules.LogLevel))
+	}
+
+	if a.config.AuditLogPath != "" {
+		auditLog, err := operator.OpenAuditLog(a.config.AuditLogPath)
+		if err != nil {
+			return fmt.Errorf("unable to open audit log: %w", err)
+		}
+		auditLog.Identity = a.modules.AuditIdentityFunc
+		a.OnTerminated(func(_ error) { _ = auditLog.Close() })
+
+		a.zlogger.Info("recording management api calls to audit log", zap.String("audit_log_path", a.config.AuditLogPath))
+		httpOptions = append(httpOptions, a.modules.Operator.AuditLogOption(auditLog))
+	}
+
+	if a.config.EnablePprof {
+		a.zlogger.Info("exposing pprof handlers on management http server")
+		httpOptions = append(httpOptions, registerPprofHandlers)
+	}
+
+	if len(a.config.DisabledEndpoints) != 0 {
+		a.modules.Operator.SetDisabledEndpoints(a.config.DisabledEndpoints)
+	}
+
 	a.zlogger.Info("launching operator")
+	a.modules.MetricsAndReadinessManager.SetLogger(a.zlogger)
 	go a.modules.MetricsAndReadinessManager.Launch()
 	go a.Shutdown(a.modules.Operator.Launch(a.config.HTTPAddr, httpOptions...))
 
 	if a.config.ConnectionWatchdog {
+		a.modules.MetricsAndReadinessManager.SetWatchdogLogInterval(a.config.WatchdogLogInterval)
 		go a.modules.LaunchConnectionWatchdogFunc(a.Terminating())
 	}
 
+	if a.config.UpstreamDisconnectGrace != 0 {
+		if !a.config.ConnectionWatchdog {
+			a.zlogger.Warn("upstream disconnect grace set without the connection watchdog, nothing reports the upstream connection")
+		}
+		a.modules.MetricsAndReadinessManager.SetUpstreamDisconnectGrace(a.config.UpstreamDisconnectGrace)
+	}
+
+	if a.config.MaxClockSkew != 0 {
+		measure := a.modules.ClockSkewFunc
+		if measure == nil {
+			server := a.config.ClockSkewNTPServer
+			if server == "" {
+				server = defaultClockSkewNTPServer
+			}
+			measure = func() (time.Duration, error) { return nodeManager.QueryNTPClockSkew(server, 5*time.Second) }
+		}
+		go a.modules.MetricsAndReadinessManager.MonitorClockSkew(a.config.MaxClockSkew, clockSkewCheckInterval, measure, a.Terminating())
+	}
+
+	if a.config.MaxBlocksBehind != 0 {
+		reference := a.modules.ReferenceHeadFunc
+		if reference == nil && a.config.ReferenceHeadURL != "" {
+			referenceURL := a.config.ReferenceHeadURL
+			reference = func() (uint64, error) { return nodeManager.QueryNodeosHeadBlockNum(referenceURL, 5*time.Second) }
+		}
+		if reference == nil {
+			a.zlogger.Warn("max blocks behind set without a reference head (reference_head_url), not monitoring blocks behind")
+		} else {
+			go a.modules.MetricsAndReadinessManager.MonitorBlocksBehind(a.config.MaxBlocksBehind, blocksBehindCheckInterval, reference, a.Terminating())
+		}
+	}
+
+	if a.config.EnableSignalTriggers {
+		go a.handleSignalTriggers()
+	}
+
+	if a.config.LocalBlocksLogRetention != 0 {
+		trimmer, ok := a.modules.Operator.Superviser.(nodeManager.BlocksLogTrimmerChainSuperviser)
+		if !hasMindreader || !ok {
+			a.zlogger.Warn("local blocks log retention requires mindreader and a chain superviser able to trim the blocks log, not trimming it")
+		} else {
+			go a.launchBlocksLogReaper(trimmer)
+		}
+	}
+
+	return nil
+}
+
+// configureCronSchedules must be called after the other automatic schedules,
+// the cron expressions take precedence over their periods
+func (a *App) configureCronSchedules(hostname string) error {
+	location, err := time.LoadLocation(a.config.ScheduleTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid schedule timezone: %w", err)
+	}
+
+	for _, s := range []struct {
+		spec          string
+		backuperName  string
+		hostnameMatch string
+	}{
+		{a.config.BackupCron, operator.BackupModuleName, a.config.AutoBackupHostnameMatch},
+		{a.config.SnapshotCron, operator.SnapshotModuleName, a.config.AutoSnapshotHostnameMatch},
+		{a.config.VolumeSnapshotCron, operator.VolumeSnapshotModuleName, ""},
+	} {
+		if s.spec == "" {
+			continue
+		}
+		cron, err := operator.ParseCronSchedule(s.spec, location)
+		if err != nil {
+			return err
+		}
+		a.modules.Operator.ConfigureAutoCron(s.backuperName, cron, s.hostnameMatch, hostname)
+	}
+	return nil
+}
+
+// applyMindreaderHostnameMatch drops the mindreader plugin when this host is
+// not one allowed to run it, the node then runs alone.
+func (a *App) applyMindreaderHostnameMatch(hostname string) error {
+	matches, err := nodeManager.MatchHostname(a.config.MindreaderHostnameMatch, hostname)
+	if err != nil {
+		return err
+	}
+
+	if matches {
+		a.zlogger.Info("hostname matches, running mindreader", zap.String("hostname", hostname), zap.String("mindreader_hostname_match", a.config.MindreaderHostnameMatch))
+		return nil
+	}
+
+	remover, ok := a.modules.Operator.Superviser.(nodeManager.LogPluginRemovalChainSuperviser)
+	if !ok {
+		return fmt.Errorf("mindreader must not run on host %q, but the chain superviser cannot unregister its plugin", hostname)
+	}
+
+	a.zlogger.Info("hostname does not match, running node without mindreader", zap.String("hostname", hostname), zap.String("mindreader_hostname_match", a.config.MindreaderHostnameMatch))
+	remover.UnregisterLogPlugin(a.modules.MindreaderPlugin)
+	a.modules.MindreaderPlugin = nil
+	return nil
+}
+
+// uploadStartupReport never fails the boot, the report is only informative
+func (a *App) uploadStartupReport() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := a.modules.Operator.UploadStartupReport(ctx); err != nil {
+		a.zlogger.Warn("unable to upload startup report", zap.Error(err))
+	}
+}
+
+// handleSignalTriggers queues operations on SIGUSR1 (snapshot) and SIGUSR2 (backup),
+// they go through the operator's command queue like the HTTP triggered ones.
+func (a *App) handleSignalTriggers() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	a.zlogger.Info("listening for signal triggers", zap.String("snapshot", "SIGUSR1"), zap.String("backup", "SIGUSR2"))
+	for {
+		select {
+		case <-a.Terminating():
+			return
+		case sig := <-signals:
+			a.zlogger.Info("received signal trigger", zap.Stringer("signal", sig))
+			switch sig {
+			case syscall.SIGUSR1:
+				a.modules.Operator.TriggerBackup(operator.SnapshotModuleName)
+			case syscall.SIGUSR2:
+				a.modules.Operator.TriggerBackup(operator.BackupModuleName)
+			}
+		}
+	}
+}
+
+const blocksLogReaperInterval = time.Minute
+
+const handoverDrainTimeout = 2 * time.Minute
+
+const clockSkewCheckInterval = time.Minute
+const blocksBehindCheckInterval = 10 * time.Second
+const defaultClockSkewNTPServer = "pool.ntp.org:123"
+
+func (a *App) configureBackupSigning() error {
+	var signingKey ed25519.PrivateKey
+	if a.config.BackupSigningKey != "" {
+		key, err := operator.ParseBackupSigningKey(a.config.BackupSigningKey)
+		if err != nil {
+			return err
+		}
+		signingKey = key
+	}
+
+	var verifyKey ed25519.PublicKey
+	if a.config.BackupVerifyKey != "" {
+		key, err := operator.ParseBackupVerifyKey(a.config.BackupVerifyKey)
+		if err != nil {
+			return err
+		}
+		verifyKey = key
+	}
+
+	if err := a.modules.Operator.ConfigureBackupSigning(signingKey, verifyKey, a.config.RequireSignedBackups); err != nil {
+		return fmt.Errorf("unable to configure backup signing: %w", err)
+	}
 	return nil
 }
 
+const resumeFromStoreTimeout = 2 * time.Minute
+
+// launchBlocksLogReaper trims the node's blocks log to `LocalBlocksLogRetention`
+// blocks below the last uploaded merged bundle, never trimming blocks that were
+// not uploaded yet.
+func (a *App) launchBlocksLogReaper(trimmer nodeManager.BlocksLogTrimmerChainSuperviser) {
+	ticker := time.NewTicker(blocksLogReaperInterval)
+	defer ticker.Stop()
+
+	var trimmedBefore uint64
+	for {
+		select {
+		case <-a.Terminating():
+			return
+		case <-ticker.C:
+		}
+
+		lastUploaded := a.modules.MindreaderPlugin.LastUploadedMergedBlock()
+		if lastUploaded <= a.config.LocalBlocksLogRetention {
+			continue
+		}
+
+		before := lastUploaded - a.config.LocalBlocksLogRetention
+		if before <= trimmedBefore {
+			continue
+		}
+
+		if err := trimmer.TrimBlocksLog(before); err != nil {
+			a.zlogger.Warn("unable to trim blocks log, will retry", zap.Uint64("before_block_num", before), zap.Error(err))
+			continue
+		}
+
+		a.zlogger.Info("trimmed blocks log", zap.Uint64("before_block_num", before), zap.Uint64("last_uploaded_block_num", lastUploaded))
+		trimmedBefore = before
+	}
+}
+
+// forceExitAfter bounds the whole shutdown sequence, exiting the process
+// after `timeout` if the app is not terminated by then.
+func (a *App) forceExitAfter(timeout time.Duration) {
+	select {
+	case <-a.Terminated():
+		return
+	case <-time.After(timeout):
+	}
+
+	var pending []string
+	if !a.modules.Operator.IsTerminated() {
+		pending = append(pending, "operator")
+	}
+	if !a.modules.Operator.Superviser.IsTerminated() {
+		pending = append(pending, "chain superviser")
+	}
+	if a.modules.MindreaderPlugin != nil && !a.modules.MindreaderPlugin.IsTerminated() {
+		pending = append(pending, "mindreader")
+	}
+
+	a.zlogger.Error("shutdown timeout reached, forcing exit", zap.Duration("shutdown_timeout", timeout), zap.Strings("pending_steps", pending))
+	_ = a.zlogger.Sync()
+	os.Exit(1)
+}
+
 func (a *App) IsReady() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
 
-	url := fmt.Sprintf("http://%s/healthz", a.config.HTTPAddr)
+	readinessPath := a.config.ReadinessPath
+	if readinessPath == "" {
+		readinessPath = operator.DefaultReadinessPath
+	}
+
+	url := fmt.Sprintf("http://%s%s", a.config.HTTPAddr, readinessPath)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		a.zlogger.Warn("unable to build get health request", zap.Error(err))
@@ -167,12 +689,24 @@ func (a *App) IsReady() bool {
 
 func (a *App) startMindreader() error {
 	a.zlogger.Info("starting mindreader gRPC server")
-	gs := dgrpc.NewServer(dgrpc.WithLogger(a.zlogger))
+	serverOptions := []dgrpc.ServerOption{dgrpc.WithLogger(a.zlogger)}
+	if a.config.GRPCReadyAfterFirstBlock {
+		a.zlogger.Info("mindreader gRPC calls are unavailable until the first block is produced")
+		ready := a.modules.MindreaderPlugin.HasProducedFirstBlock
+		serverOptions = append(serverOptions,
+			dgrpc.WithPostUnaryInterceptor(mindreader.WarmupGateUnaryInterceptor(ready)),
+			dgrpc.WithPostStreamInterceptor(mindreader.WarmupGateStreamInterceptor(ready)),
+		)
+	}
+	gs := dgrpc.NewServer(serverOptions...)
 
 	if a.modules.RegisterGRPCService != nil {
 		err := a.modules.RegisterGRPCService(gs)
 		if err != nil {
-			return fmt.Errorf("register extra grpc service: %w", err)
+			if !a.config.GRPCServiceRegistrationOptional {
+				return fmt.Errorf("register extra grpc service: %w", err)
+			}
+			a.zlogger.Warn("unable to register extra grpc service, starting without it", zap.Error(err))
 		}
 	}
 
@@ -185,3 +719,112 @@ func (a *App) startMindreader() error {
 	go a.modules.MindreaderPlugin.Launch()
 	return nil
 }
+
+func (a *App) flushMindreaderHandler(w http.ResponseWriter, _ *http.Request) {
+	flushed, err := a.modules.MindreaderPlugin.FlushBundle()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to flush bundle: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"flushed": flushed != nil}
+	if flushed != nil {
+		resp["start_block"] = flushed.StartBlock
+		resp["end_block"] = flushed.EndBlock
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (a *App) mindreaderLastBlockHandler(w http.ResponseWriter, _ *http.Request) {
+	last := a.modules.MindreaderPlugin.LastArchivedBlock()
+	if last == nil {
+		http.Error(w, "no block archived yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(last)
+}
+
+// mindreaderHandoverHandler retires this mindreader: the node is stopped (and
+// stays in maintenance), every block it produced is archived and the bundle
+// in progress is flushed. The replacing mindreader starts at `next_start_block_num`.
+func (a *App) mindreaderHandoverHandler(w http.ResponseWriter, r *http.Request) {
+	a.zlogger.Info("mindreader handover requested, stopping node")
+	if err := a.modules.Operator.Maintenance(); err != nil {
+		http.Error(w, fmt.Sprintf("unable to stop node: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), handoverDrainTimeout)
+	defer cancel()
+	if err := a.modules.MindreaderPlugin.WaitForDrain(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("mindreader did not drain its blocks: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := a.modules.MindreaderPlugin.FlushBundle(); err != nil {
+		http.Error(w, fmt.Sprintf("unable to flush bundle: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	last := a.modules.MindreaderPlugin.LastArchivedBlock()
+	if last == nil {
+		http.Error(w, "node stopped without any block archived, nothing to hand over", http.StatusConflict)
+		return
+	}
+
+	a.zlogger.Info("mindreader handed over", zap.Uint64("last_block_num", last.BlockNum), zap.String("last_block_id", last.BlockID), zap.Uint64("next_start_block_num", last.NextStartBlockNum))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(last)
+}
+
+// flushMindreaderBundle finalizes the merged bundle once every block of the
+// stopped node went through mindreader, so none straddles a blocks log rotation.
+func (a *App) flushMindreaderBundle() error {
+	ctx, cancel := context.WithTimeout(context.Background(), handoverDrainTimeout)
+	defer cancel()
+	if err := a.modules.MindreaderPlugin.WaitForDrain(ctx); err != nil {
+		return fmt.Errorf("mindreader did not drain its blocks: %w", err)
+	}
+
+	flushed, err := a.modules.MindreaderPlugin.FlushBundle()
+	if err != nil {
+		return fmt.Errorf("unable to flush bundle: %w", err)
+	}
+	if flushed != nil {
+		a.zlogger.Info("flushed mindreader bundle before blocks log rotation", zap.Uint64("start_block", flushed.StartBlock), zap.Uint64("end_block", flushed.EndBlock))
+	}
+	return nil
+}
+
+func registerPprofHandlers(r *mux.Router) {
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile).Methods("GET")
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace).Methods("GET")
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index).Methods("GET") // index and named profiles (heap, goroutine, ...)
+}
+
+type mindreaderDescription struct {
+	LastArchivedBlock       *mindreader.HandoverBlock   `json:"last_archived_block"`
+	LastUploadedMergedBlock uint64                      `json:"last_uploaded_merged_block"`
+	ContinuityChecker       bool                        `json:"continuity_checker"`
+	ContinuityLocked        bool                        `json:"continuity_locked"`
+	ContinuityGaps          []*mindreader.ContinuityGap `json:"continuity_gaps,omitempty"`
+	GRPCGated               bool                        `json:"grpc_gated"` // gRPC calls are unavailable until the first block, see `Config.GRPCReadyAfterFirstBlock`
+}
+
+func (a *App) describeMindreader() interface{} {
+	plugin := a.modules.MindreaderPlugin
+	return &mindreaderDescription{
+		LastArchivedBlock:       plugin.LastArchivedBlock(),
+		LastUploadedMergedBlock: plugin.LastUploadedMergedBlock(),
+		ContinuityChecker:       plugin.HasContinuityChecker(),
+		ContinuityLocked:        plugin.ContinuityLocked(),
+		ContinuityGaps:          plugin.ContinuityGaps(),
+		GRPCGated:               a.config.GRPCReadyAfterFirstBlock && !plugin.HasProducedFirstBlock(),
+	}
+}
@@ -19,9 +19,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/dfuse-io/dmetrics"
 	nodeManager "github.com/dfuse-io/node-manager"
 	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/dfuse-io/node-manager/mindreader"
@@ -32,10 +32,46 @@ import (
 )
 
 type Config struct {
-	ManagerAPIAddress  string
-	ConnectionWatchdog bool
+	ManagerAPIAddress  string `yaml:"manager_api_address"`
+	ConnectionWatchdog bool   `yaml:"connection_watchdog"`
 
-	GRPCAddr string
+	UpstreamDisconnectGrace time.Duration `yaml:"upstream_disconnect_grace"` // If non-zero, not ready once the connection watchdog reports the upstream node disconnected for longer than that
+	WatchdogLogInterval     time.Duration `yaml:"watchdog_log_interval"`     // If non-zero, the connection watchdog failures repeating the previous one are summarized at most once per interval instead of each being logged
+
+	GRPCAddr string `yaml:"grpc_addr"`
+
+	MetricsLabels map[string]string `yaml:"metrics_labels"` // constant labels (like `chain` or `network`) added to every metric
+
+	StatsDAddr          string        `yaml:"statsd_addr"`           // If non-empty, the key metrics (head block, drift, backup duration, restart count) are also sent to this StatsD server (`host:port`, UDP, with DogStatsD tags)
+	StatsDFlushInterval time.Duration `yaml:"statsd_flush_interval"` // How often the metrics are sent to `StatsDAddr`, defaults to 10s
+
+	ReadinessPath string `yaml:"readiness_path"` // readiness check path, served in addition to `/healthz`, defaults to `/healthz`
+}
+
+// LoadConfig reads the YAML or JSON config file at `path` and validates it,
+// see `nodeManager.LoadConfigFile`.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if err := nodeManager.LoadConfigFile(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports all the problems found in the config at once
+func (c *Config) Validate() error {
+	v := &nodeManager.ConfigValidator{}
+	v.Addr("manager_api_address", c.ManagerAPIAddress, true)
+	v.Addr("grpc_addr", c.GRPCAddr, true)
+	v.Check(c.ReadinessPath == "" || strings.HasPrefix(c.ReadinessPath, "/"), "readiness_path %q must start with `/`", c.ReadinessPath)
+	v.NonNegative("upstream_disconnect_grace", c.UpstreamDisconnectGrace)
+	v.NonNegative("watchdog_log_interval", c.WatchdogLogInterval)
+	v.Addr("statsd_addr", c.StatsDAddr, false)
+	v.NonNegative("statsd_flush_interval", c.StatsDFlushInterval)
+	return v.Err()
 }
 
 type Modules struct {
@@ -45,6 +81,8 @@ type Modules struct {
 	LaunchConnectionWatchdogFunc func(terminating <-chan struct{})
 	StartFailureHandlerFunc      func()
 	GrpcServer                   *grpc.Server
+	LogLevel                     *zap.AtomicLevel                   // optional, level of `zlogger`, adjustable at runtime through `/v1/log_level`
+	ReadinessFunc                func() (ready bool, reason string) // optional, chain-specific readiness required in addition to the built-in checks, its reason is served on `/healthz` when not ready
 }
 
 type App struct {
@@ -70,14 +108,25 @@ func (a *App) Run() error {
 	hostname, _ := os.Hostname()
 	a.zlogger.Info("retrieved hostname from os", zap.String("hostname", hostname))
 
-	dmetrics.Register(metrics.NodeosMetricset)
-	dmetrics.Register(metrics.Metricset)
+	metrics.Register(a.config.MetricsLabels)
+	if a.config.StatsDAddr != "" {
+		sink, err := metrics.NewStatsDSink(a.config.StatsDAddr, a.zlogger)
+		if err != nil {
+			return err
+		}
+		go sink.Run(a.config.StatsDFlushInterval, a.Terminating())
+	}
 
 	err := mindreader.RunGRPCServer(a.modules.GrpcServer, a.config.GRPCAddr, a.zlogger)
 	if err != nil {
 		return err
 	}
 
+	a.modules.Operator.SetDescribeConfig(a.config)
+	if a.modules.ReadinessFunc != nil {
+		a.modules.Operator.SetReadinessFunc(a.modules.ReadinessFunc)
+	}
+
 	a.OnTerminating(func(err error) {
 		a.modules.Operator.Shutdown(err)
 		<-a.modules.Operator.Terminated()
@@ -88,15 +137,29 @@ func (a *App) Run() error {
 		a.Shutdown(err)
 	})
 
+	a.modules.MetricsAndReadinessManager.SetLogger(a.zlogger)
+
 	// TODO remove the flag, the watchdog could be part of the operator itself.
 	if a.config.ConnectionWatchdog {
+		a.modules.MetricsAndReadinessManager.SetWatchdogLogInterval(a.config.WatchdogLogInterval)
 		go a.modules.LaunchConnectionWatchdogFunc(a.modules.Operator.Terminating())
 	}
 
+	if a.config.UpstreamDisconnectGrace != 0 {
+		if !a.config.ConnectionWatchdog {
+			a.zlogger.Warn("upstream disconnect grace set without the connection watchdog, nothing reports the upstream connection")
+		}
+		a.modules.MetricsAndReadinessManager.SetUpstreamDisconnectGrace(a.config.UpstreamDisconnectGrace)
+	}
+
 	a.zlogger.Info("launching metrics and readinessManager")
 	go a.modules.MetricsAndReadinessManager.Launch()
 
-	var httpOptions []operator.HTTPOption
+	httpOptions := []operator.HTTPOption{a.modules.Operator.ReadinessPathOption(a.config.ReadinessPath)}
+	if a.modules.LogLevel != nil {
+		httpOptions = append(httpOptions, operator.WithLogLevelHandler(*a.modules.LogLevel))
+	}
+
 	a.zlogger.Info("launching operator")
 	go a.Shutdown(a.modules.Operator.Launch(a.config.ManagerAPIAddress, httpOptions...))
 
@@ -107,7 +170,12 @@ func (a *App) IsReady() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
 
-	url := fmt.Sprintf("http://%s/healthz", a.config.ManagerAPIAddress)
+	readinessPath := a.config.ReadinessPath
+	if readinessPath == "" {
+		readinessPath = operator.DefaultReadinessPath
+	}
+
+	url := fmt.Sprintf("http://%s%s", a.config.ManagerAPIAddress, readinessPath)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		a.zlogger.Warn("unable to build get health request", zap.Error(err))
@@ -16,6 +16,8 @@ package node_mindreader_stdin
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -31,19 +33,75 @@ import (
 )
 
 type Config struct {
-	GRPCAddr                     string
-	ArchiveStoreURL              string
-	MergeArchiveStoreURL         string
-	OneblockSuffix               string
-	BatchMode                    bool
-	MergeThresholdBlockAge       time.Duration
-	MindReadBlocksChanCapacity   int
-	FailOnNonContinuousBlocks    bool
-	StartBlockNum                uint64
-	StopBlockNum                 uint64
-	DiscardAfterStopBlock        bool
-	WorkingDir                   string
-	WaitUploadCompleteOnShutdown time.Duration
+	GRPCAddr                     string        `yaml:"grpc_addr"`
+	ArchiveStoreURL              string        `yaml:"archive_store_url"`
+	MergeArchiveStoreURL         string        `yaml:"merge_archive_store_url"`
+	OneblockSuffix               string        `yaml:"oneblock_suffix"`
+	BatchMode                    bool          `yaml:"batch_mode"`
+	MergeThresholdBlockAge       time.Duration `yaml:"merge_threshold_block_age"`
+	MindReadBlocksChanCapacity   int           `yaml:"mind_read_blocks_chan_capacity"`
+	FailOnNonContinuousBlocks    bool          `yaml:"fail_on_non_continuous_blocks"`
+	ContinuityAllowSkips         uint64        `yaml:"continuity_allow_skips"` // number of consecutive block numbers that may be missing without the continuity checker locking
+	ContinuityOnGap              string        `yaml:"continuity_on_gap"`      // what the continuity checker does on a gap: `lock` (the default), `shutdown` or `continue`, see `mindreader.ContinuityGapPolicy`
+	StartBlockNum                uint64        `yaml:"start_block_num"`
+	ResumeFromStore              bool          `yaml:"resume_from_store"` // if true, starts right after the highest bundle of the merged store (never below `start_block_num`), not processing again what was uploaded before a restart
+	StopBlockNum                 uint64        `yaml:"stop_block_num"`
+	DiscardAfterStopBlock        bool          `yaml:"discard_after_stop_block"`
+	WorkingDir                   string        `yaml:"working_dir"`
+	WaitUploadCompleteOnShutdown time.Duration `yaml:"wait_upload_complete_on_shutdown"`
+	AllowCompressedBlockLog      bool          `yaml:"allow_compressed_block_log"` // if true, gzip-compressed input is detected and decompressed on the fly
+	BlockHubBufferSize           int           `yaml:"block_hub_buffer_size"`      // if non-zero, blocks are streamed over gRPC with a buffer of this many blocks per subscriber, slower subscribers get dropped
+	BlockHubBurstSize            int           `yaml:"block_hub_burst_size"`       // number of recent blocks a new subscriber may ask for, see `BlockHubBufferSize`
+	OutputFileMode               os.FileMode   `yaml:"output_file_mode"`           // if non-zero, mode (like 0640) of the block files written to local stores
+	OutputFileOwner              string        `yaml:"output_file_owner"`          // if set, user name or id owning the block files written to local stores
+	OutputFileGroup              string        `yaml:"output_file_group"`          // if set, group name or id of the block files written to local stores
+	ContentHashBundleNames       bool          `yaml:"content_hash_bundle_names"`  // if true, merged bundles are named after their blocks range and content hash, identical bundles are uploaded once
+	OrderedBundleCommits         bool          `yaml:"ordered_bundle_commits"`     // if true, merged bundles become visible in the merged store strictly in block order, see `mindreader.WithOrderedBundleCommits`
+	ReplayReadAheadBytes         int           `yaml:"replay_read_ahead_bytes"`    // if non-zero, input is read ahead of the parser into a buffer of that many bytes, it stops once the stop block is reached
+}
+
+// LoadConfig reads the YAML or JSON config file at `path` and validates it,
+// see `nodeManager.LoadConfigFile`.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{
+		MindReadBlocksChanCapacity: 100,
+	}
+	if err := nodeManager.LoadConfigFile(path, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports all the problems found in the config at once
+func (c *Config) Validate() error {
+	v := &nodeManager.ConfigValidator{}
+	v.Addr("grpc_addr", c.GRPCAddr, true)
+	v.Check(c.ArchiveStoreURL != "", "archive_store_url is required")
+	v.Check(c.MergeArchiveStoreURL != "", "merge_archive_store_url is required")
+	v.Check(c.WorkingDir != "", "working_dir is required")
+	v.Check(c.MindReadBlocksChanCapacity > 0, "mind_read_blocks_chan_capacity must be positive")
+	v.Check(c.StopBlockNum == 0 || c.StopBlockNum >= c.StartBlockNum, "stop_block_num %d is below start_block_num %d", c.StopBlockNum, c.StartBlockNum)
+	v.Check(c.ContinuityOnGap == "" || isContinuityGapPolicy(c.ContinuityOnGap), "continuity_on_gap %q is not one of %v", c.ContinuityOnGap, mindreader.ContinuityGapPolicies)
+	v.Check(c.ReplayReadAheadBytes >= 0, "replay_read_ahead_bytes cannot be negative")
+	v.Check(c.BlockHubBufferSize >= 0, "block_hub_buffer_size cannot be negative")
+	v.Check(c.BlockHubBurstSize >= 0, "block_hub_burst_size cannot be negative")
+	v.Check(c.BlockHubBurstSize == 0 || c.BlockHubBufferSize != 0, "block_hub_burst_size requires block_hub_buffer_size")
+	v.Check(c.OutputFileMode&^os.ModePerm == 0, "output_file_mode %o must only hold permission bits", c.OutputFileMode)
+	v.NonNegative("merge_threshold_block_age", c.MergeThresholdBlockAge)
+	v.NonNegative("wait_upload_complete_on_shutdown", c.WaitUploadCompleteOnShutdown)
+	return v.Err()
+}
+
+func isContinuityGapPolicy(policy string) bool {
+	for _, known := range mindreader.ContinuityGapPolicies {
+		if string(known) == policy {
+			return true
+		}
+	}
+	return false
 }
 
 type Modules struct {
@@ -52,6 +110,7 @@ type Modules struct {
 	MetricsAndReadinessManager *nodeManager.MetricsAndReadinessManager
 	RegisterGRPCService        func(server *grpc.Server) error
 	Tracker                    *bstream.Tracker
+	BlockLineParser            mindreader.BlockLineParser // optional, see `mindreader.WithBlockLineParser`
 }
 
 type App struct {
@@ -78,6 +137,29 @@ func (a *App) Run() error {
 
 	gs := dgrpc.NewServer(dgrpc.WithLogger(a.zlogger))
 
+	var options []mindreader.MindReaderPluginOption
+	if a.modules.BlockLineParser != nil {
+		options = append(options, mindreader.WithBlockLineParser(a.modules.BlockLineParser))
+	}
+	if a.Config.ContinuityAllowSkips != 0 {
+		options = append(options, mindreader.WithContinuityAllowedSkips(a.Config.ContinuityAllowSkips))
+	}
+	if a.Config.ContinuityOnGap != "" {
+		options = append(options, mindreader.WithContinuityGapPolicy(mindreader.ContinuityGapPolicy(a.Config.ContinuityOnGap)))
+	}
+	if a.Config.OutputFileMode != 0 || a.Config.OutputFileOwner != "" || a.Config.OutputFileGroup != "" {
+		options = append(options, mindreader.WithOutputFilePermissions(a.Config.OutputFileMode, a.Config.OutputFileOwner, a.Config.OutputFileGroup))
+	}
+	if a.Config.ContentHashBundleNames {
+		options = append(options, mindreader.WithContentHashBundleNames())
+	}
+	if a.Config.OrderedBundleCommits {
+		options = append(options, mindreader.WithOrderedBundleCommits())
+	}
+	if a.Config.BlockHubBufferSize != 0 {
+		options = append(options, mindreader.WithBlockHub(mindreader.NewBlockHub(gs, a.Config.BlockHubBufferSize, a.Config.BlockHubBurstSize, a.zlogger)))
+	}
+
 	a.zlogger.Info("launching mindreader plugin")
 	mindreaderLogPlugin, err := mindreader.NewMindReaderPlugin(
 		a.Config.ArchiveStoreURL,
@@ -98,11 +180,24 @@ func (a *App) Run() error {
 		a.Config.OneblockSuffix,
 		nil,
 		a.zlogger,
+		options...,
 	)
 	if err != nil {
 		return err
 	}
 
+	if a.Config.ResumeFromStore {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		startBlockNum, err := mindreaderLogPlugin.ResumeFromMergedStore(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("unable to resume from the merged store: %w", err)
+		}
+		if a.Config.StopBlockNum != 0 && startBlockNum > a.Config.StopBlockNum {
+			a.zlogger.Warn("merged store already goes past the stop block, every block will be discarded", zap.Uint64("start_block_num", startBlockNum), zap.Uint64("stop_block_num", a.Config.StopBlockNum))
+		}
+	}
+
 	a.zlogger.Debug("configuring shutter")
 	mindreaderLogPlugin.OnTerminated(a.Shutdown)
 	a.OnTerminating(mindreaderLogPlugin.Shutdown)
@@ -124,10 +219,28 @@ func (a *App) Run() error {
 
 	go a.modules.MetricsAndReadinessManager.Launch()
 
-	stdin := bufio.NewReader(os.Stdin)
 	go func() {
+		var input io.Reader = os.Stdin
+		if a.Config.ReplayReadAheadBytes != 0 {
+			a.zlogger.Info("reading stdin ahead", zap.Int("replay_read_ahead_bytes", a.Config.ReplayReadAheadBytes))
+			input = mindreader.NewReadAheadReader(os.Stdin, a.Config.ReplayReadAheadBytes, mindreaderLogPlugin.Terminating())
+		}
+
+		stdin, err := a.newInputReader(input)
+		if err != nil {
+			a.zlogger.Error("unable to read from stdin", zap.Error(err))
+			mindreaderLogPlugin.Shutdown(err)
+			return
+		}
+
 		a.zlogger.Info("starting stdin reader")
 		for {
+			if mindreaderLogPlugin.IsTerminating() {
+				// like once the stop block is reached, the rest would be discarded
+				a.zlogger.Info("mindreader is terminating, done reading from stdin")
+				return
+			}
+
 			in, err := stdin.ReadString('\n')
 			if err != nil {
 				if err != io.EOF {
@@ -150,6 +263,32 @@ func (a *App) Run() error {
 	return nil
 }
 
+// newInputReader wraps the input into a buffered reader, transparently
+// decompressing it when it is gzipped and `AllowCompressedBlockLog` is set.
+func (a *App) newInputReader(in io.Reader) (*bufio.Reader, error) {
+	reader := bufio.NewReader(in)
+	if !a.Config.AllowCompressedBlockLog {
+		return reader, nil
+	}
+
+	magic, err := reader.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to peek at input: %w", err)
+	}
+
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return reader, nil
+	}
+
+	a.zlogger.Info("input is gzip compressed, decompressing it on the fly")
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read gzip input: %w", err)
+	}
+
+	return bufio.NewReader(gzipReader), nil
+}
+
 func (a *App) OnReady(f func()) {
 	a.ReadyFunc = f
 }
@@ -0,0 +1,62 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_mindreader_stdin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestApp_NewInputReader(t *testing.T) {
+	lines := "DMLOG BLOCK 1\nDMLOG BLOCK 2\n"
+
+	gzipped := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(gzipped)
+	_, err := gzipWriter.Write([]byte(lines))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	tests := []struct {
+		name          string
+		allowCompress bool
+		input         []byte
+		expected      []byte
+	}{
+		{"gzipped", true, gzipped.Bytes(), []byte(lines)},
+		{"plain", true, []byte(lines), []byte(lines)},
+		{"plain shorter than the magic", true, []byte("D"), []byte("D")},
+		{"empty", true, nil, []byte{}},
+		{"gzipped without detection", false, gzipped.Bytes(), gzipped.Bytes()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New(&Config{AllowCompressedBlockLog: test.allowCompress}, &Modules{}, zap.NewNop())
+
+			reader, err := a.newInputReader(bytes.NewReader(test.input))
+			require.NoError(t, err)
+
+			out, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, out)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// QueryNodeosHeadBlockNum returns the head block of the nodeos instance
+// serving its chain API at `apiURL`, like an upstream node or a peer.
+func QueryNodeosHeadBlockNum(apiURL string, timeout time.Duration) (uint64, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(strings.TrimSuffix(apiURL, "/") + "/v1/chain/get_info")
+	if err != nil {
+		return 0, fmt.Errorf("unable to query reference node %q: %w", apiURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("reference node %q answered with status %d", apiURL, resp.StatusCode)
+	}
+
+	info := &struct {
+		HeadBlockNum uint64 `json:"head_block_num"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return 0, fmt.Errorf("invalid answer from reference node %q: %w", apiURL, err)
+	}
+	return info.HeadBlockNum, nil
+}
+
+// MonitorBlocksBehind compares the head block of the node against the
+// reference head returned by `reference` (like `QueryNodeosHeadBlockNum` on
+// an upstream node) every `interval`. While it is more than `maxBehind`
+// blocks behind, the manager reports itself degraded. While the reference is
+// unavailable, the lag is unknown and not held against the node. It blocks
+// until `terminating` is closed.
+func (m *MetricsAndReadinessManager) MonitorBlocksBehind(maxBehind uint64, interval time.Duration, reference func() (uint64, error), terminating <-chan struct{}) {
+	m.blocksBehindLock.Lock()
+	m.maxBlocksBehind = maxBehind
+	m.blocksBehindLock.Unlock()
+
+	for {
+		m.checkBlocksBehind(reference)
+
+		select {
+		case <-terminating:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (m *MetricsAndReadinessManager) checkBlocksBehind(reference func() (uint64, error)) {
+	referenceNum, err := reference()
+	if err != nil {
+		if m.setBlocksBehind(0, false) {
+			m.logger.Warn("reference head unavailable, blocks behind unknown until it answers again", zap.Error(err))
+		}
+		return
+	}
+
+	localNum, _, _ := m.HeadBlock()
+	if localNum == 0 {
+		m.setBlocksBehind(0, false)
+		return
+	}
+
+	var behind uint64
+	if referenceNum > localNum {
+		behind = referenceNum - localNum
+	}
+	metrics.BlocksBehind.SetUint64(behind)
+
+	wasBehind := m.TooManyBlocksBehind()
+	m.setBlocksBehind(behind, true)
+	switch tooMany := m.TooManyBlocksBehind(); {
+	case tooMany && !wasBehind:
+		m.logger.Warn("node is too many blocks behind its reference", zap.Uint64("blocks_behind", behind), zap.Uint64("reference_block_num", referenceNum), zap.Uint64("head_block_num", localNum))
+	case !tooMany && wasBehind:
+		m.logger.Info("node caught up with its reference", zap.Uint64("blocks_behind", behind))
+	}
+}
+
+// setBlocksBehind returns whether the lag was known before, when it becomes
+// unknown
+func (m *MetricsAndReadinessManager) setBlocksBehind(behind uint64, known bool) (wasKnown bool) {
+	m.blocksBehindLock.Lock()
+	defer m.blocksBehindLock.Unlock()
+
+	wasKnown = m.blocksBehindKnown
+	m.blocksBehind = behind
+	m.blocksBehindKnown = known
+	return wasKnown
+}
+
+// TooManyBlocksBehind returns whether the node was last found more than the
+// max blocks of `MonitorBlocksBehind` behind its reference.
+func (m *MetricsAndReadinessManager) TooManyBlocksBehind() bool {
+	m.blocksBehindLock.Lock()
+	defer m.blocksBehindLock.Unlock()
+
+	return m.maxBlocksBehind != 0 && m.blocksBehindKnown && m.blocksBehind > m.maxBlocksBehind
+}
+
+func (m *MetricsAndReadinessManager) blocksBehindReason() string {
+	if !m.TooManyBlocksBehind() {
+		return ""
+	}
+
+	m.blocksBehindLock.Lock()
+	defer m.blocksBehindLock.Unlock()
+	return fmt.Sprintf("%d blocks behind the reference head (max %d)", m.blocksBehind, m.maxBlocksBehind)
+}
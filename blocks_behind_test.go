@@ -0,0 +1,71 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryNodeosHeadBlockNum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chain/get_info", r.URL.Path)
+		_, _ = w.Write([]byte(`{"chain_id":"abc","head_block_num":1234}`))
+	}))
+	defer server.Close()
+
+	num, err := QueryNodeosHeadBlockNum(server.URL+"/", 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1234), num)
+}
+
+func TestMetricsAndReadinessManager_BlocksBehind(t *testing.T) {
+	m := NewMetricsAndReadinessManager(nil, nil, time.Minute)
+	m.lastSeenBlock = &headBlock{Num: 1000}
+
+	var referenceNum uint64 = 1100
+	var referenceErr error
+	reference := func() (uint64, error) { return referenceNum, referenceErr }
+
+	terminating := make(chan struct{})
+	close(terminating)
+	m.MonitorBlocksBehind(50, time.Minute, reference, terminating)
+	assert.True(t, m.TooManyBlocksBehind())
+	assert.Contains(t, m.DegradedReason(), "100 blocks behind the reference head (max 50)")
+
+	// an unavailable reference is not held against the node
+	referenceErr = errors.New("connection refused")
+	m.checkBlocksBehind(reference)
+	assert.False(t, m.TooManyBlocksBehind())
+	assert.Equal(t, "", m.DegradedReason())
+
+	referenceErr = nil
+	referenceNum = 1020
+	m.checkBlocksBehind(reference)
+	assert.False(t, m.TooManyBlocksBehind())
+
+	// both reasons are reported
+	referenceNum = 2000
+	m.checkBlocksBehind(reference)
+	m.MonitorClockSkew(time.Second, time.Minute, func() (time.Duration, error) { return 2 * time.Second, nil }, terminating)
+	assert.Contains(t, m.DegradedReason(), "host clock skewed by 2s")
+	assert.Contains(t, m.DegradedReason(), "; 1000 blocks behind")
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the Unix one
+const ntpEpochOffset = 2208988800
+
+// QueryNTPClockSkew returns the offset of the host clock from the clock of
+// the NTP `server` (`host:port`), positive when the host is ahead.
+func QueryNTPClockSkew(server string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, fmt.Errorf("unable to reach ntp server %q: %w", server, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	request := make([]byte, 48)
+	request[0] = 0x1b // no leap indicator, version 3, client mode
+	sentAt := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, fmt.Errorf("unable to query ntp server %q: %w", server, err)
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read ntp server %q response: %w", server, err)
+	}
+	receivedAt := time.Now()
+	if n < 48 {
+		return 0, fmt.Errorf("ntp server %q response too short, %d bytes", server, n)
+	}
+
+	serverReceivedAt := ntpTime(response[32:40])
+	serverSentAt := ntpTime(response[40:48])
+	if serverSentAt.IsZero() {
+		return 0, fmt.Errorf("ntp server %q did not return its time", server)
+	}
+
+	offset := (serverReceivedAt.Sub(sentAt) + serverSentAt.Sub(receivedAt)) / 2
+	return -offset, nil
+}
+
+func ntpTime(data []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(data[0:4])
+	fraction := binary.BigEndian.Uint32(data[4:8])
+	if seconds == 0 && fraction == 0 {
+		return time.Time{}
+	}
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+// MonitorClockSkew measures the skew of the host clock with `measure` every
+// `interval`, like with `QueryNTPClockSkew` or against the block times of
+// peers. While it is beyond `maxSkew`, the head block drift is not trusted:
+// the readiness keeps its state instead of being evaluated from it, and the
+// manager reports itself degraded. It blocks until `terminating` is closed,
+// failed measures keep the last skew.
+func (m *MetricsAndReadinessManager) MonitorClockSkew(maxSkew, interval time.Duration, measure func() (time.Duration, error), terminating <-chan struct{}) {
+	m.clockSkewLock.Lock()
+	m.maxClockSkew = maxSkew
+	m.clockSkewLock.Unlock()
+
+	for {
+		skew, err := measure()
+		if err != nil {
+			m.logger.Warn("unable to measure clock skew, keeping the last one", zap.Error(err))
+		} else {
+			m.setClockSkew(skew)
+		}
+
+		select {
+		case <-terminating:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (m *MetricsAndReadinessManager) setClockSkew(skew time.Duration) {
+	metrics.ClockSkew.SetFloat64(skew.Seconds())
+
+	wasSkewed := m.ClockSkewed()
+	m.clockSkewLock.Lock()
+	m.clockSkew = skew
+	maxSkew := m.maxClockSkew
+	m.clockSkewLock.Unlock()
+
+	switch skewed := m.ClockSkewed(); {
+	case skewed && !wasSkewed:
+		m.logger.Warn("host clock is skewed, not trusting the head block drift for readiness", zap.Duration("skew", skew), zap.Duration("max_clock_skew", maxSkew))
+	case !skewed && wasSkewed:
+		m.logger.Info("host clock is not skewed anymore", zap.Duration("skew", skew))
+	}
+}
+
+// ClockSkewed returns whether the last measured skew of the host clock is
+// beyond the max one of `MonitorClockSkew`.
+func (m *MetricsAndReadinessManager) ClockSkewed() bool {
+	m.clockSkewLock.Lock()
+	defer m.clockSkewLock.Unlock()
+
+	if m.maxClockSkew == 0 {
+		return false
+	}
+	skew := m.clockSkew
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew > m.maxClockSkew
+}
+
+func (m *MetricsAndReadinessManager) clockSkewReason() string {
+	if !m.ClockSkewed() {
+		return ""
+	}
+
+	m.clockSkewLock.Lock()
+	defer m.clockSkewLock.Unlock()
+	return fmt.Sprintf("host clock skewed by %s (max %s), head block drift not trusted for readiness", m.clockSkew, m.maxClockSkew)
+}
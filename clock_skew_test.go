@@ -0,0 +1,81 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryNTPClockSkew(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// a server whose clock is 10 seconds behind
+	go func() {
+		request := make([]byte, 48)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		response := make([]byte, 48)
+		now := time.Now().Add(-10 * time.Second)
+		binary.BigEndian.PutUint32(response[32:36], uint32(now.Unix()+ntpEpochOffset))
+		binary.BigEndian.PutUint32(response[40:44], uint32(now.Unix()+ntpEpochOffset))
+		_, _ = conn.WriteTo(response, addr)
+	}()
+
+	skew, err := QueryNTPClockSkew(conn.LocalAddr().String(), 5*time.Second)
+	require.NoError(t, err)
+	assert.True(t, skew > 8*time.Second && skew < 12*time.Second, "skew %s", skew)
+}
+
+func TestMetricsAndReadinessManager_ClockSkew(t *testing.T) {
+	m := NewMetricsAndReadinessManager(nil, nil, time.Minute)
+	assert.False(t, m.ClockSkewed())
+
+	terminating := make(chan struct{})
+	close(terminating)
+	m.MonitorClockSkew(time.Second, time.Minute, func() (time.Duration, error) { return -2 * time.Second, nil }, terminating)
+	assert.True(t, m.ClockSkewed())
+	assert.Contains(t, m.DegradedReason(), "host clock skewed by -2s")
+
+	m.setClockSkew(500 * time.Millisecond)
+	assert.False(t, m.ClockSkewed())
+	assert.Equal(t, "", m.DegradedReason())
+}
+
+func TestMetricsAndReadinessManager_ReadinessWhileClockSkewed(t *testing.T) {
+	m := NewMetricsAndReadinessManager(nil, nil, time.Minute)
+	m.maxClockSkew = time.Second
+	m.setClockSkew(time.Hour)
+
+	// a head block time looking recent is not trusted, the node stalled
+	m.updateReadiness(time.Now(), time.Now().Add(-2*time.Minute))
+	assert.False(t, m.IsReady())
+
+	m.updateReadiness(time.Now().Add(-2*time.Hour), time.Now())
+	assert.True(t, m.IsReady())
+
+	m.setClockSkew(0)
+	m.updateReadiness(time.Now().Add(-2*time.Hour), time.Now())
+	assert.False(t, m.IsReady())
+}
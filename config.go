@@ -0,0 +1,104 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadConfigFile decodes the YAML (or JSON, which is valid YAML) file at
+// `path` into `config`, fields already set on `config` are kept as defaults.
+// Unknown fields are rejected, durations are written like `30s`.
+func LoadConfigFile(path string, config interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file %q: %w", path, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return fmt.Errorf("unable to decode config file %q: %w", path, err)
+	}
+	return nil
+}
+
+// ConfigValidator accumulates the problems found in a configuration, so
+// they can all be reported at once.
+type ConfigValidator struct {
+	problems []string
+}
+
+func (v *ConfigValidator) Check(valid bool, format string, args ...interface{}) {
+	if !valid {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// Addr checks that `addr` is a `host:port` address, empty is accepted unless `required`
+func (v *ConfigValidator) Addr(field, addr string, required bool) {
+	if addr == "" {
+		v.Check(!required, "%s is required", field)
+		return
+	}
+
+	_, _, err := net.SplitHostPort(addr)
+	v.Check(err == nil, "%s %q is not a valid address: %s", field, addr, err)
+}
+
+func (v *ConfigValidator) NonNegative(field string, d time.Duration) {
+	v.Check(d >= 0, "%s cannot be negative, got %s", field, d)
+}
+
+// HostnameMatch checks that `match` is usable by `MatchHostname`
+func (v *ConfigValidator) HostnameMatch(field, match string) {
+	if match == "" {
+		return
+	}
+
+	_, err := hostnameRegexp(match)
+	v.Check(err == nil, "%s %q is not a valid regular expression: %s", field, match, err)
+}
+
+func (v *ConfigValidator) Err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(v.problems, "; "))
+}
+
+// MatchHostname tells if `hostname` is `match` or, taken as a regular
+// expression, matches it entirely (like `node-[02]` for the first and third
+// ordinals of a stateful set).
+func MatchHostname(match, hostname string) (bool, error) {
+	if match == hostname {
+		return true, nil
+	}
+
+	re, err := hostnameRegexp(match)
+	if err != nil {
+		return false, fmt.Errorf("invalid hostname match %q: %w", match, err)
+	}
+	return re.MatchString(hostname), nil
+}
+
+func hostnameRegexp(match string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + match + ")$")
+}
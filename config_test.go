@@ -0,0 +1,93 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Addr   string        `yaml:"addr"`
+	Period time.Duration `yaml:"period"`
+	Size   int           `yaml:"size"`
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	c := &testConfig{Size: 10}
+	require.NoError(t, LoadConfigFile(writeTestConfig(t, `{"addr": ":8080", "period": "30s"}`), c))
+	assert.Equal(t, &testConfig{Addr: ":8080", Period: 30 * time.Second, Size: 10}, c)
+
+	c = &testConfig{}
+	require.NoError(t, LoadConfigFile(writeTestConfig(t, "addr: localhost:9000\nsize: 3\n"), c))
+	assert.Equal(t, &testConfig{Addr: "localhost:9000", Size: 3}, c)
+
+	assert.Error(t, LoadConfigFile(writeTestConfig(t, "unknown: true\n"), &testConfig{}))
+}
+
+func TestConfigValidator(t *testing.T) {
+	v := &ConfigValidator{}
+	v.Addr("addr", ":8080", true)
+	v.NonNegative("period", time.Second)
+	assert.NoError(t, v.Err())
+
+	v = &ConfigValidator{}
+	v.Addr("addr", "", true)
+	v.Addr("other_addr", "localhost", false)
+	v.NonNegative("period", -time.Second)
+	err := v.Err()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "addr is required")
+	assert.Contains(t, err.Error(), `other_addr "localhost" is not a valid address`)
+	assert.Contains(t, err.Error(), "period cannot be negative")
+}
+
+func TestMatchHostname(t *testing.T) {
+	tests := []struct {
+		match    string
+		hostname string
+		expected bool
+	}{
+		{"node-0", "node-0", true},
+		{"node-0", "node-01", false},
+		{"node-[02]", "node-2", true},
+		{"node-[02]", "node-1", false},
+		{"node-.*", "other-node-1", false},
+	}
+
+	for _, test := range tests {
+		matches, err := MatchHostname(test.match, test.hostname)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, matches, "%q against %q", test.match, test.hostname)
+	}
+
+	_, err := MatchHostname("node-[", "node-0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dfuse-io/node-manager/operator"
+	"go.uber.org/zap"
+)
+
+// previousBackup is the latest complete backup, whose unchanged files are
+// hardlinked instead of copied when `LocalBackupDedup` is enabled.
+type previousBackup struct {
+	name  string
+	files map[string]*operator.ManifestFile
+}
+
+// loadPreviousBackup reads the file list of the latest complete backup from
+// its completion marker, nil means every file is copied.
+func (m *Module) loadPreviousBackup(ctx context.Context) *previousBackup {
+	names, err := m.List(nil)
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+	name := names[len(names)-1]
+
+	reader, err := m.store.OpenObject(ctx, name+completeSuffix)
+	if err != nil {
+		m.log().Warn("unable to read previous backup marker, copying every file", zap.String("backup_name", name), zap.Error(err))
+		return nil
+	}
+	defer reader.Close()
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil || len(content) == 0 {
+		return nil // taken by an older version, nothing to link against
+	}
+
+	var files []*operator.ManifestFile
+	if err := json.Unmarshal(content, &files); err != nil {
+		m.log().Warn("invalid previous backup marker, copying every file", zap.String("backup_name", name), zap.Error(err))
+		return nil
+	}
+
+	previous := &previousBackup{name: name, files: make(map[string]*operator.ManifestFile, len(files))}
+	for _, file := range files {
+		previous.files[file.Path] = file
+	}
+	return previous
+}
+
+// linkFile hardlinks the objects of `file` from the previous backup into
+// backup `name` if the file did not change, it returns false when the file
+// must be copied.
+func (m *Module) linkFile(previous *previousBackup, name string, file *operator.ManifestFile) ([]string, bool, error) {
+	if previous == nil {
+		return nil, false, nil
+	}
+
+	previousFile := previous.files[file.Path]
+	if previousFile == nil || previousFile.Size != file.Size || previousFile.SHA256 != file.SHA256 {
+		return nil, false, nil
+	}
+
+	// the file is either a single object or split in parts, named after it
+	sourceDir := filepath.Dir(m.localStore.ObjectPath(path.Join(previous.name, file.Path)))
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	base := path.Base(file.Path)
+	var objectNames []string
+	for _, entry := range entries {
+		if entry.Name() != base && !strings.HasPrefix(entry.Name(), base+partSuffix) {
+			continue
+		}
+
+		objectName := path.Join(name, path.Dir(file.Path), entry.Name())
+		target := m.localStore.ObjectPath(objectName)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return objectNames, true, err
+		}
+		if err := os.Link(filepath.Join(sourceDir, entry.Name()), target); err != nil {
+			return objectNames, true, fmt.Errorf("unable to link %q from previous backup: %w", file.Path, err)
+		}
+		objectNames = append(objectNames, objectName)
+	}
+
+	return objectNames, len(objectNames) != 0, nil
+}
@@ -0,0 +1,872 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/abourget/llerrgroup"
+	"github.com/dfuse-io/dstore"
+	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/operator"
+	"github.com/dfuse-io/shutter"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// completeSuffix marks a backup whose files were all uploaded, it is written
+// last as a sibling of the backup's files.
+const completeSuffix = ".complete"
+
+// pinnedSuffix marks a backup that retention must never delete, it is a
+// sibling of the backup's files like the completion marker.
+const pinnedSuffix = ".pinned"
+
+// partSuffix is appended, with the part index, to the objects of files
+// uploaded in several parts
+const partSuffix = ".dirbackup-part-"
+
+// backupNameRegex matches the last path segment of a backup name
+var backupNameRegex = regexp.MustCompile(`^\d{10}-(\d{8}T\d{6}Z)$`)
+
+type Config struct {
+	SourceDir               string // directory backed up and restored into, like the chain's data directory, defaults to the `data-dir` of `NodeConfigFile`
+	StoreURL                string // dstore URL backups are written to
+	BackupUploadConcurrency int    // number of files uploaded in parallel, defaults to 1
+
+	RestoreDownloadConcurrency int // number of files downloaded in parallel when restoring, defaults to 1
+
+	PartSize              int64         // files bigger than this are uploaded in parts of that size, each retried on its own (0 disables it)
+	UploadRetries         int           // number of times a failed file or part upload is retried before failing the backup
+	AbandonedBackupMaxAge time.Duration // incomplete backups older than this are deleted when the module is created (0 disables it)
+
+	// If non-zero, new file or part uploads wait while the ones in flight add up to more than that many bytes, the store
+	// may buffer whole objects in memory. A file or part bigger than that is uploaded alone.
+	MaxUploadInflightBytes int64
+
+	RetainBackups         int           // after each backup, only that many of the most recent unpinned backups are kept (0 keeps them all)
+	PruneDelayAfterBackup time.Duration // waits that long after a backup before pruning, in the background, for eventually consistent stores
+
+	LocalBackupDedup bool // hardlinks the files unchanged since the previous backup instead of copying them, `StoreURL` must be a local directory
+
+	Components map[string][]string // paths, relative to `SourceDir`, making up each component restorable on its own, defaults to `DefaultComponents`
+
+	// Glob patterns (see `operator.MatchExcludePattern`) of the files left out of the backups, like sockets or scratch
+	// files, `*.sock` matching at any depth and `state/*.tmp` relative to `SourceDir`. They are recorded in the manifest.
+	BackupExcludePatterns []string
+
+	// Go template of the path of each backup under the operator's prefix, like `{{.ChainID}}/{{.Date}}/{{.Name}}`,
+	// see `BackupPathData` for the available fields. It must end with `{{.Name}}`, defaults to the name alone.
+	BackupPathTemplate string
+
+	NodeConfigFile string // nodeos `config.ini`, its `data-dir` is used when `SourceDir` is not set, and checked against it otherwise
+}
+
+// DefaultComponents matches the layout of a nodeos data directory.
+var DefaultComponents = map[string][]string{
+	"blocks_log": {"blocks"},
+	"state":      {"state"},
+}
+
+// Module backs up a local directory by uploading each of its files to a
+// dstore. A backup is named after the block it was taken at, under the
+// optional prefix set by the operator. It is shut down along with the
+// operator it is registered to, canceling the delayed prunes.
+type Module struct {
+	*shutter.Shutter
+
+	config *Config
+	store  dstore.Store
+	prefix string
+
+	localStore   *dstore.LocalStore // set when `LocalBackupDedup` is enabled
+	pathTemplate *template.Template // set when `BackupPathTemplate` is
+	chainID      string
+	hostname     string
+	progress     operator.ProgressReporter
+	inflight     *inflightBytes
+	pruneLock    sync.Mutex
+	logger       *zap.Logger
+
+	operationLoggerLock sync.Mutex
+	operationLogger     *zap.Logger // of the operator's operation in progress, see `log`
+}
+
+func New(config *Config, logger *zap.Logger) (*Module, error) {
+	if config.NodeConfigFile != "" {
+		if err := applyNodeConfig(config, logger); err != nil {
+			return nil, err
+		}
+	}
+	if config.SourceDir == "" {
+		return nil, fmt.Errorf("no source directory to back up, set it or a node config file with a `data-dir`")
+	}
+
+	for _, pattern := range config.BackupExcludePatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid backup exclude pattern %q: %w", pattern, err)
+		}
+	}
+
+	store, err := dstore.NewStore(config.StoreURL, "", "", false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create backup store: %w", err)
+	}
+
+	m := &Module{
+		Shutter:  shutter.New(),
+		config:   config,
+		store:    store,
+		inflight: newInflightBytes(config.MaxUploadInflightBytes),
+		logger:   logger,
+	}
+
+	if config.BackupPathTemplate != "" {
+		if m.pathTemplate, err = parseBackupPathTemplate(config.BackupPathTemplate); err != nil {
+			return nil, err
+		}
+		if m.hostname, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("unable to get hostname for backup path template: %w", err)
+		}
+	}
+
+	if config.LocalBackupDedup {
+		localStore, ok := store.(*dstore.LocalStore)
+		if !ok {
+			return nil, fmt.Errorf("local backup deduplication requires a local store, got %q", config.StoreURL)
+		}
+		m.localStore = localStore
+	}
+
+	if config.AbandonedBackupMaxAge != 0 {
+		if err := m.deleteAbandonedBackups(time.Now().Add(-config.AbandonedBackupMaxAge)); err != nil {
+			logger.Warn("unable to clean up abandoned backups", zap.Error(err))
+		}
+	}
+
+	return m, nil
+}
+
+// applyNodeConfig defaults `SourceDir` to the node's data directory, the two
+// disagreeing means backing up something else than the node's data.
+func applyNodeConfig(config *Config, logger *zap.Logger) error {
+	nodeConfig, err := nodeManager.ReadNodeosConfig(config.NodeConfigFile)
+	if err != nil {
+		return err
+	}
+	if nodeConfig.DataDir == "" {
+		logger.Info("node config file sets no data dir", zap.String("node_config_file", config.NodeConfigFile))
+		return nil
+	}
+
+	if config.SourceDir == "" {
+		logger.Info("auto-detected source dir from node config file", zap.String("node_config_file", config.NodeConfigFile), zap.String("source_dir", nodeConfig.DataDir))
+		config.SourceDir = nodeConfig.DataDir
+		return nil
+	}
+
+	if filepath.Clean(config.SourceDir) != filepath.Clean(nodeConfig.DataDir) {
+		logger.Warn("source dir differs from the data dir of the node config file, make sure the right directory is backed up",
+			zap.String("source_dir", config.SourceDir),
+			zap.String("node_data_dir", nodeConfig.DataDir),
+			zap.String("node_config_file", config.NodeConfigFile),
+		)
+	}
+	return nil
+}
+
+func (m *Module) RequiresStop() bool {
+	return true
+}
+
+func (m *Module) BackupRoot() string {
+	return m.config.SourceDir
+}
+
+func (m *Module) BackupExcludePatterns() []string {
+	return m.config.BackupExcludePatterns
+}
+
+func (m *Module) SetBackupPrefix(prefix string) {
+	m.prefix = prefix
+}
+
+func (m *Module) SetChainID(chainID string) {
+	m.chainID = chainID
+}
+
+func (m *Module) SetOperationLogger(logger *zap.Logger) {
+	m.operationLoggerLock.Lock()
+	defer m.operationLoggerLock.Unlock()
+	m.operationLogger = logger
+}
+
+// log returns the logger of the operator's operation in progress, if any
+func (m *Module) log() *zap.Logger {
+	m.operationLoggerLock.Lock()
+	defer m.operationLoggerLock.Unlock()
+	if m.operationLogger != nil {
+		return m.operationLogger
+	}
+	return m.logger
+}
+
+func (m *Module) SetProgressReporter(reporter operator.ProgressReporter) {
+	m.progress = reporter
+}
+
+func (m *Module) Backup(lastSeenBlockNum uint32) (string, error) {
+	return m.BackupWithContext(context.Background(), lastSeenBlockNum, nil)
+}
+
+func (m *Module) BackupWithContext(ctx context.Context, lastSeenBlockNum uint32, _ map[string]string) (string, error) {
+	takenAt := time.Now().UTC()
+	name, err := m.backupPath(fmt.Sprintf("%010d-%s", lastSeenBlockNum, takenAt.Format("20060102T150405Z")), takenAt)
+	if err != nil {
+		return "", err
+	}
+
+	files, excluded, err := operator.ListManifestFilesExcluding(m.config.SourceDir, m.config.BackupExcludePatterns)
+	if err != nil {
+		return "", err
+	}
+	if len(excluded) != 0 {
+		var excludedBytes int64
+		for _, file := range excluded {
+			excludedBytes += file.Size
+		}
+		m.log().Info("excluded files from backup", zap.String("backup_name", name), zap.Int("file_count", len(excluded)), zap.Int64("bytes", excludedBytes), zap.Strings("patterns", m.config.BackupExcludePatterns))
+	}
+
+	var previous *previousBackup
+	if m.localStore != nil {
+		previous = m.loadPreviousBackup(ctx)
+	}
+
+	m.log().Info("backing up directory", zap.String("backup_name", name), zap.String("source_dir", m.config.SourceDir), zap.Int("file_count", len(files)))
+	uploaded, err := m.uploadFiles(ctx, name, files, previous)
+	if err != nil {
+		m.deleteObjects(uploaded)
+		return "", fmt.Errorf("backup %q failed: %w", name, err)
+	}
+
+	// the marker lists the files, so restores can verify them and the next backup knows what it can link
+	marker, err := json.Marshal(files)
+	if err != nil {
+		m.deleteObjects(uploaded)
+		return "", err
+	}
+
+	if err := m.store.WriteObject(ctx, name+completeSuffix, bytes.NewReader(marker)); err != nil {
+		m.deleteObjects(uploaded)
+		return "", fmt.Errorf("unable to mark backup %q as complete: %w", name, err)
+	}
+
+	if m.config.RetainBackups > 0 {
+		if m.config.PruneDelayAfterBackup > 0 {
+			go m.pruneBackupsAfter(name, m.config.PruneDelayAfterBackup)
+		} else {
+			m.pruneBackupsAfter(name, 0)
+		}
+	}
+
+	return name, nil
+}
+
+// uploadFiles returns the objects written so far, even on failure, so they can be cleaned up
+func (m *Module) uploadFiles(ctx context.Context, name string, files []*operator.ManifestFile, previous *previousBackup) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := m.config.BackupUploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var lock sync.Mutex
+	var uploaded []string
+
+	progress := &uploadProgress{done: atomic.NewInt64(0), reporter: m.progress}
+	for _, file := range files {
+		progress.total += file.Size
+	}
+
+	eg := llerrgroup.New(concurrency)
+	for _, file := range files {
+		if eg.Stop() || ctx.Err() != nil {
+			break
+		}
+
+		file := file
+		eg.Go(func() error {
+			objectNames, linked, err := m.linkFile(previous, name, file)
+			if linked && err == nil {
+				progress.add(file.Size)
+			}
+			if !linked {
+				objectNames, err = m.uploadFile(ctx, filepath.Join(m.config.SourceDir, filepath.FromSlash(file.Path)), path.Join(name, file.Path), file.Size, progress)
+			}
+
+			lock.Lock()
+			uploaded = append(uploaded, objectNames...)
+			lock.Unlock()
+
+			if err != nil {
+				cancel() // first failure cancels the uploads in flight
+			}
+			return err
+		})
+	}
+
+	err := eg.Wait()
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return uploaded, err
+}
+
+// uploadFile returns the objects written, files bigger than `PartSize` are
+// split in parts so that a failure only retries the part that failed.
+func (m *Module) uploadFile(ctx context.Context, localFile, objectName string, size int64, progress *uploadProgress) ([]string, error) {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if m.config.PartSize <= 0 || size <= m.config.PartSize {
+		if err := m.uploadWithRetries(ctx, objectName, io.NewSectionReader(f, 0, size)); err != nil {
+			return nil, fmt.Errorf("unable to upload %q: %w", localFile, err)
+		}
+		progress.add(size)
+		return []string{objectName}, nil
+	}
+
+	var objectNames []string
+	for part, offset := 0, int64(0); offset < size; part, offset = part+1, offset+m.config.PartSize {
+		partName := fmt.Sprintf("%s%s%05d", objectName, partSuffix, part)
+		partSize := m.config.PartSize
+		if size-offset < partSize {
+			partSize = size - offset
+		}
+		section := io.NewSectionReader(f, offset, partSize)
+		if err := m.uploadWithRetries(ctx, partName, section); err != nil {
+			return objectNames, fmt.Errorf("unable to upload part %d of %q: %w", part, localFile, err)
+		}
+		progress.add(section.Size())
+		objectNames = append(objectNames, partName)
+	}
+	return objectNames, nil
+}
+
+// uploadProgress sums up the bytes uploaded by the workers of a backup
+type uploadProgress struct {
+	done     *atomic.Int64
+	total    int64
+	reporter operator.ProgressReporter
+}
+
+func (p *uploadProgress) add(bytes int64) {
+	done := p.done.Add(bytes)
+	if p.reporter != nil {
+		p.reporter(done, p.total)
+	}
+}
+
+func (m *Module) uploadWithRetries(ctx context.Context, objectName string, section *io.SectionReader) (err error) {
+	for attempt := 0; attempt <= m.config.UploadRetries; attempt++ {
+		if attempt > 0 {
+			m.log().Info("retrying upload", zap.String("object", objectName), zap.Int("attempt", attempt), zap.Error(err))
+		}
+
+		if err = m.inflight.acquire(ctx, section.Size()); err != nil {
+			return err
+		}
+		err = m.writeSection(ctx, objectName, section)
+		m.inflight.release(section.Size())
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if err = operator.WrapStoreFullError(err); errors.Is(err, operator.ErrBackupStoreFull) {
+			return err // retrying does not free any space
+		}
+	}
+	return err
+}
+
+func (m *Module) writeSection(ctx context.Context, objectName string, section *io.SectionReader) error {
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return m.store.WriteObject(ctx, objectName, section)
+}
+
+func (m *Module) deleteObjects(objectNames []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	m.log().Info("deleting backup objects", zap.Int("object_count", len(objectNames)))
+	for _, objectName := range objectNames {
+		if err := m.store.DeleteObject(ctx, objectName); err != nil {
+			m.log().Warn("unable to delete object of partial backup", zap.String("object", objectName), zap.Error(err))
+		}
+	}
+}
+
+// List returns the complete backups under the module's prefix, oldest first.
+func (m *Module) List(_ map[string]string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	walkPrefix := ""
+	if m.prefix != "" {
+		walkPrefix = m.prefix + "/"
+	}
+
+	var names []string
+	err := m.store.Walk(ctx, walkPrefix, "", func(filename string) error {
+		if strings.HasSuffix(filename, completeSuffix) {
+			names = append(names, strings.TrimSuffix(filename, completeSuffix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backups: %w", err)
+	}
+
+	sortBackupNames(names)
+	return names, nil
+}
+
+// Restore replaces the content of the source directory with the files of
+// backup `name`, "latest" being the most recent complete backup.
+func (m *Module) Restore(name string) error {
+	return m.RestoreComponents(name, nil)
+}
+
+// RestoreComponents replaces only the paths of the given `components` in the
+// source directory with their files from backup `name`, leaving the rest of
+// it untouched. The whole directory is replaced when `components` is empty or
+// holds "all". Each requested component must be present in the backup.
+func (m *Module) RestoreComponents(name string, components []string) error {
+	_, err := m.restore(name, components, m.config.SourceDir)
+	return err
+}
+
+// RestoreTo restores backup `name` into `dir` instead of the source
+// directory, which is left untouched, returning the name of the backup
+// restored.
+func (m *Module) RestoreTo(name, dir string) (string, error) {
+	return m.restore(name, nil, dir)
+}
+
+// ResolveBackupName returns the name of the backup `name` designates, the
+// most recent complete backup for "latest".
+func (m *Module) ResolveBackupName(name string) (string, error) {
+	if name != "latest" {
+		return name, nil
+	}
+
+	names, err := m.List(nil)
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no backup to restore")
+	}
+	return names[len(names)-1], nil
+}
+
+func (m *Module) restore(name string, components []string, dir string) (string, error) {
+	name, err := m.ResolveBackupName(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	complete, err := m.store.FileExists(ctx, name+completeSuffix)
+	if err != nil {
+		return "", fmt.Errorf("unable to check backup %q: %w", name, err)
+	}
+	if !complete {
+		return "", fmt.Errorf("backup %q does not exist or is incomplete", name)
+	}
+
+	paths, err := m.componentPaths(components)
+	if err != nil {
+		return "", err
+	}
+
+	var objectNames []string
+	err = m.store.Walk(ctx, name+"/", "", func(filename string) error {
+		objectNames = append(objectNames, filename)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to list files of backup %q: %w", name, err)
+	}
+
+	var files []*restoredFile
+	byPath := make(map[string]*restoredFile)
+	found := make(map[string]bool)
+	sort.Strings(objectNames) // parts of a file must be appended in order
+	for _, objectName := range objectNames {
+		rel := strings.TrimPrefix(objectName, name+"/")
+		if idx := strings.LastIndex(rel, partSuffix); idx != -1 {
+			rel = rel[:idx]
+		}
+
+		if paths != nil {
+			component := matchComponent(paths, rel)
+			if component == "" {
+				continue
+			}
+			found[component] = true
+		}
+
+		file := byPath[rel]
+		if file == nil {
+			file = &restoredFile{rel: rel}
+			byPath[rel] = file
+			files = append(files, file)
+		}
+		file.objectNames = append(file.objectNames, objectName)
+	}
+
+	expected := m.loadBackupFiles(ctx, name)
+	for _, file := range files {
+		file.expected = expected[file.rel]
+	}
+
+	if paths == nil {
+		m.log().Info("restoring directory", zap.String("backup_name", name), zap.String("source_dir", dir))
+		if err := os.RemoveAll(dir); err != nil {
+			return "", fmt.Errorf("unable to clear %q: %w", dir, err)
+		}
+	} else {
+		for component := range paths {
+			if !found[component] {
+				return "", fmt.Errorf("backup %q has no file for component %q", name, component)
+			}
+		}
+		for _, componentPaths := range paths {
+			for _, p := range componentPaths {
+				if err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+					return "", fmt.Errorf("unable to clear %q: %w", p, err)
+				}
+			}
+		}
+		m.log().Info("restoring directory components", zap.String("backup_name", name), zap.String("source_dir", dir), zap.Strings("components", components))
+	}
+
+	return name, m.downloadFiles(ctx, files, dir)
+}
+
+// restoredFile is a file of a backup being restored, out of one object or
+// of several parts.
+type restoredFile struct {
+	rel         string
+	objectNames []string
+	expected    *operator.ManifestFile // nil when the backup does not list its files
+}
+
+// BackupFiles returns the files listed by the completion marker of backup
+// `name`, the ones it uploaded, nil when it lists none (taken by an older version).
+func (m *Module) BackupFiles(name string) ([]*operator.ManifestFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	return m.readBackupFiles(ctx, name)
+}
+
+func (m *Module) readBackupFiles(ctx context.Context, name string) ([]*operator.ManifestFile, error) {
+	reader, err := m.store.OpenObject(ctx, name+completeSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read marker of backup %q: %w", name, err)
+	}
+	defer reader.Close()
+
+	var files []*operator.ManifestFile
+	if err := json.NewDecoder(reader).Decode(&files); err != nil {
+		if err != io.EOF {
+			return nil, fmt.Errorf("invalid marker of backup %q: %w", name, err)
+		}
+		return nil, nil
+	}
+	return files, nil
+}
+
+// loadBackupFiles reads the files listed by the completion marker of backup
+// `name`, keyed by path, nil when it lists none (taken by an older version).
+func (m *Module) loadBackupFiles(ctx context.Context, name string) map[string]*operator.ManifestFile {
+	files, err := m.readBackupFiles(ctx, name)
+	if err != nil {
+		m.log().Warn("restored files are not verified", zap.String("backup_name", name), zap.Error(err))
+		return nil
+	}
+	if files == nil {
+		return nil
+	}
+
+	byPath := make(map[string]*operator.ManifestFile, len(files))
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	return byPath
+}
+
+// downloadFiles downloads `RestoreDownloadConcurrency` files at a time, the
+// parts of each file being appended in order by the same worker.
+func (m *Module) downloadFiles(ctx context.Context, files []*restoredFile, dir string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := m.config.RestoreDownloadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	eg := llerrgroup.New(concurrency)
+	for _, file := range files {
+		if eg.Stop() || ctx.Err() != nil {
+			break
+		}
+
+		file := file
+		eg.Go(func() error {
+			err := m.downloadFile(ctx, file, filepath.Join(dir, filepath.FromSlash(file.rel)))
+			if err != nil {
+				cancel() // first failure cancels the downloads in flight
+			}
+			return err
+		})
+	}
+
+	err := eg.Wait()
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// componentPaths returns the paths of each requested component, nil when
+// the whole directory must be restored.
+func (m *Module) componentPaths(components []string) (map[string][]string, error) {
+	if len(components) == 0 {
+		return nil, nil
+	}
+
+	known := m.config.Components
+	if known == nil {
+		known = DefaultComponents
+	}
+
+	paths := make(map[string][]string)
+	for _, component := range components {
+		if component == "all" {
+			return nil, nil
+		}
+		componentPaths, ok := known[component]
+		if !ok {
+			return nil, fmt.Errorf("unknown component %q", component)
+		}
+		paths[component] = componentPaths
+	}
+	return paths, nil
+}
+
+// matchComponent returns the component the file at `rel` belongs to, if any.
+func matchComponent(paths map[string][]string, rel string) string {
+	for component, componentPaths := range paths {
+		for _, p := range componentPaths {
+			if rel == p || strings.HasPrefix(rel, strings.TrimSuffix(p, "/")+"/") {
+				return component
+			}
+		}
+	}
+	return ""
+}
+
+// OpenBackupFile reads the file at `filePath` (relative to the source
+// directory) out of backup `name`, joining its parts if it was uploaded in
+// several of them.
+func (m *Module) OpenBackupFile(ctx context.Context, name, filePath string) (io.ReadCloser, error) {
+	objectName := path.Join(name, filePath)
+
+	var partNames []string
+	err := m.store.Walk(ctx, objectName+partSuffix, "", func(filename string) error {
+		partNames = append(partNames, filename)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list parts of %q: %w", objectName, err)
+	}
+	if len(partNames) == 0 {
+		return m.store.OpenObject(ctx, objectName)
+	}
+
+	sort.Strings(partNames)
+	return &partsReader{ctx: ctx, store: m.store, partNames: partNames}, nil
+}
+
+// partsReader reads the parts of a file one after the other
+type partsReader struct {
+	ctx       context.Context
+	store     dstore.Store
+	partNames []string
+	current   io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.partNames) == 0 {
+				return 0, io.EOF
+			}
+
+			reader, err := r.store.OpenObject(r.ctx, r.partNames[0])
+			if err != nil {
+				return 0, fmt.Errorf("unable to open %q: %w", r.partNames[0], err)
+			}
+			r.current = reader
+			r.partNames = r.partNames[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+func (m *Module) downloadFile(ctx context.Context, file *restoredFile, localFile string) error {
+	if err := os.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(localFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	var size int64
+	for _, objectName := range file.objectNames {
+		reader, err := m.store.OpenObject(ctx, objectName)
+		if err != nil {
+			return fmt.Errorf("unable to open %q: %w", objectName, err)
+		}
+
+		written, err := io.Copy(io.MultiWriter(f, h), reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("unable to download %q: %w", objectName, err)
+		}
+		size += written
+	}
+
+	if file.expected != nil {
+		if size != file.expected.Size {
+			return fmt.Errorf("restored file %q has size %d, expected %d", file.rel, size, file.expected.Size)
+		}
+		if checksum := hex.EncodeToString(h.Sum(nil)); checksum != file.expected.SHA256 {
+			return fmt.Errorf("restored file %q has checksum %s, expected %s", file.rel, checksum, file.expected.SHA256)
+		}
+	}
+	return nil
+}
+
+// deleteAbandonedBackups removes the objects of incomplete backups taken
+// before `before`, left behind by a node-manager that died mid-backup.
+func (m *Module) deleteAbandonedBackups(before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	complete := make(map[string]bool)
+	objects := make(map[string][]string)
+	err := m.store.Walk(ctx, "", "", func(filename string) error {
+		if strings.HasSuffix(filename, completeSuffix) {
+			complete[strings.TrimSuffix(filename, completeSuffix)] = true
+			return nil
+		}
+		if strings.HasSuffix(filename, pinnedSuffix) {
+			complete[strings.TrimSuffix(filename, pinnedSuffix)] = true // never touch a pinned backup
+			return nil
+		}
+
+		if name := backupNameOf(filename); name != "" {
+			objects[name] = append(objects[name], filename)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, objectNames := range objects {
+		if complete[name] {
+			continue
+		}
+
+		takenAt, err := time.Parse("20060102T150405Z", backupNameRegex.FindStringSubmatch(path.Base(name))[1])
+		if err != nil || !takenAt.Before(before) {
+			continue
+		}
+
+		m.log().Info("deleting abandoned incomplete backup", zap.String("backup_name", name), zap.Time("taken_at", takenAt))
+		m.deleteObjects(objectNames)
+	}
+	return nil
+}
+
+// backupNameOf returns the name of the backup an object belongs to, if any
+func backupNameOf(objectName string) string {
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments[:len(segments)-1] {
+		if backupNameRegex.MatchString(segment) {
+			return strings.Join(segments[:i+1], "/")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,475 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dfuse-io/node-manager/operator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestModule(t *testing.T, concurrency int) (*Module, string, func()) {
+	t.Helper()
+
+	root, err := ioutil.TempDir("", "dirbackup")
+	require.NoError(t, err)
+
+	sourceDir := filepath.Join(root, "source")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "state"), 0755))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "state", fmt.Sprintf("file-%d", i)), []byte(fmt.Sprintf("content %d", i)), 0644))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("blocks"), 0644))
+
+	m, err := New(&Config{
+		SourceDir:               sourceDir,
+		StoreURL:                "file://" + filepath.Join(root, "store"),
+		BackupUploadConcurrency: concurrency,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	return m, sourceDir, func() { os.RemoveAll(root) }
+}
+
+func TestModule_BackupAndRestore(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 4)
+	defer cleanup()
+	m.SetBackupPrefix("chain")
+
+	name, err := m.Backup(123)
+	require.NoError(t, err)
+	assert.Regexp(t, `^chain/0000000123-`, name)
+	taken, err := operator.ListManifestFiles(sourceDir)
+	require.NoError(t, err)
+
+	names, err := m.List(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{name}, names)
+
+	resolved, err := m.ResolveBackupName("latest")
+	require.NoError(t, err)
+	assert.Equal(t, name, resolved)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("corrupted"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "extra"), []byte("extra"), 0644))
+
+	// the files uploaded, not the ones the directory holds now
+	files, err := m.BackupFiles(name)
+	require.NoError(t, err)
+	assert.Equal(t, taken, files)
+
+	require.NoError(t, m.Restore("latest"))
+
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "blocks", string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(sourceDir, "state", "file-7"))
+	require.NoError(t, err)
+	assert.Equal(t, "content 7", string(content))
+
+	_, err = os.Stat(filepath.Join(sourceDir, "extra"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestModule_RestoreComponents(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 1)
+	defer cleanup()
+	m.config.Components = map[string][]string{
+		"blocks_log": {"blocks.log"},
+		"state":      {"state"},
+		"snapshots":  {"snapshots"},
+	}
+
+	name, err := m.Backup(123)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("corrupted"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "state", "file-7"), []byte("newer state"), 0644))
+
+	require.NoError(t, m.RestoreComponents(name, []string{"blocks_log"}))
+
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "blocks", string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(sourceDir, "state", "file-7"))
+	require.NoError(t, err)
+	assert.Equal(t, "newer state", string(content))
+
+	assert.Error(t, m.RestoreComponents(name, []string{"snapshots"}))
+	assert.Error(t, m.RestoreComponents(name, []string{"unknown"}))
+
+	require.NoError(t, m.RestoreComponents(name, []string{"state"}))
+	content, err = ioutil.ReadFile(filepath.Join(sourceDir, "state", "file-7"))
+	require.NoError(t, err)
+	assert.Equal(t, "content 7", string(content))
+}
+
+func TestModule_RestoreTo(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 2)
+	defer cleanup()
+
+	name, err := m.Backup(123)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("newer blocks"), 0644))
+
+	dir, err := ioutil.TempDir("", "dirbackup_restore_to")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	restored, err := m.RestoreTo("latest", dir)
+	require.NoError(t, err)
+	assert.Equal(t, name, restored)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "state", "file-7"))
+	require.NoError(t, err)
+	assert.Equal(t, "content 7", string(content))
+
+	// the source directory is left untouched
+	content, err = ioutil.ReadFile(filepath.Join(sourceDir, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "newer blocks", string(content))
+}
+
+func TestModule_BackupExcludePatterns(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 2)
+	defer cleanup()
+	m.config.BackupExcludePatterns = []string{"*.tmp", "scratch"}
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "state", "shared.tmp"), []byte("transient"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "scratch"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "scratch", "reversible"), []byte("transient"), 0644))
+
+	name, err := m.Backup(123)
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(sourceDir))
+	require.NoError(t, m.Restore(name))
+
+	_, err = os.Stat(filepath.Join(sourceDir, "state", "shared.tmp"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(sourceDir, "scratch"))
+	assert.True(t, os.IsNotExist(err))
+
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "state", "file-7"))
+	require.NoError(t, err)
+	assert.Equal(t, "content 7", string(content))
+
+	_, err = New(&Config{SourceDir: sourceDir, StoreURL: "file://" + sourceDir, BackupExcludePatterns: []string{"[bad"}}, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestModule_RestoreIncomplete(t *testing.T) {
+	m, _, cleanup := newTestModule(t, 1)
+	defer cleanup()
+
+	assert.Error(t, m.Restore("latest"))
+	assert.Error(t, m.Restore("0000000001-20200101T000000Z"))
+}
+
+func TestModule_BackupInParts(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 2)
+	defer cleanup()
+	m.config.PartSize = 4
+
+	large := []byte("this file is uploaded in several parts")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "large"), large, 0644))
+
+	var lock sync.Mutex
+	var maxDone, total int64
+	m.SetProgressReporter(func(doneBytes, totalBytes int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		if doneBytes > maxDone {
+			maxDone = doneBytes
+		}
+		total = totalBytes
+	})
+
+	name, err := m.Backup(42)
+	require.NoError(t, err)
+	assert.Equal(t, total, maxDone)
+	assert.Equal(t, int64(len(large)+len("blocks")+10*len("content 0")), total)
+
+	exists, err := m.store.FileExists(context.Background(), name+"/large"+partSuffix+"00009")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	reader, err := m.OpenBackupFile(context.Background(), name, "large")
+	require.NoError(t, err)
+	read, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, large, read)
+
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "large")))
+	require.NoError(t, m.Restore(name))
+
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "large"))
+	require.NoError(t, err)
+	assert.Equal(t, large, content)
+}
+
+func TestModule_DeleteAbandonedBackups(t *testing.T) {
+	m, _, cleanup := newTestModule(t, 1)
+	defer cleanup()
+
+	ctx := context.Background()
+	require.NoError(t, m.store.WriteObject(ctx, "chain/0000000001-20200101T000000Z/blocks.log", bytes.NewReader([]byte("partial"))))
+	complete, err := m.Backup(2)
+	require.NoError(t, err)
+
+	require.NoError(t, m.deleteAbandonedBackups(time.Now().Add(-time.Hour)))
+
+	exists, err := m.store.FileExists(ctx, "chain/0000000001-20200101T000000Z/blocks.log")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = m.store.FileExists(ctx, complete+"/blocks.log")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestModule_LocalBackupDedup(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 2)
+	defer cleanup()
+
+	m, err := New(&Config{SourceDir: sourceDir, StoreURL: m.config.StoreURL, LocalBackupDedup: true}, zap.NewNop())
+	require.NoError(t, err)
+
+	first, err := m.Backup(100)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("more blocks"), 0644))
+	second, err := m.Backup(200)
+	require.NoError(t, err)
+
+	sameFile := func(rel string) bool {
+		before, err := os.Stat(m.localStore.ObjectPath(first + "/" + rel))
+		require.NoError(t, err)
+		after, err := os.Stat(m.localStore.ObjectPath(second + "/" + rel))
+		require.NoError(t, err)
+		return os.SameFile(before, after)
+	}
+	assert.True(t, sameFile("state/file-3"))
+	assert.False(t, sameFile("blocks.log"))
+
+	require.NoError(t, m.Restore(second))
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "more blocks", string(content))
+}
+
+func TestModule_RetentionSkipsPinnedBackups(t *testing.T) {
+	m, _, cleanup := newTestModule(t, 1)
+	defer cleanup()
+	m.config.RetainBackups = 2
+
+	golden, err := m.Backup(100)
+	require.NoError(t, err)
+	require.NoError(t, m.Pin(golden))
+
+	var names []string
+	for _, blockNum := range []uint32{200, 300, 400} {
+		name, err := m.Backup(blockNum)
+		require.NoError(t, err)
+		names = append(names, name)
+	}
+
+	backups, err := m.List(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{golden, names[1], names[2]}, backups)
+
+	pinned, err := m.PinnedBackups()
+	require.NoError(t, err)
+	assert.Equal(t, []string{golden}, pinned)
+
+	exists, err := m.store.FileExists(context.Background(), names[0]+"/blocks.log")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, m.Unpin(golden))
+	_, err = m.Backup(500)
+	require.NoError(t, err)
+
+	backups, err = m.List(nil)
+	require.NoError(t, err)
+	assert.Len(t, backups, 2)
+	assert.NotContains(t, backups, golden)
+}
+
+func TestModule_DelayedPrune(t *testing.T) {
+	m, _, cleanup := newTestModule(t, 1)
+	defer cleanup()
+	m.config.RetainBackups = 1
+	m.config.PruneDelayAfterBackup = 50 * time.Millisecond
+
+	countBackups := func() int {
+		backups, err := m.List(nil)
+		require.NoError(t, err)
+		return len(backups)
+	}
+
+	_, err := m.Backup(100)
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond) // its own prune is done, with nothing to delete
+	_, err = m.Backup(200)
+	require.NoError(t, err)
+	assert.Equal(t, 2, countBackups(), "pruned only after the delay")
+	require.Eventually(t, func() bool { return countBackups() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// canceled on shutdown
+	_, err = m.Backup(300)
+	require.NoError(t, err)
+	m.Shutdown(nil)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, 2, countBackups())
+}
+
+func TestModule_BackupPathTemplate(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 1)
+	defer cleanup()
+
+	var err error
+	m.pathTemplate, err = parseBackupPathTemplate("{{.ChainID}}/{{.Date}}/{{.Name}}")
+	require.NoError(t, err)
+	m.SetBackupPrefix("backups")
+	m.SetChainID("eos")
+
+	first, err := m.Backup(123)
+	require.NoError(t, err)
+	assert.Regexp(t, `^backups/eos/\d{4}/\d{2}/\d{2}/0000000123-\d{8}T\d{6}Z$`, first)
+
+	m.pathTemplate, err = parseBackupPathTemplate("{{.Hostname}}/{{.Name}}")
+	require.NoError(t, err)
+	second, err := m.Backup(99)
+	require.NoError(t, err)
+
+	names, err := m.List(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{second, first}, names)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "blocks.log"), []byte("corrupted"), 0644))
+	require.NoError(t, m.Restore("latest"))
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "blocks", string(content))
+
+	for _, invalid := range []string{"{{.ChainID}}", "{{.Name}}/{{.Date}}", "{{.Unknown}}/{{.Name}}", "{{.Name"} {
+		_, err := parseBackupPathTemplate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestModule_SourceDirFromNodeConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "dirbackup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	nodeConfigFile := filepath.Join(root, "config.ini")
+	require.NoError(t, ioutil.WriteFile(nodeConfigFile, []byte("data-dir = "+filepath.Join(root, "data")+"\n"), 0644))
+
+	config := &Config{StoreURL: "file://" + filepath.Join(root, "store"), NodeConfigFile: nodeConfigFile}
+	_, err = New(config, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "data"), config.SourceDir)
+
+	// an explicit source dir wins
+	config = &Config{SourceDir: "/elsewhere", StoreURL: "file://" + filepath.Join(root, "store"), NodeConfigFile: nodeConfigFile}
+	_, err = New(config, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, "/elsewhere", config.SourceDir)
+
+	_, err = New(&Config{StoreURL: "file://" + filepath.Join(root, "store")}, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestModule_ParallelRestoreVerifiesFiles(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 4)
+	defer cleanup()
+	m.config.RestoreDownloadConcurrency = 4
+	m.config.PartSize = 4
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "state", "nested"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "state", "nested", "large"), []byte("uploaded in several parts"), 0644))
+
+	name, err := m.Backup(10)
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(sourceDir))
+	require.NoError(t, m.Restore(name))
+
+	content, err := ioutil.ReadFile(filepath.Join(sourceDir, "state", "nested", "large"))
+	require.NoError(t, err)
+	assert.Equal(t, "uploaded in several parts", string(content))
+	content, err = ioutil.ReadFile(filepath.Join(sourceDir, "state", "file-9"))
+	require.NoError(t, err)
+	assert.Equal(t, "content 9", string(content))
+
+	require.NoError(t, m.store.WriteObject(context.Background(), name+"/state/file-3", bytes.NewReader([]byte("corrupted"))))
+	err = m.Restore(name)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "state/file-3")
+}
+
+func TestModule_MaxUploadInflightBytes(t *testing.T) {
+	m, sourceDir, cleanup := newTestModule(t, 4)
+	defer cleanup()
+	m.inflight = newInflightBytes(20)
+
+	// bigger than the limit, uploaded alone
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "large"), bytes.Repeat([]byte("x"), 64), 0644))
+
+	name, err := m.Backup(42)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), m.inflight.used)
+
+	require.NoError(t, m.Restore(name))
+}
+
+func TestInflightBytes(t *testing.T) {
+	b := newInflightBytes(10)
+	ctx := context.Background()
+
+	require.NoError(t, b.acquire(ctx, 6))
+	require.NoError(t, b.acquire(ctx, 4))
+
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(ctx, 3) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(6)
+	require.NoError(t, <-acquired)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, b.acquire(canceled, 8))
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dfuse-io/node-manager/metrics"
+)
+
+// inflightBytes accounts for the bytes being uploaded, holding new uploads
+// back while they exceed `max` (0 does not limit them)
+type inflightBytes struct {
+	max int64
+
+	lock     sync.Mutex
+	used     int64
+	released chan struct{} // closed, then replaced, on each release
+}
+
+func newInflightBytes(max int64) *inflightBytes {
+	return &inflightBytes{max: max, released: make(chan struct{})}
+}
+
+// acquire waits until `size` bytes fit under the limit, a size bigger than
+// the limit fits once nothing else is in flight.
+func (b *inflightBytes) acquire(ctx context.Context, size int64) error {
+	for {
+		b.lock.Lock()
+		if b.max <= 0 || b.used == 0 || b.used+size <= b.max {
+			b.used += size
+			b.lock.Unlock()
+			metrics.UploadInflightBytes.Native().Add(float64(size))
+			return nil
+		}
+		released := b.released
+		b.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (b *inflightBytes) release(size int64) {
+	b.lock.Lock()
+	b.used -= size
+	close(b.released)
+	b.released = make(chan struct{})
+	b.lock.Unlock()
+	metrics.UploadInflightBytes.Native().Sub(float64(size))
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// BackupPathData is available to `Config.BackupPathTemplate`
+type BackupPathData struct {
+	ChainID  string // chain id reported by the operator, empty until known
+	Date     string // day the backup is taken at, as `2006/01/02` in UTC
+	Hostname string
+	Name     string // backup name, like `0000000123-20200101T000000Z`
+}
+
+// parseBackupPathTemplate validates `text` by rendering it with sample
+// values, the path must end with the backup name so that listing, pruning
+// and restoring can recognize backups wherever the template puts them.
+func parseBackupPathTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("backup_path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup path template %q: %w", text, err)
+	}
+
+	sample := &BackupPathData{ChainID: "chain", Date: "2020/01/01", Hostname: "host", Name: "0000000001-20200101T000000Z"}
+	rendered, err := renderBackupPath(tmpl, sample)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup path template %q: %w", text, err)
+	}
+	if path.Base(rendered) != sample.Name {
+		return nil, fmt.Errorf("invalid backup path template %q: it must end with {{.Name}}", text)
+	}
+	return tmpl, nil
+}
+
+func renderBackupPath(tmpl *template.Template, data *BackupPathData) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	rendered := path.Clean("/" + buf.String())[1:] // no way out of the prefix
+	if rendered == "" {
+		return "", fmt.Errorf("empty backup path")
+	}
+	return rendered, nil
+}
+
+// backupPath returns the object key of the backup named `name`, taken at
+// `takenAt`, under the module's prefix.
+func (m *Module) backupPath(name string, takenAt time.Time) (string, error) {
+	if m.pathTemplate == nil {
+		return path.Join(m.prefix, name), nil
+	}
+
+	rendered, err := renderBackupPath(m.pathTemplate, &BackupPathData{
+		ChainID:  m.chainID,
+		Date:     takenAt.UTC().Format("2006/01/02"),
+		Hostname: m.hostname,
+		Name:     name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to render backup path: %w", err)
+	}
+	return path.Join(m.prefix, rendered), nil
+}
+
+// sortBackupNames orders backups by their name (the block they were taken
+// at, then their time), whatever the path the template put them under.
+func sortBackupNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		bi, bj := path.Base(names[i]), path.Base(names[j])
+		if bi != bj {
+			return strings.Compare(bi, bj) < 0
+		}
+		return names[i] < names[j]
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dirbackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Pin protects complete backup `name` from retention.
+func (m *Module) Pin(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	complete, err := m.store.FileExists(ctx, name+completeSuffix)
+	if err != nil {
+		return fmt.Errorf("unable to check backup %q: %w", name, err)
+	}
+	if !complete {
+		return fmt.Errorf("backup %q does not exist or is incomplete", name)
+	}
+
+	return m.store.WriteObject(ctx, name+pinnedSuffix, bytes.NewReader(nil))
+}
+
+// Unpin makes backup `name` subject to retention again, unpinning a backup
+// that is not pinned is a no-op.
+func (m *Module) Unpin(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pinned, err := m.store.FileExists(ctx, name+pinnedSuffix)
+	if err != nil || !pinned {
+		return err
+	}
+	return m.store.DeleteObject(ctx, name+pinnedSuffix)
+}
+
+// PinnedBackups returns the pinned backups under the module's prefix, oldest first.
+func (m *Module) PinnedBackups() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	walkPrefix := ""
+	if m.prefix != "" {
+		walkPrefix = m.prefix + "/"
+	}
+
+	var names []string
+	err := m.store.Walk(ctx, walkPrefix, "", func(filename string) error {
+		if strings.HasSuffix(filename, pinnedSuffix) {
+			names = append(names, strings.TrimSuffix(filename, pinnedSuffix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pinned backups: %w", err)
+	}
+
+	sortBackupNames(names)
+	return names, nil
+}
+
+// pruneListAttempts bounds how many times backups are listed, waiting
+// `pruneListRetryDelay` in between, until the backup just taken shows up
+const (
+	pruneListAttempts   = 5
+	pruneListRetryDelay = 2 * time.Second
+)
+
+// pruneBackupsAfter waits `delay` before pruning, unless the module is shut
+// down meanwhile. The prunes are serialized, but not their waits.
+func (m *Module) pruneBackupsAfter(justTaken string, delay time.Duration) {
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-m.Terminating():
+			m.log().Info("module shut down, skipping delayed prune of old backups", zap.String("backup_name", justTaken))
+			return
+		}
+	}
+
+	m.pruneLock.Lock()
+	defer m.pruneLock.Unlock()
+
+	if err := m.pruneBackups(justTaken); err != nil {
+		m.log().Warn("unable to prune old backups", zap.Error(err))
+	}
+}
+
+// pruneBackups deletes the unpinned backups beyond the `RetainBackups` most
+// recent ones. Pinned backups are neither deleted nor counted. Nothing is
+// deleted until the listing includes `justTaken`, a stale listing could
+// otherwise count the wrong backups.
+func (m *Module) pruneBackups(justTaken string) error {
+	names, err := m.listIncluding(justTaken)
+	if err != nil {
+		return err
+	}
+
+	pinnedNames, err := m.PinnedBackups()
+	if err != nil {
+		return err
+	}
+	pinned := make(map[string]bool, len(pinnedNames))
+	for _, name := range pinnedNames {
+		pinned[name] = true
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if !pinned[name] {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) <= m.config.RetainBackups {
+		return nil
+	}
+
+	for _, name := range candidates[:len(candidates)-m.config.RetainBackups] {
+		if err := m.deleteBackup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) listIncluding(name string) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		names, err := m.List(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, listed := range names {
+			if listed == name {
+				return names, nil
+			}
+		}
+
+		if attempt == pruneListAttempts {
+			return nil, fmt.Errorf("backup %q still not listed after %d attempts, not pruning", name, attempt)
+		}
+		m.log().Info("backup just taken not listed yet, retrying", zap.String("backup_name", name), zap.Int("attempt", attempt))
+		time.Sleep(pruneListRetryDelay)
+	}
+}
+
+// deleteBackup removes the completion marker first so that a backup
+// partially deleted is never mistaken for a complete one.
+func (m *Module) deleteBackup(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	m.log().Info("deleting backup past retention", zap.String("backup_name", name))
+	if err := m.store.DeleteObject(ctx, name+completeSuffix); err != nil {
+		return fmt.Errorf("unable to delete completion marker of backup %q: %w", name, err)
+	}
+
+	var objectNames []string
+	err := m.store.Walk(ctx, name+"/", "", func(filename string) error {
+		objectNames = append(objectNames, filename)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list files of backup %q: %w", name, err)
+	}
+
+	m.deleteObjects(objectNames)
+	return nil
+}
@@ -13,6 +13,7 @@ require (
 	github.com/dfuse-io/dmetrics v0.0.0-20200508152325-93e7e9d576bb
 	github.com/dfuse-io/dstore v0.1.1-0.20210203172334-dec78c6098a6
 	github.com/dfuse-io/logging v0.0.0-20210109005628-b97a57253f70
+	github.com/dfuse-io/pbgo v0.0.6-0.20210125181705-b17235518132
 	github.com/dfuse-io/shutter v1.4.1
 	github.com/eoscanada/eos-go v0.9.1-0.20200506160036-5e090ae689ef
 	github.com/eoscanada/pitreos v1.1.1-0.20200721154110-fb345999fa39
@@ -20,10 +21,13 @@ require (
 	github.com/gorilla/mux v1.7.0
 	github.com/klauspost/compress v1.10.2
 	github.com/matishsiao/goInfo v0.0.0-20170803142006-617e6440957e
+	github.com/prometheus/client_golang v1.1.0
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4
 	github.com/stretchr/testify v1.4.0
 	go.uber.org/atomic v1.6.0
 	go.uber.org/zap v1.14.0
 	google.golang.org/grpc v1.29.1
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 replace github.com/ShinyTrinkets/overseer => github.com/dfuse-io/overseer v0.2.1-0.20210326144022-ee491780e3ef
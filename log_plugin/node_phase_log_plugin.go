@@ -0,0 +1,137 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"github.com/dfuse-io/shutter"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// NodePhase is the startup phase of nodeos, its value is the one reported by
+// the `node_manager_node_phase` gauge.
+type NodePhase int32
+
+const (
+	NodePhaseUnknown NodePhase = iota
+	NodePhaseLoadingBlocksLog
+	NodePhaseReplaying
+	NodePhaseSyncing
+	NodePhaseLive
+)
+
+func (p NodePhase) String() string {
+	switch p {
+	case NodePhaseLoadingBlocksLog:
+		return "loading_blocks_log"
+	case NodePhaseReplaying:
+		return "replaying"
+	case NodePhaseSyncing:
+		return "syncing"
+	case NodePhaseLive:
+		return "live"
+	default:
+		return "unknown"
+	}
+}
+
+var blockLatencyRegex = regexp.MustCompile(`latency: (-?\d+) ms`)
+
+// NodePhaseLogPlugin follows the nodeos console output to tell which phase
+// the node is in. A received block is considered live when its latency is
+// within `liveMaxLatency`.
+type NodePhaseLogPlugin struct {
+	*shutter.Shutter
+
+	phase          *atomic.Int32
+	liveMaxLatency time.Duration
+	logger         *zap.Logger
+}
+
+func NewNodePhaseLogPlugin(liveMaxLatency time.Duration, logger *zap.Logger) *NodePhaseLogPlugin {
+	return &NodePhaseLogPlugin{
+		Shutter:        shutter.New(),
+		phase:          atomic.NewInt32(int32(NodePhaseUnknown)),
+		liveMaxLatency: liveMaxLatency,
+		logger:         logger,
+	}
+}
+
+func (p *NodePhaseLogPlugin) Name() string {
+	return "NodePhaseLogPlugin"
+}
+func (p *NodePhaseLogPlugin) Launch() {}
+func (p *NodePhaseLogPlugin) Stop()   {}
+
+func (p *NodePhaseLogPlugin) Phase() NodePhase {
+	return NodePhase(p.phase.Load())
+}
+
+func (p *NodePhaseLogPlugin) IsLive() bool {
+	return p.Phase() == NodePhaseLive
+}
+
+func (p *NodePhaseLogPlugin) LogLine(in string) {
+	if strings.HasPrefix(in, "DMLOG ") {
+		return
+	}
+
+	if phase := p.phaseOf(in); phase != NodePhaseUnknown {
+		p.setPhase(phase)
+	}
+}
+
+func (p *NodePhaseLogPlugin) phaseOf(line string) NodePhase {
+	switch {
+	case strings.Contains(line, "initializing chain plugin"), strings.Contains(line, "Opening block log"):
+		return NodePhaseLoadingBlocksLog
+	case strings.Contains(line, "attempting to replay"), strings.Contains(line, "Replaying blocks"):
+		return NodePhaseReplaying
+	case strings.Contains(line, "Produced block"):
+		return NodePhaseLive
+	case strings.Contains(line, "Received block"):
+		match := blockLatencyRegex.FindStringSubmatch(line)
+		if match == nil {
+			return NodePhaseUnknown
+		}
+		latency, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return NodePhaseUnknown
+		}
+		if time.Duration(latency)*time.Millisecond <= p.liveMaxLatency {
+			return NodePhaseLive
+		}
+		return NodePhaseSyncing
+	case strings.Contains(line, "requesting range"), strings.Contains(line, "catching up"):
+		return NodePhaseSyncing
+	}
+	return NodePhaseUnknown
+}
+
+func (p *NodePhaseLogPlugin) setPhase(phase NodePhase) {
+	previous := NodePhase(p.phase.Swap(int32(phase)))
+	if previous == phase {
+		return
+	}
+
+	metrics.NodePhase.SetFloat64(float64(phase))
+	p.logger.Info("node phase changed", zap.Stringer("from", previous), zap.Stringer("to", phase))
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logplugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNodePhaseLogPlugin(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		out  NodePhase
+	}{
+		{"empty", []string{}, NodePhaseUnknown},
+		{"loading", []string{"info  2020-05-01T10:00:00.000 nodeos    chain_plugin.cpp:594          plugin_initialize    ] initializing chain plugin"}, NodePhaseLoadingBlocksLog},
+		{"replaying", []string{
+			"info  2020-05-01T10:00:00.000 nodeos    chain_plugin.cpp:594          plugin_initialize    ] initializing chain plugin",
+			"info  2020-05-01T10:00:01.000 nodeos    controller.cpp:434            startup              ] existing block log, attempting to replay from 2 to 1000 blocks",
+		}, NodePhaseReplaying},
+		{"syncing", []string{"info  2020-05-01T10:10:00.000 nodeos    producer_plugin.cpp:345       on_incoming_block    ] Received block 1b4ef3a1b2c2c2e2... #1001 @ 2020-04-01T10:00:00.000 signed by eosio [trxs: 0, lib: 1000, conf: 0, latency: 2592000000 ms]"}, NodePhaseSyncing},
+		{"live", []string{"info  2020-05-01T10:10:00.000 nodeos    producer_plugin.cpp:345       on_incoming_block    ] Received block 1b4ef3a1b2c2c2e2... #1001 @ 2020-05-01T10:10:00.000 signed by eosio [trxs: 0, lib: 1000, conf: 0, latency: 150 ms]"}, NodePhaseLive},
+		{"producing", []string{"info  2020-05-01T10:10:00.000 nodeos    producer_plugin.cpp:1897      produce_block        ] Produced block 1b4ef3a1b2c2c2e2... #1001 @ 2020-05-01T10:10:00.000 signed by eosio [trxs: 0, lib: 1000, confirmed: 0]"}, NodePhaseLive},
+		{"unrelated lines keep phase", []string{
+			"info  2020-05-01T10:00:01.000 nodeos    controller.cpp:434            startup              ] existing block log, attempting to replay from 2 to 1000 blocks",
+			"info  2020-05-01T10:00:02.000 nodeos    http_plugin.cpp:510           plugin_startup       ] start listening for http requests",
+		}, NodePhaseReplaying},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plugin := NewNodePhaseLogPlugin(30*time.Second, zap.NewNop())
+
+			for _, line := range test.in {
+				plugin.LogLine(line)
+			}
+
+			assert.Equal(t, test.out, plugin.Phase())
+			assert.Equal(t, test.out == NodePhaseLive, plugin.IsLive())
+		})
+	}
+}
@@ -22,6 +22,40 @@ var Metricset = dmetrics.NewSet()
 
 //FIXME this may be covered by another metric's registration in dmetrics. Minor Race condition alert
 var SuccessfulBackups = Metricset.NewCounter("successful_backups", "This counter increments every time that a backup is completed successfully")
+var BackupsAborted = Metricset.NewCounter("node_manager_backups_aborted_total", "This counter increments every time a backup is aborted because the chain stopped unexpectedly while it was running")
+
+var ContinuityGaps = Metricset.NewCounter("node_manager_continuity_gaps_total", "This counter increments every time the continuity checker detects a gap")
+var ContinuityLocked = Metricset.NewGauge("node_manager_continuity_locked", "Is the continuity checker currently locked (1) or not (0)")
+var BackupAuditFailures = Metricset.NewCounter("node_manager_backup_audit_failures_total", "This counter increments every time a backup read back from its store does not match its manifest")
+var BackupStoreFull = Metricset.NewCounter("node_manager_backup_store_full_total", "This counter increments every time a backup fails because its store ran out of space or quota")
+var ForceKills = Metricset.NewCounter("node_manager_force_kills_total", "This counter increments every time the node process ignores its stop signal for its whole grace period and is sent SIGKILL")
+var DirtyShutdowns = Metricset.NewCounter("node_manager_dirty_shutdowns_total", "This counter increments every time the chain is found not cleanly shut down after being stopped for a backup")
+
+var Reorgs = Metricset.NewCounter("node_manager_reorgs_total", "This counter increments every time the mindreader sees a block at or below the previous block's height with a different id")
+var ReorgDepth = Metricset.NewHistogram("node_manager_reorg_depth", "Number of blocks undone by each reorg seen by the mindreader")
+
+var NodePhase = Metricset.NewGauge("node_manager_node_phase", "Startup phase of the node: unknown (0), loading blocks log (1), replaying (2), syncing (3) or live (4)")
+
+var IsProducing = Metricset.NewGauge("node_manager_is_producing", "Whether the managed node is currently an active block producer (1) or not (0)")
+
+var GRPCSubscribers = Metricset.NewGauge("node_manager_grpc_subscribers", "Number of gRPC subscribers currently streaming blocks from the mindreader block hub")
+var CommittedMergedBlock = Metricset.NewGauge("node_manager_mindreader_committed_merged_block", "Last block of the merged bundles committed in block order, for mindreaders with ordered bundle commits")
+var GRPCSlowSubscriberDrops = Metricset.NewCounter("node_manager_grpc_slow_subscriber_drops_total", "This counter increments every time a gRPC block subscriber is dropped for being too slow to keep up")
+
+var OperatorLoopDuration = Metricset.NewHistogram("node_manager_operator_loop_duration_seconds", "Time spent by the operator's main loop handling each command, the loop is blocked for that whole duration")
+var BackupProgressRatio = Metricset.NewGauge("node_manager_backup_progress_ratio", "Ratio of bytes uploaded by the backup in progress, for modules reporting it")
+var MetricsPanics = Metricset.NewCounter("node_manager_metrics_panics_total", "This counter increments every time the metrics and readiness collection loop panics and is restarted")
+var UpstreamDisconnected = Metricset.NewGauge("node_manager_upstream_disconnected_seconds", "Time since the connection watchdog lost the connection to the upstream node, 0 while connected")
+var ClockSkew = Metricset.NewGauge("node_manager_clock_skew_seconds", "Offset of the host clock from the reference clock (NTP server or peers), positive when ahead, for nodes checking their clock skew")
+var SecondsSinceBlockProgress = Metricset.NewGauge("node_manager_seconds_since_block_progress", "Seconds since the head block of the running node last advanced, for nodes monitoring their block progress")
+var BlocksBehind = Metricset.NewGauge("node_manager_blocks_behind", "Blocks the node is behind its reference head (upstream node or peer), for nodes monitoring their blocks behind")
+var NodeUptime = Metricset.NewGauge("node_manager_node_uptime_seconds", "Time since the node process was last (re)started by the operator, 0 while it is not running")
+var NodeRestartCount = Metricset.NewCounter("node_manager_node_restart_count", "Number of times the node process was started again after its first start, by the operator")
+var LastBackupDuration = Metricset.NewGauge("node_manager_last_backup_duration_seconds", "Time taken by the last successful backup")
+var ReadySince = Metricset.NewGauge("node_manager_ready_since_seconds", "Unix time at which the node last became ready, 0 while not ready")
+var OperatorLoopStall = Metricset.NewGauge("node_manager_operator_loop_stall_seconds", "Time since the operator's main loop last started an iteration")
+var UploadInflightBytes = Metricset.NewGauge("node_manager_upload_inflight_bytes", "Bytes of the files and parts currently being uploaded by directory backups")
+var OperationsAborted = Metricset.NewCounter("node_manager_operations_aborted_total", "This counter increments every time an operation in progress is aborted on request")
 
 func NewHeadBlockTimeDrift(serviceName string) *dmetrics.HeadTimeDrift {
 	return Metricset.NewHeadTimeDrift(serviceName)
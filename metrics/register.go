@@ -0,0 +1,35 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/dfuse-io/dmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers the node-manager metricsets, every one of their metrics
+// carrying `labels` (like `chain=eos` or `network=mainnet`) as constant labels.
+func Register(labels map[string]string) {
+	if len(labels) == 0 {
+		dmetrics.Register(NodeosMetricset, Metricset)
+		return
+	}
+
+	register := dmetrics.PrometheusRegister
+	dmetrics.PrometheusRegister = prometheus.WrapRegistererWith(prometheus.Labels(labels), prometheus.DefaultRegisterer).MustRegister
+	defer func() { dmetrics.PrometheusRegister = register }()
+
+	dmetrics.Register(NodeosMetricset, Metricset)
+}
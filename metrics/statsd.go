@@ -0,0 +1,172 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// DefaultStatsDFlushInterval is how often `StatsDSink` sends the metrics by default
+const DefaultStatsDFlushInterval = 10 * time.Second
+
+// statsDMaxPacketSize keeps the packets within the usual network MTU
+const statsDMaxPacketSize = 1432
+
+// StatsDMirroredMetrics are the metrics mirrored by `StatsDSink`, by their
+// Prometheus name
+var StatsDMirroredMetrics = []string{
+	"head_block_number",
+	"head_block_time_drift",
+	"node_manager_last_backup_duration_seconds",
+	"node_manager_node_restart_count",
+}
+
+// StatsDSink mirrors `StatsDMirroredMetrics` to a StatsD server, in addition
+// to Prometheus. Gauges are sent as gauges and counters as counters of their
+// increase since the previous flush, their labels as DogStatsD tags. Sending
+// is best-effort, over UDP, and never blocks the metrics themselves.
+type StatsDSink struct {
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+	logger   *zap.Logger
+
+	counters map[string]float64 // last value sent of each counter, by name and tags
+}
+
+func NewStatsDSink(addr string, logger *zap.Logger) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach statsd server %q: %w", addr, err)
+	}
+
+	return &StatsDSink{
+		conn:     conn,
+		gatherer: prometheus.DefaultGatherer,
+		logger:   logger,
+		counters: map[string]float64{},
+	}, nil
+}
+
+// Run flushes the metrics every `interval` (`DefaultStatsDFlushInterval`
+// when 0) until `terminating` is closed.
+func (s *StatsDSink) Run(interval time.Duration, terminating <-chan struct{}) {
+	defer s.conn.Close()
+
+	if interval == 0 {
+		interval = DefaultStatsDFlushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-terminating:
+			return
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				s.logger.Debug("unable to send metrics to statsd", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *StatsDSink) flush() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("unable to gather metrics: %w", err)
+	}
+
+	var packet bytes.Buffer
+	for _, line := range s.lines(families) {
+		if packet.Len() != 0 && packet.Len()+1+len(line) > statsDMaxPacketSize {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() != 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err = s.conn.Write(packet.Bytes())
+	return err
+}
+
+func (s *StatsDSink) lines(families []*dto.MetricFamily) (lines []string) {
+	for _, family := range families {
+		if !isStatsDMirrored(family.GetName()) {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			tags := statsDTags(metric.GetLabel())
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				lines = append(lines, statsDLine(family.GetName(), metric.GetGauge().GetValue(), "g", tags))
+			case dto.MetricType_COUNTER:
+				key := family.GetName() + tags
+				value := metric.GetCounter().GetValue()
+				delta := value - s.counters[key]
+				s.counters[key] = value
+				if delta > 0 {
+					lines = append(lines, statsDLine(family.GetName(), delta, "c", tags))
+				}
+			}
+		}
+	}
+	return lines
+}
+
+func isStatsDMirrored(name string) bool {
+	for _, mirrored := range StatsDMirroredMetrics {
+		if mirrored == name {
+			return true
+		}
+	}
+	return false
+}
+
+func statsDLine(name string, value float64, kind, tags string) string {
+	return name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind + tags
+}
+
+func statsDTags(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	tags := make([]string, 0, len(labels))
+	for _, label := range labels {
+		tags = append(tags, label.GetName()+":"+label.GetValue())
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStatsDSink(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	headBlock := prometheus.NewGauge(prometheus.GaugeOpts{Name: "head_block_number", ConstLabels: prometheus.Labels{"service": "test", "chain": "eos"}})
+	restarts := prometheus.NewCounter(prometheus.CounterOpts{Name: "node_manager_node_restart_count"})
+	ignored := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_manager_node_phase"})
+	registry.MustRegister(headBlock, restarts, ignored)
+
+	sink, err := NewStatsDSink(server.LocalAddr().String(), zap.NewNop())
+	require.NoError(t, err)
+	sink.gatherer = registry
+
+	read := func() []string {
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, statsDMaxPacketSize)
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	headBlock.Set(1000)
+	restarts.Add(2)
+	require.NoError(t, sink.flush())
+	assert.Equal(t, []string{
+		"head_block_number:1000|g|#chain:eos,service:test",
+		"node_manager_node_restart_count:2|c",
+	}, read())
+
+	// counters are sent as their increase, only when they increased
+	headBlock.Set(1001)
+	require.NoError(t, sink.flush())
+	assert.Equal(t, []string{"head_block_number:1001|g|#chain:eos,service:test"}, read())
+
+	restarts.Inc()
+	require.NoError(t, sink.flush())
+	assert.Contains(t, read(), "node_manager_node_restart_count:1|c")
+}
@@ -48,6 +48,7 @@ type OneBlockArchiver struct {
 	oneBlockStore      dstore.Store
 	blockWriterFactory bstream.BlockWriterFactory
 	suffix             string
+	outputPermissions  *outputFilePermissions // applied to uploaded files, see `WithOutputFilePermissions`
 
 	uploadMutex sync.Mutex
 	workDir     string
@@ -191,7 +192,7 @@ func (s *OneBlockArchiver) uploadFiles() error {
 			if err = s.oneBlockStore.PushLocalFile(ctx, file, toBaseName); err != nil {
 				return fmt.Errorf("moving file %q to storage: %w", file, err)
 			}
-			return nil
+			return s.outputPermissions.apply(s.oneBlockStore, toBaseName)
 		})
 	}
 
@@ -16,10 +16,12 @@ package mindreader
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dfuse-io/bstream"
@@ -47,6 +49,26 @@ type ArchiverSelector struct {
 	workDir string
 	logger  *zap.Logger
 	running bool
+
+	storeLock sync.Mutex
+}
+
+// bundleFlusher is implemented by archivers that buffer blocks in memory before
+// writing them out as a bundle.
+type bundleFlusher interface {
+	FlushBundle() (*FlushedBundle, error)
+}
+
+// uploadTracker is implemented by archivers able to tell up to which block
+// everything they wrote is uploaded.
+type uploadTracker interface {
+	LastUploadedBlock() uint64
+}
+
+// FlushedBundle describes the blocks flushed out of an in-progress bundle.
+type FlushedBundle struct {
+	StartBlock uint64 `json:"start_block"`
+	EndBlock   uint64 `json:"end_block"`
 }
 
 func NewArchiverSelector(
@@ -240,7 +262,46 @@ func (s *ArchiverSelector) chooseArchiver(merging bool) Archiver {
 	return s.oneblockArchiver
 }
 
+// FlushBundle writes the blocks of the in-progress merged bundle so they get
+// uploaded right away, see `MergeArchiver.FlushBundle`, merging goes on with
+// the next blocks. It returns nil when not currently merging or nothing was
+// buffered since the last flush.
+func (s *ArchiverSelector) FlushBundle() (*FlushedBundle, error) {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
+
+	if !s.currentlyMerging {
+		return nil, nil
+	}
+
+	flusher, ok := s.mergeArchiver.(bundleFlusher)
+	if !ok {
+		return nil, fmt.Errorf("merge archiver does not support flushing its bundle")
+	}
+
+	flushed, err := flusher.FlushBundle()
+	if err != nil || flushed == nil {
+		return nil, err
+	}
+
+	s.logger.Info("flushed in-progress merged bundle", zap.Uint64("start_block", flushed.StartBlock), zap.Uint64("end_block", flushed.EndBlock))
+	return flushed, nil
+}
+
+// LastUploadedMergedBlock returns the block up to which every merged bundle is
+// uploaded, 0 if unknown.
+func (s *ArchiverSelector) LastUploadedMergedBlock() uint64 {
+	tracker, ok := s.mergeArchiver.(uploadTracker)
+	if !ok {
+		return 0
+	}
+	return tracker.LastUploadedBlock()
+}
+
 func (s *ArchiverSelector) StoreBlock(block *bstream.Block) error {
+	s.storeLock.Lock()
+	defer s.storeLock.Unlock()
+
 	if s.firstBoundaryPassed && !s.currentlyMerging {
 		return s.oneblockArchiver.StoreBlock(block) // once we passed a boundary creating oneblocks, we never go back to merging, too risky
 	}
@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -120,3 +121,63 @@ func TestArchiverSelector(t *testing.T) {
 		})
 	}
 }
+
+func TestArchiverSelector_FlushBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "test-mindreader-archiver-selector")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ma := NewMergeArchiver(dstore.NewMockStore(nil), bstream.GetBlockWriterFactory, dir, zap.NewNop())
+	oa := &testArchiver{}
+	s := NewArchiverSelector(oa, ma, bstream.GetBlockReaderFactory, true, bstream.NewTracker(0), time.Minute, dir, zap.NewNop())
+
+	for i := uint64(100); i <= 150; i++ {
+		require.NoError(t, s.StoreBlock(&bstream.Block{Number: i, PayloadBuffer: []byte{0x01}}))
+	}
+
+	flushed, err := s.FlushBundle()
+	require.NoError(t, err)
+	assert.Equal(t, &FlushedBundle{StartBlock: 100, EndBlock: 150}, flushed)
+
+	flushed, err = s.FlushBundle()
+	require.NoError(t, err)
+	assert.Nil(t, flushed, "nothing buffered since the last flush")
+
+	for i := uint64(151); i <= 170; i++ {
+		require.NoError(t, s.StoreBlock(&bstream.Block{Number: i, PayloadBuffer: []byte{0x01}}))
+	}
+	flushed, err = s.FlushBundle()
+	require.NoError(t, err)
+	assert.Equal(t, &FlushedBundle{StartBlock: 151, EndBlock: 170}, flushed, "only the blocks since the last flush")
+
+	// a reorg cannot rewind blocks already flushed
+	assert.Error(t, s.StoreBlock(&bstream.Block{Number: 160, PayloadBuffer: []byte{0x01}}))
+
+	// the bundle keeps being built, the full bundle is still written at the boundary
+	for i := uint64(171); i < 300; i++ {
+		require.NoError(t, s.StoreBlock(&bstream.Block{Number: i, PayloadBuffer: []byte{0x01}}))
+	}
+	assert.Nil(t, oa.blocks)
+
+	files, err := findFilesToUpload(dir, zap.NewNop(), ".merged")
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	assert.ElementsMatch(t, []string{"0000000100-0000000150.merged", "0000000151-0000000170.merged", "0000000100.merged", "0000000200.merged"}, names)
+
+	bundle, err := os.Open(filepath.Join(dir, "0000000100.merged"))
+	require.NoError(t, err)
+	defer bundle.Close()
+	reader, err := bstream.GetBlockReaderFactory.New(bundle)
+	require.NoError(t, err)
+	for i := uint64(100); i < 200; i++ {
+		blk, err := reader.Read()
+		require.NoError(t, err)
+		assert.Equal(t, i, blk.Num())
+	}
+
+	require.NoError(t, ma.uploadFiles())
+	assert.Equal(t, uint64(299), ma.LastUploadedBlock())
+}
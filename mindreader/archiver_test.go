@@ -89,6 +89,31 @@ func TestMindReaderPlugin_GatePassed(t *testing.T) {
 	assert.Equal(t, "00000002a", s.blocks[0].ID())
 }
 
+func TestMindReaderPlugin_Handover(t *testing.T) {
+	s := NewTestStore()
+
+	mindReader, err := testNewMindReaderPlugin(s, 0, 0)
+	require.NoError(t, err)
+	mindReader.SetStartBlockNum(2)
+	assert.Nil(t, mindReader.LastArchivedBlock())
+
+	mindReader.Launch()
+
+	mindReader.LogLine(`DMLOG {"id":"00000001a"}`)
+	mindReader.LogLine(`DMLOG {"id":"00000002a"}`)
+	mindReader.LogLine(`DMLOG {"id":"00000003a"}`)
+
+	s.consumeBlockFromChannel(t, 50*time.Millisecond)
+	s.consumeBlockFromChannel(t, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, mindReader.WaitForDrain(ctx))
+
+	assert.Equal(t, &HandoverBlock{BlockNum: 3, BlockID: "00000003a", NextStartBlockNum: 4}, mindReader.LastArchivedBlock())
+	assert.Equal(t, "00000002a", s.blocks[0].ID())
+}
+
 func TestMindReaderPlugin_StopAtBlockNumReached(t *testing.T) {
 	t.Skip()
 	s := NewTestStore()
@@ -210,6 +235,7 @@ type testContinuityChecker struct{}
 func (t *testContinuityChecker) IsLocked() bool                      { return false }
 func (t *testContinuityChecker) Reset()                              {}
 func (t *testContinuityChecker) Write(lastSeenBlockNum uint64) error { return nil }
+func (t *testContinuityChecker) Gaps() []*ContinuityGap              { return nil }
 
 type testBlockWriter struct {
 	writer io.Writer
@@ -0,0 +1,163 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dfuse-io/bstream"
+	"github.com/dfuse-io/node-manager/metrics"
+	pbbstream "github.com/dfuse-io/pbgo/dfuse/bstream/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BlockHub fans the blocks out to every gRPC `Blocks` subscriber. Each one has
+// its own bounded buffer, a subscriber too slow to keep up is dropped with a
+// `ResourceExhausted` status instead of slowing down the node.
+type BlockHub struct {
+	bufferSize int
+	burstSize  int
+	logger     *zap.Logger
+
+	lock        sync.Mutex
+	subscribers map[*hubSubscriber]bool
+	recent      []*bstream.Block // last `burstSize` blocks, sent first to new subscribers asking for them
+}
+
+type hubSubscriber struct {
+	name    string
+	blocks  chan *bstream.Block
+	dropped chan struct{}
+}
+
+// NewBlockHub registers the hub as the `BlockStream` service of `server`, it
+// replaces `blockstream.Server`, both cannot be registered on the same server.
+func NewBlockHub(server *grpc.Server, bufferSize, burstSize int, logger *zap.Logger) *BlockHub {
+	h := &BlockHub{
+		bufferSize:  bufferSize,
+		burstSize:   burstSize,
+		logger:      logger,
+		subscribers: make(map[*hubSubscriber]bool),
+	}
+	pbbstream.RegisterBlockStreamServer(server, h)
+	return h
+}
+
+// PushBlock never blocks, subscribers whose buffer is full are dropped
+func (h *BlockHub) PushBlock(block *bstream.Block) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.burstSize > 0 {
+		h.recent = append(h.recent, block)
+		if len(h.recent) > h.burstSize {
+			h.recent = h.recent[len(h.recent)-h.burstSize:]
+		}
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.blocks <- block:
+		default:
+			h.logger.Warn("dropping slow block subscriber", zap.String("subscriber", sub.name), zap.Int("buffer_size", h.bufferSize))
+			metrics.GRPCSlowSubscriberDrops.Inc()
+			h.remove(sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (h *BlockHub) Blocks(req *pbbstream.BlockRequest, stream pbbstream.BlockStream_BlocksServer) error {
+	sub := h.subscribe(req)
+	defer h.unsubscribe(sub)
+
+	for {
+		select {
+		case <-sub.dropped: // checked first so a dropped subscriber is not fed its remaining buffer
+			return h.droppedError(sub)
+		default:
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.dropped:
+			return h.droppedError(sub)
+		case block := <-sub.blocks:
+			protoBlock, err := block.ToProto()
+			if err != nil {
+				return status.Error(codes.Internal, fmt.Sprintf("unable to transform block %s: %s", block, err))
+			}
+			if err := stream.Send(protoBlock); err != nil {
+				h.logger.Info("failed sending block to subscriber, closing subscription", zap.String("subscriber", sub.name), zap.Error(err))
+				return nil
+			}
+		}
+	}
+}
+
+func (h *BlockHub) droppedError(sub *hubSubscriber) error {
+	return status.Error(codes.ResourceExhausted, fmt.Sprintf("subscriber %q too slow, more than %d blocks behind", sub.name, h.bufferSize))
+}
+
+func (h *BlockHub) subscribe(req *pbbstream.BlockRequest) *hubSubscriber {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// clamped, the requested burst comes straight from the client
+	burstSize := req.Burst
+	if burstSize < 0 {
+		burstSize = 0
+	}
+	if burstSize > int64(len(h.recent)) {
+		burstSize = int64(len(h.recent))
+	}
+	burst := h.recent[len(h.recent)-int(burstSize):]
+
+	sub := &hubSubscriber{
+		name:    req.Requester,
+		blocks:  make(chan *bstream.Block, h.bufferSize+len(burst)),
+		dropped: make(chan struct{}),
+	}
+	for _, block := range burst {
+		sub.blocks <- block
+	}
+
+	h.subscribers[sub] = true
+	metrics.GRPCSubscribers.SetUint64(uint64(len(h.subscribers)))
+	h.logger.Info("block subscriber added", zap.String("subscriber", sub.name), zap.Int("burst", len(burst)), zap.Int("subscriber_count", len(h.subscribers)))
+	return sub
+}
+
+func (h *BlockHub) unsubscribe(sub *hubSubscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.remove(sub)
+}
+
+// remove assumes the lock is held
+func (h *BlockHub) remove(sub *hubSubscriber) {
+	if !h.subscribers[sub] {
+		return
+	}
+
+	delete(h.subscribers, sub)
+	metrics.GRPCSubscribers.SetUint64(uint64(len(h.subscribers)))
+	h.logger.Info("block subscriber removed", zap.String("subscriber", sub.name), zap.Int("subscriber_count", len(h.subscribers)))
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dfuse-io/bstream"
+	pbbstream "github.com/dfuse-io/pbgo/dfuse/bstream/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testBlocksServer struct {
+	grpc.ServerStream
+	ctx     context.Context
+	sent    chan *pbbstream.Block
+	release chan struct{} // if set, `Send` blocks until it is closed
+}
+
+func (s *testBlocksServer) Context() context.Context { return s.ctx }
+func (s *testBlocksServer) Send(block *pbbstream.Block) error {
+	if s.release != nil {
+		<-s.release
+		return nil
+	}
+	s.sent <- block
+	return nil
+}
+
+func testHubBlock(num uint64) *bstream.Block {
+	return &bstream.Block{Number: num, PayloadBuffer: []byte{0x01}, Timestamp: time.Now()}
+}
+
+func TestBlockHub_BurstThenLive(t *testing.T) {
+	hub := NewBlockHub(grpc.NewServer(), 10, 2, testLogger)
+	hub.PushBlock(testHubBlock(1))
+	hub.PushBlock(testHubBlock(2))
+	hub.PushBlock(testHubBlock(3))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &testBlocksServer{ctx: ctx, sent: make(chan *pbbstream.Block)}
+	done := make(chan error)
+	go func() { done <- hub.Blocks(&pbbstream.BlockRequest{Burst: 5, Requester: "test"}, stream) }()
+
+	assert.Equal(t, uint64(2), (<-stream.sent).Number)
+	assert.Equal(t, uint64(3), (<-stream.sent).Number)
+	hub.PushBlock(testHubBlock(4))
+	assert.Equal(t, uint64(4), (<-stream.sent).Number)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Len(t, hub.subscribers, 0)
+}
+
+func TestBlockHub_NegativeBurst(t *testing.T) {
+	hub := NewBlockHub(grpc.NewServer(), 10, 2, testLogger)
+	hub.PushBlock(testHubBlock(1))
+	hub.PushBlock(testHubBlock(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &testBlocksServer{ctx: ctx, sent: make(chan *pbbstream.Block)}
+	done := make(chan error)
+	go func() { done <- hub.Blocks(&pbbstream.BlockRequest{Burst: -1, Requester: "test"}, stream) }()
+
+	require.Eventually(t, func() bool {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		return len(hub.subscribers) == 1
+	}, time.Second, time.Millisecond)
+
+	// no burst, only live blocks
+	hub.PushBlock(testHubBlock(3))
+	assert.Equal(t, uint64(3), (<-stream.sent).Number)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestBlockHub_SlowSubscriberDropped(t *testing.T) {
+	hub := NewBlockHub(grpc.NewServer(), 2, 0, testLogger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &testBlocksServer{ctx: ctx, release: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- hub.Blocks(&pbbstream.BlockRequest{Requester: "slow"}, stream) }()
+
+	require.Eventually(t, func() bool {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		return len(hub.subscribers) == 1
+	}, time.Second, time.Millisecond)
+
+	// at most one block is held in `Send`, the buffer holds two, so the fourth one overflows it
+	for i := uint64(1); i <= 4; i++ {
+		hub.PushBlock(testHubBlock(i))
+	}
+	assert.Len(t, hub.subscribers, 0)
+
+	close(stream.release)
+	err := <-done
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -16,10 +16,15 @@ package mindreader
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/google/renameio"
 	"go.uber.org/zap"
 )
@@ -28,12 +33,70 @@ type ContinuityChecker interface {
 	IsLocked() bool
 	Reset()
 	Write(lastSeenBlockNum uint64) error
+	Gaps() []*ContinuityGap
 }
 
-func NewContinuityChecker(filePath string, zlogger *zap.Logger) (*continuityChecker, error) {
+// ContinuityGap is a hole detected by the continuity checker
+type ContinuityGap struct {
+	ExpectedBlock uint64    `json:"expected_block"`
+	ReceivedBlock uint64    `json:"received_block"`
+	Time          time.Time `json:"time"`
+}
+
+const defaultGapHistorySize = 100
+
+// ContinuityGapPolicy is what the continuity checker does when it detects a gap
+type ContinuityGapPolicy string
+
+const (
+	ContinuityGapLock     ContinuityGapPolicy = "lock"     // locks itself (persisted, until reset) and the plugin shuts down, the default
+	ContinuityGapShutdown ContinuityGapPolicy = "shutdown" // the plugin shuts down, without locking, it resumes after the gap once restarted
+	ContinuityGapContinue ContinuityGapPolicy = "continue" // the gap is recorded and counted, blocks keep flowing
+)
+
+// ContinuityGapPolicies are all the valid `ContinuityGapPolicy` values
+var ContinuityGapPolicies = []ContinuityGapPolicy{ContinuityGapLock, ContinuityGapShutdown, ContinuityGapContinue}
+
+// ErrContinuityGapTolerated is wrapped by the errors of `Write` for gaps
+// accepted under the `ContinuityGapContinue` policy, the block is fine to use
+var ErrContinuityGapTolerated = errors.New("continuity gap tolerated")
+
+type ContinuityCheckerOption func(cc *continuityChecker)
+
+// WithAllowedSkips tolerates up to `count` missing block numbers between two
+// consecutive blocks, for chains where block numbers are legitimately skipped
+// (missed rounds). See `Write` for the exact definition.
+func WithAllowedSkips(count uint64) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		cc.allowedSkips = count
+	}
+}
+
+// WithGapHistory keeps the last `size` gaps detected (100 by default), the
+// history survives resets and, if `persist` is true, restarts too.
+func WithGapHistory(size int, persist bool) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		cc.gapHistorySize = size
+		cc.persistGaps = persist
+	}
+}
+
+// WithGapPolicy sets what happens when a gap is detected, `ContinuityGapLock` by default.
+func WithGapPolicy(policy ContinuityGapPolicy) ContinuityCheckerOption {
+	return func(cc *continuityChecker) {
+		cc.gapPolicy = policy
+	}
+}
+
+func NewContinuityChecker(filePath string, zlogger *zap.Logger, options ...ContinuityCheckerOption) (*continuityChecker, error) {
 	cc := &continuityChecker{
-		filePath: filePath,
-		zlogger:  zlogger,
+		filePath:       filePath,
+		zlogger:        zlogger,
+		gapHistorySize: defaultGapHistorySize,
+		gapPolicy:      ContinuityGapLock,
+	}
+	for _, opt := range options {
+		opt(cc)
 	}
 	err := cc.load()
 	if err != nil {
@@ -46,8 +109,15 @@ func NewContinuityChecker(filePath string, zlogger *zap.Logger) (*continuityChec
 type continuityChecker struct {
 	highestSeenBlock uint64
 	locked           bool
+	allowedSkips     uint64
+	gapPolicy        ContinuityGapPolicy
 	filePath         string
 	zlogger          *zap.Logger
+
+	gapsLock       sync.Mutex
+	gaps           []*ContinuityGap // oldest first
+	gapHistorySize int
+	persistGaps    bool
 }
 
 func (cc *continuityChecker) IsLocked() bool {
@@ -58,6 +128,7 @@ func (cc *continuityChecker) Reset() {
 	cc.zlogger.Info("resetting continuity checker")
 	cc.highestSeenBlock = 0
 	cc.locked = false
+	metrics.ContinuityLocked.SetUint64(0)
 
 	err := os.Remove(cc.filePath)
 	if err != nil && !os.IsNotExist(err) {
@@ -73,10 +144,17 @@ func (cc *continuityChecker) Reset() {
 func (cc *continuityChecker) load() error {
 	if _, err := os.Stat(cc.lockFilePath()); err == nil {
 		cc.locked = true
+		metrics.ContinuityLocked.SetUint64(1)
 	}
 
 	defer cc.zlogger.Info("loading continuity checker info", zap.Bool("locked", cc.locked), zap.Uint64("highest_seen_block", cc.highestSeenBlock))
 
+	if cc.persistGaps {
+		if err := cc.loadGaps(); err != nil {
+			cc.zlogger.Warn("cannot load continuity gaps history", zap.String("gaps_file_path", cc.gapsFilePath()), zap.Error(err))
+		}
+	}
+
 	b, err := ioutil.ReadFile(cc.filePath)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -91,8 +169,54 @@ func (cc *continuityChecker) load() error {
 func (cc *continuityChecker) lockFilePath() string {
 	return cc.filePath + ".broken"
 }
+
+func (cc *continuityChecker) gapsFilePath() string {
+	return cc.filePath + ".gaps.json"
+}
+
+func (cc *continuityChecker) loadGaps() error {
+	b, err := ioutil.ReadFile(cc.gapsFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(b, &cc.gaps)
+}
+
+// Gaps returns the gaps detected so far, oldest first
+func (cc *continuityChecker) Gaps() []*ContinuityGap {
+	cc.gapsLock.Lock()
+	defer cc.gapsLock.Unlock()
+
+	return append([]*ContinuityGap{}, cc.gaps...)
+}
+
+func (cc *continuityChecker) recordGap(gap *ContinuityGap) {
+	cc.gapsLock.Lock()
+	defer cc.gapsLock.Unlock()
+
+	cc.gaps = append(cc.gaps, gap)
+	if len(cc.gaps) > cc.gapHistorySize {
+		cc.gaps = cc.gaps[len(cc.gaps)-cc.gapHistorySize:]
+	}
+
+	if cc.persistGaps {
+		b, err := json.Marshal(cc.gaps)
+		if err == nil {
+			err = renameio.WriteFile(cc.gapsFilePath(), b, os.FileMode(0644))
+		}
+		if err != nil {
+			cc.zlogger.Error("cannot persist continuity gaps history", zap.String("gaps_file_path", cc.gapsFilePath()), zap.Error(err))
+		}
+	}
+}
 func (cc *continuityChecker) setLock() {
 	cc.locked = true
+	metrics.ContinuityGaps.Inc()
+	metrics.ContinuityLocked.SetUint64(1)
+
 	_, err := os.Create(cc.lockFilePath())
 	if err != nil {
 		cc.zlogger.Error("cannot create lock file", zap.String("lock_file_path", cc.lockFilePath()), zap.Error(err))
@@ -100,11 +224,12 @@ func (cc *continuityChecker) setLock() {
 }
 
 // Write checks that the either:
-// val =< highestSeenBlock OR val == highestSeenBlock+1 OR highestSeenBlock == 0
+// val =< highestSeenBlock OR val <= highestSeenBlock+1+allowedSkips OR highestSeenBlock == 0
+// (allowedSkips being 0 unless configured, meaning each block must directly follow the highest seen one)
 // it then updates the highestSeenBlock value if it needs to changed (on the cc and on disk)
 // In case the value does not match these 3 conditions, (that block would create a hole
 // in the continuity), the checker becomes locked, a lock file is written to disk, and an error
-// is returned.
+// is returned. Other `ContinuityGapPolicy` values accept the block instead of locking.
 func (cc *continuityChecker) Write(val uint64) error {
 	if cc.locked {
 		return fmt.Errorf("ontinuity checker already locked")
@@ -112,10 +237,36 @@ func (cc *continuityChecker) Write(val uint64) error {
 	if val <= cc.highestSeenBlock {
 		return nil
 	}
+	if cc.highestSeenBlock != 0 && val > cc.highestSeenBlock+1+cc.allowedSkips {
+		cc.recordGap(&ContinuityGap{ExpectedBlock: cc.highestSeenBlock + 1, ReceivedBlock: val, Time: time.Now()})
+		gapErr := fmt.Errorf("ontinuity checker failed: block %d would creates a hole after highest seen block: %d", val, cc.highestSeenBlock)
+
+		switch cc.gapPolicy {
+		case ContinuityGapContinue:
+			metrics.ContinuityGaps.Inc()
+			if err := cc.writeHighestSeenBlock(val); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s: %w", gapErr, ErrContinuityGapTolerated)
+		case ContinuityGapShutdown:
+			// the gap is accepted, a restart resumes after it
+			metrics.ContinuityGaps.Inc()
+			if err := cc.writeHighestSeenBlock(val); err != nil {
+				return err
+			}
+			return gapErr
+		default:
+			cc.setLock()
+			return gapErr
+		}
+	}
 	if cc.highestSeenBlock != 0 && val > cc.highestSeenBlock+1 {
-		cc.setLock()
-		return fmt.Errorf("ontinuity checker failed: block %d would creates a hole after highest seen block: %d", val, cc.highestSeenBlock)
+		cc.zlogger.Info("tolerating skipped block numbers", zap.Uint64("highest_seen_block", cc.highestSeenBlock), zap.Uint64("block_num", val))
 	}
+	return cc.writeHighestSeenBlock(val)
+}
+
+func (cc *continuityChecker) writeHighestSeenBlock(val uint64) error {
 	cc.highestSeenBlock = val
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, uint64(val))
@@ -15,6 +15,7 @@
 package mindreader
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -55,3 +56,82 @@ func TestContinuityChecker(t *testing.T) {
 	assert.Error(t, cc2.Write(10))
 
 }
+
+func TestContinuityCheckerGapPolicy(t *testing.T) {
+	for _, policy := range []ContinuityGapPolicy{ContinuityGapShutdown, ContinuityGapContinue} {
+		t.Run(string(policy), func(t *testing.T) {
+			tmp := tempFileName()
+
+			cc, err := NewContinuityChecker(tmp, testLogger, WithGapPolicy(policy))
+			require.NoError(t, err)
+
+			defer func() {
+				os.Remove(tmp)
+				os.Remove(fmt.Sprintf("%s.broken", tmp))
+			}()
+
+			require.NoError(t, cc.Write(10))
+			err = cc.Write(13)
+			require.Error(t, err)
+			assert.Equal(t, policy == ContinuityGapContinue, errors.Is(err, ErrContinuityGapTolerated))
+			assert.False(t, cc.locked)
+			assert.Len(t, cc.Gaps(), 1)
+
+			// the gap is accepted, even across restarts
+			cc2, err := NewContinuityChecker(tmp, testLogger, WithGapPolicy(policy))
+			require.NoError(t, err)
+			assert.False(t, cc2.locked)
+			assert.NoError(t, cc2.Write(14))
+		})
+	}
+}
+
+func TestContinuityCheckerAllowedSkips(t *testing.T) {
+	tmp := tempFileName()
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithAllowedSkips(2))
+	require.NoError(t, err)
+
+	defer func() {
+		os.Remove(tmp)
+		os.Remove(fmt.Sprintf("%s.broken", tmp))
+	}()
+
+	cc.Reset()
+
+	require.NoError(t, cc.Write(10))
+	assert.NoError(t, cc.Write(13))
+	assert.EqualValues(t, 13, cc.highestSeenBlock)
+	assert.False(t, cc.locked)
+	assert.Error(t, cc.Write(17))
+	assert.True(t, cc.locked)
+}
+
+func TestContinuityCheckerGapHistory(t *testing.T) {
+	tmp := tempFileName()
+
+	cc, err := NewContinuityChecker(tmp, testLogger, WithGapHistory(2, true))
+	require.NoError(t, err)
+
+	defer func() {
+		os.Remove(tmp)
+		os.Remove(fmt.Sprintf("%s.broken", tmp))
+		os.Remove(fmt.Sprintf("%s.gaps.json", tmp))
+	}()
+
+	for _, gapAt := range []uint64{20, 30, 40} {
+		cc.Reset()
+		require.NoError(t, cc.Write(gapAt-10))
+		assert.Error(t, cc.Write(gapAt))
+	}
+
+	gaps := cc.Gaps()
+	require.Len(t, gaps, 2)
+	assert.Equal(t, uint64(21), gaps[0].ExpectedBlock)
+	assert.Equal(t, uint64(30), gaps[0].ReceivedBlock)
+	assert.Equal(t, uint64(40), gaps[1].ReceivedBlock)
+
+	cc2, err := NewContinuityChecker(tmp, testLogger, WithGapHistory(2, true))
+	require.NoError(t, err)
+	assert.Len(t, cc2.Gaps(), 2)
+}
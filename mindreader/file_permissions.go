@@ -0,0 +1,89 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/dfuse-io/dstore"
+)
+
+// outputFilePermissions are applied to the block files written to local
+// stores, so they can be read by processes running as another user.
+type outputFilePermissions struct {
+	mode os.FileMode // 0 keeps the store's default
+	uid  int         // -1 keeps the current owner
+	gid  int         // -1 keeps the current group
+}
+
+// newOutputFilePermissions resolves `owner` and `group`, either names or
+// numeric ids, empty ones are left unchanged.
+func newOutputFilePermissions(mode os.FileMode, owner, group string) (*outputFilePermissions, error) {
+	p := &outputFilePermissions{mode: mode, uid: -1, gid: -1}
+
+	if owner != "" {
+		id := owner
+		if _, err := strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return nil, fmt.Errorf("unknown output file owner %q: %w", owner, err)
+			}
+			id = u.Uid
+		}
+		p.uid, _ = strconv.Atoi(id)
+	}
+
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return nil, fmt.Errorf("unknown output file group %q: %w", group, err)
+			}
+			id = g.Gid
+		}
+		p.gid, _ = strconv.Atoi(id)
+	}
+
+	return p, nil
+}
+
+// apply sets the permissions of object `baseName`, it is a no-op on remote stores
+func (p *outputFilePermissions) apply(store dstore.Store, baseName string) error {
+	if p == nil {
+		return nil
+	}
+
+	localStore, ok := store.(*dstore.LocalStore)
+	if !ok {
+		return nil
+	}
+
+	path := localStore.ObjectPath(baseName)
+	if p.mode != 0 {
+		if err := os.Chmod(path, p.mode); err != nil {
+			return fmt.Errorf("unable to set mode of %q: %w", path, err)
+		}
+	}
+	if p.uid != -1 || p.gid != -1 {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return fmt.Errorf("unable to set owner of %q: %w", path, err)
+		}
+	}
+	return nil
+}
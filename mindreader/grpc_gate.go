@@ -0,0 +1,62 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// health checks are never gated, they report the server's state themselves
+const grpcHealthServicePrefix = "/grpc.health.v1.Health/"
+
+// HasProducedFirstBlock tells whether a block went through the archiver yet,
+// blocks before the start block (see `SetStartBlockNum`) are never archived.
+func (p *MindReaderPlugin) HasProducedFirstBlock() bool {
+	return p.LastArchivedBlock() != nil
+}
+
+// WarmupGateStreamInterceptor fails the streaming calls with `Unavailable`
+// until `ready` returns true, so clients retry instead of getting an empty
+// stream from a mindreader not producing blocks yet.
+func WarmupGateStreamInterceptor(ready func() bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := warmupGateError(info.FullMethod, ready); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// WarmupGateUnaryInterceptor is the unary counterpart of `WarmupGateStreamInterceptor`
+func WarmupGateUnaryInterceptor(ready func() bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := warmupGateError(info.FullMethod, ready); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func warmupGateError(fullMethod string, ready func() bool) error {
+	if strings.HasPrefix(fullMethod, grpcHealthServicePrefix) || ready() {
+		return nil
+	}
+	return status.Error(codes.Unavailable, "mindreader has not produced its first block yet, retry later")
+}
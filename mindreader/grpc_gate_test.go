@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWarmupGate(t *testing.T) {
+	ready := false
+	isReady := func() bool { return ready }
+
+	stream := WarmupGateStreamInterceptor(isReady)
+	unary := WarmupGateUnaryInterceptor(isReady)
+
+	called := 0
+	streamHandler := func(interface{}, grpc.ServerStream) error { called++; return nil }
+	unaryHandler := func(context.Context, interface{}) (interface{}, error) { called++; return nil, nil }
+	blocks := &grpc.StreamServerInfo{FullMethod: "/dfuse.bstream.v1.BlockStream/Blocks"}
+
+	err := stream(nil, nil, blocks, streamHandler)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/dfuse.headinfo.v1.HeadInfo/GetHeadInfo"}, unaryHandler)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 0, called)
+
+	// health checks go through
+	_, err = unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, unaryHandler)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, called)
+
+	ready = true
+	assert.NoError(t, stream(nil, nil, blocks, streamHandler))
+	assert.Equal(t, 2, called)
+}
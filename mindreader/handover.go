@@ -0,0 +1,87 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfuse-io/bstream"
+)
+
+// drainQuietPeriod is how long the plugin's queues must stay empty for it
+// to be considered drained, a block may be in between them meanwhile
+const drainQuietPeriod = 500 * time.Millisecond
+
+// HandoverBlock is the last block handed to the archiver, a mindreader
+// replacing this one starts at `NextStartBlockNum`.
+type HandoverBlock struct {
+	BlockNum          uint64 `json:"block_num"`
+	BlockID           string `json:"block_id"`
+	LIBNum            uint64 `json:"lib_num"`
+	NextStartBlockNum uint64 `json:"next_start_block_num"`
+}
+
+func (p *MindReaderPlugin) setLastArchivedBlock(block *bstream.Block) {
+	p.lastArchivedLock.Lock()
+	defer p.lastArchivedLock.Unlock()
+
+	p.lastArchived = &HandoverBlock{
+		BlockNum:          block.Num(),
+		BlockID:           block.ID(),
+		LIBNum:            block.LibNum,
+		NextStartBlockNum: block.Num() + 1,
+	}
+}
+
+// LastArchivedBlock returns the last block stored by the archiver, nil if none yet
+func (p *MindReaderPlugin) LastArchivedBlock() *HandoverBlock {
+	p.lastArchivedLock.Lock()
+	defer p.lastArchivedLock.Unlock()
+	return p.lastArchived
+}
+
+// SetStartBlockNum makes the plugin discard the blocks before `blockNum`, to
+// resume exactly where a retired mindreader stopped. It must be called before `Launch`.
+func (p *MindReaderPlugin) SetStartBlockNum(blockNum uint64) {
+	p.startGate = NewBlockNumberGate(blockNum)
+}
+
+// WaitForDrain returns once every console line received so far went through
+// the archiver, it is meant to be called once the node stopped writing to it.
+func (p *MindReaderPlugin) WaitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(drainQuietPeriod / 5)
+	defer ticker.Stop()
+
+	var quietSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if len(p.lines) != 0 || len(p.blocks) != 0 {
+			quietSince = time.Time{}
+			continue
+		}
+		if quietSince.IsZero() {
+			quietSince = time.Now()
+		}
+		if time.Since(quietSince) >= drainQuietPeriod {
+			return nil
+		}
+	}
+}
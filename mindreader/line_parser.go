@@ -0,0 +1,67 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// BlockLineRef is the block information that can be extracted from a single
+// console line of the managed process. `ID` and `Time` may be empty when the
+// log format does not carry them.
+type BlockLineRef struct {
+	Num  uint64
+	ID   string
+	Time time.Time
+}
+
+// BlockLineParser extracts block information out of a console line of the
+// managed process, `ok` is false when the line does not refer to a block.
+type BlockLineParser interface {
+	ParseBlockLine(line string) (ref *BlockLineRef, ok bool)
+}
+
+type BlockLineParserFunc func(line string) (ref *BlockLineRef, ok bool)
+
+func (f BlockLineParserFunc) ParseBlockLine(line string) (*BlockLineRef, bool) {
+	return f(line)
+}
+
+// Matches nodeos `Received block` and `Produced block` lines, like:
+//
+//	Received block 1b8e4f2cf1a4c3ea... #127350812 @ 2020-06-22T15:03:09.500 signed by eosnationftw [...]
+var eosioBlockLineRegex = regexp.MustCompile(`(?:Received|Produced) block ([0-9a-f]+)\.*\s+#(\d+) @ (\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3})`)
+
+// EOSIOBlockLineParser understands the block lines printed by nodeos.
+var EOSIOBlockLineParser = BlockLineParserFunc(func(line string) (*BlockLineRef, bool) {
+	matches := eosioBlockLineRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, false
+	}
+
+	num, err := strconv.ParseUint(matches[2], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	ref := &BlockLineRef{Num: num, ID: matches[1]}
+	if t, err := time.Parse("2006-01-02T15:04:05.000", matches[3]); err == nil {
+		ref.Time = t
+	}
+
+	return ref, true
+})
@@ -0,0 +1,54 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEOSIOBlockLineParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		expectOK  bool
+		expectRef *BlockLineRef
+	}{
+		{
+			"received block",
+			"info  2020-06-22T15:03:09.501 nodeos    producer_plugin.cpp:376       on_incoming_block    ] Received block 1b8e4f2cf1a4c3ea... #127350812 @ 2020-06-22T15:03:09.500 signed by eosnationftw [trxs: 12, lib: 127350479, conf: 0, latency: 1 ms]",
+			true,
+			&BlockLineRef{Num: 127350812, ID: "1b8e4f2cf1a4c3ea", Time: time.Date(2020, 6, 22, 15, 3, 9, 500000000, time.UTC)},
+		},
+		{
+			"produced block",
+			"info  2020-06-22T15:03:09.501 nodeos    producer_plugin.cpp:2085      produce_block        ] Produced block 0001ea0f2a6e0ce8... #125455 @ 2020-06-22T15:03:09.000 signed by eosio [trxs: 0, lib: 125454, confirmed: 0]",
+			true,
+			&BlockLineRef{Num: 125455, ID: "0001ea0f2a6e0ce8", Time: time.Date(2020, 6, 22, 15, 3, 9, 0, time.UTC)},
+		},
+		{"deep mind line", "DMLOG ACCEPTED_BLOCK 125455 {}", false, nil},
+		{"unrelated line", "info  2020-06-22T15:03:09.501 nodeos    net_plugin.cpp:3024  connection accepted", false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ref, ok := EOSIOBlockLineParser.ParseBlockLine(test.line)
+			assert.Equal(t, test.expectOK, ok)
+			assert.Equal(t, test.expectRef, ref)
+		})
+	}
+}
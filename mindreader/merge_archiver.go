@@ -17,9 +17,12 @@ package mindreader
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +32,7 @@ import (
 	"github.com/abourget/llerrgroup"
 	"github.com/dfuse-io/bstream"
 	"github.com/dfuse-io/dstore"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -37,14 +41,21 @@ type MergeArchiver struct {
 	*shutter.Shutter
 	store              dstore.Store
 	blockWriterFactory bstream.BlockWriterFactory
+	outputPermissions  *outputFilePermissions // applied to uploaded files, see `WithOutputFilePermissions`
+	contentHashNames   bool                   // see `WithContentHashBundleNames`
+	orderedCommits     bool                   // see `WithOrderedBundleCommits`
 
 	uploadMutex sync.Mutex
 	workDir     string
 	expectBlock uint64
 	buffer      *bytes.Buffer
+	blocks      []*bstream.Block // blocks currently held in buffer, kept so the bundle can be flushed
+	flushedUpTo uint64           // next block after the last flushed range of the bundle being built, 0 if none was flushed
 	blockWriter bstream.BlockWriter
 	logger      *zap.Logger
 	running     bool
+
+	lastUploadedBlock *atomic.Uint64 // last block of the highest bundle uploaded, 0 if none yet
 }
 
 func NewMergeArchiver(
@@ -59,6 +70,7 @@ func NewMergeArchiver(
 		workDir:            workDir,
 		blockWriterFactory: blockWriterFactory,
 		logger:             logger,
+		lastUploadedBlock:  atomic.NewUint64(0),
 	}
 
 	a.OnTerminating(func(err error) {
@@ -120,6 +132,10 @@ func (m *MergeArchiver) uploadFiles() error {
 		return nil
 	}
 
+	if m.orderedCommits {
+		return m.uploadFilesInOrder(filesToUpload)
+	}
+
 	eg := llerrgroup.New(5)
 	for _, file := range filesToUpload {
 		if eg.Stop() {
@@ -137,18 +153,61 @@ func (m *MergeArchiver) uploadFiles() error {
 				m.logger.Debug("uploading file to storage", zap.String("local_file", file), zap.String("remove_base", toBaseName))
 			}
 
+			// named after their content, a bundle already uploaded is identical to ours
+			if m.contentHashNames {
+				exists, err := m.store.FileExists(ctx, toBaseName)
+				if err != nil {
+					return fmt.Errorf("checking if %q exists in storage: %w", toBaseName, err)
+				}
+				if exists {
+					m.logger.Info("identical merged bundle already uploaded, skipping it", zap.String("base_name", toBaseName))
+					return os.Remove(file)
+				}
+			}
+
 			if err = m.store.PushLocalFile(ctx, file, toBaseName); err != nil {
 				return fmt.Errorf("moving file %q to storage: %w", file, err)
 			}
-			return nil
+			return m.outputPermissions.apply(m.store, toBaseName)
 		})
 	}
 
-	return eg.Wait()
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// every pending bundle is now uploaded, so are all the blocks up to the highest one
+	for _, file := range filesToUpload {
+		lastNum, ok := bundleLastBlock(strings.TrimSuffix(filepath.Base(file), ".merged"))
+		if ok && lastNum > m.lastUploadedBlock.Load() {
+			m.lastUploadedBlock.Store(lastNum)
+		}
+	}
+	return nil
+}
+
+// bundleLastBlock returns the last block of the merged bundle named
+// `baseName`, either a full 100-blocks bundle (`0000000100`) or one named
+// after its range (`0000000100-0000000150`, optionally followed by a hash)
+func bundleLastBlock(baseName string) (uint64, bool) {
+	parts := strings.SplitN(baseName, "-", 3)
+	if len(parts) == 1 {
+		baseNum, err := strconv.ParseUint(parts[0], 10, 64)
+		return baseNum + 99, err == nil
+	}
+	lastNum, err := strconv.ParseUint(parts[1], 10, 64)
+	return lastNum, err == nil
+}
+
+// LastUploadedBlock returns the last block of the highest merged bundle
+// uploaded so far, 0 if none was. All the bundles below it were uploaded too.
+func (m *MergeArchiver) LastUploadedBlock() uint64 {
+	return m.lastUploadedBlock.Load()
 }
 
 func (m *MergeArchiver) newBuffer() error {
 	m.buffer = &bytes.Buffer{}
+	m.blocks = nil
 	blockWriter, err := m.blockWriterFactory.New(m.buffer)
 	if err != nil {
 		return fmt.Errorf("blockWriteFactory: %w", err)
@@ -161,8 +220,7 @@ func (m *MergeArchiver) newBuffer() error {
 func (m *MergeArchiver) Terminate() <-chan interface{} {
 	ch := make(chan interface{})
 	if m.buffer != nil {
-		err := m.writePartialFile()
-		if err != nil {
+		if err := m.writePartialFile(); err != nil {
 			m.logger.Error("writing partial file", zap.Error(err))
 		}
 	}
@@ -176,6 +234,77 @@ func (m *MergeArchiver) Terminate() <-chan interface{} {
 	return ch
 }
 
+// FlushBundle writes the blocks of the bundle currently being built that
+// were not flushed yet right away, named after their range (like
+// `0000000100-0000000150`), for the upload loop to push them. The bundle
+// keeps being built: once the boundary is reached, the full 100-blocks bundle
+// is written as usual, so readers expecting them see no gap. It returns nil
+// when there is nothing to flush.
+func (m *MergeArchiver) FlushBundle() (*FlushedBundle, error) {
+	var pending []*bstream.Block
+	for _, blk := range m.blocks {
+		if blk.Num() >= m.flushedUpTo {
+			pending = append(pending, blk)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	buffer := &bytes.Buffer{}
+	blockWriter, err := m.blockWriterFactory.New(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("blockWriteFactory: %w", err)
+	}
+	for _, blk := range pending {
+		if err := blockWriter.Write(blk); err != nil {
+			return nil, fmt.Errorf("blockWriter.Write: %w", err)
+		}
+	}
+
+	flushed := &FlushedBundle{StartBlock: pending[0].Num(), EndBlock: pending[len(pending)-1].Num()}
+	if err := m.writeMergedFile(flushed.StartBlock, flushed.EndBlock, buffer); err != nil {
+		return nil, err
+	}
+	m.flushedUpTo = flushed.EndBlock + 1
+	return flushed, nil
+}
+
+// writeMergedFile writes `buffer` as the merged bundle going from `startNum`
+// to `endNum`, named after its range unless it is a full one
+func (m *MergeArchiver) writeMergedFile(startNum, endNum uint64, buffer *bytes.Buffer) error {
+	baseName := fmt.Sprintf("%010d", startNum)
+	if m.contentHashNames {
+		checksum := sha256.Sum256(buffer.Bytes())
+		baseName = fmt.Sprintf("%010d-%010d-%s", startNum, endNum, hex.EncodeToString(checksum[:]))
+	} else if startNum%100 != 0 || endNum%100 != 99 {
+		baseName = fmt.Sprintf("%010d-%010d", startNum, endNum)
+	}
+	if startNum%1000 == 0 {
+		m.logger.Info("writing merged blocks log (%1000)", zap.String("base_name", baseName))
+	}
+
+	tempFile := filepath.Join(m.workDir, baseName+".merged.temp")
+	finalFile := filepath.Join(m.workDir, baseName+".merged")
+
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+
+	if _, err := buffer.WriteTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("write file %q: %w", tempFile, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close file %q: %w", tempFile, err)
+	}
+	if err := os.Rename(tempFile, finalFile); err != nil {
+		return fmt.Errorf("rename %q to %q: %w", tempFile, finalFile, err)
+	}
+	return nil
+}
+
 func (m *MergeArchiver) writePartialFile() error {
 	filename := filepath.Join(m.workDir, fmt.Sprintf("archiver_%010d.partial", m.expectBlock))
 
@@ -189,7 +318,44 @@ func (m *MergeArchiver) writePartialFile() error {
 	return err
 }
 
+// rewindTo drops the blocks at or above `blockNum` from the bundle being built,
+// so that the blocks of the new fork replace them after a reorg.
+func (m *MergeArchiver) rewindTo(blockNum uint64) error {
+	if len(m.blocks) == 0 || m.blocks[0].Num() > blockNum {
+		return fmt.Errorf("blocks non contiguous, cannot rewind to block %d outside of current bundle, expectedBlock: %d", blockNum, m.expectBlock)
+	}
+	if blockNum < m.flushedUpTo {
+		return fmt.Errorf("blocks non contiguous, cannot rewind to block %d already flushed, expectedBlock: %d", blockNum, m.expectBlock)
+	}
+
+	var kept []*bstream.Block
+	for _, blk := range m.blocks {
+		if blk.Num() < blockNum {
+			kept = append(kept, blk)
+		}
+	}
+
+	m.logger.Info("rewinding merged bundle because of a reorg", zap.Uint64("block_num", blockNum), zap.Int("dropped_blocks", len(m.blocks)-len(kept)))
+	if err := m.newBuffer(); err != nil {
+		return err
+	}
+	for _, blk := range kept {
+		if err := m.blockWriter.Write(blk); err != nil {
+			return fmt.Errorf("blockWriter.Write: %w", err)
+		}
+	}
+	m.blocks = kept
+	m.expectBlock = blockNum
+	return nil
+}
+
 func (m *MergeArchiver) StoreBlock(block *bstream.Block) error {
+	if m.buffer != nil && block.Num() < m.expectBlock {
+		if err := m.rewindTo(block.Num()); err != nil {
+			return err
+		}
+	}
+
 	if m.buffer == nil && block.Num() < 3 {
 		// Special case the beginning of the EOS chain
 
@@ -203,6 +369,7 @@ func (m *MergeArchiver) StoreBlock(block *bstream.Block) error {
 		if err := m.newBuffer(); err != nil {
 			return err
 		}
+		m.flushedUpTo = 0
 
 		if m.expectBlock%100 == 0 { // relaxing enforcement here, 299 could be followed by 400 if the blocks 300->399 were sent to another archiver
 			m.expectBlock = block.Num()
@@ -222,27 +389,12 @@ func (m *MergeArchiver) StoreBlock(block *bstream.Block) error {
 	if err := m.blockWriter.Write(block); err != nil {
 		return fmt.Errorf("blockWriter.Write: %w", err)
 	}
+	m.blocks = append(m.blocks, block)
 
 	if block.Num()%100 == 99 {
-		baseNum := block.Num() - 99
-		baseName := fmt.Sprintf("%010d", baseNum)
-		if baseNum%1000 == 0 {
-			m.logger.Info("writing merged blocks log (%1000)", zap.String("base_name", baseName))
-		}
-
-		tempFile := filepath.Join(m.workDir, baseName+".merged.temp")
-		finalFile := filepath.Join(m.workDir, baseName+".merged")
-
-		file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("open file: %w", err)
-		}
-
-		m.buffer.WriteTo(file)
-		if err := os.Rename(tempFile, finalFile); err != nil {
-			return fmt.Errorf("rename %q to %q: %w", tempFile, finalFile, err)
-		}
-
+		m.blocks = nil
+		m.flushedUpTo = 0
+		return m.writeMergedFile(block.Num()-99, block.Num(), m.buffer)
 	}
 
 	return nil
@@ -15,13 +15,22 @@
 package mindreader
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/dfuse-io/bstream"
 	"github.com/dfuse-io/dbin"
 	"github.com/dfuse-io/dstore"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -38,10 +47,15 @@ func init() {
 	})
 }
 func TestMergeArchiver(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "merge_archiver")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
 	mStore := dstore.NewMockStore(nil)
 	a := &MergeArchiver{
 		logger:             zap.NewNop(),
 		store:              mStore,
+		workDir:            workDir,
 		blockWriterFactory: bstream.GetBlockWriterFactory,
 	}
 
@@ -64,6 +78,7 @@ func TestMergeArchiver(t *testing.T) {
 
 	assert.NoError(t, a.StoreBlock(&bstream.Block{Number: 199, PayloadBuffer: []byte{0x01}}))
 	assert.True(t, a.buffer.Len() == 0)
+	assert.FileExists(t, filepath.Join(workDir, "0000000100.merged"))
 
 	assert.NoError(t, a.StoreBlock(&bstream.Block{Number: 300, PayloadBuffer: []byte{0x01}}), "should accept any block ...00 after block ...99")
 	assert.True(t, a.buffer.Len() < prevSize)
@@ -91,3 +106,195 @@ func TestMergeArchiverSpecialCase(t *testing.T) {
 		prevSize = a.buffer.Len()
 	}
 }
+
+func TestMergeArchiverReorg(t *testing.T) {
+	mStore := dstore.NewMockStore(nil)
+	a := &MergeArchiver{
+		logger:             zap.NewNop(),
+		store:              mStore,
+		blockWriterFactory: bstream.GetBlockWriterFactory,
+	}
+
+	for i := 100; i < 110; i++ {
+		assert.NoError(t, a.StoreBlock(&bstream.Block{Number: uint64(i), Id: "a", PayloadBuffer: []byte{0x01}}))
+	}
+	prevSize := a.buffer.Len()
+
+	assert.NoError(t, a.StoreBlock(&bstream.Block{Number: 105, Id: "b", PayloadBuffer: []byte{0x02}}))
+	assert.Equal(t, uint64(106), a.expectBlock)
+	assert.Len(t, a.blocks, 6)
+	assert.Equal(t, "b", a.blocks[5].ID())
+	assert.True(t, a.buffer.Len() < prevSize)
+
+	assert.Error(t, a.StoreBlock(&bstream.Block{Number: 99, Id: "b", PayloadBuffer: []byte{0x02}}), "cannot rewind before the current bundle")
+}
+
+func TestMergeArchiverLastUploadedBlock(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "merge_archiver")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	a := NewMergeArchiver(dstore.NewMockStore(nil), bstream.GetBlockWriterFactory, workDir, zap.NewNop())
+	assert.Equal(t, uint64(0), a.LastUploadedBlock())
+
+	for i := 100; i < 350; i++ {
+		require.NoError(t, a.StoreBlock(&bstream.Block{Number: uint64(i), PayloadBuffer: []byte{0x01}}))
+	}
+	require.NoError(t, a.uploadFiles())
+	assert.Equal(t, uint64(299), a.LastUploadedBlock())
+}
+
+func TestMergeArchiverOutputFilePermissions(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "merge_archiver")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	store, err := dstore.NewDBinStore("file://" + filepath.Join(workDir, "store"))
+	require.NoError(t, err)
+
+	a := NewMergeArchiver(store, bstream.GetBlockWriterFactory, filepath.Join(workDir, "work"), zap.NewNop())
+	a.outputPermissions, err = newOutputFilePermissions(0600, "", "")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(a.workDir, 0755))
+
+	for i := 100; i < 200; i++ {
+		require.NoError(t, a.StoreBlock(&bstream.Block{Number: uint64(i), PayloadBuffer: []byte{0x01}}))
+	}
+	require.NoError(t, a.uploadFiles())
+
+	info, err := os.Stat(store.(*dstore.LocalStore).ObjectPath("0000000100"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestMergeArchiverContentHashNames(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "merge_archiver")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	store, err := dstore.NewDBinStore("file://" + filepath.Join(workDir, "store"))
+	require.NoError(t, err)
+
+	writeBundle := func() {
+		a := NewMergeArchiver(store, bstream.GetBlockWriterFactory, filepath.Join(workDir, "work"), zap.NewNop())
+		a.contentHashNames = true
+		require.NoError(t, os.MkdirAll(a.workDir, 0755))
+
+		for i := 100; i < 200; i++ {
+			require.NoError(t, a.StoreBlock(&bstream.Block{Number: uint64(i), PayloadBuffer: []byte{0x01}}))
+		}
+		require.NoError(t, a.uploadFiles())
+		assert.Equal(t, uint64(199), a.LastUploadedBlock())
+	}
+
+	writeBundle()
+	var names []string
+	require.NoError(t, store.Walk(context.Background(), "", "", func(filename string) error {
+		names = append(names, filename)
+		return nil
+	}))
+	require.Len(t, names, 1)
+	assert.Regexp(t, `^0000000100-0000000199-[0-9a-f]{64}$`, names[0])
+
+	uploaded, err := os.Stat(store.(*dstore.LocalStore).ObjectPath(names[0]))
+	require.NoError(t, err)
+
+	// reprocessing yields the same name, the bundle is not uploaded again
+	writeBundle()
+	again, err := os.Stat(store.(*dstore.LocalStore).ObjectPath(names[0]))
+	require.NoError(t, err)
+	assert.Equal(t, uploaded.ModTime(), again.ModTime())
+
+	left, err := findFilesToUpload(filepath.Join(workDir, "work"), zap.NewNop(), ".merged")
+	require.NoError(t, err)
+	assert.Len(t, left, 0)
+}
+
+// orderingStore pushes the first bundles slower (or the objects in `delays`
+// when set), and fails the ones in `failing`
+type orderingStore struct {
+	dstore.Store
+	failing map[string]bool
+	delays  map[string]time.Duration
+
+	lock    sync.Mutex
+	commits []string
+}
+
+func (s *orderingStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	if s.failing[base] {
+		return fmt.Errorf("write failed")
+	}
+	delays := s.delays
+	if delays == nil {
+		delays = map[string]time.Duration{stagedBundlePrefix + "0000000100": 100 * time.Millisecond, stagedBundlePrefix + "0000000200": 50 * time.Millisecond}
+	}
+	select {
+	case <-time.After(delays[base]):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if !strings.HasPrefix(base, stagedBundlePrefix) {
+		s.lock.Lock()
+		s.commits = append(s.commits, base)
+		s.lock.Unlock()
+	}
+	return s.Store.WriteObject(ctx, base, f)
+}
+
+func TestMergeArchiverOrderedCommits(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		failing          map[string]bool
+		delays           map[string]time.Duration
+		expectCommits    []string
+		expectLastUpload uint64
+	}{
+		{"all uploaded", nil, nil, []string{"0000000100", "0000000200", "0000000300"}, 399},
+		{"failed bundle holds back the next ones", map[string]bool{stagedBundlePrefix + "0000000200": true}, nil, []string{"0000000100"}, 199},
+		{"slow commit does not expire the next bundles", nil, map[string]time.Duration{"0000000100": 150 * time.Millisecond}, []string{"0000000100", "0000000200", "0000000300"}, 399},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.delays != nil {
+				defer func(timeout time.Duration) { bundleStageTimeout = timeout }(bundleStageTimeout)
+				bundleStageTimeout = 100 * time.Millisecond // shorter than the wait for the slow commit
+			}
+
+			workDir, err := ioutil.TempDir("", "merge_archiver")
+			require.NoError(t, err)
+			defer os.RemoveAll(workDir)
+
+			localStore, err := dstore.NewDBinStore("file://" + filepath.Join(workDir, "store"))
+			require.NoError(t, err)
+			store := &orderingStore{Store: localStore, failing: test.failing, delays: test.delays}
+
+			a := NewMergeArchiver(store, bstream.GetBlockWriterFactory, filepath.Join(workDir, "work"), zap.NewNop())
+			a.orderedCommits = true
+			require.NoError(t, os.MkdirAll(a.workDir, 0755))
+
+			for i := 100; i < 400; i++ {
+				require.NoError(t, a.StoreBlock(&bstream.Block{Number: uint64(i), PayloadBuffer: []byte{0x01}}))
+			}
+			err = a.uploadFiles()
+			if test.failing != nil {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expectCommits, store.commits)
+			assert.Equal(t, test.expectLastUpload, a.LastUploadedBlock())
+
+			for _, name := range test.expectCommits {
+				exists, err := localStore.FileExists(context.Background(), stagedBundlePrefix+name)
+				require.NoError(t, err)
+				assert.False(t, exists, "staged %s left behind", name)
+			}
+
+			// the bundles held back are uploaded again on the next run
+			left, err := findFilesToUpload(a.workDir, zap.NewNop(), ".merged")
+			require.NoError(t, err)
+			assert.Len(t, left, 3-len(test.expectCommits))
+		})
+	}
+}
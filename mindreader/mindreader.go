@@ -15,15 +15,19 @@
 package mindreader
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/dfuse-io/bstream"
 	"github.com/dfuse-io/bstream/blockstream"
 	"github.com/dfuse-io/dstore"
 	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/dfuse-io/shutter"
 	"go.uber.org/zap"
 )
@@ -50,7 +54,8 @@ type MindReaderPlugin struct {
 	waitUploadCompleteOnShutdown time.Duration // if non-zero, will try to upload files for this amount of time. Failed uploads will stay in workingDir
 
 	lines         chan string
-	consoleReader ConsolerReader // contains the 'reader' part of the pipe
+	blocks        chan *bstream.Block // transformed blocks waiting for consumeReadFlow
+	consoleReader ConsolerReader      // contains the 'reader' part of the pipe
 
 	transformer     ConsoleReaderBlockTransformer // objects read from consoleReader are transformed into blocks
 	channelCapacity int                           // transformed blocks are buffered in a channel
@@ -61,8 +66,114 @@ type MindReaderPlugin struct {
 	continuityChecker   ContinuityChecker
 
 	blockStreamServer    *blockstream.Server
+	blockHub             *BlockHub // if set, blocks are also fanned out to its subscribers, see `WithBlockHub`
 	headBlockUpdateFunc  nodeManager.HeadBlockUpdater
 	consoleReaderFactory ConsolerReaderFactory
+	blockLineParser      BlockLineParser // if set, head block is tracked from the raw console lines instead of the transformed blocks
+
+	continuityAllowedSkips      uint64 // passed to the continuity checker, see `WithAllowedSkips`
+	continuityGapHistorySize    int    // passed to the continuity checker, see `WithGapHistory`
+	continuityPersistGapHistory bool
+	continuityGapHandler        func(gap *ContinuityGap) // see `OnContinuityGap`
+	continuityGapPolicy         ContinuityGapPolicy      // see `WithContinuityGapPolicy`
+
+	contentHashBundleNames bool // see `WithContentHashBundleNames`
+	orderedBundleCommits   bool // see `WithOrderedBundleCommits`
+
+	rateLimiter *blockRateLimiter // if set, caps how fast blocks are consumed, see `SetMaxBlocksPerSecond`
+
+	outputFileMode  os.FileMode // see `WithOutputFilePermissions`
+	outputFileOwner string
+	outputFileGroup string
+
+	lastBlockNum uint64 // last block seen by consumeReadFlow, used to detect reorgs
+	lastBlockID  string
+
+	lastArchivedLock sync.Mutex
+	lastArchived     *HandoverBlock // see `LastArchivedBlock`
+}
+
+// MindReaderPluginOption configures optional behaviors of the MindReaderPlugin.
+type MindReaderPluginOption func(p *MindReaderPlugin)
+
+// WithBlockLineParser makes the plugin track the head block from the console
+// lines recognized by `parser` (see `EOSIOBlockLineParser`), which is useful
+// for chains whose log format differs from the one the transformer expects.
+func WithBlockLineParser(parser BlockLineParser) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.blockLineParser = parser
+	}
+}
+
+// SetBlockLineParser is `WithBlockLineParser` for an already created plugin,
+// it must be called before the managed process starts logging.
+func (p *MindReaderPlugin) SetBlockLineParser(parser BlockLineParser) {
+	p.blockLineParser = parser
+}
+
+// WithContinuityAllowedSkips makes the continuity checker (enabled with
+// `failOnNonContinuousBlocks`) tolerate up to `count` skipped block numbers.
+func WithContinuityAllowedSkips(count uint64) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.continuityAllowedSkips = count
+	}
+}
+
+// WithContinuityGapHistory makes the continuity checker keep the last `size`
+// gaps it detected, persisted in the working directory if `persist` is true.
+func WithContinuityGapHistory(size int, persist bool) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.continuityGapHistorySize = size
+		p.continuityPersistGapHistory = persist
+	}
+}
+
+// WithContinuityGapPolicy sets what the continuity checker (enabled with
+// `failOnNonContinuousBlocks`) does when it detects a gap, see `ContinuityGapPolicy`.
+func WithContinuityGapPolicy(policy ContinuityGapPolicy) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.continuityGapPolicy = policy
+	}
+}
+
+// WithOutputFilePermissions sets the mode (if non-zero), owner and group (if
+// non-empty, names or numeric ids) of the one-block files and merged bundles
+// written to local stores. Remote stores are left untouched.
+func WithOutputFilePermissions(mode os.FileMode, owner, group string) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.outputFileMode = mode
+		p.outputFileOwner = owner
+		p.outputFileGroup = group
+	}
+}
+
+// WithContentHashBundleNames names merged bundles `<start block>-<end block>-<sha256
+// of content>` instead of their start block alone, a bundle already uploaded
+// under the same name is identical and not uploaded again. Consumers of the
+// merged blocks store must expect that naming.
+func WithContentHashBundleNames() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.contentHashBundleNames = true
+	}
+}
+
+// WithOrderedBundleCommits makes merged bundles visible in the merged blocks
+// store strictly in block order. They are still uploaded concurrently, under
+// a `staged-` prefixed name, and copied to their final name once the bundles
+// before them were. `LastUploadedMergedBlock` is the block they are committed
+// up to.
+func WithOrderedBundleCommits() MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.orderedBundleCommits = true
+	}
+}
+
+// WithBlockHub makes the plugin push every block to `hub`, which streams them
+// to gRPC subscribers, dropping the ones that cannot keep up.
+func WithBlockHub(hub *BlockHub) MindReaderPluginOption {
+	return func(p *MindReaderPlugin) {
+		p.blockHub = hub
+	}
 }
 
 // NewMindReaderPlugin initiates its own:
@@ -91,6 +202,7 @@ func NewMindReaderPlugin(
 	oneblockSuffix string,
 	blockStreamServer *blockstream.Server,
 	zlogger *zap.Logger,
+	options ...MindReaderPluginOption,
 ) (*MindReaderPlugin, error) {
 	zlogger.Info("creating mindreader plugin",
 		zap.String("archive_store_url", archiveStoreURL),
@@ -118,8 +230,7 @@ func NewMindReaderPlugin(
 	if err != nil {
 		return nil, fmt.Errorf("setting up archive store: %w", err)
 	}
-	var oneBlockArchiver Archiver
-	oneBlockArchiver = NewOneBlockArchiver(oneblockArchiveStore, bstream.GetBlockWriterFactory, workingDirectory, oneblockSuffix, zlogger)
+	oneBlockArchiver := NewOneBlockArchiver(oneblockArchiveStore, bstream.GetBlockWriterFactory, workingDirectory, oneblockSuffix, zlogger)
 
 	mergeArchiveStore, err := dstore.NewDBinStore(mergeArchiveStoreURL)
 	if err != nil {
@@ -129,8 +240,7 @@ func NewMindReaderPlugin(
 		mergeArchiveStore.SetOverwrite(true)
 	}
 
-	var mergeArchiver Archiver
-	mergeArchiver = NewMergeArchiver(mergeArchiveStore, bstream.GetBlockWriterFactory, workingDirectory, zlogger)
+	mergeArchiver := NewMergeArchiver(mergeArchiveStore, bstream.GetBlockWriterFactory, workingDirectory, zlogger)
 
 	archiverSelector := NewArchiverSelector(oneBlockArchiver, mergeArchiver, bstream.GetBlockReaderFactory, batchMode, tracker, mergeThresholdBlockAge, workingDirectory, zlogger)
 
@@ -154,6 +264,42 @@ func NewMindReaderPlugin(
 	}
 	mindReaderPlugin.waitUploadCompleteOnShutdown = waitUploadCompleteOnShutdown
 
+	for _, opt := range options {
+		opt(mindReaderPlugin)
+	}
+
+	mergeArchiver.contentHashNames = mindReaderPlugin.contentHashBundleNames
+	mergeArchiver.orderedCommits = mindReaderPlugin.orderedBundleCommits
+
+	if mindReaderPlugin.outputFileMode != 0 || mindReaderPlugin.outputFileOwner != "" || mindReaderPlugin.outputFileGroup != "" {
+		permissions, err := newOutputFilePermissions(mindReaderPlugin.outputFileMode, mindReaderPlugin.outputFileOwner, mindReaderPlugin.outputFileGroup)
+		if err != nil {
+			return nil, err
+		}
+		oneBlockArchiver.outputPermissions = permissions
+		mergeArchiver.outputPermissions = permissions
+	}
+
+	if failOnNonContinuousBlocks {
+		ccOptions := []ContinuityCheckerOption{WithAllowedSkips(mindReaderPlugin.continuityAllowedSkips)}
+		if mindReaderPlugin.continuityGapPolicy != "" {
+			ccOptions = append(ccOptions, WithGapPolicy(mindReaderPlugin.continuityGapPolicy))
+		}
+		if mindReaderPlugin.continuityGapHistorySize != 0 || mindReaderPlugin.continuityPersistGapHistory {
+			size := mindReaderPlugin.continuityGapHistorySize
+			if size == 0 {
+				size = defaultGapHistorySize
+			}
+			ccOptions = append(ccOptions, WithGapHistory(size, mindReaderPlugin.continuityPersistGapHistory))
+		}
+
+		continuityChecker, err := NewContinuityChecker(filepath.Join(workingDirectory, "continuity_check"), zlogger, ccOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up continuity checker: %w", err)
+		}
+		mindReaderPlugin.continuityChecker = continuityChecker
+	}
+
 	return mindReaderPlugin, nil
 }
 
@@ -192,6 +338,7 @@ func (p *MindReaderPlugin) Launch() {
 
 	p.consumeReadFlowDone = make(chan interface{})
 	blocks := make(chan *bstream.Block, p.channelCapacity)
+	p.blocks = blocks
 
 	lines := make(chan string, 10000) //need a config here?
 	p.lines = lines
@@ -223,7 +370,7 @@ func (p *MindReaderPlugin) Launch() {
 	}()
 }
 
-func (p MindReaderPlugin) Stop() {
+func (p *MindReaderPlugin) Stop() {
 	p.zlogger.Info("mindreader is stopping")
 	close(p.lines)
 	p.waitForReadFlowToComplete()
@@ -256,7 +403,13 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 			return
 		}
 
+		if p.rateLimiter != nil {
+			// no more throttling once terminating, the blocks left must be drained
+			p.rateLimiter.wait(p.Terminating())
+		}
+
 		p.zlogger.Debug("got one block", zap.Uint64("block_num", block.Number))
+		p.detectReorg(block)
 
 		err := p.archiver.StoreBlock(block)
 		if err != nil {
@@ -265,6 +418,8 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 				go p.Shutdown(fmt.Errorf("archiver store block failed: %w", err))
 				continue
 			}
+		} else {
+			p.setLastArchivedBlock(block)
 		}
 		if p.blockStreamServer != nil {
 			err = p.blockStreamServer.PushBlock(block)
@@ -277,11 +432,23 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 				continue
 			}
 		}
+		if p.blockHub != nil {
+			p.blockHub.PushBlock(block)
+		}
 
 		if p.continuityChecker != nil {
+			wasLocked := p.continuityChecker.IsLocked()
 			err = p.continuityChecker.Write(block.Num())
+			if errors.Is(err, ErrContinuityGapTolerated) {
+				p.zlogger.Warn("continuity gap tolerated, keeping on", zap.Error(err))
+				p.reportContinuityGap()
+				continue
+			}
 			if err != nil {
 				p.zlogger.Error("failed continuity check", zap.Error(err))
+				if !wasLocked {
+					p.reportContinuityGap()
+				}
 
 				if !p.IsTerminating() {
 					go p.Shutdown(fmt.Errorf("continuity check failed: %w", err))
@@ -292,6 +459,25 @@ func (p *MindReaderPlugin) consumeReadFlow(blocks <-chan *bstream.Block) {
 	}
 }
 
+// detectReorg reports blocks replacing ones already seen at the same or a
+// higher height. The archivers receive the corrected blocks like any other.
+func (p *MindReaderPlugin) detectReorg(block *bstream.Block) {
+	if p.lastBlockID != "" && block.Num() <= p.lastBlockNum && block.ID() != p.lastBlockID {
+		depth := p.lastBlockNum - block.Num() + 1
+		p.zlogger.Warn("chain reorganization detected",
+			zap.Uint64("previous_block_num", p.lastBlockNum),
+			zap.String("previous_block_id", p.lastBlockID),
+			zap.Stringer("block", block),
+			zap.Uint64("depth", depth),
+		)
+		metrics.Reorgs.Inc()
+		metrics.ReorgDepth.ObserveInt64(int64(depth))
+	}
+
+	p.lastBlockNum = block.Num()
+	p.lastBlockID = block.ID()
+}
+
 func (p *MindReaderPlugin) readOneMessage(blocks chan<- *bstream.Block) error {
 	obj, err := p.consoleReader.Read()
 	if err != nil {
@@ -303,11 +489,15 @@ func (p *MindReaderPlugin) readOneMessage(blocks chan<- *bstream.Block) error {
 		return fmt.Errorf("unable to transform console read obj to bstream.Block: %w", err)
 	}
 
+	gatePassed := p.startGate.passed
 	if !p.startGate.pass(block) {
 		return nil
 	}
+	if !gatePassed && p.startGate.blockNum != 0 && block.Num() > p.startGate.blockNum {
+		p.zlogger.Warn("first block is above the start block, the blocks in between are missing", zap.Uint64("block_num", block.Num()), zap.Uint64("start_block_num", p.startGate.blockNum))
+	}
 
-	if p.headBlockUpdateFunc != nil {
+	if p.headBlockUpdateFunc != nil && p.blockLineParser == nil {
 		p.headBlockUpdateFunc(block.Num(), block.ID(), block.Time())
 	}
 
@@ -326,13 +516,68 @@ func (p *MindReaderPlugin) LogLine(in string) {
 	if p.IsTerminating() {
 		return
 	}
+
+	if p.blockLineParser != nil && p.headBlockUpdateFunc != nil {
+		if ref, ok := p.blockLineParser.ParseBlockLine(in); ok {
+			p.headBlockUpdateFunc(ref.Num, ref.ID, ref.Time)
+		}
+	}
+
 	p.lines <- in
 }
 
+// FlushBundle writes the merged bundle currently being built, see
+// `ArchiverSelector.FlushBundle`. It returns nil when there was nothing to flush.
+func (p *MindReaderPlugin) FlushBundle() (*FlushedBundle, error) {
+	selector, ok := p.archiver.(*ArchiverSelector)
+	if !ok {
+		return nil, fmt.Errorf("archiver does not support flushing bundles")
+	}
+	return selector.FlushBundle()
+}
+
+// LastUploadedMergedBlock returns the block up to which every merged bundle is
+// uploaded, 0 if unknown. Blocks archived as one-block files are not accounted for.
+func (p *MindReaderPlugin) LastUploadedMergedBlock() uint64 {
+	selector, ok := p.archiver.(*ArchiverSelector)
+	if !ok {
+		return 0
+	}
+	return selector.LastUploadedMergedBlock()
+}
+
 func (p *MindReaderPlugin) HasContinuityChecker() bool {
 	return p.continuityChecker != nil
 }
 
+// ContinuityLocked tells if the continuity checker is locked after a gap
+func (p *MindReaderPlugin) ContinuityLocked() bool {
+	return p.continuityChecker != nil && p.continuityChecker.IsLocked()
+}
+
+// ContinuityGaps returns the gaps detected by the continuity checker, oldest first
+func (p *MindReaderPlugin) ContinuityGaps() []*ContinuityGap {
+	if p.continuityChecker == nil {
+		return nil
+	}
+	return p.continuityChecker.Gaps()
+}
+
+// OnContinuityGap makes the plugin call `handler` with each gap detected by
+// the continuity checker. It must be called before `Launch`.
+func (p *MindReaderPlugin) OnContinuityGap(handler func(gap *ContinuityGap)) {
+	p.continuityGapHandler = handler
+}
+
+func (p *MindReaderPlugin) reportContinuityGap() {
+	if p.continuityGapHandler == nil {
+		return
+	}
+	if gaps := p.continuityChecker.Gaps(); len(gaps) > 0 {
+		p.continuityGapHandler(gaps[len(gaps)-1])
+	}
+}
+
 func (p *MindReaderPlugin) ResetContinuityChecker() {
 	if p.continuityChecker != nil {
 		p.continuityChecker.Reset()
@@ -0,0 +1,179 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abourget/llerrgroup"
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// stagedBundlePrefix prefixes the merged bundles uploaded but not committed
+// yet, see `WithOrderedBundleCommits`
+const stagedBundlePrefix = "staged-"
+
+var errPredecessorNotCommitted = errors.New("a previous bundle was not committed")
+
+// Timeouts of staging a bundle and of committing it, waiting for the
+// previous bundles to be committed is not bounded by either
+var (
+	bundleStageTimeout  = 3 * time.Minute
+	bundleCommitTimeout = 3 * time.Minute
+)
+
+// uploadFilesInOrder uploads the merged bundles concurrently under a staged
+// name, then commits them (copies them to their final name) strictly in
+// block order: a bundle uploaded before its predecessors is held until they
+// are committed. A failed bundle holds back all the ones after it, they are
+// uploaded again on the next run.
+func (m *MergeArchiver) uploadFilesInOrder(filesToUpload []string) error {
+	sort.Slice(filesToUpload, func(i, j int) bool {
+		return filepath.Base(filesToUpload[i]) < filepath.Base(filesToUpload[j])
+	})
+
+	committed := make([]chan struct{}, len(filesToUpload))
+	for i := range committed {
+		committed[i] = make(chan struct{})
+	}
+	failed := make(chan struct{})
+	var failOnce sync.Once
+
+	eg := llerrgroup.New(5)
+	for i, file := range filesToUpload {
+		if eg.Stop() {
+			break
+		}
+
+		i := i
+		file := file
+		toBaseName := strings.TrimSuffix(filepath.Base(file), ".merged")
+
+		eg.Go(func() error {
+			err := m.uploadAndCommit(i, file, toBaseName, committed, failed)
+			if err != nil {
+				failOnce.Do(func() { close(failed) })
+				return err
+			}
+			close(committed[i])
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
+
+func (m *MergeArchiver) uploadAndCommit(index int, file, toBaseName string, committed []chan struct{}, failed chan struct{}) error {
+	stagedName := stagedBundlePrefix + toBaseName
+	exists, err := m.stage(file, toBaseName, stagedName)
+	if err != nil {
+		return err
+	}
+
+	if index > 0 {
+		select {
+		case <-committed[index-1]:
+		case <-failed:
+			return fmt.Errorf("holding back %q: %w", toBaseName, errPredecessorNotCommitted)
+		}
+	}
+
+	if !exists {
+		ctx, cancel := context.WithTimeout(context.Background(), bundleCommitTimeout)
+		defer cancel()
+		if err := m.commitStagedBundle(ctx, stagedName, toBaseName); err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	lastNum, ok := bundleLastBlock(toBaseName)
+	if ok && lastNum > m.lastUploadedBlock.Load() {
+		m.lastUploadedBlock.Store(lastNum)
+		metrics.CommittedMergedBlock.SetUint64(lastNum)
+	}
+	return nil
+}
+
+// stage uploads the local bundle `file` under `stagedName`, unless an
+// identical one is already in storage (with content hash names), in which
+// case it returns true and discards `file`.
+func (m *MergeArchiver) stage(file, toBaseName, stagedName string) (exists bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bundleStageTimeout)
+	defer cancel()
+
+	if m.contentHashNames {
+		if exists, err = m.store.FileExists(ctx, toBaseName); err != nil {
+			return false, fmt.Errorf("checking if %q exists in storage: %w", toBaseName, err)
+		}
+	}
+
+	if exists {
+		m.logger.Info("identical merged bundle already uploaded, skipping it", zap.String("base_name", toBaseName))
+		return true, os.Remove(file)
+	}
+
+	if traceEnabled {
+		m.logger.Debug("uploading staged file to storage", zap.String("local_file", file), zap.String("staged_base", stagedName))
+	}
+	return false, m.stageBundle(ctx, file, stagedName)
+}
+
+// stageBundle uploads the local bundle `file` under `stagedName`, keeping it
+// so it is uploaded again if it is held back
+func (m *MergeArchiver) stageBundle(ctx context.Context, file, stagedName string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := m.store.WriteObject(ctx, stagedName, f); err != nil {
+		return fmt.Errorf("staging file %q to storage: %w", file, err)
+	}
+	return nil
+}
+
+// commitStagedBundle makes the staged bundle visible under its final name
+func (m *MergeArchiver) commitStagedBundle(ctx context.Context, stagedName, toBaseName string) error {
+	reader, err := m.store.OpenObject(ctx, stagedName)
+	if err != nil {
+		return fmt.Errorf("opening staged bundle %q: %w", stagedName, err)
+	}
+	defer reader.Close()
+
+	if err := m.store.WriteObject(ctx, toBaseName, reader); err != nil {
+		return fmt.Errorf("committing staged bundle %q: %w", stagedName, err)
+	}
+	if err := m.outputPermissions.apply(m.store, toBaseName); err != nil {
+		return err
+	}
+
+	if err := m.store.DeleteObject(ctx, stagedName); err != nil {
+		m.logger.Warn("unable to delete committed staged bundle", zap.String("staged_base", stagedName), zap.Error(err))
+	}
+	return nil
+}
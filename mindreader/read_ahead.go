@@ -0,0 +1,95 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"io"
+)
+
+const readAheadChunkSize = 64 * 1024
+
+// ReadAheadReader reads its input ahead of its consumer in the background,
+// keeping up to about `size` bytes in memory, so the consumer does not wait
+// on slow reads (like a block log on disk).
+type ReadAheadReader struct {
+	chunks  chan readAheadChunk
+	current []byte
+	err     error
+}
+
+type readAheadChunk struct {
+	data []byte
+	err  error
+}
+
+// NewReadAheadReader starts reading `in` right away. Reading stops once `done`
+// is closed (like when the stop block is reached), what was already read
+// ahead is still returned, followed by `io.EOF`.
+func NewReadAheadReader(in io.Reader, size int, done <-chan struct{}) *ReadAheadReader {
+	chunkSize := readAheadChunkSize
+	if size < chunkSize {
+		chunkSize = size
+	}
+
+	r := &ReadAheadReader{chunks: make(chan readAheadChunk, size/chunkSize)}
+	go r.fill(in, chunkSize, done)
+	return r
+}
+
+func (r *ReadAheadReader) fill(in io.Reader, chunkSize int, done <-chan struct{}) {
+	defer close(r.chunks)
+
+	for {
+		buf := make([]byte, chunkSize)
+		n, err := in.Read(buf)
+		if n > 0 {
+			select {
+			case r.chunks <- readAheadChunk{data: buf[:n]}:
+			case <-done:
+				return
+			}
+		}
+
+		if err != nil {
+			select {
+			case r.chunks <- readAheadChunk{err: err}:
+			case <-done:
+			}
+			return
+		}
+	}
+}
+
+func (r *ReadAheadReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		chunk, ok := <-r.chunks
+		switch {
+		case !ok:
+			r.err = io.EOF
+		case chunk.err != nil:
+			r.err = chunk.err
+		default:
+			r.current = chunk.data
+		}
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadReader(t *testing.T) {
+	input := bytes.Repeat([]byte("DMLOG BLOCK 1\n"), 20000)
+
+	data, err := ioutil.ReadAll(NewReadAheadReader(bytes.NewReader(input), 256*1024, nil))
+	require.NoError(t, err)
+	assert.Equal(t, input, data)
+}
+
+type countingReader struct {
+	io.Reader
+	reads chan int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.reads <- n
+	return n, err
+}
+
+func TestReadAheadReader_StopsReadingWhenDone(t *testing.T) {
+	// far more input than what is read ahead
+	in := &countingReader{Reader: strings.NewReader(strings.Repeat("x", 1024*1024)), reads: make(chan int, 100)}
+	done := make(chan struct{})
+	reader := NewReadAheadReader(in, 4*1024, done)
+
+	// the buffer (a single chunk) gets full, reading blocks on the next one
+	<-in.reads
+	<-in.reads
+	close(done)
+
+	select {
+	case <-in.reads:
+		t.Fatal("input read once done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// what was read ahead is still there, then it ends
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, len(data) > 0 && len(data) <= 8*1024, "read %d bytes", len(data))
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dfuse-io/dstore"
+	"go.uber.org/zap"
+)
+
+// ResumeFromMergedStore makes the plugin start right after the highest bundle
+// of the merged blocks store, so a restarted mindreader does not process and
+// upload again the bundles it already did. The start block never moves
+// backwards: when the store ends below the start block already set, the
+// blocks in between are missing from it, which is logged. It must be called
+// before `Launch` and returns the effective start block.
+func (p *MindReaderPlugin) ResumeFromMergedStore(ctx context.Context) (uint64, error) {
+	selector, ok := p.archiver.(*ArchiverSelector)
+	if !ok {
+		return 0, fmt.Errorf("archiver does not support resuming from the merged blocks store")
+	}
+	merger, ok := selector.mergeArchiver.(*MergeArchiver)
+	if !ok {
+		return 0, fmt.Errorf("merge archiver does not support resuming from the merged blocks store")
+	}
+
+	current := p.startGate.blockNum
+	lastNum, found, err := highestMergedBundle(ctx, merger.store)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find the highest merged bundle: %w", err)
+	}
+	if !found {
+		p.zlogger.Info("no merged bundle in the store, not resuming", zap.Uint64("start_block_num", current))
+		return current, nil
+	}
+
+	next := lastNum + 1
+	switch {
+	case next < current:
+		p.zlogger.Warn("merged blocks store ends below the start block, the blocks in between are missing from it", zap.Uint64("store_next_block_num", next), zap.Uint64("start_block_num", current))
+		return current, nil
+	case next == current:
+		return current, nil
+	}
+
+	p.zlogger.Info("resuming after the highest merged bundle of the store, blocks before it are discarded", zap.Uint64("start_block_num", next), zap.Uint64("previous_start_block_num", current))
+	p.SetStartBlockNum(next)
+	if next-1 > merger.lastUploadedBlock.Load() {
+		merger.lastUploadedBlock.Store(next - 1)
+	}
+	return next, nil
+}
+
+// highestMergedBundle returns the last block of the highest bundle of
+// `store`. Bundle names begin with their zero-padded start block, so the
+// highest start block is found one digit at a time with prefix listings,
+// rather than listing them all. The bundles starting there, either a full
+// 100-blocks one or ones named after their range (see `bundleLastBlock`),
+// give the last block.
+func highestMergedBundle(ctx context.Context, store dstore.Store) (lastNum uint64, found bool, err error) {
+	prefix := ""
+	for len(prefix) < 10 {
+		matched := false
+		for digit := 9; digit >= 0; digit-- {
+			candidate := prefix + strconv.Itoa(digit)
+			files, err := store.ListFiles(ctx, candidate, ".tmp", 1)
+			if err != nil {
+				return 0, false, err
+			}
+			if len(files) != 0 {
+				prefix = candidate
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, false, nil
+		}
+	}
+
+	err = store.Walk(ctx, prefix, ".tmp", func(filename string) error {
+		num, ok := bundleLastBlock(strings.TrimSuffix(path.Base(filename), ".merged"))
+		if ok && (!found || num > lastNum) {
+			lastNum = num
+			found = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return lastNum, found, nil
+}
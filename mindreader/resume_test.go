@@ -0,0 +1,105 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dfuse-io/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMindReaderPlugin_ResumeFromMergedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := dstore.NewDBinStore("file://" + dir)
+	require.NoError(t, err)
+
+	resume := func(startBlock uint64) uint64 {
+		merger := testNewMergeArchiver(dir, store)
+		plugin, err := testNewMindReaderPlugin(&ArchiverSelector{mergeArchiver: merger}, startBlock, 0)
+		require.NoError(t, err)
+
+		next, err := plugin.ResumeFromMergedStore(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, next, plugin.startGate.blockNum)
+		return next
+	}
+
+	// nothing uploaded yet
+	assert.Equal(t, uint64(0), resume(0))
+	assert.Equal(t, uint64(500), resume(500))
+
+	for _, name := range []string{"0000000800", "0000000900", "0000001000-0000001099-abcdef", stagedBundlePrefix + "0000009900"} {
+		require.NoError(t, store.WriteObject(context.Background(), name, bytes.NewReader([]byte{0x01})))
+	}
+
+	// overlap, the uploaded bundles are not processed again
+	assert.Equal(t, uint64(1100), resume(0))
+	assert.Equal(t, uint64(1100), resume(900))
+
+	// flushed before a handover, resumes right after the flushed range
+	for _, name := range []string{"0000001100-0000001150", "0000001151-0000001170"} {
+		require.NoError(t, store.WriteObject(context.Background(), name, bytes.NewReader([]byte{0x01})))
+	}
+	assert.Equal(t, uint64(1171), resume(0))
+
+	// gap, the start block is never moved backwards
+	assert.Equal(t, uint64(5000), resume(5000))
+}
+
+func TestHighestMergedBundle(t *testing.T) {
+	tests := []struct {
+		name          string
+		objects       []string
+		expectedFound bool
+		expectedLast  uint64
+	}{
+		{"empty store", nil, false, 0},
+		{"only staged bundles", []string{stagedBundlePrefix + "0000000100"}, false, 0},
+		{"full bundles", []string{"0000000000", "0000000100"}, true, 199},
+		{"flushed range highest", []string{"0000000000", "0000000100-0000000150"}, true, 150},
+		{"range after a flush highest", []string{"0000000000", "0000000100-0000000150", "0000000151-0000000199"}, true, 199},
+		{"full bundle after a flush", []string{"0000000100-0000000150", "0000000151-0000000199", "0000000200"}, true, 299},
+		{"content hash names", []string{"0000000000-0000000099-aa11", "0000000100-0000000150-bb22"}, true, 150},
+		{"canonical and range at the same start", []string{"0000000100", "0000000100-0000000150"}, true, 199},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "resume")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			store, err := dstore.NewDBinStore("file://" + dir)
+			require.NoError(t, err)
+			for _, name := range test.objects {
+				require.NoError(t, store.WriteObject(context.Background(), name, bytes.NewReader([]byte{0x01})))
+			}
+
+			lastNum, found, err := highestMergedBundle(context.Background(), store)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedFound, found)
+			assert.Equal(t, test.expectedLast, lastNum)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var blockRateLogInterval = 30 * time.Second
+
+// SetMaxBlocksPerSecond caps how fast the plugin consumes blocks, the node is
+// slowed down in turn once the buffered lines and blocks are full. It must be
+// called before `Launch`, 0 removes the cap.
+func (p *MindReaderPlugin) SetMaxBlocksPerSecond(rate float64) {
+	if rate == 0 {
+		p.rateLimiter = nil
+		return
+	}
+	p.rateLimiter = newBlockRateLimiter(rate, p.zlogger)
+}
+
+// blockRateLimiter is a token bucket letting through `rate` blocks per
+// second, in bursts of at most one second worth of blocks.
+type blockRateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	windowStart  time.Time // effective rate is logged every `blockRateLogInterval`
+	windowBlocks int
+
+	logger *zap.Logger
+}
+
+func newBlockRateLimiter(rate float64, logger *zap.Logger) *blockRateLimiter {
+	burst := math.Max(1, rate)
+	now := time.Now()
+	return &blockRateLimiter{
+		rate:        rate,
+		burst:       burst,
+		tokens:      burst,
+		last:        now,
+		windowStart: now,
+		logger:      logger,
+	}
+}
+
+// wait blocks until the next block may be processed, or until `cancel` is
+// closed, which lets everything through.
+func (l *blockRateLimiter) wait(cancel <-chan struct{}) {
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+
+	if l.tokens < 0 {
+		select {
+		case <-time.After(time.Duration(-l.tokens / l.rate * float64(time.Second))):
+		case <-cancel:
+		}
+	}
+
+	l.windowBlocks++
+	if elapsed := time.Since(l.windowStart); elapsed >= blockRateLogInterval {
+		l.logger.Info("mindreader block processing throttled",
+			zap.Float64("max_blocks_per_second", l.rate),
+			zap.Float64("effective_blocks_per_second", float64(l.windowBlocks)/elapsed.Seconds()),
+		)
+		l.windowStart = time.Now()
+		l.windowBlocks = 0
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mindreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockRateLimiter(t *testing.T) {
+	limiter := newBlockRateLimiter(50, testLogger)
+
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		limiter.wait(nil)
+	}
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "burst took %s", time.Since(start))
+
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.wait(nil)
+	}
+	assert.True(t, time.Since(start) >= 150*time.Millisecond, "10 blocks over the burst took %s", time.Since(start))
+}
+
+func TestBlockRateLimiter_Cancel(t *testing.T) {
+	limiter := newBlockRateLimiter(0.1, testLogger)
+	cancel := make(chan struct{})
+	close(cancel)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.wait(cancel)
+	}
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "cancelled waits took %s", time.Since(start))
+}
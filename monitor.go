@@ -1,25 +1,68 @@
 package node_manager
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dfuse-io/dmetrics"
+	"github.com/dfuse-io/node-manager/metrics"
 	"go.uber.org/atomic"
+	"go.uber.org/zap"
 )
 
+const metricsLoopRestartDelay = time.Second
+
+// maxMetricsLoopRestarts consecutive panics of the collection loop before
+// giving up on it, a loop that ran for `metricsLoopHealthyAfter` resets the count
+const maxMetricsLoopRestarts = 10
+const metricsLoopHealthyAfter = 10 * time.Minute
+
 type Readiness interface {
 	IsReady() bool
 }
 
+// HeadBlockReporter is implemented by readiness checks tracking the node's
+// head block, it returns the last one seen (zero values if none yet).
+type HeadBlockReporter interface {
+	HeadBlock() (num uint64, id string, blockTime time.Time)
+}
+
+// DegradedReporter is implemented by readiness checks that can work in a
+// degraded mode, it returns why they are, empty when they are not.
+type DegradedReporter interface {
+	DegradedReason() string
+}
+
 type MetricsAndReadinessManager struct {
 	headBlockChan      chan *headBlock
 	headBlockTimeDrift *dmetrics.HeadTimeDrift
 	headBlockNumber    *dmetrics.HeadBlockNum
 	readinessProbe     *atomic.Bool
 
+	lastSeenBlockLock sync.RWMutex
+	lastSeenBlock     *headBlock
+
 	// ReadinessMaxLatency is the max delta between head block time and
 	// now before /healthz starts returning success
 	readinessMaxLatency time.Duration
+
+	upstreamLock            sync.Mutex
+	upstreamDisconnectedAt  time.Time // zero while connected
+	upstreamDisconnectGrace time.Duration
+	upstreamFailures        upstreamFailures // see `ReportUpstreamFailure`
+
+	clockSkewLock sync.Mutex
+	clockSkew     time.Duration // last measured, see `MonitorClockSkew`
+	maxClockSkew  time.Duration
+
+	blocksBehindLock  sync.Mutex
+	blocksBehind      uint64 // last measured, see `MonitorBlocksBehind`
+	blocksBehindKnown bool
+	maxBlocksBehind   uint64
+
+	logger *zap.Logger
 }
 
 func NewMetricsAndReadinessManager(headBlockTimeDrift *dmetrics.HeadTimeDrift, headBlockNumber *dmetrics.HeadBlockNum, readinessMaxLatency time.Duration) *MetricsAndReadinessManager {
@@ -29,9 +72,16 @@ func NewMetricsAndReadinessManager(headBlockTimeDrift *dmetrics.HeadTimeDrift, h
 		headBlockTimeDrift:  headBlockTimeDrift,
 		headBlockNumber:     headBlockNumber,
 		readinessMaxLatency: readinessMaxLatency,
+		logger:              zap.NewNop(),
 	}
 }
 
+// SetLogger sets the logger reporting the panics of the collection loop, it
+// must be called before `Launch`.
+func (m *MetricsAndReadinessManager) SetLogger(logger *zap.Logger) {
+	m.logger = logger
+}
+
 func (m *MetricsAndReadinessManager) setReadinessProbeOn() {
 	if m.readinessProbe.CAS(false, true) {
 		//m.Logger.Info("nodeos superviser is now assumed to be ready")
@@ -44,19 +94,130 @@ func (m *MetricsAndReadinessManager) setReadinessProbeOff() {
 	}
 }
 
+// DegradedReason implements `DegradedReporter`, the manager is degraded while
+// the host clock is skewed (see `MonitorClockSkew`) or the node is too many
+// blocks behind its reference (see `MonitorBlocksBehind`).
+func (m *MetricsAndReadinessManager) DegradedReason() string {
+	var reasons []string
+	for _, reason := range []string{m.clockSkewReason(), m.blocksBehindReason()} {
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
 func (m *MetricsAndReadinessManager) IsReady() bool {
-	return m.readinessProbe.Load()
+	return m.readinessProbe.Load() && !m.upstreamDisconnectedTooLong()
+}
+
+// SetUpstreamDisconnectGrace makes the manager not ready once the upstream
+// node is disconnected (see `SetUpstreamConnected`) for longer than `grace`,
+// 0 ignores the upstream connection.
+func (m *MetricsAndReadinessManager) SetUpstreamDisconnectGrace(grace time.Duration) {
+	m.upstreamLock.Lock()
+	defer m.upstreamLock.Unlock()
+
+	m.upstreamDisconnectGrace = grace
+}
+
+// SetUpstreamConnected is fed by the connection watchdog with the state of
+// the connection to the upstream node (like the relay a mindreader syncs from).
+func (m *MetricsAndReadinessManager) SetUpstreamConnected(connected bool) {
+	m.upstreamLock.Lock()
+	defer m.upstreamLock.Unlock()
+
+	if connected {
+		m.logUpstreamRecovered()
+		m.upstreamDisconnectedAt = time.Time{}
+		return
+	}
+	if m.upstreamDisconnectedAt.IsZero() {
+		m.upstreamDisconnectedAt = time.Now()
+	}
+}
+
+// UpstreamDisconnectedFor returns how long the upstream node has been
+// disconnected, 0 while connected.
+func (m *MetricsAndReadinessManager) UpstreamDisconnectedFor() time.Duration {
+	m.upstreamLock.Lock()
+	defer m.upstreamLock.Unlock()
+
+	if m.upstreamDisconnectedAt.IsZero() {
+		return 0
+	}
+	return time.Since(m.upstreamDisconnectedAt)
+}
+
+func (m *MetricsAndReadinessManager) upstreamDisconnectedTooLong() bool {
+	m.upstreamLock.Lock()
+	grace := m.upstreamDisconnectGrace
+	m.upstreamLock.Unlock()
+
+	return grace != 0 && m.UpstreamDisconnectedFor() > grace
 }
 
+// Launch runs the collection loop, restarting it when it panics, up to
+// `maxMetricsLoopRestarts` times in a row.
 func (m *MetricsAndReadinessManager) Launch() {
-	var lastSeenBlock *headBlock
+	consecutivePanics := 0
+	for {
+		startedAt := time.Now()
+		err := m.collect()
+
+		if time.Since(startedAt) > metricsLoopHealthyAfter {
+			consecutivePanics = 0
+		}
+		consecutivePanics++
+		metrics.MetricsPanics.Inc()
+
+		if consecutivePanics > maxMetricsLoopRestarts {
+			m.logger.Error("metrics and readiness collection loop keeps panicking, giving up on it, metrics will not be updated anymore and the node is reported not ready", zap.Int("consecutive_panics", consecutivePanics), zap.Error(err))
+			m.setReadinessProbeOff()
+			m.drainHeadBlocks()
+			return
+		}
+
+		m.logger.Warn("restarting metrics and readiness collection loop", zap.Duration("delay", metricsLoopRestartDelay), zap.Int("consecutive_panics", consecutivePanics))
+		time.Sleep(metricsLoopRestartDelay)
+	}
+}
+
+// drainHeadBlocks keeps `UpdateHeadBlock` from blocking its callers, and
+// `HeadBlock` up to date, without the collection loop
+func (m *MetricsAndReadinessManager) drainHeadBlocks() {
+	for block := range m.headBlockChan {
+		m.lastSeenBlockLock.Lock()
+		m.lastSeenBlock = block
+		m.lastSeenBlockLock.Unlock()
+	}
+}
+
+func (m *MetricsAndReadinessManager) collect() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+			m.logger.Error("metrics and readiness collection loop panicked", zap.Error(err), zap.Stack("stack"))
+		}
+	}()
+
+	lastSeenBlock, _ := m.lastSeenHeadBlock()
+	progressedAt := time.Now() // last time the head block moved forward, by the local clock
 	for {
 		select {
 		case block := <-m.headBlockChan:
+			if lastSeenBlock == nil || block.Num > lastSeenBlock.Num {
+				progressedAt = time.Now()
+			}
 			lastSeenBlock = block
+			m.lastSeenBlockLock.Lock()
+			m.lastSeenBlock = block
+			m.lastSeenBlockLock.Unlock()
 		case <-time.After(time.Second):
 		}
 
+		metrics.UpstreamDisconnected.SetFloat64(m.UpstreamDisconnectedFor().Seconds())
+
 		if lastSeenBlock == nil {
 			continue
 		}
@@ -73,13 +234,23 @@ func (m *MetricsAndReadinessManager) Launch() {
 			m.headBlockTimeDrift.SetBlockTime(lastSeenBlock.Time)
 		}
 
-		// readiness
-		if m.readinessMaxLatency == 0 || time.Since(lastSeenBlock.Time) < m.readinessMaxLatency {
-			m.setReadinessProbeOn()
-		} else {
-			m.setReadinessProbeOff()
-		}
+		m.updateReadiness(lastSeenBlock.Time, progressedAt)
+	}
+}
 
+// updateReadiness reports the node ready when its head block time is recent
+// enough. While the host clock is skewed, the drift cannot be trusted, it
+// falls back to when the head block last progressed by the local clock.
+func (m *MetricsAndReadinessManager) updateReadiness(blockTime, progressedAt time.Time) {
+	reference := blockTime
+	if m.ClockSkewed() {
+		reference = progressedAt
+	}
+
+	if m.readinessMaxLatency == 0 || time.Since(reference) < m.readinessMaxLatency {
+		m.setReadinessProbeOn()
+	} else {
+		m.setReadinessProbeOff()
 	}
 }
 
@@ -91,6 +262,21 @@ func (m *MetricsAndReadinessManager) UpdateHeadBlock(num uint64, ID string, t ti
 	}
 }
 
+func (m *MetricsAndReadinessManager) HeadBlock() (num uint64, id string, blockTime time.Time) {
+	block, ok := m.lastSeenHeadBlock()
+	if !ok {
+		return 0, "", time.Time{}
+	}
+	return block.Num, block.ID, block.Time
+}
+
+func (m *MetricsAndReadinessManager) lastSeenHeadBlock() (*headBlock, bool) {
+	m.lastSeenBlockLock.RLock()
+	defer m.lastSeenBlockLock.RUnlock()
+
+	return m.lastSeenBlock, m.lastSeenBlock != nil
+}
+
 type headBlock struct {
 	ID   string
 	Num  uint64
@@ -0,0 +1,82 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMetricsAndReadinessManager_UpstreamDisconnectGrace(t *testing.T) {
+	m := NewMetricsAndReadinessManager(nil, nil, 0)
+	m.setReadinessProbeOn()
+
+	// ignored until a grace is set
+	m.SetUpstreamConnected(false)
+	m.upstreamDisconnectedAt = time.Now().Add(-time.Hour)
+	assert.True(t, m.IsReady())
+
+	m.SetUpstreamDisconnectGrace(time.Minute)
+	assert.False(t, m.IsReady())
+	assert.True(t, m.UpstreamDisconnectedFor() >= time.Hour)
+
+	// a disconnection within the grace is tolerated
+	m.SetUpstreamConnected(true)
+	assert.Equal(t, time.Duration(0), m.UpstreamDisconnectedFor())
+	m.SetUpstreamConnected(false)
+	assert.True(t, m.IsReady())
+
+	// and reporting it again does not reset when it started
+	m.upstreamDisconnectedAt = time.Now().Add(-2 * time.Minute)
+	m.SetUpstreamConnected(false)
+	assert.False(t, m.IsReady())
+}
+
+func TestMetricsAndReadinessManager_ReportUpstreamFailure(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	m := NewMetricsAndReadinessManager(nil, nil, 0)
+	m.SetLogger(zap.New(core))
+	m.SetWatchdogLogInterval(time.Minute)
+
+	for i := 0; i < 5; i++ {
+		m.ReportUpstreamFailure(errors.New("connection refused"))
+	}
+	assert.Equal(t, 1, logs.Len(), "first failure only")
+	assert.True(t, m.UpstreamDisconnectedFor() > 0)
+
+	m.ReportUpstreamFailure(errors.New("no route to host"))
+	assert.Equal(t, 2, logs.Len(), "a different failure is logged")
+
+	m.upstreamFailures.lastLoggedAt = time.Now().Add(-2 * time.Minute)
+	m.ReportUpstreamFailure(errors.New("no route to host"))
+	require.Equal(t, 3, logs.Len())
+	summary := logs.All()[2]
+	assert.Equal(t, "connection watchdog still unable to reach upstream node", summary.Message)
+	assert.Equal(t, int64(1), summary.ContextMap()["attempts"])
+
+	m.SetUpstreamConnected(true)
+	require.Equal(t, 4, logs.Len())
+	assert.Equal(t, int64(7), logs.All()[3].ContextMap()["failed_attempts"])
+
+	// a new outage logs its first failure in full
+	m.ReportUpstreamFailure(errors.New("no route to host"))
+	assert.Equal(t, 5, logs.Len())
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NodeosConfig holds the settings of a nodeos `config.ini` node-manager can
+// default its own to, each is empty when the file does not set it.
+type NodeosConfig struct {
+	DataDir     string // `data-dir`, always absolute
+	HTTPAddress string // `http-server-address`, like `0.0.0.0:8888`
+	P2PAddress  string // `p2p-listen-endpoint`, like `0.0.0.0:9876`
+}
+
+// ReadNodeosConfig parses and validates the nodeos `config.ini` at `path`.
+func ReadNodeosConfig(path string) (*NodeosConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read nodeos config file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	c := &NodeosConfig{}
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid nodeos config file %q: line %d is not `key = value`", path, lineNum)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "data-dir":
+			c.DataDir = value
+		case "http-server-address":
+			c.HTTPAddress = value
+		case "p2p-listen-endpoint":
+			c.P2PAddress = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read nodeos config file %q: %w", path, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid nodeos config file %q: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *NodeosConfig) validate() error {
+	v := &ConfigValidator{}
+
+	// nodeos resolves it against its own working directory, which we cannot know
+	v.Check(c.DataDir == "" || filepath.IsAbs(c.DataDir), "data-dir %q must be absolute to be used by node-manager", c.DataDir)
+	v.Addr("http-server-address", c.HTTPAddress, false)
+	v.Addr("p2p-listen-endpoint", c.P2PAddress, false)
+
+	if c.P2PAddress != "" {
+		_, err := c.P2PPort()
+		v.Check(err == nil, "p2p-listen-endpoint %q has an invalid port", c.P2PAddress)
+	}
+	return v.Err()
+}
+
+// HTTPClientAddress is `HTTPAddress` as reachable from the same host, a
+// wildcard host (like `0.0.0.0`) being replaced by `localhost`.
+func (c *NodeosConfig) HTTPClientAddress() string {
+	host, port, err := net.SplitHostPort(c.HTTPAddress)
+	if err != nil {
+		return c.HTTPAddress
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// P2PPort is the port of `P2PAddress`
+func (c *NodeosConfig) P2PPort() (int, error) {
+	_, port, err := net.SplitHostPort(c.P2PAddress)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(port)
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadNodeosConfig(t *testing.T) {
+	path := writeTestConfig(t, `
+# comments and unrelated keys are ignored
+plugin = eosio::chain_api_plugin
+plugin = eosio::producer_api_plugin
+data-dir = /data/nodeos
+http-server-address = 0.0.0.0:8888
+p2p-listen-endpoint = 0.0.0.0:9876
+`)
+
+	c, err := ReadNodeosConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "/data/nodeos", c.DataDir)
+	assert.Equal(t, "0.0.0.0:8888", c.HTTPAddress)
+	assert.Equal(t, "localhost:8888", c.HTTPClientAddress())
+
+	port, err := c.P2PPort()
+	require.NoError(t, err)
+	assert.Equal(t, 9876, port)
+}
+
+func TestReadNodeosConfig_Invalid(t *testing.T) {
+	_, err := ReadNodeosConfig(writeTestConfig(t, "data-dir = data\nhttp-server-address = 8888\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "data-dir \"data\" must be absolute")
+	assert.Contains(t, err.Error(), "http-server-address")
+
+	_, err = ReadNodeosConfig(writeTestConfig(t, "not a setting\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeossnapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dfuse-io/dstore"
+	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/operator"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// snapshotSuffix is the extension of the portable snapshots written by nodeos
+const snapshotSuffix = ".bin"
+
+type Config struct {
+	NodeosAPIAddress string // address of the node's HTTP API, like `localhost:8888`, the `producer_api_plugin` must be enabled, defaults to the `http-server-address` of `NodeConfigFile`
+	StoreURL         string // dstore URL the snapshots are uploaded to
+
+	SnapshotsDir      string        // if set, the snapshot is read from this directory instead of the path reported by nodeos (when it is mounted elsewhere)
+	KeepLocalSnapshot bool          // if false, the snapshot file written by nodeos is deleted once uploaded
+	CreateTimeout     time.Duration // how long nodeos may take to write the snapshot, defaults to 10 minutes
+
+	NodeConfigFile string // nodeos `config.ini`, read for the defaults of `NodeosAPIAddress`
+}
+
+// Module takes nodeos' native portable snapshots through its
+// `/v1/producer/create_snapshot` RPC and uploads them to a dstore, named
+// after the block nodeos took them at. The node keeps running meanwhile.
+type Module struct {
+	config *Config
+	store  dstore.Store
+	prefix string
+	client *http.Client
+
+	lastBlockNum *atomic.Uint64
+	logger       *zap.Logger
+}
+
+// createSnapshotResponse is the answer of nodeos' `create_snapshot` RPC
+type createSnapshotResponse struct {
+	HeadBlockID  string `json:"head_block_id"`
+	HeadBlockNum uint64 `json:"head_block_num"`
+	SnapshotName string `json:"snapshot_name"`
+}
+
+func New(config *Config, logger *zap.Logger) (*Module, error) {
+	if config.NodeConfigFile != "" && config.NodeosAPIAddress == "" {
+		nodeConfig, err := nodeManager.ReadNodeosConfig(config.NodeConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if nodeConfig.HTTPAddress != "" {
+			config.NodeosAPIAddress = nodeConfig.HTTPClientAddress()
+			logger.Info("auto-detected nodeos api address from node config file", zap.String("node_config_file", config.NodeConfigFile), zap.String("nodeos_api_address", config.NodeosAPIAddress))
+		}
+	}
+	if config.NodeosAPIAddress == "" {
+		return nil, fmt.Errorf("no nodeos api address, set it or a node config file with an `http-server-address`")
+	}
+
+	store, err := dstore.NewStore(config.StoreURL, "", "", false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create snapshot store: %w", err)
+	}
+
+	timeout := config.CreateTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+
+	return &Module{
+		config:       config,
+		store:        store,
+		client:       &http.Client{Timeout: timeout},
+		lastBlockNum: atomic.NewUint64(0),
+		logger:       logger,
+	}, nil
+}
+
+func (m *Module) RequiresStop() bool {
+	return false
+}
+
+func (m *Module) SetBackupPrefix(prefix string) {
+	m.prefix = prefix
+}
+
+// LastBackupBlockNum is the block nodeos took the last snapshot at
+func (m *Module) LastBackupBlockNum() uint64 {
+	return m.lastBlockNum.Load()
+}
+
+func (m *Module) Backup(_ uint32) (string, error) {
+	return m.BackupWithContext(context.Background(), 0, nil)
+}
+
+func (m *Module) BackupWithContext(ctx context.Context, _ uint32, _ map[string]string) (string, error) {
+	snapshot, err := m.createSnapshot(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	localFile := snapshot.SnapshotName
+	if m.config.SnapshotsDir != "" {
+		localFile = filepath.Join(m.config.SnapshotsDir, filepath.Base(snapshot.SnapshotName))
+	}
+
+	name := path.Join(m.prefix, fmt.Sprintf("%010d-%s", snapshot.HeadBlockNum, snapshot.HeadBlockID))
+	m.logger.Info("uploading nodeos snapshot", zap.String("backup_name", name), zap.String("local_file", localFile), zap.Uint64("block_num", snapshot.HeadBlockNum))
+
+	f, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to open snapshot written by nodeos: %w", err)
+	}
+	defer f.Close()
+
+	if err := m.store.WriteObject(ctx, name+snapshotSuffix, f); err != nil {
+		return "", fmt.Errorf("unable to upload snapshot %q: %w", name, operator.WrapStoreFullError(err))
+	}
+
+	if !m.config.KeepLocalSnapshot {
+		if err := os.Remove(localFile); err != nil {
+			m.logger.Warn("unable to delete uploaded snapshot", zap.String("local_file", localFile), zap.Error(err))
+		}
+	}
+
+	m.lastBlockNum.Store(snapshot.HeadBlockNum)
+	return name, nil
+}
+
+func (m *Module) createSnapshot(ctx context.Context) (*createSnapshotResponse, error) {
+	url := fmt.Sprintf("http://%s/v1/producer/create_snapshot", m.config.NodeosAPIAddress)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Info("requesting snapshot from nodeos", zap.String("url", url))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request snapshot from nodeos: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read nodeos snapshot response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("nodeos refused to create snapshot (status %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	snapshot := &createSnapshotResponse{}
+	if err := json.Unmarshal(body, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid nodeos snapshot response: %w", err)
+	}
+	if snapshot.SnapshotName == "" {
+		return nil, fmt.Errorf("nodeos snapshot response has no snapshot_name: %s", strings.TrimSpace(string(body)))
+	}
+	return snapshot, nil
+}
+
+// List returns the snapshots under the module's prefix, oldest first.
+func (m *Module) List(_ map[string]string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	walkPrefix := ""
+	if m.prefix != "" {
+		walkPrefix = m.prefix + "/"
+	}
+
+	var names []string
+	err := m.store.Walk(ctx, walkPrefix, "", func(filename string) error {
+		if strings.HasSuffix(filename, snapshotSuffix) {
+			names = append(names, strings.TrimSuffix(filename, snapshotSuffix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list snapshots: %w", err)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodeossnapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestModule_Backup(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodeossnapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	// nodeos reports a path of its own, the snapshot is found under `SnapshotsDir`
+	snapshotsDir := filepath.Join(root, "snapshots")
+	require.NoError(t, os.MkdirAll(snapshotsDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(snapshotsDir, "snapshot-0000abcd.bin"), []byte("snapshot"), 0644))
+
+	nodeos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/producer/create_snapshot", r.URL.Path)
+		fmt.Fprint(w, `{"head_block_id":"0000abcd","head_block_num":43981,"snapshot_name":"/nodeos/data/snapshots/snapshot-0000abcd.bin"}`)
+	}))
+	defer nodeos.Close()
+
+	m, err := New(&Config{
+		NodeosAPIAddress: strings.TrimPrefix(nodeos.URL, "http://"),
+		StoreURL:         "file://" + filepath.Join(root, "store"),
+		SnapshotsDir:     snapshotsDir,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	m.SetBackupPrefix("chain")
+
+	name, err := m.Backup(10)
+	require.NoError(t, err)
+	assert.Equal(t, "chain/0000043981-0000abcd", name)
+	assert.Equal(t, uint64(43981), m.LastBackupBlockNum())
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "store", "chain", "0000043981-0000abcd.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot", string(content))
+
+	_, err = os.Stat(filepath.Join(snapshotsDir, "snapshot-0000abcd.bin"))
+	assert.True(t, os.IsNotExist(err), "uploaded snapshot should be deleted locally")
+
+	names, err := m.List(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chain/0000043981-0000abcd"}, names)
+}
+
+func TestModule_BackupRefused(t *testing.T) {
+	nodeos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":404,"message":"Not Found"}`)
+	}))
+	defer nodeos.Close()
+
+	root, err := ioutil.TempDir("", "nodeossnapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	m, err := New(&Config{NodeosAPIAddress: strings.TrimPrefix(nodeos.URL, "http://"), StoreURL: "file://" + root}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = m.Backup(10)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 404")
+	assert.Equal(t, uint64(0), m.LastBackupBlockNum())
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// ErrNoOperation is returned by `Abort` when no operation is in progress
+var ErrNoOperation = errors.New("no operation in progress")
+
+// ErrOperationNotAbortable is returned by `Abort` when the operation in
+// progress cannot be canceled, like restores or backups of modules not
+// implementing `CancelableBackupModule`
+var ErrOperationNotAbortable = errors.New("operation in progress cannot be aborted")
+
+// setOperationCancel makes the operation in progress abortable through `cancel`
+func (o *Operator) setOperationCancel(cancel context.CancelFunc) {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+	o.operationCancel = cancel
+}
+
+func (o *Operator) isOperationAborted() bool {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+	return o.operationAborted
+}
+
+// Abort cancels the operation in progress, its module cleans up the partial
+// artifacts and the node is restarted if it stopped for it. It returns the
+// status of the aborted operation, without waiting for it to be done. When
+// idle, it cancels the backups waiting for a global operation lock slot.
+func (o *Operator) Abort() (*OperationStatus, error) {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	if o.operation == nil {
+		if len(o.globalLockWaits) == 0 {
+			return nil, ErrNoOperation
+		}
+
+		status := &OperationStatus{Operation: "backup"}
+		for _, wait := range o.globalLockWaits {
+			o.zlogger.Warn("aborting backup waiting for a global operation lock slot on request", zap.String("module", wait.module))
+			wait.cancel()
+			status.Module = wait.module
+			metrics.OperationsAborted.Inc()
+		}
+		return status, nil
+	}
+	status := *o.operation
+	if o.operationCancel == nil {
+		return &status, ErrOperationNotAbortable
+	}
+
+	if !o.operationAborted {
+		o.zlogger.Warn("aborting operation on request", zap.String("operation", status.Operation), zap.String("module", status.Module), zap.String("id", status.ID))
+		o.operationAborted = true
+		o.operationCancel()
+		metrics.OperationsAborted.Inc()
+	}
+	return &status, nil
+}
+
+func (o *Operator) abortHandler(w http.ResponseWriter, _ *http.Request) {
+	status, err := o.Abort()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const defaultBackupAuditRecentCount = 5
+
+// AuditableBackupModule is implemented by modules able to read back the
+// files of a backup, so they can be verified against its manifest.
+type AuditableBackupModule interface {
+	ListableBackupModule
+	OpenBackupFile(ctx context.Context, backupName, path string) (io.ReadCloser, error)
+}
+
+// runBackupAudits verifies one of the recent backups against its manifest
+// every `Options.BackupAuditInterval`, in turn, until the operator terminates.
+func (o *Operator) runBackupAudits() {
+	var audited int
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-time.After(o.options.BackupAuditInterval):
+		}
+
+		candidates := o.backupAuditCandidates()
+		if len(candidates) == 0 {
+			o.zlogger.Debug("no backup with a manifest to audit")
+			continue
+		}
+
+		candidate := candidates[audited%len(candidates)]
+		audited++
+		o.auditBackup(candidate.module, candidate.moduleName, candidate.backupName)
+	}
+}
+
+type backupAuditCandidate struct {
+	module     AuditableBackupModule
+	moduleName string
+	backupName string
+}
+
+// backupAuditCandidates returns the `Options.BackupAuditRecentCount` most
+// recent backups of each auditable module.
+func (o *Operator) backupAuditCandidates() []*backupAuditCandidate {
+	recentCount := o.options.BackupAuditRecentCount
+	if recentCount <= 0 {
+		recentCount = defaultBackupAuditRecentCount
+	}
+
+	var moduleNames []string
+	for name := range o.backupModules {
+		moduleNames = append(moduleNames, name)
+	}
+	sort.Strings(moduleNames)
+
+	var candidates []*backupAuditCandidate
+	for _, moduleName := range moduleNames {
+		mod, ok := o.backupModules[moduleName].(AuditableBackupModule)
+		if !ok {
+			continue
+		}
+
+		names, err := mod.List(nil)
+		if err != nil {
+			o.zlogger.Warn("unable to list backups to audit", zap.String("module", moduleName), zap.Error(err))
+			continue
+		}
+		names = o.filterPrefixedBackups(names)
+		if len(names) > recentCount {
+			names = names[len(names)-recentCount:]
+		}
+
+		for _, name := range names {
+			candidates = append(candidates, &backupAuditCandidate{module: mod, moduleName: moduleName, backupName: name})
+		}
+	}
+	return candidates
+}
+
+func (o *Operator) auditBackup(mod AuditableBackupModule, moduleName, backupName string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.Terminating():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	manifest, err := o.loadBackupManifest(ctx, backupName)
+	if err != nil {
+		o.zlogger.Warn("unable to load manifest of backup to audit", zap.String("backup_name", backupName), zap.Error(err))
+		return
+	}
+	if manifest == nil || len(manifest.Files) == 0 {
+		o.zlogger.Debug("backup has no manifest listing its files, skipping audit", zap.String("backup_name", backupName))
+		return
+	}
+
+	o.zlogger.Info("auditing backup", zap.String("module", moduleName), zap.String("backup_name", backupName), zap.Int("file_count", len(manifest.Files)))
+	start := time.Now()
+	err = o.verifyBackupFiles(ctx, mod, backupName, manifest)
+	if err == nil {
+		o.zlogger.Info("backup audit succeeded", zap.String("backup_name", backupName), zap.Duration("elapsed", time.Since(start)))
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// pruned while we were reading it, not a corruption
+	if names, listErr := mod.List(nil); listErr == nil && !containsString(names, backupName) {
+		o.zlogger.Info("audited backup was deleted in the meantime, ignoring audit failure", zap.String("backup_name", backupName), zap.Error(err))
+		return
+	}
+
+	o.zlogger.Error("backup audit failed", zap.String("module", moduleName), zap.String("backup_name", backupName), zap.Error(err))
+	metrics.BackupAuditFailures.Inc()
+	o.notify(EventBackupAuditFailed, err.Error(), map[string]string{"module": moduleName, "backup_name": backupName})
+}
+
+func (o *Operator) verifyBackupFiles(ctx context.Context, mod AuditableBackupModule, backupName string, manifest *BackupManifest) error {
+	for _, file := range manifest.Files {
+		reader, err := mod.OpenBackupFile(ctx, backupName, file.Path)
+		if err != nil {
+			return fmt.Errorf("unable to open file %q: %w", file.Path, err)
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, newThrottledReader(ctx, reader, o.options.BackupAuditMaxBytesPerSecond))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read file %q: %w", file.Path, err)
+		}
+
+		if size != file.Size {
+			return fmt.Errorf("file %q has size %d, expected %d", file.Path, size, file.Size)
+		}
+		if checksum := hex.EncodeToString(h.Sum(nil)); checksum != file.SHA256 {
+			return fmt.Errorf("file %q has checksum %s, expected %s", file.Path, checksum, file.SHA256)
+		}
+	}
+	return nil
+}
+
+// throttledReader limits reads to `bytesPerSecond` on average, to bound the
+// egress of audits. It stops with the context's error once canceled.
+type throttledReader struct {
+	ctx            context.Context
+	reader         io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+}
+
+func newThrottledReader(ctx context.Context, reader io.Reader, bytesPerSecond int64) io.Reader {
+	return &throttledReader{ctx: ctx, reader: reader, bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if r.bytesPerSecond > 0 {
+		if int64(len(p)) > r.bytesPerSecond {
+			p = p[:r.bytesPerSecond]
+		}
+
+		if ahead := r.ahead(); ahead > 0 {
+			select {
+			case <-time.After(ahead):
+			case <-r.ctx.Done():
+				return 0, r.ctx.Err()
+			}
+		}
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// ahead returns how long reading must wait to get back to `bytesPerSecond`,
+// computed in float seconds since `read` in nanoseconds overflows past ~9 GB.
+func (r *throttledReader) ahead() time.Duration {
+	return time.Duration(float64(r.read)/float64(r.bytesPerSecond)*float64(time.Second)) - time.Since(r.start)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AuditEntry is one line of the `AuditLog`, for a single mutating call
+// to the management API.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"` // path template of the matched route, like `/v1/backup_manifest/{name:.+}`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Identity   string    `json:"identity,omitempty"` // as returned by `AuditLog.Identity`, if set
+	Status     int       `json:"status"`
+	Duration   string    `json:"duration"`
+}
+
+// AuditLog records every mutating management API call as a JSON line appended
+// to a file, see `AuditLogOption`. Each entry is synced to disk before the
+// call returns.
+type AuditLog struct {
+	// Identity optionally tells who made the call, like the token identity
+	// resolved by an authenticating proxy in front of the management API.
+	Identity func(r *http.Request) string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log at `path`, creating it if needed,
+// existing entries are never rewritten.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record appends `entry` and flushes it to disk.
+func (l *AuditLog) Record(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, err := l.file.Write(data); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *AuditLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
+
+// AuditLogOption records the mutating calls to every route of the
+// management API, including those added by other options, to `log`.
+func (o *Operator) AuditLogOption(log *AuditLog) HTTPOption {
+	return func(r *mux.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if !mayMutate(req) {
+					next.ServeHTTP(w, req)
+					return
+				}
+
+				start := time.Now()
+				sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(sw, req)
+
+				entry := &AuditEntry{
+					Time:       start,
+					RemoteAddr: req.RemoteAddr,
+					Method:     req.Method,
+					Path:       req.URL.Path,
+					Query:      req.URL.RawQuery,
+					Status:     sw.status,
+					Duration:   time.Since(start).String(),
+				}
+				if route := mux.CurrentRoute(req); route != nil {
+					entry.Route, _ = route.GetPathTemplate()
+				}
+				if log.Identity != nil {
+					entry.Identity = log.Identity(req)
+				}
+
+				if err := log.Record(entry); err != nil {
+					o.zlogger.Error("unable to record management api call to audit log", zap.String("method", entry.Method), zap.String("path", entry.Path), zap.Error(err))
+				}
+			})
+		})
+	}
+}
+
+// mayMutate tells whether `req` must be audited: calls with any method but
+// `GET`, `HEAD` and `OPTIONS`, and calls of any method to the routes not
+// declaring which methods they accept, which may mutate on a `GET`.
+func mayMutate(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && req.Method != http.MethodOptions {
+		return true
+	}
+
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return false
+	}
+	methods, err := route.GetMethods()
+	return err != nil || len(methods) == 0
+}
+
+// statusResponseWriter remembers the status code written by a handler
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming handlers working through the audit log
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_AuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit_log")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	serve := func() {
+		log, err := OpenAuditLog(path)
+		require.NoError(t, err)
+		defer log.Close()
+		log.Identity = func(r *http.Request) string { return r.Header.Get("X-Identity") }
+
+		r := mux.NewRouter()
+		r.HandleFunc("/v1/ping", o.pingHandler).Methods("GET")
+		r.HandleFunc("/v1/log_level", o.pingHandler).Methods("GET", "PUT")
+		r.HandleFunc("/v1/reset", o.pingHandler)
+		r.HandleFunc("/v1/backup_manifest/{name:.+}", func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}).Methods("DELETE")
+		o.AuditLogOption(log)(r)
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/ping", nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/log_level", nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/reset", nil)) // accepting any method, may mutate
+
+		req := httptest.NewRequest("DELETE", "/v1/backup_manifest/bk-1?force=true", nil)
+		req.Header.Set("X-Identity", "ops")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// entries of a previous run are kept
+	serve()
+	serve()
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := &AuditEntry{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, entries, 4)
+	for i, entry := range entries {
+		if i%2 == 0 {
+			assert.Equal(t, "GET", entry.Method)
+			assert.Equal(t, "/v1/reset", entry.Route)
+			continue
+		}
+
+		assert.Equal(t, "DELETE", entry.Method)
+		assert.Equal(t, "/v1/backup_manifest/{name:.+}", entry.Route)
+		assert.Equal(t, "/v1/backup_manifest/bk-1", entry.Path)
+		assert.Equal(t, "force=true", entry.Query)
+		assert.Equal(t, "ops", entry.Identity)
+		assert.Equal(t, http.StatusNotFound, entry.Status)
+		assert.NotEmpty(t, entry.RemoteAddr)
+		assert.False(t, entry.Time.IsZero())
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dfuse-io/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testAuditableBackupModule struct {
+	files map[string]map[string][]byte // backup name, then file path
+}
+
+func (m *testAuditableBackupModule) RequiresStop() bool { return false }
+func (m *testAuditableBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+func (m *testAuditableBackupModule) List(_ map[string]string) ([]string, error) {
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	return names, nil
+}
+func (m *testAuditableBackupModule) OpenBackupFile(_ context.Context, backupName, path string) (io.ReadCloser, error) {
+	content, ok := m.files[backupName][path]
+	if !ok {
+		return nil, fmt.Errorf("file %q not found", path)
+	}
+	return ioutil.NopCloser(bytes.NewReader(content)), nil
+}
+
+func TestOperator_AuditBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+dir, "", "", false)
+	require.NoError(t, err)
+
+	content := []byte("blocks")
+	sum := sha256.Sum256(content)
+	manifest, err := json.Marshal(&BackupManifest{
+		BackupName: "0000000100",
+		Files:      []*ManifestFile{{Path: "blocks.log", Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000000100", bytes.NewReader(manifest)))
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupManifestStore: manifestStore, BackupAuditMaxBytesPerSecond: 1024})
+	require.NoError(t, err)
+	mod := &testAuditableBackupModule{files: map[string]map[string][]byte{"0000000100": {"blocks.log": content}}}
+	require.NoError(t, o.RegisterBackupModule("audited", mod))
+
+	candidates := o.backupAuditCandidates()
+	require.Len(t, candidates, 1)
+	assert.Equal(t, "0000000100", candidates[0].backupName)
+
+	events := o.events.subscribe()
+	o.auditBackup(mod, "audited", "0000000100")
+	assert.Len(t, events, 0)
+
+	mod.files["0000000100"]["blocks.log"] = []byte("rotten")
+	o.auditBackup(mod, "audited", "0000000100")
+	select {
+	case event := <-events:
+		assert.Equal(t, EventBackupAuditFailed, event.Type)
+		assert.Contains(t, event.Message, "checksum")
+	case <-time.After(time.Second):
+		t.Fatal("expected an audit failure event")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	start := time.Now()
+	read, err := ioutil.ReadAll(newThrottledReader(context.Background(), bytes.NewReader(make([]byte, 300)), 1000))
+	require.NoError(t, err)
+	assert.Len(t, read, 300)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ioutil.ReadAll(newThrottledReader(ctx, bytes.NewReader(make([]byte, 300)), 1000))
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestThrottledReader_MultiGigabyteRead(t *testing.T) {
+	r := &throttledReader{bytesPerSecond: 100 * 1000 * 1000, start: time.Now().Add(-100 * time.Second), read: 20 * 1000 * 1000 * 1000}
+	assert.InDelta(t, float64(100*time.Second), float64(r.ahead()), float64(time.Second))
+
+	r.start = time.Now().Add(-300 * time.Second)
+	assert.True(t, r.ahead() < 0, "caught up reads are not delayed")
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// ManifestSignatureSuffix is appended to the backup name to store the
+// Ed25519 signature of its manifest, next to it in the manifest store
+const ManifestSignatureSuffix = ".sig"
+
+var errUnsignedBackup = errors.New("backup manifest is not signed")
+
+// ParseBackupSigningKey decodes a hex-encoded Ed25519 private key, either its
+// 32 bytes seed or the full 64 bytes key.
+func ParseBackupSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("backup signing key is not hex-encoded: %w", err)
+	}
+
+	switch len(data) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(data), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(data), nil
+	default:
+		return nil, fmt.Errorf("backup signing key must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(data))
+	}
+}
+
+// ParseBackupVerifyKey decodes a hex-encoded Ed25519 public key.
+func ParseBackupVerifyKey(encoded string) (ed25519.PublicKey, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("backup verify key is not hex-encoded: %w", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("backup verify key must be %d bytes, got %d", ed25519.PublicKeySize, len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// ConfigureBackupSigning signs the manifest of each backup with
+// `signingKey`, and verifies the signature of the manifests loaded (on
+// restore, dry-run restore and audit) against `verifyKey`, which defaults to
+// the public key of `signingKey`. A manifest with an invalid signature is
+// always rejected. With `requireSigned`, restores of backups without a
+// manifest or without a signature are refused too, so a backup substituted in
+// the object store is never restored. It requires
+// `Options.BackupManifestStore` and must be called before `Launch`.
+func (o *Operator) ConfigureBackupSigning(signingKey ed25519.PrivateKey, verifyKey ed25519.PublicKey, requireSigned bool) error {
+	if signingKey == nil && verifyKey == nil && !requireSigned {
+		return nil
+	}
+	if o.options.BackupManifestStore == nil {
+		return fmt.Errorf("backup signing requires a backup manifest store")
+	}
+	if verifyKey == nil && signingKey != nil {
+		verifyKey = signingKey.Public().(ed25519.PublicKey)
+	}
+	if requireSigned && verifyKey == nil {
+		return fmt.Errorf("requiring signed backups requires a key to verify them")
+	}
+
+	o.backupSigningKey = signingKey
+	o.backupVerifyKey = verifyKey
+	o.requireSignedBackups = requireSigned
+	return nil
+}
+
+// signBackupManifest writes the signature of the manifest `data` next to it
+func (o *Operator) signBackupManifest(ctx context.Context, backupName string, data []byte) error {
+	signature := ed25519.Sign(o.backupSigningKey, data)
+	if err := o.options.BackupManifestStore.WriteObject(ctx, backupName+ManifestSignatureSuffix, bytes.NewReader(signature)); err != nil {
+		return fmt.Errorf("unable to write signature of backup manifest %q: %w", backupName, err)
+	}
+	return nil
+}
+
+// verifyBackupManifestSignature checks the manifest `data` against its
+// signature, returning `errUnsignedBackup` when it has none.
+func (o *Operator) verifyBackupManifestSignature(ctx context.Context, backupName string, data []byte) error {
+	store := o.options.BackupManifestStore
+	signatureName := backupName + ManifestSignatureSuffix
+
+	exists, err := store.FileExists(ctx, signatureName)
+	if err != nil {
+		return fmt.Errorf("unable to check for signature of backup manifest %q: %w", backupName, err)
+	}
+	if !exists {
+		return errUnsignedBackup
+	}
+
+	reader, err := store.OpenObject(ctx, signatureName)
+	if err != nil {
+		return fmt.Errorf("unable to open signature of backup manifest %q: %w", backupName, err)
+	}
+	defer reader.Close()
+
+	signature, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read signature of backup manifest %q: %w", backupName, err)
+	}
+
+	if !ed25519.Verify(o.backupVerifyKey, data, signature) {
+		return fmt.Errorf("signature of backup manifest %q is invalid, the backup may have been tampered with", backupName)
+	}
+	return nil
+}
+
+// checkBackupSignature refuses a backup whose manifest is tampered with, or
+// missing or unsigned when signed backups are required, before anything is
+// stopped to restore it. Its files are then verified against the manifest
+// before being put in place, see `restoreVerified`.
+func (o *Operator) checkBackupSignature(backupName string) error {
+	if o.backupVerifyKey == nil {
+		return nil
+	}
+	if backupName == "latest" {
+		if o.requireSignedBackups {
+			return fmt.Errorf("signed backups are required, the restore module cannot resolve `latest`, the backup to restore must be named explicitly")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	manifest, err := o.loadBackupManifest(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if manifest == nil && o.requireSignedBackups {
+		return fmt.Errorf("refusing backup %q, it has no manifest and signed backups are required", backupName)
+	}
+	return nil
+}
+
+// checkSignedRestore refuses, when signed backups are required, the restores
+// whose files cannot be verified against the signed manifest before being put
+// in place: restores of some components only, or by modules unable to
+// restore into a staging directory.
+func (o *Operator) checkSignedRestore(mod RestorableBackupModule, components []string) error {
+	if !o.requireSignedBackups {
+		return nil
+	}
+	if components != nil {
+		return fmt.Errorf("signed backups are required, restoring only some components cannot be verified against the backup manifest")
+	}
+	if !o.canRestoreVerified(mod) {
+		return fmt.Errorf("signed backups are required, the restore module cannot restore into a staging directory to verify the backup before putting it in place")
+	}
+	return nil
+}
@@ -1,10 +1,15 @@
 package operator
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"go.uber.org/zap"
 )
 
 func (o *Operator) RegisterBackupModule(name string, mod BackupModule) error {
@@ -15,6 +20,14 @@ func (o *Operator) RegisterBackupModule(name string, mod BackupModule) error {
 		return fmt.Errorf("backup module %scannot be registered twice", name)
 	}
 	o.backupModules[name] = mod
+	if terminating, ok := mod.(TerminatingBackupModule); ok {
+		o.OnTerminating(terminating.Shutdown)
+	}
+	if reporting, ok := mod.(ProgressReportingBackupModule); ok {
+		reporting.SetProgressReporter(func(doneBytes, totalBytes int64) {
+			o.reportProgress(name, doneBytes, totalBytes)
+		})
+	}
 	if o.backupModules == nil {
 		o.backupModules = make(map[string]BackupModule)
 	}
@@ -25,6 +38,146 @@ func (o *Operator) RegisterBackupSchedule(sched *BackupSchedule) {
 	o.backupSchedules = append(o.backupSchedules, sched)
 }
 
+// ConfigureAutoBackup schedules the backup module registered under
+// `BackupModuleName`. When `hostnameMatch` is set and differs from `hostname`,
+// no schedule is registered.
+func (o *Operator) ConfigureAutoBackup(period time.Duration, modulo int, hostnameMatch, hostname string) {
+	o.configureAutoSchedule(&BackupSchedule{BlocksBetweenRuns: modulo, TimeBetweenRuns: period, BackuperName: BackupModuleName}, hostnameMatch, hostname)
+}
+
+// ConfigureAutoSnapshot schedules the backup module registered under
+// `SnapshotModuleName`, see `ConfigureAutoBackup`.
+func (o *Operator) ConfigureAutoSnapshot(period time.Duration, modulo int, hostnameMatch, hostname string) {
+	o.configureAutoSchedule(&BackupSchedule{BlocksBetweenRuns: modulo, TimeBetweenRuns: period, BackuperName: SnapshotModuleName}, hostnameMatch, hostname)
+}
+
+// ConfigureAutoSnapshotAtBlockTime schedules the backup module registered
+// under `SnapshotModuleName` at the first block whose timestamp crosses a
+// multiple of `boundary` (like each UTC midnight for `24h`), so snapshots
+// align to chain time even when the node is behind.
+func (o *Operator) ConfigureAutoSnapshotAtBlockTime(boundary time.Duration, hostnameMatch, hostname string) {
+	o.configureAutoSchedule(&BackupSchedule{BlockTimeBoundary: boundary, BackuperName: SnapshotModuleName}, hostnameMatch, hostname)
+}
+
+// ConfigureAutoVolumeSnapshot schedules the backup module registered under
+// `VolumeSnapshotModuleName`, also running it once at each of `specificBlocks`.
+func (o *Operator) ConfigureAutoVolumeSnapshot(period time.Duration, modulo int, specificBlocks []uint64) {
+	o.configureAutoSchedule(&BackupSchedule{BlocksBetweenRuns: modulo, TimeBetweenRuns: period, SpecificBlocks: specificBlocks, BackuperName: VolumeSnapshotModuleName}, "", "")
+}
+
+func (o *Operator) configureAutoSchedule(sched *BackupSchedule, hostnameMatch, hostname string) {
+	if hostnameMatch != "" && hostnameMatch != hostname {
+		o.zlogger.Info("skipping automatic schedule because hostname does not match required value",
+			zap.String("hostname", hostname),
+			zap.String("required_hostname", hostnameMatch),
+			zap.String("backuper_name", sched.BackuperName),
+		)
+		return
+	}
+
+	o.RegisterBackupSchedule(sched)
+}
+
+const chainIDPlaceholder = "{chain_id}"
+
+// resolveBackupPrefix computes the backup prefix out of `Options.BackupPrefix`
+// and the chain id, then hands it to the backup modules supporting it. When
+// the prefix depends on the chain id, it is set once the chain id is known,
+// see `whenChainIDKnown`.
+func (o *Operator) resolveBackupPrefix() error {
+	prefix := o.options.BackupPrefix
+	if prefix != "" && !strings.Contains(prefix, chainIDPlaceholder) {
+		o.setBackupPrefix(prefix)
+		return nil
+	}
+
+	_, supported := o.Superviser.(nodeManager.ChainIDChainSuperviser)
+	if !supported && o.options.ChainID == "" {
+		if prefix != "" {
+			return fmt.Errorf("backup prefix %q requires the chain id but the chain superviser cannot report it (set it explicitly to skip this)", prefix)
+		}
+
+		o.zlogger.Info("chain superviser cannot report its chain id, backup names will not be prefixed")
+		return nil
+	}
+
+	o.whenChainIDKnown(func(chainID string) {
+		if prefix == "" {
+			o.setBackupPrefix(chainID)
+			return
+		}
+		o.setBackupPrefix(strings.Replace(prefix, chainIDPlaceholder, chainID, -1))
+	})
+	return nil
+}
+
+func (o *Operator) setBackupPrefix(prefix string) {
+	o.zlogger.Info("prefixing backup names", zap.String("backup_prefix", prefix))
+	o.backupPrefix.Store(prefix)
+	for name, mod := range o.backupModules {
+		prefixable, ok := mod.(PrefixableBackupModule)
+		if !ok {
+			o.zlogger.Warn("backup module does not support name prefixes, its backups may collide with other chains'", zap.String("module", name))
+			continue
+		}
+		prefixable.SetBackupPrefix(prefix)
+	}
+}
+
+// setBackupChainID hands the chain id to the backup modules using it, like
+// in the path of their backups, once it is known, see `whenChainIDKnown`.
+func (o *Operator) setBackupChainID() {
+	var mods []ChainIDAwareBackupModule
+	for _, mod := range o.backupModules {
+		if aware, ok := mod.(ChainIDAwareBackupModule); ok {
+			mods = append(mods, aware)
+		}
+	}
+	if len(mods) == 0 {
+		return
+	}
+
+	if _, supported := o.Superviser.(nodeManager.ChainIDChainSuperviser); !supported && o.options.ChainID == "" {
+		o.zlogger.Warn("chain superviser cannot report its chain id, backup modules will not know it (set it explicitly to fix this)")
+		return
+	}
+
+	o.whenChainIDKnown(func(chainID string) {
+		for _, mod := range mods {
+			mod.SetChainID(chainID)
+		}
+	})
+}
+
+// filterPrefixedBackups drops the backup names not belonging to this chain.
+func (o *Operator) filterPrefixedBackups(names []string) []string {
+	prefix := o.backupPrefix.Load()
+	if prefix == "" {
+		return names
+	}
+
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// backupModuleName returns the name under which the module picked by
+// `selectBackupModule` is registered.
+func backupModuleName(mods map[string]BackupModule, optionalName string) string {
+	if optionalName != "" || len(mods) != 1 {
+		return optionalName
+	}
+
+	for name := range mods {
+		return name
+	}
+	return ""
+}
+
 func selectBackupModule(mods map[string]BackupModule, optionalName string) (BackupModule, error) {
 	if len(mods) == 0 {
 		return nil, fmt.Errorf("no registered backup modules")
@@ -53,6 +206,41 @@ func selectBackupModule(mods map[string]BackupModule, optionalName string) (Back
 
 }
 
+// restoreComponents returns the components requested by the comma-separated
+// `components` parameter of a restore, nil when the whole backup is restored.
+func restoreComponents(params map[string]string) []string {
+	var components []string
+	for _, component := range strings.Split(params["components"], ",") {
+		component = strings.TrimSpace(component)
+		if component == "all" {
+			return nil
+		}
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// restoreBackupName returns the name of the backup a restore is requested
+// for, `latest` by default, resolved when the module supports it.
+func restoreBackupName(mod RestorableBackupModule, params map[string]string) (string, error) {
+	backupName := "latest"
+	if b, ok := params["backupName"]; ok {
+		backupName = b
+	}
+
+	resolver, ok := mod.(BackupNameResolvingModule)
+	if !ok {
+		return backupName, nil
+	}
+	resolved, err := resolver.ResolveBackupName(backupName)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve backup %q: %w", backupName, err)
+	}
+	return resolved, nil
+}
+
 func selectRestoreModule(choices map[string]BackupModule, optionalName string) (RestorableBackupModule, error) {
 	mods := restorable(choices)
 	if len(mods) == 0 {
@@ -111,6 +299,34 @@ func selectListableBackupModule(choices map[string]BackupModule, optionalName st
 
 }
 
+func selectPinnableBackupModule(choices map[string]BackupModule, optionalName string) (PinnableBackupModule, error) {
+	mods := pinnable(choices)
+	if len(mods) == 0 {
+		return nil, fmt.Errorf("none of the registered backup modules support pinning")
+	}
+
+	if optionalName != "" {
+		chosen, ok := mods[optionalName]
+		if !ok {
+			return nil, fmt.Errorf("invalid pinnable backup module: %s", optionalName)
+		}
+		return chosen, nil
+	}
+
+	if len(mods) > 1 {
+		var modNames []string
+		for k := range mods {
+			modNames = append(modNames, k)
+		}
+		return nil, fmt.Errorf("more than one pinnable module registered, and none specified (%s)", strings.Join(modNames, ","))
+	}
+
+	for _, mod := range mods { // single element in map
+		return mod, nil
+	}
+	return nil, fmt.Errorf("impossible path")
+}
+
 func restorable(in map[string]BackupModule) map[string]RestorableBackupModule {
 	out := make(map[string]RestorableBackupModule)
 	for k, v := range in {
@@ -131,10 +347,216 @@ func listable(in map[string]BackupModule) map[string]ListableBackupModule {
 	return out
 }
 
+func pinnable(in map[string]BackupModule) map[string]PinnableBackupModule {
+	out := make(map[string]PinnableBackupModule)
+	for k, v := range in {
+		if pinnable, ok := v.(PinnableBackupModule); ok {
+			out[k] = pinnable
+		}
+	}
+	return out
+}
+
+// Well-known backup module names, used by the `ConfigureAuto*` helpers and
+// by the dedicated HTTP endpoints to find the module they operate on.
+const (
+	BackupModuleName         = "backup"
+	SnapshotModuleName       = "snapshot"
+	VolumeSnapshotModuleName = "volume_snapshot"
+	ChainSnapshotModuleName  = "chain_snapshot"
+)
+
+// BackupInfo is an entry of the `/v1/list_backups` response
+type BackupInfo struct {
+	Name   string `json:"name"`
+	Pinned bool   `json:"pinned"`
+}
+
+type backupResult struct {
+	Name     string `json:"name"`
+	BlockNum uint64 `json:"block_num"`
+	Tag      string `json:"tag,omitempty"`
+	Skipped  string `json:"skipped,omitempty"` // why no backup was taken, `Name` is then the last one
+}
+
+// takeBackup runs `backupMod` within the current stop window and records its
+// outcome. `interrupted` turns a backup cut short (node crash, abort request)
+// into the error to record instead of the module's own, restarting the node
+// is left to the caller.
+func (o *Operator) takeBackup(ctx context.Context, logger *zap.Logger, backupMod BackupModule, backuperName string, labels map[string]string, interrupted func(backupName string) error) (*backupResult, error) {
+	startedAt := time.Now()
+	lastSeenBlockNum := o.Superviser.LastSeenBlockNum()
+	o.notify(EventBackupStarted, "", map[string]string{"module": backuperName, "block_num": strconv.FormatUint(lastSeenBlockNum, 10)})
+	backupName, err := o.runBackupModule(ctx, backupMod, uint32(lastSeenBlockNum), labels)
+	if interruption := interrupted(backupName); interruption != nil {
+		err = interruption
+	}
+	if err != nil {
+		o.notify(EventBackupFailed, err.Error(), map[string]string{"module": backuperName, "block_num": strconv.FormatUint(lastSeenBlockNum, 10)})
+		o.recordResult("backup", backuperName, startedAt, lastSeenBlockNum, "", err)
+		return nil, err
+	}
+
+	if reporting, ok := backupMod.(BlockNumReportingBackupModule); ok && reporting.LastBackupBlockNum() != 0 {
+		lastSeenBlockNum = reporting.LastBackupBlockNum()
+	}
+	logger.Info("Completed backup", zap.String("backup_name", backupName), zap.Uint64("block_num", lastSeenBlockNum))
+	o.setLastRun(backuperName, &OperationRun{Time: time.Now(), BlockNum: lastSeenBlockNum, BackupName: backupName})
+	o.recordResult("backup", backuperName, startedAt, lastSeenBlockNum, backupName, nil)
+	o.notify(EventBackupCompleted, "", map[string]string{"module": backuperName, "block_num": strconv.FormatUint(lastSeenBlockNum, 10), "backup_name": backupName})
+	o.writeBackupManifest(backupMod, backuperName, backupName, lastSeenBlockNum, labels)
+	return &backupResult{Name: backupName, BlockNum: lastSeenBlockNum}, nil
+}
+
+// promoteSnapshot takes a full backup after every
+// `Options.PromoteEveryNthSnapshotToBackup` successful snapshots. It runs as
+// part of the snapshot operation, before the node is restarted, so both share
+// the same stop; it returns whether it had to stop the node itself.
+func (o *Operator) promoteSnapshot(ctx context.Context, cancel context.CancelFunc, logger *zap.Logger, snapshotName string) (stopped bool, err error) {
+	every := o.options.PromoteEveryNthSnapshotToBackup
+	if every <= 0 {
+		return false, nil
+	}
+	backupMod, ok := o.backupModules[BackupModuleName]
+	if !ok {
+		o.zlogger.Warn("cannot promote snapshots to backups, no backup module registered", zap.String("backuper_name", BackupModuleName))
+		return false, nil
+	}
+
+	o.snapshotsSincePromotion++
+	if o.snapshotsSincePromotion < every {
+		return false, nil
+	}
+	o.snapshotsSincePromotion = 0
+
+	logger.Info("promoting snapshot to a full backup", zap.String("snapshot_name", snapshotName), zap.Int("every_nth_snapshot", every))
+	var labels map[string]string
+	if backupMod.RequiresStop() {
+		if o.Superviser.IsRunning() {
+			if err := o.cleanSuperviserStop(); err != nil {
+				return false, err
+			}
+			stopped = true
+		}
+		if !o.checkCleanShutdown() {
+			labels = map[string]string{"dirty": "true"}
+		}
+	}
+
+	if _, ok := backupMod.(CancelableBackupModule); ok {
+		o.setOperationCancel(cancel)
+	}
+	_, err = o.takeBackup(ctx, logger, backupMod, BackupModuleName, labels, func(string) error {
+		if o.isOperationAborted() {
+			return fmt.Errorf("promoted backup aborted on request")
+		}
+		return nil
+	})
+	if err != nil {
+		return stopped, fmt.Errorf("snapshot %q taken but its promotion to a full backup failed: %w", snapshotName, err)
+	}
+	return stopped, nil
+}
+
+// snapshotModuleNames are the modules guarded by `Options.MinBlocksBetweenSnapshots`
+var snapshotModuleNames = map[string]bool{
+	SnapshotModuleName:       true,
+	VolumeSnapshotModuleName: true,
+	ChainSnapshotModuleName:  true,
+}
+
+// recentSnapshot returns the last successful run of `backuperName` when it is
+// a snapshot module that ran fewer than `Options.MinBlocksBetweenSnapshots`
+// blocks before `blockNum`, nil otherwise.
+func (o *Operator) recentSnapshot(backuperName string, blockNum uint64) *OperationRun {
+	if o.options.MinBlocksBetweenSnapshots == 0 || !snapshotModuleNames[backuperName] {
+		return nil
+	}
+
+	last := o.LastRun(backuperName)
+	if last == nil || blockNum >= last.BlockNum+o.options.MinBlocksBetweenSnapshots {
+		return nil
+	}
+
+	o.zlogger.Info("skipping snapshot, too few blocks since the last one",
+		zap.String("backuper_name", backuperName),
+		zap.Uint64("block_num", blockNum),
+		zap.Uint64("last_snapshot_block_num", last.BlockNum),
+		zap.Uint64("min_blocks_between_snapshots", o.options.MinBlocksBetweenSnapshots),
+	)
+	return last
+}
+
+func backupLabels(params map[string]string) map[string]string {
+	if tag := params["tag"]; tag != "" {
+		return map[string]string{"tag": tag}
+	}
+	return nil
+}
+
+func (o *Operator) runBackupModule(ctx context.Context, mod BackupModule, lastSeenBlockNum uint32, labels map[string]string) (string, error) {
+	if cancelable, ok := mod.(CancelableBackupModule); ok {
+		return cancelable.BackupWithContext(ctx, lastSeenBlockNum, labels)
+	}
+
+	if labelable, ok := mod.(LabelableBackupModule); ok {
+		return labelable.BackupWithLabels(lastSeenBlockNum, labels)
+	}
+
+	if len(labels) > 0 {
+		o.zlogger.Warn("backup module does not support labels, ignoring them", zap.Reflect("labels", labels))
+	}
+	return mod.Backup(lastSeenBlockNum)
+}
+
 type BackupModule interface {
 	RequiresStop() bool
 	Backup(lastSeenBlockNum uint32) (string, error)
 }
+
+// LabelableBackupModule is implemented by modules able to attach free-form
+// labels (like a user-provided `tag`) to the backups they take.
+type LabelableBackupModule interface {
+	BackupModule
+	BackupWithLabels(lastSeenBlockNum uint32, labels map[string]string) (string, error)
+}
+
+// PrefixableBackupModule is implemented by modules able to keep the backups
+// they take, list and delete under a given name prefix.
+type PrefixableBackupModule interface {
+	BackupModule
+	SetBackupPrefix(prefix string)
+}
+
+// ChainIDAwareBackupModule is implemented by modules using the chain id, it
+// is set once known, after the node first started.
+type ChainIDAwareBackupModule interface {
+	BackupModule
+	SetChainID(chainID string)
+}
+
+// TerminatingBackupModule is implemented by modules running work in the
+// background, they are shut down as the operator terminates.
+type TerminatingBackupModule interface {
+	BackupModule
+	Shutdown(err error)
+}
+
+// LoggingBackupModule is implemented by modules logging what they do, they
+// log through the operator's logger of the operation in progress, tagged with
+// its id (see `/v1/operation/{id}/logs`), until it is reset to nil.
+type LoggingBackupModule interface {
+	BackupModule
+	SetOperationLogger(logger *zap.Logger)
+}
+
+// CancelableBackupModule is implemented by modules able to abort a backup in
+// progress when `ctx` is canceled, cleaning up any partial artifact.
+type CancelableBackupModule interface {
+	BackupModule
+	BackupWithContext(ctx context.Context, lastSeenBlockNum uint32, labels map[string]string) (string, error)
+}
+
 type ListableBackupModule interface {
 	BackupModule
 	List(params map[string]string) ([]string, error)
@@ -144,11 +566,211 @@ type RestorableBackupModule interface {
 	Restore(name string) error
 }
 
+// ComponentRestorableBackupModule is implemented by modules able to restore
+// only some components of a backup (like the blocks log or the state),
+// leaving the others in place.
+type ComponentRestorableBackupModule interface {
+	RestorableBackupModule
+	RestoreComponents(name string, components []string) error
+}
+
+// DirRestorableBackupModule is implemented by modules able to restore a
+// backup into another directory than the node's, like for dry-run restores.
+// It returns the name of the backup restored, `latest` being resolved.
+type DirRestorableBackupModule interface {
+	RestorableBackupModule
+	RestoreTo(name, dir string) (restoredName string, err error)
+}
+
+// BackupNameResolvingModule is implemented by modules able to tell which
+// backup a name designates, like `latest` for the most recent one, so a
+// restore is verified against its manifest and recorded under its real name.
+type BackupNameResolvingModule interface {
+	RestorableBackupModule
+	ResolveBackupName(name string) (string, error)
+}
+
+// BlockNumReportingBackupModule is implemented by modules whose backups are
+// taken at a block of their own choosing (like nodeos' native snapshots),
+// recorded instead of the last block seen by the superviser.
+type BlockNumReportingBackupModule interface {
+	BackupModule
+	LastBackupBlockNum() uint64
+}
+
+// PinnableBackupModule is implemented by modules able to pin backups, a
+// pinned backup is never deleted by the module's retention.
+type PinnableBackupModule interface {
+	BackupModule
+	Pin(name string) error
+	Unpin(name string) error
+	PinnedBackups() ([]string, error)
+}
+
 type BackupSchedule struct {
 	BlocksBetweenRuns     int
 	TimeBetweenRuns       time.Duration
-	RequiredHostnameMatch string // will not run backup if !empty env.Hostname != HostnameMatch
-	BackuperName          string // must match id of backupModule
+	SpecificBlocks        []uint64      // runs once as soon as each of these blocks has been seen
+	BlockTimeBoundary     time.Duration // runs at the first block whose timestamp crosses a multiple of it (UTC midnight for `24h`)
+	Cron                  *CronSchedule // runs at each time matching it, taking precedence over `TimeBetweenRuns`
+	RequiredHostnameMatch string        // will not run backup if !empty env.Hostname != HostnameMatch
+	BackuperName          string        // must match id of backupModule
+
+	// Runtime state, maintained by the schedule's run loops
+	stateLock         sync.Mutex
+	disabled          bool
+	lastRun           time.Time
+	lastRunBlock      uint64
+	nextRunTime       time.Time
+	nextRunBlock      uint64
+	nextSpecificBlock uint64
+	blockReference    uint64    // head block at the last block-based run (or when the schedule started)
+	lastBoundary      time.Time // block time boundary crossed by the last block time based run (or when the schedule started)
+}
+
+// OperationRun is the last completed run of an operation, however it was triggered.
+type OperationRun struct {
+	Time       time.Time
+	BlockNum   uint64
+	BackupName string
+}
+
+// ScheduleStatus is a snapshot of a backup schedule's configuration and state.
+type ScheduleStatus struct {
+	BackuperName          string     `json:"backuper_name"`
+	Enabled               bool       `json:"enabled"`
+	TimeBetweenRuns       string     `json:"time_between_runs,omitempty"`
+	BlocksBetweenRuns     int        `json:"blocks_between_runs,omitempty"`
+	SpecificBlocks        []uint64   `json:"specific_blocks,omitempty"`
+	BlockTimeBoundary     string     `json:"block_time_boundary,omitempty"`
+	Cron                  string     `json:"cron,omitempty"`
+	RequiredHostnameMatch string     `json:"required_hostname_match,omitempty"`
+	LastRun               *time.Time `json:"last_run,omitempty"`
+	LastRunBlock          uint64     `json:"last_run_block,omitempty"`
+	NextRunTime           *time.Time `json:"next_run_time,omitempty"`
+	NextRunBlock          uint64     `json:"next_run_block,omitempty"`
+	NextRunBlockTime      *time.Time `json:"next_run_block_time,omitempty"`
+
+	// Last completed run of this schedule's operation, including manually triggered ones
+	LastCompleted      *time.Time `json:"last_completed,omitempty"`
+	LastCompletedBlock uint64     `json:"last_completed_block,omitempty"`
+	LastBackupName     string     `json:"last_backup_name,omitempty"`
+}
+
+func (s *BackupSchedule) Status() *ScheduleStatus {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	status := &ScheduleStatus{
+		BackuperName:          s.BackuperName,
+		Enabled:               !s.disabled,
+		BlocksBetweenRuns:     s.BlocksBetweenRuns,
+		SpecificBlocks:        s.SpecificBlocks,
+		RequiredHostnameMatch: s.RequiredHostnameMatch,
+		LastRunBlock:          s.lastRunBlock,
+		NextRunBlock:          s.nextRunBlock,
+	}
+	if s.TimeBetweenRuns != 0 {
+		status.TimeBetweenRuns = s.TimeBetweenRuns.String()
+	}
+	if s.BlockTimeBoundary != 0 {
+		status.BlockTimeBoundary = s.BlockTimeBoundary.String()
+	}
+	if s.Cron != nil {
+		status.Cron = s.Cron.String()
+	}
+	if !s.lastBoundary.IsZero() {
+		nextRunBlockTime := s.lastBoundary.Add(s.BlockTimeBoundary)
+		status.NextRunBlockTime = &nextRunBlockTime
+	}
+	if !s.lastRun.IsZero() {
+		lastRun := s.lastRun
+		status.LastRun = &lastRun
+	}
+	if !s.nextRunTime.IsZero() {
+		nextRunTime := s.nextRunTime
+		status.NextRunTime = &nextRunTime
+	}
+	if s.nextSpecificBlock != 0 && (status.NextRunBlock == 0 || s.nextSpecificBlock < status.NextRunBlock) {
+		status.NextRunBlock = s.nextSpecificBlock
+	}
+
+	return status
+}
+
+func (s *BackupSchedule) setDisabled() {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.disabled = true
+}
+
+func (s *BackupSchedule) setLastRun(t time.Time, blockNum uint64) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.lastRun = t
+	s.lastRunBlock = blockNum
+}
+
+func (s *BackupSchedule) setNextRunTime(t time.Time) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.nextRunTime = t
+}
+
+func (s *BackupSchedule) setNextRunBlock(blockNum uint64) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.nextRunBlock = blockNum
+}
+
+// dueAtBlock tells if a block-based run is due now that the chain reached
+// `lastSeenBlockNum`, moving the schedule's reference forward when it is.
+// Only this schedule's own runs move it.
+func (s *BackupSchedule) dueAtBlock(lastSeenBlockNum uint64) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	freq := uint64(s.BlocksBetweenRuns)
+	if s.blockReference == 0 {
+		s.blockReference = lastSeenBlockNum
+		s.nextRunBlock = s.blockReference + freq + 1
+		return false
+	}
+
+	if lastSeenBlockNum > s.blockReference+freq {
+		s.blockReference = lastSeenBlockNum
+		s.nextRunBlock = s.blockReference + freq + 1
+		return true
+	}
+	return false
+}
+
+// dueAtBlockTime tells if a block time based run is due now that the chain
+// reached a block timestamped `blockTime`. Boundaries only ever move forward,
+// so a reorg bringing the head back before an already crossed boundary does
+// not fire it again once crossed anew.
+func (s *BackupSchedule) dueAtBlockTime(blockTime time.Time) bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	// the zero time is a UTC midnight, so daily boundaries fall on UTC midnights
+	boundary := blockTime.UTC().Truncate(s.BlockTimeBoundary)
+	if s.lastBoundary.IsZero() {
+		s.lastBoundary = boundary
+		return false
+	}
+
+	if boundary.After(s.lastBoundary) {
+		s.lastBoundary = boundary
+		return true
+	}
+	return false
+}
+
+func (s *BackupSchedule) setNextSpecificBlock(blockNum uint64) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	s.nextSpecificBlock = blockNum
 }
 
 func NewBackupSchedule(freqBlocks, freqTime, requiredHostname, backuperName string) (*BackupSchedule, error) {
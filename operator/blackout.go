@@ -0,0 +1,96 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BlackoutWindow is a daily time range during which scheduled operations
+// are skipped, it spans midnight when it ends before it starts.
+type BlackoutWindow struct {
+	spec     string
+	start    time.Duration // since midnight
+	end      time.Duration // since midnight
+	location *time.Location
+}
+
+// ParseBlackoutWindow parses `HH:MM-HH:MM`, optionally followed by a time
+// zone name (like `22:30-01:00 America/Montreal`), UTC by default.
+func ParseBlackoutWindow(spec string) (*BlackoutWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid blackout window %q, expected `HH:MM-HH:MM [time zone]`", spec)
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid blackout window %q, expected `HH:MM-HH:MM [time zone]`", spec)
+	}
+
+	w := &BlackoutWindow{spec: spec, location: time.UTC}
+	var err error
+	if w.start, err = parseTimeOfDay(bounds[0]); err != nil {
+		return nil, fmt.Errorf("invalid blackout window %q: %w", spec, err)
+	}
+	if w.end, err = parseTimeOfDay(bounds[1]); err != nil {
+		return nil, fmt.Errorf("invalid blackout window %q: %w", spec, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid blackout window %q: it is empty", spec)
+	}
+
+	if len(fields) == 2 {
+		if w.location, err = time.LoadLocation(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid blackout window %q: %w", spec, err)
+		}
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(in string) (time.Duration, error) {
+	t, err := time.Parse("15:04", in)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", in)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains tells if `t` falls within the window, the end being excluded
+func (w *BlackoutWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.start < w.end {
+		return sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	return sinceMidnight >= w.start || sinceMidnight < w.end
+}
+
+func (w *BlackoutWindow) String() string {
+	return w.spec
+}
+
+// activeBlackoutWindow returns the blackout window `t` falls within, if any
+func (o *Operator) activeBlackoutWindow(t time.Time) *BlackoutWindow {
+	for _, w := range o.blackoutWindows {
+		if w.Contains(t) {
+			return w
+		}
+	}
+	return nil
+}
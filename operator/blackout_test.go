@@ -0,0 +1,54 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlackoutWindow(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", "2020-06-15 "+hhmm)
+		require.NoError(t, err)
+		return tm
+	}
+
+	w, err := ParseBlackoutWindow("02:00-04:30")
+	require.NoError(t, err)
+	assert.False(t, w.Contains(at("01:59")))
+	assert.True(t, w.Contains(at("02:00")))
+	assert.True(t, w.Contains(at("04:29")))
+	assert.False(t, w.Contains(at("04:30")))
+
+	w, err = ParseBlackoutWindow("22:00-01:00")
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at("23:15")))
+	assert.True(t, w.Contains(at("00:30")))
+	assert.False(t, w.Contains(at("12:00")))
+
+	w, err = ParseBlackoutWindow("22:00-23:00 America/Montreal") // UTC-4 in June
+	require.NoError(t, err)
+	assert.True(t, w.Contains(at("02:30")))
+	assert.False(t, w.Contains(at("22:30")))
+
+	for _, invalid := range []string{"", "02:00", "2:00-25:00", "02:00-02:00", "02:00-03:00 Nowhere/Land", "02:00-03:00 UTC extra"} {
+		_, err := ParseBlackoutWindow(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
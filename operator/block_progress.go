@@ -0,0 +1,112 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+// blockProgressCheckInterval is how often the head block of the node is
+// checked by the block progress monitor, overridden in tests
+var blockProgressCheckInterval = 5 * time.Second
+
+type blockProgressMonitor struct {
+	maxNoProgress time.Duration
+	restart       bool
+
+	lock     sync.Mutex
+	degraded string // why the node is degraded, empty while blocks progress
+}
+
+func (m *blockProgressMonitor) degradedReason() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.degraded
+}
+
+func (m *blockProgressMonitor) setDegraded(reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.degraded = reason
+}
+
+// ConfigureBlockProgressMonitor watches the head block of the node and
+// reports it degraded when it did not advance for `maxNoProgress` while the
+// node is running, catching a node that looks alive but whose blocks log is
+// stalled. With `restart`, the node is also restarted, once per stall. Time
+// spent stopped, in standby or during an operation is not counted. It must be
+// called before `Launch`, a zero `maxNoProgress` disables it.
+func (o *Operator) ConfigureBlockProgressMonitor(maxNoProgress time.Duration, restart bool) {
+	if maxNoProgress <= 0 {
+		o.blockProgress = nil
+		return
+	}
+	o.blockProgress = &blockProgressMonitor{maxNoProgress: maxNoProgress, restart: restart}
+}
+
+func (o *Operator) monitorBlockProgress() {
+	monitor := o.blockProgress
+	ticker := time.NewTicker(blockProgressCheckInterval)
+	defer ticker.Stop()
+
+	lastBlockNum := o.Superviser.LastSeenBlockNum()
+	lastProgressAt := time.Now()
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+		}
+
+		blockNum := o.Superviser.LastSeenBlockNum()
+		if blockNum != lastBlockNum {
+			if monitor.degradedReason() != "" {
+				o.zlogger.Info("head block progressing again", zap.Uint64("block_num", blockNum))
+				monitor.setDegraded("")
+			}
+			lastBlockNum = blockNum
+			lastProgressAt = time.Now()
+		}
+		if !o.Superviser.IsRunning() || o.standby.Load() || o.OperationStatus().InProgress {
+			lastProgressAt = time.Now()
+		}
+
+		stalledFor := time.Since(lastProgressAt)
+		metrics.SecondsSinceBlockProgress.SetFloat64(stalledFor.Seconds())
+		if stalledFor < monitor.maxNoProgress || monitor.degradedReason() != "" {
+			continue
+		}
+
+		reason := fmt.Sprintf("head block stuck at #%d for %s (max %s)", blockNum, stalledFor.Truncate(time.Second), monitor.maxNoProgress)
+		o.zlogger.Warn("head block not progressing while the node is running, blocks log may be stalled", zap.Uint64("block_num", blockNum), zap.Duration("stalled_for", stalledFor), zap.Bool("restart", monitor.restart))
+		monitor.setDegraded(reason)
+		o.notify(EventBlockProgressStalled, reason, map[string]string{"block_num": strconv.FormatUint(blockNum, 10)})
+
+		if monitor.restart {
+			select {
+			case o.commandChan <- &Command{cmd: "reload", logger: o.zlogger}:
+			case <-o.Terminating():
+				return
+			}
+			lastProgressAt = time.Now()
+		}
+	}
+}
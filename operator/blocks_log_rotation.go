@@ -0,0 +1,99 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"go.uber.org/zap"
+)
+
+type blocksLogRotationResult struct {
+	BoundaryBlockNum uint64 `json:"boundary_block_num"` // last block of the finalized segment
+}
+
+// SetBeforeBlocksLogRotation makes `POST /v1/rotate_blocks_log` call `hook`
+// once the node is stopped, before its blocks log is rotated, like to have
+// mindreader flush its bundle so none straddles the boundary. The node is
+// restarted without rotating if it fails. It must be called before `Launch`.
+func (o *Operator) SetBeforeBlocksLogRotation(hook func() error) {
+	o.beforeBlocksLogRotation = hook
+}
+
+// rotateBlocksLog stops the node, so the boundary is at a block it fully
+// wrote, finalizes its blocks log segment and restarts it.
+func (o *Operator) rotateBlocksLog(cmd *Command) error {
+	rotator, ok := o.Superviser.(nodeManager.BlocksLogRotatorChainSuperviser)
+	if !ok {
+		cmd.Return(fmt.Errorf("chain superviser cannot rotate the blocks log"))
+		return nil
+	}
+
+	if err := o.deferWhileProducing(cmd.cmd); err != nil {
+		cmd.Return(err)
+		return nil
+	}
+
+	wasRunning := o.Superviser.IsRunning()
+	if wasRunning {
+		o.zlogger.Info("stopping node to rotate its blocks log")
+		if err := o.cleanSuperviserStop(); err != nil {
+			return err
+		}
+	}
+
+	startedAt := time.Now()
+	boundary, err := o.rotateStoppedBlocksLog(rotator)
+	o.recordResult(cmd.cmd, "", startedAt, boundary, "", err)
+	if err != nil {
+		o.zlogger.Error("unable to rotate blocks log", zap.Error(err))
+		cmd.Return(err)
+	} else {
+		o.zlogger.Info("rotated blocks log", zap.Uint64("boundary_block_num", boundary))
+		cmd.result = &blocksLogRotationResult{BoundaryBlockNum: boundary}
+	}
+
+	if wasRunning {
+		return o.runSubCommand("start", cmd)
+	}
+	return nil
+}
+
+func (o *Operator) rotateStoppedBlocksLog(rotator nodeManager.BlocksLogRotatorChainSuperviser) (uint64, error) {
+	if o.beforeBlocksLogRotation != nil {
+		if err := o.beforeBlocksLogRotation(); err != nil {
+			return 0, fmt.Errorf("preparing blocks log rotation: %w", err)
+		}
+	}
+	return rotator.RotateBlocksLog()
+}
+
+// rotateBlocksLogHandler always runs synchronously since the caller is
+// interested in the boundary block.
+func (o *Operator) rotateBlocksLogHandler(w http.ResponseWriter, _ *http.Request) {
+	c := &Command{cmd: "rotate_blocks_log", logger: o.zlogger}
+	if err := o.sendCommandAndWait(c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: blocks log rotation failed: %s \n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.result)
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultChainIDDetectionTimeout = time.Minute
+	chainIDRetryMaxDelay           = 10 * time.Second
+)
+
+// ChainID returns `Options.ChainID` if set, or else the chain id reported by
+// the superviser. The node's API is usually not up right after it started, so
+// fetching it is retried with a backoff up to `Options.ChainIDDetectionTimeout`.
+// Once obtained, it is cached.
+func (o *Operator) ChainID() (string, error) {
+	if o.options.ChainID != "" {
+		return o.options.ChainID, nil
+	}
+
+	o.chainIDLock.Lock()
+	defer o.chainIDLock.Unlock()
+	if o.chainID != "" {
+		return o.chainID, nil
+	}
+
+	chainIDSuperviser, ok := o.Superviser.(nodeManager.ChainIDChainSuperviser)
+	if !ok {
+		return "", fmt.Errorf("chain superviser cannot report its chain id")
+	}
+
+	timeout := o.options.ChainIDDetectionTimeout
+	if timeout == 0 {
+		timeout = defaultChainIDDetectionTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	chainID, err := o.fetchChainID(chainIDSuperviser, func(delay time.Duration) bool {
+		return !time.Now().Add(delay).After(deadline)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch chain id within %s: %w", timeout, err)
+	}
+
+	o.chainID = chainID
+	return chainID, nil
+}
+
+// whenChainIDKnown calls `apply` with the chain id, right away when set in
+// `Options.ChainID`, or else once the node reports it, see
+// `launchChainIDResolution`. It must be called from the command loop.
+func (o *Operator) whenChainIDKnown(apply func(chainID string)) {
+	if o.options.ChainID != "" {
+		apply(o.options.ChainID)
+		return
+	}
+	o.chainIDAppliers = append(o.chainIDAppliers, apply)
+}
+
+// launchChainIDResolution fetches the chain id awaited by `whenChainIDKnown`
+// off the command loop, retrying until the node reports it. Meanwhile,
+// backups are refused rather than written without it.
+func (o *Operator) launchChainIDResolution() {
+	appliers := o.chainIDAppliers
+	o.chainIDAppliers = nil
+	if len(appliers) == 0 {
+		return
+	}
+
+	o.backupChainIDPending.Store(true)
+	go func() {
+		for {
+			chainID, err := o.ChainID()
+			if err == nil {
+				for _, apply := range appliers {
+					apply(chainID)
+				}
+				o.backupChainIDPending.Store(false)
+				return
+			}
+
+			o.zlogger.Warn("unable to determine chain id, backups are refused until it is known, will retry", zap.Error(err))
+			select {
+			case <-o.Terminating():
+				return
+			case <-time.After(chainIDRetryMaxDelay):
+			}
+		}
+	}()
+}
+
+// fetchChainID retries with a backoff until the superviser reports the chain
+// id, as long as `keepTrying` agrees to wait for the next `delay`.
+func (o *Operator) fetchChainID(superviser nodeManager.ChainIDChainSuperviser, keepTrying func(delay time.Duration) bool) (string, error) {
+	delay := 500 * time.Millisecond
+	for {
+		chainID, err := superviser.ChainID()
+		if err == nil {
+			return chainID, nil
+		}
+
+		if !keepTrying(delay) {
+			return "", err
+		}
+
+		o.zlogger.Debug("unable to fetch chain id, retrying", zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-o.Terminating():
+			return "", fmt.Errorf("operator terminating: %w", err)
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > chainIDRetryMaxDelay {
+			delay = chainIDRetryMaxDelay
+		}
+	}
+}
+
+// verifyChainID checks, once the node started at `startedAt` answers, that it
+// runs `Options.ExpectedChainID`. On mismatch, the operator shuts down, stopping
+// the node. It gives up silently when the node gets restarted in the meantime,
+// the new start being verified on its own.
+func (o *Operator) verifyChainID(startedAt int64) {
+	chainIDSuperviser := o.Superviser.(nodeManager.ChainIDChainSuperviser) // checked in `New`
+
+	chainID, err := o.fetchChainID(chainIDSuperviser, func(_ time.Duration) bool {
+		return o.startedAt.Load() == startedAt
+	})
+	if err != nil {
+		return
+	}
+
+	if chainID != o.options.ExpectedChainID {
+		err := fmt.Errorf("node runs chain %q but chain %q is expected, refusing to continue (restored from the wrong backup?)", chainID, o.options.ExpectedChainID)
+		o.zlogger.Error("chain id mismatch, stopping node", zap.String("chain_id", chainID), zap.String("expected_chain_id", o.options.ExpectedChainID))
+		o.notify(EventChainIDMismatch, err.Error(), map[string]string{"chain_id": chainID, "expected_chain_id": o.options.ExpectedChainID})
+		o.Shutdown(err)
+		return
+	}
+
+	o.zlogger.Info("verified chain id", zap.String("chain_id", chainID))
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"os"
+)
+
+// MarkerFileCleanShutdownChecker considers the shutdown dirty when the marker
+// file (like a lock file left behind by the chain process) still exists.
+type MarkerFileCleanShutdownChecker struct {
+	Path string
+}
+
+func NewMarkerFileCleanShutdownChecker(path string) *MarkerFileCleanShutdownChecker {
+	return &MarkerFileCleanShutdownChecker{Path: path}
+}
+
+func (c *MarkerFileCleanShutdownChecker) IsCleanShutdown() (bool, error) {
+	_, err := os.Stat(c.Path)
+	if err == nil {
+		return false, nil
+	}
+
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unable to stat marker file %q: %s", c.Path, err)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DiagnosticsPrefix is where the crash diagnostics are uploaded in
+// `Options.BackupManifestStore`, under `<hostname>/<capture>/`
+const DiagnosticsPrefix = "diagnostics/"
+
+// ConfigureCrashDiagnostics makes the operator capture diagnostics in a new
+// directory under `dir` when the crash-loop limiter trips, before shutting
+// down: a goroutine dump and a heap profile of the operator, the recent log
+// entries of the ring buffer (see `SetLogRingBuffer`) and the last log lines
+// of the node. With `upload`, they are also written to
+// `Options.BackupManifestStore` under `DiagnosticsPrefix`. It must be called
+// before `Launch`, an empty `dir` disables it.
+func (o *Operator) ConfigureCrashDiagnostics(dir string, upload bool) error {
+	if upload && dir != "" && o.options.BackupManifestStore == nil {
+		return fmt.Errorf("uploading crash diagnostics requires a backup manifest store")
+	}
+	o.diagnosticsDir = dir
+	o.uploadDiagnostics = upload
+	return nil
+}
+
+// captureCrashDiagnostics never fails, the operator is shutting down anyway
+func (o *Operator) captureCrashDiagnostics() {
+	if o.diagnosticsDir == "" {
+		return
+	}
+
+	dir, err := o.writeCrashDiagnostics(time.Now())
+	if err != nil {
+		o.zlogger.Error("unable to capture crash diagnostics", zap.String("diagnostics_dir", o.diagnosticsDir), zap.Error(err))
+		return
+	}
+	o.zlogger.Info("captured crash diagnostics", zap.String("dir", dir))
+
+	if !o.uploadDiagnostics {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	if err := o.uploadCrashDiagnostics(ctx, dir); err != nil {
+		o.zlogger.Error("unable to upload crash diagnostics", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+	o.zlogger.Info("uploaded crash diagnostics", zap.String("dir", dir))
+}
+
+func (o *Operator) writeCrashDiagnostics(now time.Time) (string, error) {
+	dir := filepath.Join(o.diagnosticsDir, "crash_loop-"+now.UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	goroutines, err := os.Create(filepath.Join(dir, "goroutines.txt"))
+	if err != nil {
+		return "", err
+	}
+	err = pprof.Lookup("goroutine").WriteTo(goroutines, 2)
+	goroutines.Close()
+	if err != nil {
+		return "", fmt.Errorf("unable to write goroutine dump: %w", err)
+	}
+
+	heap, err := os.Create(filepath.Join(dir, "heap.pprof"))
+	if err != nil {
+		return "", err
+	}
+	runtime.GC()
+	err = pprof.WriteHeapProfile(heap)
+	heap.Close()
+	if err != nil {
+		return "", fmt.Errorf("unable to write heap profile: %w", err)
+	}
+
+	if o.logRingBuffer != nil {
+		data, err := json.MarshalIndent(o.logRingBuffer.Entries(zapcore.DebugLevel), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "logs.json"), data, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	if lines := o.Superviser.LastLogLines(); len(lines) != 0 {
+		if err := ioutil.WriteFile(filepath.Join(dir, "node_last_log_lines.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func (o *Operator) uploadCrashDiagnostics(ctx context.Context, dir string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to get hostname: %w", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		key := DiagnosticsPrefix + hostname + "/" + filepath.Base(dir) + "/" + file.Name()
+		err = o.options.BackupManifestStore.WriteObject(ctx, key, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to write %q: %w", key, err)
+		}
+	}
+	return nil
+}
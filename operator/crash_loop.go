@@ -0,0 +1,83 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// crashLoopLimiter allows restarting a crashed node at most `maxRestarts`
+// times within `window`. Crashes before `warmupUntil` are not counted.
+type crashLoopLimiter struct {
+	maxRestarts int
+	window      time.Duration
+	warmupUntil time.Time
+	restarts    []time.Time
+}
+
+// recordCrash returns whether the crash counted toward the limit and whether
+// the node may be restarted.
+func (l *crashLoopLimiter) recordCrash(now time.Time) (counted bool, allowed bool) {
+	if now.Before(l.warmupUntil) {
+		return false, true
+	}
+
+	var recent []time.Time
+	for _, restart := range l.restarts {
+		if now.Sub(restart) < l.window {
+			recent = append(recent, restart)
+		}
+	}
+	l.restarts = recent
+
+	if len(l.restarts) >= l.maxRestarts {
+		return true, false
+	}
+	l.restarts = append(l.restarts, now)
+	return true, true
+}
+
+// handleCrash restarts the node after an unexpected stop when the crash-loop
+// limiter allows it, it returns the error to shut down with otherwise.
+func (o *Operator) handleCrash(crashErr error) error {
+	if o.crashLoop == nil {
+		return crashErr
+	}
+
+	counted, allowed := o.crashLoop.recordCrash(time.Now())
+	if !allowed {
+		o.zlogger.Error("crash-loop limiter tripped, giving up on the node", zap.Int("max_restarts", o.crashLoop.maxRestarts), zap.Duration("window", o.crashLoop.window))
+		o.captureCrashDiagnostics()
+		return fmt.Errorf("%w: more than %d restarts within %s: %s", ErrCrashLoop, o.crashLoop.maxRestarts, o.crashLoop.window, crashErr)
+	}
+
+	if counted {
+		o.zlogger.Warn("node crashed, restarting it", zap.Int("restarts_in_window", len(o.crashLoop.restarts)), zap.Int("max_restarts", o.crashLoop.maxRestarts), zap.Error(crashErr))
+	} else {
+		o.zlogger.Warn("node crashed during crash-loop warmup, restarting it without counting the crash", zap.Error(crashErr))
+	}
+
+	if err := o.cleanStaleLocks(); err != nil {
+		o.zlogger.Warn("unable to clean stale lock files before restarting node", zap.Error(err))
+	}
+
+	if err := o.runCommand(&Command{cmd: "start", logger: o.zlogger}); err != nil {
+		return fmt.Errorf("unable to restart node after crash: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dfuse-io/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCrashLoopLimiter(t *testing.T) {
+	start := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := &crashLoopLimiter{maxRestarts: 2, window: 10 * time.Minute, warmupUntil: start.Add(time.Minute)}
+
+	type result struct{ counted, allowed bool }
+	check := func(at time.Duration) result {
+		counted, allowed := l.recordCrash(start.Add(at))
+		return result{counted, allowed}
+	}
+
+	assert.Equal(t, result{false, true}, check(10*time.Second), "first boot crash during warmup")
+	assert.Equal(t, result{true, true}, check(2*time.Minute))
+	assert.Equal(t, result{true, true}, check(3*time.Minute))
+	assert.Equal(t, result{true, false}, check(4*time.Minute), "third crash within window trips")
+	assert.Equal(t, result{true, true}, check(13*time.Minute), "first restart left the window")
+}
+
+func TestOperator_CrashLoopDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crash_diagnostics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := dstore.NewStore("file://"+filepath.Join(dir, "store"), "", "", false)
+	require.NoError(t, err)
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupManifestStore: store})
+	require.NoError(t, err)
+	o.SetLogRingBuffer(NewLogRingBuffer(10))
+	require.NoError(t, o.ConfigureCrashDiagnostics(filepath.Join(dir, "local"), true))
+	o.crashLoop = &crashLoopLimiter{maxRestarts: 0, window: time.Minute}
+
+	err = o.handleCrash(errors.New("node exited"))
+	assert.True(t, errors.Is(err, ErrCrashLoop))
+
+	captures, err := filepath.Glob(filepath.Join(dir, "local", "crash_loop-*"))
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	for _, name := range []string{"goroutines.txt", "heap.pprof", "logs.json"} {
+		_, err := os.Stat(filepath.Join(captures[0], name))
+		assert.NoError(t, err, name)
+	}
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	exists, err := store.FileExists(context.Background(), DiagnosticsPrefix+hostname+"/"+filepath.Base(captures[0])+"/goroutines.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	o, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+	assert.Error(t, o.ConfigureCrashDiagnostics(dir, true))
+}
@@ -0,0 +1,256 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cronMaxLookahead bounds the search of the next matching time, an expression
+// matching nothing within it (like `0 0 30 2 *`) never runs
+const cronMaxLookahead = 5 * 366 * 24 * time.Hour
+
+var cronMonthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+var cronDayNames = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+
+// CronSchedule is a standard five fields cron expression (minute, hour, day
+// of month, month and day of week), evaluated in a time zone.
+type CronSchedule struct {
+	spec     string
+	minutes  []bool
+	hours    []bool
+	days     []bool // of month, from 1
+	months   []bool // from 1
+	weekdays []bool // Sunday is 0
+	anyDay   bool   // day of month starts with `*`
+	anyDOW   bool   // day of week starts with `*`
+	location *time.Location
+}
+
+// ParseCronSchedule parses a five fields cron expression, like `0 3 * * 1-6`
+// for every day at 03:00 but Sundays, evaluated in `location` (UTC when nil).
+// Fields accept `*`, values, ranges (`1-5`), steps (`*/15`, `0-30/10`) and
+// lists of them (`1,15`), months and days of week also accept their three
+// letters names (`JAN`, `SUN`), Sunday being either 0 or 7. Like in Vixie
+// cron, when both the day of month and the day of week are restricted (do not
+// start with `*`), either matching is enough.
+//
+// Times are those of the wall clock in `location`: a time skipped when the
+// clocks go forward runs as much later (02:30 at 03:30 when skipping from 02:00
+// to 03:00), a time repeated when they go back runs once.
+func ParseCronSchedule(spec string, location *time.Location) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields (minute hour day-of-month month day-of-week)", spec)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	s := &CronSchedule{spec: spec, location: location, anyDay: strings.HasPrefix(fields[2], "*"), anyDOW: strings.HasPrefix(fields[4], "*")}
+	var err error
+	if s.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, minute: %w", spec, err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, hour: %w", spec, err)
+	}
+	if s.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, day of month: %w", spec, err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, month: %w", spec, err)
+	}
+	if s.weekdays, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q, day of week: %w", spec, err)
+	}
+	if s.weekdays[7] {
+		s.weekdays[0] = true
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step, stepped := part, 1, false
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+			stepped = true
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			if high, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return nil, err
+			}
+			if low > high {
+				return nil, fmt.Errorf("invalid range %q", rangePart)
+			}
+		case stepped:
+			return nil, fmt.Errorf("invalid step in %q, only `*` and ranges take one", part)
+		default:
+			value, err := parseCronValue(rangePart, min, max, names)
+			if err != nil {
+				return nil, err
+			}
+			low, high = value, value
+		}
+
+		for v := low; v <= high; v += step {
+			matches[v] = true
+		}
+	}
+	return matches, nil
+}
+
+func parseCronValue(in string, min, max int, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToUpper(in)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(in)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("value %q not within %d-%d", in, min, max)
+	}
+	return value, nil
+}
+
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time strictly after `after` matching the schedule,
+// the zero time if there is none.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	// walked on the wall clock, held in UTC where each minute exists exactly once
+	wall := wallClock(after.In(s.location)).Add(time.Minute)
+	limit := wall.Add(cronMaxLookahead)
+
+	for wall.Before(limit) {
+		if !s.months[int(wall.Month())] {
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(wall) {
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.hours[wall.Hour()] {
+			wall = wall.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !s.minutes[wall.Minute()] {
+			wall = wall.Add(time.Minute)
+			continue
+		}
+
+		t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, s.location)
+		if shown := wallClock(t); shown.Before(wall) {
+			// skipped when the clocks went forward, `time.Date` went back before the change
+			t = t.Add(wall.Sub(shown))
+		} else if earlier, ok := earlierOccurrence(t); ok {
+			t = earlier
+		}
+		if t.After(after) {
+			return t
+		}
+		wall = wall.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// wallClock returns the wall clock time of `t`, in UTC
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// earlierOccurrence returns the first time the wall clock showed the time of
+// `t`, when it already did before the clocks went back.
+func earlierOccurrence(t time.Time) (time.Time, bool) {
+	_, offset := t.Zone()
+	_, earlierOffset := t.Add(-12 * time.Hour).Zone()
+	if earlierOffset <= offset {
+		return time.Time{}, false
+	}
+
+	earlier := t.Add(-time.Duration(earlierOffset-offset) * time.Second)
+	if earlier.Hour() != t.Hour() || earlier.Minute() != t.Minute() {
+		return time.Time{}, false
+	}
+	return earlier, true
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dayMatch := s.days[t.Day()]
+	weekdayMatch := s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyDOW {
+		return dayMatch && weekdayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+// ConfigureAutoCron schedules the backup module registered under
+// `backuperName` at each time matching `cron`. It takes precedence over the
+// period of the schedule already configured for that module, if any, which
+// keeps its other triggers (like every X blocks). When `hostnameMatch` is set
+// and differs from `hostname`, no schedule is registered.
+func (o *Operator) ConfigureAutoCron(backuperName string, cron *CronSchedule, hostnameMatch, hostname string) {
+	for _, sched := range o.backupSchedules {
+		if sched.BackuperName == backuperName && sched.TimeBetweenRuns != 0 && sched.Cron == nil {
+			sched.Cron = cron
+			return
+		}
+	}
+	o.configureAutoSchedule(&BackupSchedule{Cron: cron, BackuperName: backuperName}, hostnameMatch, hostname)
+}
+
+// RunOnCron sends the command at each time matching the schedule's cron
+// expression, while the chain is running.
+func (o *Operator) RunOnCron(sched *BackupSchedule, commandName string, params map[string]string) {
+	for {
+		next := sched.Cron.Next(time.Now())
+		if next.IsZero() {
+			o.zlogger.Warn("cron expression never matches, schedule stopped", zap.Stringer("cron", sched.Cron), zap.String("backuper_name", sched.BackuperName))
+			return
+		}
+		sched.setNextRunTime(next)
+
+		select {
+		case <-o.Terminating():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		if o.Superviser.IsRunning() {
+			o.sendScheduledCommand(sched, commandName, params)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCronSchedule(t *testing.T) {
+	at := func(value string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return tm
+	}
+
+	s, err := ParseCronSchedule("*/15 * * * *", nil)
+	require.NoError(t, err)
+	assert.Equal(t, at("2020-06-15 10:15"), s.Next(at("2020-06-15 10:07")))
+	assert.Equal(t, at("2020-06-15 10:30"), s.Next(at("2020-06-15 10:15")))
+
+	s, err = ParseCronSchedule("0 3 * * 1-6", nil) // 2020-06-14 is a Sunday
+	require.NoError(t, err)
+	assert.Equal(t, at("2020-06-15 03:00"), s.Next(at("2020-06-13 04:00")))
+
+	s, err = ParseCronSchedule("30 4 * JAN,jul SUN", nil)
+	require.NoError(t, err)
+	assert.Equal(t, at("2020-07-05 04:30"), s.Next(at("2020-06-15 00:00")))
+
+	s, err = ParseCronSchedule("0 0 1 * 1", nil) // either the 1st or a Monday
+	require.NoError(t, err)
+	assert.Equal(t, at("2020-06-22 00:00"), s.Next(at("2020-06-15 00:00")))
+	assert.Equal(t, at("2020-07-01 00:00"), s.Next(at("2020-06-29 00:00")))
+
+	montreal, err := time.LoadLocation("America/Montreal") // UTC-4 in June
+	require.NoError(t, err)
+	s, err = ParseCronSchedule("0 2 * * *", montreal)
+	require.NoError(t, err)
+	assert.True(t, at("2020-06-15 06:00").Equal(s.Next(at("2020-06-15 05:00"))))
+
+	s, err = ParseCronSchedule("0 0 30 2 *", nil)
+	require.NoError(t, err)
+	assert.True(t, s.Next(at("2020-06-15 00:00")).IsZero())
+
+	for _, invalid := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "5/15 * * * *", "* * * * MON/2"} {
+		_, err := ParseCronSchedule(invalid, nil)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCronSchedule_DayOfMonthAndWeek(t *testing.T) {
+	at := func(value string) time.Time {
+		tm, err := time.Parse("2006-01-02", value)
+		require.NoError(t, err)
+		return tm
+	}
+
+	// 2020-06-15 is a Monday
+	tests := []struct {
+		spec     string
+		after    string
+		expected string
+	}{
+		{"0 0 1 * *", "2020-06-15", "2020-07-01"},
+		{"0 0 * * 1", "2020-06-15", "2020-06-22"},
+		{"0 0 1 * 1", "2020-06-29", "2020-07-01"}, // both restricted, either matches
+		{"0 0 13 * FRI", "2020-06-15", "2020-06-19"},
+		{"0 0 13 * FRI", "2020-11-12", "2020-11-13"},
+		{"0 0 */1 * 1", "2020-06-29", "2020-07-06"}, // same as `*`, only the day of week restricts
+		{"0 0 1-7 * */1", "2020-06-15", "2020-07-01"},
+		{"0 0 */2 * 1", "2020-06-15", "2020-06-29"}, // starting with `*`, both must match
+		{"0 0 1 * 0", "2020-06-15", "2020-06-21"},
+		{"0 0 1 * 7", "2020-06-15", "2020-06-21"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec+" after "+test.after, func(t *testing.T) {
+			s, err := ParseCronSchedule(test.spec, nil)
+			require.NoError(t, err)
+			assert.Equal(t, at(test.expected), s.Next(at(test.after)))
+		})
+	}
+}
+
+func TestCronSchedule_DaylightSavingTime(t *testing.T) {
+	// 2020-03-08 02:00 EST skips to 03:00 EDT, 2020-11-01 02:00 EDT goes back to 01:00 EST
+	montreal, err := time.LoadLocation("America/Montreal")
+	require.NoError(t, err)
+
+	utc := func(value string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return tm
+	}
+
+	tests := []struct {
+		name     string
+		spec     string
+		after    string
+		expected string
+	}{
+		{"skipped time runs as much later", "30 2 * * *", "2020-03-08 06:00", "2020-03-08 07:30"},
+		{"skipped time back to normal the next day", "30 2 * * *", "2020-03-08 07:30", "2020-03-09 06:30"},
+		{"time right after the skip", "0 3 * * *", "2020-03-08 06:00", "2020-03-08 07:00"},
+		{"interval across the skip", "*/30 * * * *", "2020-03-08 06:30", "2020-03-08 07:00"},
+		{"repeated time runs at its first occurrence", "30 1 * * *", "2020-11-01 04:00", "2020-11-01 05:30"},
+		{"repeated time runs once", "30 1 * * *", "2020-11-01 05:30", "2020-11-02 06:30"},
+		{"repeated time already passed", "30 1 * * *", "2020-11-01 06:10", "2020-11-02 06:30"},
+		{"interval across the repeat", "*/30 * * * *", "2020-11-01 05:30", "2020-11-01 07:00"},
+		{"time right after the repeat", "0 2 * * *", "2020-11-01 04:00", "2020-11-01 07:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := ParseCronSchedule(test.spec, montreal)
+			require.NoError(t, err)
+			next := s.Next(utc(test.after))
+			assert.True(t, utc(test.expected).Equal(next), "expected %s, got %s", utc(test.expected), next.UTC())
+		})
+	}
+}
+
+func TestOperator_ConfigureAutoCron(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	cron, err := ParseCronSchedule("0 3 * * *", nil)
+	require.NoError(t, err)
+
+	o.ConfigureAutoBackup(time.Hour, 1000, "", "")
+	o.ConfigureAutoCron(BackupModuleName, cron, "", "")
+	o.ConfigureAutoCron(SnapshotModuleName, cron, "other-host", "this-host")
+	o.ConfigureAutoCron(VolumeSnapshotModuleName, cron, "", "")
+
+	require.Len(t, o.backupSchedules, 2)
+	assert.Equal(t, BackupModuleName, o.backupSchedules[0].BackuperName)
+	assert.Equal(t, cron, o.backupSchedules[0].Cron)
+	assert.Equal(t, 1000, o.backupSchedules[0].BlocksBetweenRuns)
+	assert.Equal(t, "0 3 * * *", o.backupSchedules[0].Status().Cron)
+	assert.Equal(t, VolumeSnapshotModuleName, o.backupSchedules[1].BackuperName)
+	assert.Equal(t, cron, o.backupSchedules[1].Cron)
+}
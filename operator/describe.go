@@ -0,0 +1,228 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+)
+
+// redactedKeyRegex matches the config keys whose values are never described
+var redactedKeyRegex = regexp.MustCompile(`(?i)secret|token|password|passwd|credential|private_?key|signing_?key`)
+
+// redactedURLKeyRegex matches the config keys holding webhook URLs, which
+// routinely carry their secret in the path (like Slack's or Discord's), only
+// their scheme and host are described
+var redactedURLKeyRegex = regexp.MustCompile(`(?i)webhook|notification_?routing`)
+
+const redacted = "REDACTED"
+
+// Description is the whole state of the node-manager served by `/v1/describe`.
+// It only holds values already known, gathering it never waits on the node.
+type Description struct {
+	ChainID          string                 `json:"chain_id,omitempty"`
+	Running          bool                   `json:"running"`
+	Ready            bool                   `json:"ready"`
+	NotReadyReason   string                 `json:"not_ready_reason,omitempty"`
+	Degraded         string                 `json:"degraded,omitempty"`
+	Standby          bool                   `json:"standby"`
+	UptimeSeconds    float64                `json:"uptime_seconds"`
+	HeadBlock        *DescribedHeadBlock    `json:"head_block,omitempty"`
+	ConnectedPeers   *int                   `json:"connected_peers,omitempty"`
+	CurrentOperation *OperationStatus       `json:"current_operation"`
+	Schedules        []*ScheduleStatus      `json:"schedules"`
+	LastResults      []*OperationResult     `json:"last_results"`
+	Config           interface{}            `json:"config,omitempty"`
+	Sections         map[string]interface{} `json:"sections,omitempty"`
+}
+
+type DescribedHeadBlock struct {
+	Num          uint64    `json:"num"`
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	DriftSeconds float64   `json:"drift_seconds"`
+}
+
+// SetDescribeConfig makes `/v1/describe` include `config`, its secrets (like
+// passwords in URLs or fields named after tokens or keys) redacted.
+func (o *Operator) SetDescribeConfig(config interface{}) {
+	o.describeLock.Lock()
+	defer o.describeLock.Unlock()
+	o.describeConfig = config
+}
+
+// AddDescribeSection makes `/v1/describe` include the value returned by
+// `section` under `name`, like the state of a plugin. It is called on each
+// request and must be cheap and non-blocking.
+func (o *Operator) AddDescribeSection(name string, section func() interface{}) {
+	o.describeLock.Lock()
+	defer o.describeLock.Unlock()
+	if o.describeSections == nil {
+		o.describeSections = make(map[string]func() interface{})
+	}
+	o.describeSections[name] = section
+}
+
+func (o *Operator) Describe() *Description {
+	problem := o.readinessProblem()
+	d := &Description{
+		Running:          o.Superviser.IsRunning(),
+		Ready:            problem == "",
+		NotReadyReason:   problem,
+		Standby:          o.standby.Load(),
+		UptimeSeconds:    o.uptime().Seconds(),
+		CurrentOperation: o.OperationStatus(),
+		Schedules:        o.scheduleStatuses(),
+		LastResults:      o.LastResults(),
+	}
+
+	d.ChainID = o.options.ChainID
+	if d.ChainID == "" {
+		o.chainIDLock.Lock()
+		d.ChainID = o.chainID
+		o.chainIDLock.Unlock()
+	}
+
+	if reporter, ok := o.chainReadiness.(nodeManager.HeadBlockReporter); ok {
+		if num, id, blockTime := reporter.HeadBlock(); num != 0 {
+			d.HeadBlock = &DescribedHeadBlock{Num: num, ID: id, Time: blockTime}
+			if !blockTime.IsZero() {
+				d.HeadBlock.DriftSeconds = time.Since(blockTime).Seconds()
+			}
+		}
+	}
+
+	if reporter, ok := o.chainReadiness.(nodeManager.DegradedReporter); ok {
+		d.Degraded = reporter.DegradedReason()
+	}
+	if o.blockProgress != nil {
+		if reason := o.blockProgress.degradedReason(); reason != "" {
+			if d.Degraded != "" {
+				reason = d.Degraded + "; " + reason
+			}
+			d.Degraded = reason
+		}
+	}
+
+	if counter, ok := o.Superviser.(nodeManager.PeerCountChainSuperviser); ok {
+		if peers := counter.ConnectedPeers(); peers >= 0 {
+			d.ConnectedPeers = &peers
+		}
+	}
+
+	o.describeLock.Lock()
+	defer o.describeLock.Unlock()
+	if o.describeConfig != nil {
+		d.Config = redactConfig(o.describeConfig)
+	}
+	if len(o.describeSections) != 0 {
+		d.Sections = make(map[string]interface{}, len(o.describeSections))
+		for name, section := range o.describeSections {
+			d.Sections[name] = section()
+		}
+	}
+	return d
+}
+
+func (o *Operator) describeHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.Describe())
+}
+
+// redactConfig returns `config` as generic JSON values, with the values of
+// secret-looking keys, the passwords of URLs and all but the host of webhook
+// URLs replaced.
+func redactConfig(config interface{}) interface{} {
+	cnt, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(cnt, &value); err != nil {
+		return nil
+	}
+	return redactValue("", value)
+}
+
+// redactURLs keeps only the scheme and host of the URLs in `value`
+func redactURLs(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = redactURLs(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactURLs(child)
+		}
+		return v
+	case string:
+		if v == "" {
+			return v
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return redacted
+		}
+		return (&url.URL{Scheme: u.Scheme, Host: u.Hostname()}).String() + "/" + redacted
+	default:
+		return value
+	}
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if redactedURLKeyRegex.MatchString(k) {
+				v[k] = redactURLs(child)
+				continue
+			}
+			v[k] = redactValue(k, child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(key, child)
+		}
+		return v
+	case string:
+		if v == "" {
+			return v
+		}
+		if redactedKeyRegex.MatchString(key) {
+			return redacted
+		}
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				return u.String()
+			}
+		}
+		return v
+	default:
+		if redactedKeyRegex.MatchString(key) {
+			return redacted
+		}
+		return v
+	}
+}
@@ -14,6 +14,64 @@
 
 package operator
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
 
 var ErrCleanExit = errors.New("clean exit")
+
+// ErrCrashLoop is wrapped by the error the operator shuts down with when the
+// crash-loop limiter trips
+var ErrCrashLoop = errors.New("crash loop")
+
+// ErrBackupStoreFull matches (with `errors.Is`) the errors of backups failing
+// because their store ran out of space or quota, retrying them does not help
+var ErrBackupStoreFull = errors.New("backup store is full")
+
+// storeFullMessages are lowercased fragments of the errors reported by the
+// stores when they run out of space or quota
+var storeFullMessages = []string{
+	"no space left on device",
+	"disk quota exceeded",
+	"quota exceeded",
+	"quotaexceeded",
+	"insufficient storage",
+	"insufficientstorage",
+	"storage quota",
+}
+
+// StoreFullError wraps the error of a store that ran out of space or quota
+type StoreFullError struct {
+	Err error
+}
+
+func (e *StoreFullError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrBackupStoreFull, e.Err)
+}
+
+func (e *StoreFullError) Unwrap() error { return e.Err }
+
+func (e *StoreFullError) Is(target error) bool { return target == ErrBackupStoreFull }
+
+// WrapStoreFullError returns `err` wrapped in a `StoreFullError` when it
+// reports that the store ran out of space or quota, as is otherwise.
+func WrapStoreFullError(err error) error {
+	if err == nil || errors.Is(err, ErrBackupStoreFull) {
+		return err
+	}
+
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return &StoreFullError{Err: err}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range storeFullMessages {
+		if strings.Contains(msg, fragment) {
+			return &StoreFullError{Err: err}
+		}
+	}
+	return err
+}
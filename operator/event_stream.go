@@ -0,0 +1,119 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// eventStreamBufferSize is the number of events a `/v1/events` subscriber
+// may lag behind before being dropped
+const eventStreamBufferSize = 50
+
+// eventBroadcaster fans the operator's events out to the `/v1/events` subscribers
+type eventBroadcaster struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]bool
+	logger      *zap.Logger
+}
+
+func newEventBroadcaster(logger *zap.Logger) *eventBroadcaster {
+	return &eventBroadcaster{
+		subscribers: make(map[chan Event]bool),
+		logger:      logger,
+	}
+}
+
+func (b *eventBroadcaster) subscribe() chan Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	events := make(chan Event, eventStreamBufferSize)
+	b.subscribers[events] = true
+	return events
+}
+
+func (b *eventBroadcaster) unsubscribe(events chan Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.subscribers[events] {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// publish never blocks, a subscriber whose buffer is full is dropped and its channel closed
+func (b *eventBroadcaster) publish(event Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			b.logger.Warn("event stream subscriber too slow, dropping it", zap.Int("buffer_size", eventStreamBufferSize))
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// eventsHandler streams the operator's events as Server-Sent Events, each
+// `data` line being the event as sent to the notifier. A client lagging too
+// far behind gets a final `dropped` event and is disconnected.
+func (o *Operator) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := o.events.subscribe()
+	defer o.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-o.Terminating():
+			return
+		case event, ok := <-events:
+			if !ok {
+				fmt.Fprint(w, "event: dropped\ndata: {\"reason\":\"client too slow\"}\n\n")
+				flusher.Flush()
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
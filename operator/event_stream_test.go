@@ -0,0 +1,78 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperator_EventsStream(t *testing.T) {
+	o, _ := newTestOperator(t)
+
+	server := httptest.NewServer(http.HandlerFunc(o.eventsHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// not `require.Eventually`, its ticker may panic once it returned
+	subscribed := func() bool {
+		o.events.lock.Lock()
+		defer o.events.lock.Unlock()
+		return len(o.events.subscribers) == 1
+	}
+	for deadline := time.Now().Add(time.Second); !subscribed(); time.Sleep(time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "events stream never subscribed")
+	}
+
+	o.notify(EventNodeStarted, "started", nil)
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: node_started\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"type":"node_started"`)
+	assert.Contains(t, line, `"message":"started"`)
+}
+
+func TestEventBroadcaster_DropsSlowSubscriber(t *testing.T) {
+	o, _ := newTestOperator(t)
+
+	slow := o.events.subscribe()
+	for i := 0; i < eventStreamBufferSize+1; i++ {
+		o.notify(EventNodeStarted, "", nil)
+	}
+	assert.Len(t, o.events.subscribers, 0)
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, eventStreamBufferSize, received)
+
+	// unsubscribing a dropped subscriber is a no-op
+	o.events.unsubscribe(slow)
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultGlobalOperationLockTimeout = 10 * time.Minute
+
+var globalOperationLockRetryInterval = time.Second
+
+// ErrGlobalOperationLockTimeout is returned by the backups not performed
+// because no slot of `Options.GlobalOperationLockPath` got free in time
+var ErrGlobalOperationLockTimeout = errors.New("timeout waiting for a global operation lock slot")
+
+// errCommandRequeued is returned by `runCommand` for commands it queued again
+// to run later, they are not done yet
+var errCommandRequeued = errors.New("command requeued")
+
+// tryGlobalOperationLock takes one of the `Options.GlobalOperationLockHolders`
+// slots shared by the node-managers of the host, the slots are the files
+// `<GlobalOperationLockPath>.<index>`, held with `flock` so they are freed
+// when a process dies. The returned func releases it, it is nil when every
+// slot is held.
+func (o *Operator) tryGlobalOperationLock(operation string) (release func(), err error) {
+	if o.options.GlobalOperationLockPath == "" {
+		return func() {}, nil
+	}
+
+	for i := 0; i < o.globalOperationLockHolders(); i++ {
+		path := fmt.Sprintf("%s.%d", o.options.GlobalOperationLockPath, i)
+		file, err := tryLockFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to lock %q: %w", path, err)
+		}
+		if file == nil {
+			continue
+		}
+
+		o.zlogger.Info("acquired global operation lock slot", zap.String("operation", operation), zap.String("path", path))
+		return func() {
+			_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			_ = file.Close()
+			o.zlogger.Info("released global operation lock slot", zap.String("operation", operation), zap.String("path", path))
+		}, nil
+	}
+	return nil, nil
+}
+
+func (o *Operator) globalOperationLockHolders() int {
+	if o.options.GlobalOperationLockHolders <= 0 {
+		return 1
+	}
+	return o.options.GlobalOperationLockHolders
+}
+
+// acquireGlobalOperationLock waits for a slot of `tryGlobalOperationLock`, up
+// to `Options.GlobalOperationLockTimeout`. It must not be called from the
+// command loop, see `requeueWithGlobalOperationLock`.
+func (o *Operator) acquireGlobalOperationLock(ctx context.Context, operation string) (release func(), err error) {
+	timeout := o.options.GlobalOperationLockTimeout
+	if timeout == 0 {
+		timeout = defaultGlobalOperationLockTimeout
+	}
+
+	start := time.Now()
+	o.zlogger.Info("every global operation lock slot is held by another operation, waiting", zap.String("operation", operation), zap.Int("holders", o.globalOperationLockHolders()), zap.Duration("timeout", timeout))
+	for {
+		select {
+		case <-o.Terminating():
+			return nil, fmt.Errorf("%s deferred: operator is terminating", operation)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s aborted on request while waiting for a global operation lock slot", operation)
+		case <-time.After(globalOperationLockRetryInterval):
+		}
+
+		release, err := o.tryGlobalOperationLock(operation)
+		if err != nil || release != nil {
+			return release, err
+		}
+
+		if time.Since(start) >= timeout {
+			o.zlogger.Warn("timeout waiting for a global operation lock slot, operation not performed", zap.String("operation", operation), zap.Duration("timeout", timeout))
+			return nil, fmt.Errorf("%s deferred: %w", operation, ErrGlobalOperationLockTimeout)
+		}
+	}
+}
+
+// requeueWithGlobalOperationLock waits for a global operation lock slot off
+// the command loop, then queues `cmd` again holding it. The wait can be
+// canceled through `Abort`.
+func (o *Operator) requeueWithGlobalOperationLock(cmd *Command, operation string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o.operationLock.Lock()
+	o.globalLockWaits[cmd] = &globalLockWait{cancel: cancel, module: operation}
+	o.operationLock.Unlock()
+
+	release, err := o.acquireGlobalOperationLock(ctx, operation)
+
+	o.operationLock.Lock()
+	delete(o.globalLockWaits, cmd)
+	o.operationLock.Unlock()
+
+	if err != nil {
+		cmd.Return(err)
+		return
+	}
+
+	cmd.globalLockRelease = release
+	select {
+	case o.commandChan <- cmd:
+	case <-o.Terminating():
+		release()
+		cmd.Return(fmt.Errorf("%s deferred: operator is terminating", operation))
+	}
+}
+
+// globalLockWait is a backup waiting in `requeueWithGlobalOperationLock`
+type globalLockWait struct {
+	cancel context.CancelFunc
+	module string
+}
+
+// tryLockFile returns the file at `path` locked, nil if another process (or
+// another open file of this one) holds it.
+func tryLockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return file, nil
+}
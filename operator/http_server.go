@@ -15,43 +15,80 @@
 package operator
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dfuse-io/derr"
+	nodeManager "github.com/dfuse-io/node-manager"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type HTTPOption func(r *mux.Router)
 
+// DefaultReadinessPath always serves the readiness check, see `ReadinessPathOption`
+const DefaultReadinessPath = "/healthz"
+
 func (o *Operator) RunHTTPServer(httpListenAddr string, options ...HTTPOption) *http.Server {
 	r := mux.NewRouter()
 	r.HandleFunc("/v1/ping", o.pingHandler).Methods("GET")
-	r.HandleFunc("/healthz", o.healthzHandler).Methods("GET")
+	r.HandleFunc(DefaultReadinessPath, o.healthzHandler).Methods("GET")
 	r.HandleFunc("/v1/healthz", o.healthzHandler).Methods("GET")
+	r.HandleFunc("/live", o.liveHandler).Methods("GET")
+	r.HandleFunc("/v1/ready_since", o.readySinceHandler).Methods("GET")
 	r.HandleFunc("/v1/server_id", o.serverIDHandler).Methods("GET")
 	r.HandleFunc("/v1/is_running", o.isRunningHandler).Methods("GET")
+	r.HandleFunc("/v1/node_status", o.nodeStatusHandler).Methods("GET")
 	r.HandleFunc("/v1/start_command", o.startcommandHandler).Methods("GET")
 	r.HandleFunc("/v1/maintenance", o.maintenanceHandler).Methods("POST")
 	r.HandleFunc("/v1/resume", o.resumeHandler).Methods("POST")
 	r.HandleFunc("/v1/backup", o.backupHandler).Methods("POST")
+	r.HandleFunc("/v1/volume_snapshot", o.volumeSnapshotHandler).Methods("POST")
+	r.HandleFunc("/v1/chain_snapshot", o.chainSnapshotHandler).Methods("POST")
 	r.HandleFunc("/v1/restore", o.restoreHandler).Methods("POST")
 	r.HandleFunc("/v1/list_backups", o.listBackupsHandler).Methods("GET")
+	r.HandleFunc("/v1/pin_backup", o.pinBackupHandler).Methods("POST", "DELETE")
+	r.HandleFunc("/v1/schedule", o.scheduleHandler).Methods("GET")
+	r.HandleFunc("/v1/backup_manifest/{name:.+}", o.backupManifestHandler).Methods("GET")
+	r.HandleFunc("/v1/rotate_blocks_log", o.rotateBlocksLogHandler).Methods("POST")
 	r.HandleFunc("/v1/reload", o.reloadHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_reload", o.safelyReloadHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_pause_production", o.safelyPauseProdHandler).Methods("POST")
 	r.HandleFunc("/v1/safely_resume_production", o.safelyResumeProdHandler).Methods("POST")
+	r.HandleFunc("/v1/promote", o.promoteHandler).Methods("POST")
+	r.HandleFunc("/v1/operation_status", o.operationStatusHandler).Methods("GET")
+	r.HandleFunc("/v1/abort", o.abortHandler).Methods("POST")
+	r.HandleFunc("/v1/last_results", o.lastResultsHandler).Methods("GET")
+	r.HandleFunc("/v1/events", o.eventsHandler).Methods("GET")
+	r.HandleFunc("/v1/describe", o.describeHandler).Methods("GET")
+	r.HandleFunc("/v1/last_shutdown_reason", o.lastShutdownReasonHandler).Methods("GET")
+
+	if o.logRingBuffer != nil {
+		r.HandleFunc("/v1/logs", o.logsHandler).Methods("GET")
+		r.HandleFunc("/v1/operation/{id}/logs", o.operationLogsHandler).Methods("GET")
+	}
 
 	for _, opt := range options {
 		opt(r)
 	}
 
 	o.zlogger.Info("starting webserver", zap.String("http_addr", httpListenAddr))
+	var enabledRoutes []string
+	disabledFound := make(map[string]bool)
 	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		pathTemplate, err := route.GetPathTemplate()
 		if err == nil {
+			if o.disabledEndpoints[pathTemplate] {
+				// never matched anymore, requests get a 404 like for an unknown path
+				route.BuildOnly()
+				disabledFound[pathTemplate] = true
+				return nil
+			}
+
 			methodsTmp, err := route.GetMethods()
 			var methods string
 			if err == nil {
@@ -61,6 +98,7 @@ func (o *Operator) RunHTTPServer(httpListenAddr string, options ...HTTPOption) *
 			}
 
 			o.zlogger.Debug("walked route methods", zap.String("methods", methods), zap.String("path_template", pathTemplate))
+			enabledRoutes = append(enabledRoutes, methods+" "+pathTemplate)
 		}
 		return nil
 	})
@@ -69,6 +107,13 @@ func (o *Operator) RunHTTPServer(httpListenAddr string, options ...HTTPOption) *
 		o.zlogger.Error("walking route methods", zap.Error(err))
 	}
 
+	for path := range o.disabledEndpoints {
+		if !disabledFound[path] {
+			o.zlogger.Warn("disabled endpoint does not match any route", zap.String("path", path))
+		}
+	}
+	o.zlogger.Info("enabled http routes", zap.Strings("routes", enabledRoutes), zap.Int("disabled_count", len(disabledFound)))
+
 	srv := &http.Server{Addr: httpListenAddr, Handler: r}
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -103,23 +148,81 @@ func (o *Operator) serverIDHandler(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(id))
 }
 
+// liveHandler is the liveness probe, unlike `/healthz` (readiness) it does not
+// depend on the chain, only on the operator's main loop not being stuck.
+func (o *Operator) liveHandler(w http.ResponseWriter, _ *http.Request) {
+	if !o.IsLive() {
+		http.Error(w, "not live: operator main loop is stalled", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("live\n"))
+}
+
 func (o *Operator) healthzHandler(w http.ResponseWriter, _ *http.Request) {
-	if !o.Superviser.IsRunning() {
-		http.Error(w, "not ready: chain is not running", http.StatusServiceUnavailable)
+	problem := o.readinessProblem()
+	o.updateReadySince(problem == "")
+	if problem != "" {
+		http.Error(w, "not ready: "+problem, http.StatusServiceUnavailable)
 		return
 	}
 
+	w.Write([]byte("ready\n"))
+}
+
+// readinessProblem returns why the node is not ready, empty when it is.
+func (o *Operator) readinessProblem() string {
+	if wedged, ok := o.Superviser.(nodeManager.WedgedChainSuperviser); ok && wedged.IsWedged() {
+		return "node process is wedged, it survived SIGKILL"
+	}
+
+	if !o.Superviser.IsRunning() {
+		return "chain is not running"
+	}
+
 	if !o.chainReadiness.IsReady() {
-		http.Error(w, "not ready: chain is not ready", http.StatusServiceUnavailable)
-		return
+		return "chain is not ready"
+	}
+
+	if o.options.NodePhase != nil && !o.options.NodePhase.IsLive() {
+		return "node is not live yet"
+	}
+
+	if o.standby.Load() {
+		return "node is in standby"
 	}
 
 	if o.aboutToStop.Load() || derr.IsShuttingDown() {
-		http.Error(w, "not ready: chain about to stop", http.StatusServiceUnavailable)
+		return "chain about to stop"
+	}
+
+	if o.readinessFunc != nil {
+		if ready, reason := o.readinessFunc(); !ready {
+			if reason == "" {
+				reason = "custom readiness check failed"
+			}
+			return reason
+		}
+	}
+
+	return ""
+}
+
+// SetReadinessFunc makes the readiness check also require `readiness` to
+// report the node ready, like for chain-specific conditions. Its reason is
+// served when it does not. It must be called before `Launch`, and be cheap
+// since it is called on each readiness check.
+func (o *Operator) SetReadinessFunc(readiness func() (ready bool, reason string)) {
+	o.readinessFunc = readiness
+}
+
+func (o *Operator) promoteHandler(w http.ResponseWriter, _ *http.Request) {
+	if !o.Promote() {
+		_, _ = w.Write([]byte("node was not in standby, nothing to do\n"))
 		return
 	}
 
-	w.Write([]byte("ready\n"))
+	_, _ = w.Write([]byte("promoted\n"))
 }
 
 func (o *Operator) reloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -139,13 +242,179 @@ func (o *Operator) safelyPauseProdHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (o *Operator) restoreHandler(w http.ResponseWriter, r *http.Request) {
-	params := getRequestParams(r, "backupName", "backupTag", "forceVerify")
+	params := getRequestParams(r, "backupName", "backupTag", "forceVerify", "components", "dry_run")
+	if params["dry_run"] == "true" {
+		o.restoreDryRunHandler(params, w)
+		return
+	}
 	o.triggerWebCommand("restore", params, w, r)
 }
 
+// listBackupsHandler triggers a listing like any other command, with
+// `format=json` it waits for it and returns the backups as JSON.
 func (o *Operator) listBackupsHandler(w http.ResponseWriter, r *http.Request) {
-	params := getRequestParams(r, "offset", "limit")
-	o.triggerWebCommand("list", params, w, r)
+	params := getRequestParams(r, "offset", "limit", "name")
+	if r.FormValue("format") != "json" {
+		o.triggerWebCommand("list", params, w, r)
+		return
+	}
+
+	c := &Command{cmd: "list", params: params, logger: o.zlogger}
+	if err := o.sendCommandAndWait(c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: list failed: %s \n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.result)
+}
+
+// pinBackupHandler pins (`POST`) or unpins (`DELETE`) backup `backupName`
+func (o *Operator) pinBackupHandler(w http.ResponseWriter, r *http.Request) {
+	cmdName := "pin"
+	if r.Method == "DELETE" {
+		cmdName = "unpin"
+	}
+
+	c := &Command{cmd: cmdName, params: getRequestParams(r, "backupName", "name"), logger: o.zlogger}
+	o.sendCommandSync(c, w)
+}
+
+func (o *Operator) scheduleHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.scheduleStatuses())
+}
+
+func (o *Operator) scheduleStatuses() []*ScheduleStatus {
+	statuses := make([]*ScheduleStatus, len(o.backupSchedules))
+	for i, sched := range o.backupSchedules {
+		statuses[i] = sched.Status()
+		if run := o.LastRun(sched.BackuperName); run != nil {
+			completed := run.Time
+			statuses[i].LastCompleted = &completed
+			statuses[i].LastCompletedBlock = run.BlockNum
+			statuses[i].LastBackupName = run.BackupName
+		}
+	}
+	return statuses
+}
+
+func (o *Operator) backupManifestHandler(w http.ResponseWriter, r *http.Request) {
+	if o.options.BackupManifestStore == nil {
+		http.Error(w, "backup manifests are not enabled", http.StatusNotFound)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	manifest, err := o.loadBackupManifest(r.Context(), name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to load backup manifest: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if manifest == nil {
+		http.Error(w, fmt.Sprintf("no manifest for backup %q", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(manifest)
+}
+
+// operationStatusHandler does not go through the command queue, which is
+// blocked by the operation in progress.
+func (o *Operator) operationStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.OperationStatus())
+}
+
+func (o *Operator) lastResultsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.LastResults())
+}
+
+// logsHandler serves the last log entries as JSON, or as text with `format=text`,
+// `level` filters out the entries below it (defaults to info).
+func (o *Operator) logsHandler(w http.ResponseWriter, r *http.Request) {
+	minLevel, err := requestLogLevel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeLogEntries(w, r, o.logRingBuffer.Entries(minLevel))
+}
+
+// operationLogsHandler serves the buffered log entries of one operation (its
+// id is in `/v1/operation_status` and `/v1/last_results`), like `logsHandler`.
+func (o *Operator) operationLogsHandler(w http.ResponseWriter, r *http.Request) {
+	minLevel, err := requestLogLevel(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	entries := o.logRingBuffer.OperationEntries(id, minLevel)
+	if len(entries) == 0 {
+		http.Error(w, fmt.Sprintf("no buffered log entries for operation %q", id), http.StatusNotFound)
+		return
+	}
+
+	writeLogEntries(w, r, entries)
+}
+
+func requestLogLevel(r *http.Request) (zapcore.Level, error) {
+	minLevel := zapcore.InfoLevel
+	if level := r.FormValue("level"); level != "" {
+		if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+			return minLevel, fmt.Errorf("invalid level %q", level)
+		}
+	}
+	return minLevel, nil
+}
+
+func writeLogEntries(w http.ResponseWriter, r *http.Request, entries []*LogEntry) {
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, entry := range entries {
+			fields, _ := json.Marshal(entry.Fields)
+			_, _ = fmt.Fprintf(w, "%s %s %s %s %s\n", entry.Time.Format(time.RFC3339Nano), entry.Level.CapitalString(), entry.Logger, entry.Message, fields)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// SetDisabledEndpoints keeps the routes whose path template is one of `paths`
+// (like `/v1/restore` or `/v1/backup_manifest/{name:.+}`) from being served,
+// including those added by the `HTTPOption`s, requests to them get a 404. It
+// must be called before `Launch`.
+func (o *Operator) SetDisabledEndpoints(paths []string) {
+	o.disabledEndpoints = make(map[string]bool, len(paths))
+	for _, path := range paths {
+		o.disabledEndpoints[path] = true
+	}
+}
+
+// ReadinessPathOption serves the readiness check on `path` too, the legacy
+// `DefaultReadinessPath` is kept for compatibility.
+func (o *Operator) ReadinessPathOption(path string) HTTPOption {
+	return func(r *mux.Router) {
+		if path != "" && path != DefaultReadinessPath {
+			r.HandleFunc(path, o.healthzHandler).Methods("GET")
+		}
+	}
+}
+
+// WithLogLevelHandler serves `level` on `/v1/log_level`, `GET` returns it and
+// `PUT` with `{"level":"debug"}` changes it without restarting.
+func WithLogLevelHandler(level zap.AtomicLevel) HTTPOption {
+	return func(r *mux.Router) {
+		r.Handle("/v1/log_level", level).Methods("GET", "PUT")
+	}
 }
 
 func getRequestParams(r *http.Request, terms ...string) map[string]string {
@@ -163,6 +432,46 @@ func (o *Operator) backupHandler(w http.ResponseWriter, r *http.Request) {
 	o.triggerWebCommand("backup", nil, w, r)
 }
 
+// volumeSnapshotHandler always runs synchronously since the caller
+// is interested in the resulting provider snapshot id.
+func (o *Operator) volumeSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	params := getRequestParams(r, "tag")
+	params["name"] = VolumeSnapshotModuleName
+
+	c := &Command{cmd: "backup", params: params, logger: o.zlogger}
+	if err := o.sendCommandAndWait(c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: volume snapshot failed: %s \n", err)))
+		return
+	}
+
+	resp := map[string]interface{}{}
+	if res, ok := c.result.(*backupResult); ok {
+		resp["snapshot_id"] = res.Name
+		resp["block_num"] = res.BlockNum
+		if res.Tag != "" {
+			resp["tag"] = res.Tag
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// chainSnapshotHandler runs the module registered under `ChainSnapshotModuleName`
+// synchronously, answering with the snapshot's name and the block it was taken at.
+func (o *Operator) chainSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	c := &Command{cmd: "backup", params: map[string]string{"name": ChainSnapshotModuleName}, logger: o.zlogger}
+	if err := o.sendCommandAndWait(c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: chain snapshot failed: %s \n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.result)
+}
+
 func (o *Operator) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
 	o.triggerWebCommand("maintenance", nil, w, r)
 }
@@ -197,11 +506,15 @@ func (o *Operator) sendCommandAsync(c *Command, w http.ResponseWriter) {
 	_, _ = w.Write([]byte(fmt.Sprintf("%s command submitted\n", c.cmd)))
 }
 
-func (o *Operator) sendCommandSync(c *Command, w http.ResponseWriter) {
+func (o *Operator) sendCommandAndWait(c *Command) error {
 	o.zlogger.Info("sending sync command to operator through channel", zap.Object("command", c))
 	c.returnch = make(chan error)
 	o.commandChan <- c
-	err := <-c.returnch
+	return <-c.returnch
+}
+
+func (o *Operator) sendCommandSync(c *Command, w http.ResponseWriter) {
+	err := o.sendCommandAndWait(c)
 	if err == nil {
 		w.Write([]byte(fmt.Sprintf("Success: %s completed\n", c.cmd)))
 	} else {
@@ -0,0 +1,160 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// operationIDField tags the log entries of an operation, written through the
+// logger returned by `beginOperation`
+const operationIDField = "operation_id"
+
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   zapcore.Level          `json:"level"`
+	Logger  string                 `json:"logger,omitempty"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogRingBuffer keeps the last log entries (info and above) in memory, see
+// `Tee` to make a logger feed it.
+type LogRingBuffer struct {
+	lock    sync.Mutex
+	entries []*LogEntry
+	next    int
+	full    bool
+}
+
+func NewLogRingBuffer(size int) *LogRingBuffer {
+	return &LogRingBuffer{entries: make([]*LogEntry, size)}
+}
+
+// Tee returns a logger writing to both `logger` and the ring buffer.
+func (b *LogRingBuffer) Tee(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &logRingBufferCore{buffer: b})
+	}))
+}
+
+// Entries returns the buffered entries at or above `minLevel`, oldest first.
+func (b *LogRingBuffer) Entries(minLevel zapcore.Level) []*LogEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.filter(func(entry *LogEntry) bool {
+		return entry.Level >= minLevel
+	})
+}
+
+// OperationEntries returns the buffered entries at or above `minLevel`
+// written during the operation `operationID`, oldest first.
+func (b *LogRingBuffer) OperationEntries(operationID string, minLevel zapcore.Level) []*LogEntry {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.filter(func(entry *LogEntry) bool {
+		return entry.Level >= minLevel && entry.Fields[operationIDField] == operationID
+	})
+}
+
+func (b *LogRingBuffer) filter(keep func(entry *LogEntry) bool) []*LogEntry {
+	ordered := b.entries[:b.next]
+	if b.full {
+		ordered = append(append([]*LogEntry{}, b.entries[b.next:]...), b.entries[:b.next]...)
+	}
+
+	out := make([]*LogEntry, 0, len(ordered))
+	for _, entry := range ordered {
+		if keep(entry) {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func (b *LogRingBuffer) add(entry *LogEntry) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.entries) == 0 {
+		return
+	}
+
+	b.entries[b.next] = entry
+	b.next++
+	if b.next == len(b.entries) {
+		b.next = 0
+		b.full = true
+	}
+}
+
+type logRingBufferCore struct {
+	buffer *LogRingBuffer
+	fields []zapcore.Field
+}
+
+func (c *logRingBufferCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.InfoLevel
+}
+
+func (c *logRingBufferCore) With(fields []zapcore.Field) zapcore.Core {
+	return &logRingBufferCore{
+		buffer: c.buffer,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *logRingBufferCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *logRingBufferCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(encoder)
+	}
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	c.buffer.add(&LogEntry{
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+		Fields:  encoder.Fields,
+	})
+	return nil
+}
+
+func (c *logRingBufferCore) Sync() error {
+	return nil
+}
+
+// SetLogRingBuffer makes the operator log to `buffer` as well, and serve its
+// content on `GET /v1/logs`. It must be called before `Launch`.
+func (o *Operator) SetLogRingBuffer(buffer *LogRingBuffer) {
+	o.zlogger = buffer.Tee(o.zlogger)
+	o.logRingBuffer = buffer
+}
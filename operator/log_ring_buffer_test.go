@@ -0,0 +1,108 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogRingBuffer(t *testing.T) {
+	buffer := NewLogRingBuffer(3)
+	logger := buffer.Tee(zap.NewNop()).With(zap.String("component", "test"))
+
+	logger.Debug("skipped")
+	logger.Info("one")
+	logger.Warn("two", zap.Int("count", 2))
+	logger.Info("three")
+	logger.Error("four")
+
+	entries := buffer.Entries(zapcore.InfoLevel)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "two", entries[0].Message)
+	assert.Equal(t, map[string]interface{}{"component": "test", "count": int64(2)}, entries[0].Fields)
+	assert.Equal(t, "three", entries[1].Message)
+	assert.Equal(t, "four", entries[2].Message)
+
+	entries = buffer.Entries(zapcore.WarnLevel)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "two", entries[0].Message)
+	assert.Equal(t, "four", entries[1].Message)
+}
+
+type testLoggingBackupModule struct {
+	testBackupModule
+	logger      *zap.Logger
+	otherLogger *zap.Logger // of a goroutine running concurrently with the operation
+}
+
+func (m *testLoggingBackupModule) SetOperationLogger(logger *zap.Logger) { m.logger = logger }
+func (m *testLoggingBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	m.logger.Info("backing up from the module")
+	m.otherLogger.Info("concurrent")
+	return m.testBackupModule.Backup(lastSeenBlockNum)
+}
+
+func TestOperator_OperationLogs(t *testing.T) {
+	o, _ := newTestOperator(t)
+	o.SetLogRingBuffer(NewLogRingBuffer(100))
+	module := &testLoggingBackupModule{testBackupModule: testBackupModule{name: "logging"}, logger: zap.NewNop(), otherLogger: o.zlogger}
+	require.NoError(t, o.RegisterBackupModule("logging", module))
+
+	o.zlogger.Info("before")
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": "logging"}, logger: o.zlogger}))
+	o.zlogger.Info("after")
+	assert.Nil(t, module.logger, "module logger reset once the operation is done")
+
+	results := o.LastResults()
+	require.Len(t, results, 1)
+	id := results[0].OperationID
+	require.NotEmpty(t, id)
+
+	entries := o.logRingBuffer.OperationEntries(id, zapcore.InfoLevel)
+	require.NotEmpty(t, entries)
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(t, messages, "backing up from the module")
+	assert.NotContains(t, messages, "concurrent")
+	assert.NotContains(t, messages, "before")
+	assert.NotContains(t, messages, "after")
+	assert.Equal(t, "Completed backup", entries[len(entries)-1].Message)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/operation/{id}/logs", o.operationLogsHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/operation/"+id+"/logs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var served []*LogEntry
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&served))
+	assert.Len(t, served, len(entries))
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/operation/unknown/logs", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
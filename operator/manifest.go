@@ -0,0 +1,453 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"go.uber.org/zap"
+)
+
+// BackupManifest describes the content and provenance of a backup, it is
+// written to `Options.BackupManifestStore` under the backup's name.
+type BackupManifest struct {
+	BackupName         string            `json:"backup_name"`
+	BackuperName       string            `json:"backuper_name,omitempty"`
+	BlockNum           uint64            `json:"block_num"`
+	Timestamp          time.Time         `json:"timestamp"`
+	Hostname           string            `json:"hostname"`
+	NodeManagerVersion string            `json:"node_manager_version"`
+	ChainVersion       string            `json:"chain_version,omitempty"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	Files              []*ManifestFile   `json:"files,omitempty"`
+	ExcludePatterns    []string          `json:"exclude_patterns,omitempty"` // files matching them were intentionally not backed up, see `ExcludingBackupModule`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"` // relative to the backup root, with forward slashes
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestBackupModule is implemented by modules backing up (and restoring)
+// a local directory, whose files are then listed in the backup manifest and
+// verified after a restore. `BackupFiles` returns the files backup
+// `backupName` holds, as the module uploaded them, the directory may have
+// changed since.
+type ManifestBackupModule interface {
+	BackupModule
+	BackupRoot() string
+	BackupFiles(backupName string) ([]*ManifestFile, error)
+}
+
+// ExcludingBackupModule is implemented by modules leaving out of their
+// backups the files matching some patterns (see `MatchExcludePattern`),
+// like transient files. They are recorded in the manifest.
+type ExcludingBackupModule interface {
+	ManifestBackupModule
+	BackupExcludePatterns() []string
+}
+
+// ListManifestFiles returns every regular file under `root`, sorted by path.
+func ListManifestFiles(root string) ([]*ManifestFile, error) {
+	files, _, err := ListManifestFilesExcluding(root, nil)
+	return files, err
+}
+
+// ListManifestFilesExcluding returns every regular file under `root` not
+// matching any of the `excludePatterns`, sorted by path, and the ones
+// excluded (without checksum).
+func ListManifestFilesExcluding(root string, excludePatterns []string) (files, excluded []*ManifestFile, err error) {
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && MatchExcludePattern(excludePatterns, filepath.ToSlash(rel)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			if info.Mode().IsRegular() {
+				excluded = append(excluded, &ManifestFile{Path: filepath.ToSlash(rel), Size: info.Size()})
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		checksum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &ManifestFile{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: checksum})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to list files of %q: %w", root, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, excluded, nil
+}
+
+// MatchExcludePattern tells whether the `rel` path (with forward slashes)
+// matches one of the glob `patterns` (see `path.Match`), a pattern without
+// slash matches base names at any depth, like `*.sock`. A matching directory
+// is excluded with everything below it.
+func MatchExcludePattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks that the files under `root` are exactly the ones of the
+// manifest, with the same size and checksum. Any other file, unless matching
+// the manifest's exclude patterns, fails the verification.
+func (m *BackupManifest) Verify(root string) error {
+	expected := make(map[string]bool, len(m.Files))
+	for _, file := range m.Files {
+		expected[file.Path] = true
+
+		path := filepath.Join(root, filepath.FromSlash(file.Path))
+		info, err := os.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("file %q of backup %q: %w", file.Path, m.BackupName, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("file %q of backup %q is not a regular file", file.Path, m.BackupName)
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("file %q of backup %q has size %d, expected %d", file.Path, m.BackupName, info.Size(), file.Size)
+		}
+
+		checksum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if checksum != file.SHA256 {
+			return fmt.Errorf("file %q of backup %q has checksum %s, expected %s", file.Path, m.BackupName, checksum, file.SHA256)
+		}
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel == "." {
+			return nil
+		}
+		if MatchExcludePattern(m.ExcludePatterns, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && !expected[rel] {
+			return fmt.Errorf("file %q is not part of backup %q", rel, m.BackupName)
+		}
+		return nil
+	})
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to checksum %q: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeBackupManifest never fails the backup, which is already done at this point
+func (o *Operator) writeBackupManifest(mod BackupModule, backuperName, backupName string, blockNum uint64, labels map[string]string) {
+	if o.options.BackupManifestStore == nil {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	manifest := &BackupManifest{
+		BackupName:         backupName,
+		BackuperName:       backuperName,
+		BlockNum:           blockNum,
+		Timestamp:          time.Now().UTC(),
+		Hostname:           hostname,
+		NodeManagerVersion: nodeManager.Version,
+		Labels:             labels,
+	}
+
+	if versioned, ok := o.Superviser.(nodeManager.VersionedChainSuperviser); ok {
+		version, err := versioned.ChainVersion()
+		if err != nil {
+			o.zlogger.Warn("unable to retrieve chain version for backup manifest", zap.Error(err))
+		}
+		manifest.ChainVersion = version
+	}
+
+	if manifestable, ok := mod.(ManifestBackupModule); ok {
+		if excluding, ok := mod.(ExcludingBackupModule); ok {
+			manifest.ExcludePatterns = excluding.BackupExcludePatterns()
+		}
+		files, err := manifestable.BackupFiles(backupName)
+		if err != nil {
+			o.zlogger.Error("unable to list backup files, manifest will not list them", zap.String("backup_name", backupName), zap.Error(err))
+		}
+		manifest.Files = files
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		o.zlogger.Error("unable to marshal backup manifest", zap.String("backup_name", backupName), zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	if err := o.options.BackupManifestStore.WriteObject(ctx, backupName, bytes.NewReader(data)); err != nil {
+		o.zlogger.Error("unable to write backup manifest", zap.String("backup_name", backupName), zap.Error(err))
+		return
+	}
+	if o.backupSigningKey != nil {
+		if err := o.signBackupManifest(ctx, backupName, data); err != nil {
+			o.zlogger.Error("unable to sign backup manifest", zap.String("backup_name", backupName), zap.Error(err))
+			return
+		}
+	}
+
+	o.zlogger.Info("wrote backup manifest", zap.String("backup_name", backupName), zap.Int("file_count", len(manifest.Files)), zap.Bool("signed", o.backupSigningKey != nil))
+}
+
+// loadBackupManifest returns nil when there is no manifest for that backup
+func (o *Operator) loadBackupManifest(ctx context.Context, backupName string) (*BackupManifest, error) {
+	if o.options.BackupManifestStore == nil {
+		return nil, fmt.Errorf("no backup manifest store configured")
+	}
+
+	exists, err := o.options.BackupManifestStore.FileExists(ctx, backupName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check for manifest of backup %q: %w", backupName, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	reader, err := o.options.BackupManifestStore.OpenObject(ctx, backupName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open manifest of backup %q: %w", backupName, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest of backup %q: %w", backupName, err)
+	}
+
+	if o.backupVerifyKey != nil {
+		err := o.verifyBackupManifestSignature(ctx, backupName, data)
+		switch {
+		case err == errUnsignedBackup && o.requireSignedBackups:
+			return nil, fmt.Errorf("refusing backup %q, its manifest is not signed and signed backups are required", backupName)
+		case err == errUnsignedBackup:
+			o.zlogger.Warn("backup manifest is not signed", zap.String("backup_name", backupName))
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest for backup %q: %w", backupName, err)
+	}
+	return manifest, nil
+}
+
+// restoreStagingDir is where `restoreVerified` restores a backup, under the
+// module's backup root since it may be a mount point
+const restoreStagingDir = ".restore-staging"
+
+// canRestoreVerified tells whether backups restored by `mod` can be verified
+// against their manifest before being put in place, see `restoreVerified`
+func (o *Operator) canRestoreVerified(mod RestorableBackupModule) bool {
+	_, dirRestorable := mod.(DirRestorableBackupModule)
+	_, manifestable := mod.(ManifestBackupModule)
+	return dirRestorable && manifestable && o.options.BackupManifestStore != nil
+}
+
+// restoreVerified restores the backup into a staging directory and verifies
+// it matches its manifest exactly before replacing the content of the
+// module's backup root with it, which is left untouched if anything fails.
+// It requires room for both the current data and the backup. It returns the
+// name of the backup restored, `latest` being resolved.
+func (o *Operator) restoreVerified(mod RestorableBackupModule, backupName string) (string, error) {
+	root := mod.(ManifestBackupModule).BackupRoot()
+	staging := filepath.Join(root, restoreStagingDir)
+	if err := os.RemoveAll(staging); err != nil { // left over by an interrupted restore
+		return "", fmt.Errorf("unable to clear restore staging directory: %w", err)
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return "", fmt.Errorf("unable to create restore staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	o.zlogger.Info("restoring backup into staging directory", zap.String("backup_name", backupName), zap.String("dir", staging))
+	restoredName, err := mod.(DirRestorableBackupModule).RestoreTo(backupName, staging)
+	if err != nil {
+		return backupName, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	manifest, err := o.loadBackupManifest(ctx, restoredName)
+	if err != nil {
+		return restoredName, err
+	}
+	if manifest == nil {
+		o.zlogger.Info("no manifest found for restored backup, skipping verification", zap.String("backup_name", restoredName))
+	} else {
+		if err := manifest.Verify(staging); err != nil {
+			return restoredName, fmt.Errorf("restored backup does not match its manifest, leaving the current data in place: %w", err)
+		}
+		o.zlogger.Info("restored backup matches its manifest", zap.String("backup_name", restoredName), zap.Int("file_count", len(manifest.Files)), zap.Strings("intentionally_absent", manifest.ExcludePatterns))
+	}
+
+	return restoredName, swapInStagingDir(root, staging)
+}
+
+// swapInStagingDir replaces everything under `root` with the content of
+// `staging`, one of its sub-directories
+func swapInStagingDir(root, staging string) error {
+	current, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range current {
+		if entry.Name() == filepath.Base(staging) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			return fmt.Errorf("unable to clear %q: %w", entry.Name(), err)
+		}
+	}
+
+	restored, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, entry := range restored {
+		if err := os.Rename(filepath.Join(staging, entry.Name()), filepath.Join(root, entry.Name())); err != nil {
+			return fmt.Errorf("unable to move restored %q in place: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// verifyRestoredBackup checks the restored files against the backup's
+// manifest, when both the module and the store allow it, for modules unable
+// to restore into a staging directory (see `restoreVerified`).
+func (o *Operator) verifyRestoredBackup(mod BackupModule, backupName string) error {
+	manifestable, ok := mod.(ManifestBackupModule)
+	if !ok || o.options.BackupManifestStore == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	manifest, err := o.loadBackupManifest(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		o.zlogger.Info("no manifest found for restored backup, skipping verification", zap.String("backup_name", backupName))
+		return nil
+	}
+
+	if err := manifest.Verify(manifestable.BackupRoot()); err != nil {
+		return fmt.Errorf("restored backup does not match its manifest: %w", err)
+	}
+
+	o.zlogger.Info("restored backup matches its manifest", zap.String("backup_name", backupName), zap.Int("file_count", len(manifest.Files)), zap.Strings("intentionally_absent", manifest.ExcludePatterns))
+	return nil
+}
+
+// verifyRestoredBlocksLog checks the restored blocks log against the block the
+// backup was taken at, see `Options.VerifyRestoredBlocksLog`.
+func (o *Operator) verifyRestoredBlocksLog(backupName string) error {
+	if !o.options.VerifyRestoredBlocksLog {
+		return nil
+	}
+
+	lastBlockNum, err := o.Superviser.(nodeManager.BlocksLogVerifierChainSuperviser).VerifyBlocksLog()
+	if err != nil {
+		return fmt.Errorf("restored blocks log is invalid: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	manifest, err := o.loadBackupManifest(ctx, backupName)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		o.zlogger.Warn("no manifest found for restored backup, cannot check where its blocks log should end", zap.String("backup_name", backupName), zap.Uint64("last_block_num", lastBlockNum))
+		return nil
+	}
+
+	if lastBlockNum > manifest.BlockNum || manifest.BlockNum-lastBlockNum > o.options.RestoredBlocksLogMaxLag {
+		return fmt.Errorf("restored blocks log ends at block %d, backup %q was taken at block %d (with up to %d blocks not logged yet)", lastBlockNum, backupName, manifest.BlockNum, o.options.RestoredBlocksLogMaxLag)
+	}
+
+	o.zlogger.Info("restored blocks log ends where expected", zap.String("backup_name", backupName), zap.Uint64("last_block_num", lastBlockNum), zap.Uint64("backup_block_num", manifest.BlockNum))
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/metrics"
+)
+
+const nodeUptimeReportInterval = 5 * time.Second
+
+// NodeStatus describes the node process, served on `/v1/node_status`.
+type NodeStatus struct {
+	Running       bool       `json:"running"`
+	PID           int        `json:"pid,omitempty"` // for supervisers able to tell it, see `PIDChainSuperviser`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	UptimeSeconds float64    `json:"uptime_seconds"`
+	RestartCount  uint64     `json:"restart_count"` // starts after the first one, since the operator launched
+}
+
+// NodeStatus returns the state of the node process.
+func (o *Operator) NodeStatus() *NodeStatus {
+	status := &NodeStatus{
+		Running:       o.Superviser.IsRunning(),
+		UptimeSeconds: o.uptime().Seconds(),
+	}
+	if starts := o.nodeStarts.Load(); starts > 1 {
+		status.RestartCount = starts - 1
+	}
+
+	if status.Running {
+		if startedAt := o.startedAt.Load(); startedAt != 0 {
+			t := time.Unix(0, startedAt)
+			status.StartedAt = &t
+		}
+		if reporter, ok := o.Superviser.(nodeManager.PIDChainSuperviser); ok {
+			status.PID = reporter.PID()
+		}
+	}
+	return status
+}
+
+// recordNodeStart counts the starts of the node, all but the first one being
+// restarts.
+func (o *Operator) recordNodeStart() {
+	if o.nodeStarts.Inc() > 1 {
+		metrics.NodeRestartCount.Inc()
+	}
+}
+
+// reportNodeUptime keeps the uptime metric of the node up to date.
+func (o *Operator) reportNodeUptime() {
+	ticker := time.NewTicker(nodeUptimeReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			metrics.NodeUptime.SetFloat64(o.uptime().Seconds())
+		}
+	}
+}
+
+func (o *Operator) nodeStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(o.NodeStatus())
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type EventType string
+
+const (
+	EventNodeStarted          EventType = "node_started"
+	EventNodeCrashed          EventType = "node_crashed"
+	EventBackupStarted        EventType = "backup_started"
+	EventBackupCompleted      EventType = "backup_completed"
+	EventBackupFailed         EventType = "backup_failed"
+	EventBackupAuditFailed    EventType = "backup_audit_failed"
+	EventBackupStoreFull      EventType = "backup_store_full" // high severity, backups keep failing until space is freed
+	EventChainIDMismatch      EventType = "chain_id_mismatch"
+	EventContinuityGap        EventType = "continuity_gap" // not emitted by the operator, see `Notify`
+	EventDiskLow              EventType = "disk_low"       // not emitted by the operator, reserved for disk monitoring modules
+	EventBlockProgressStalled EventType = "block_progress_stalled"
+)
+
+// EventCategory groups event types by who should hear about them, see
+// `RoutingNotifier`.
+type EventCategory string
+
+const (
+	CategoryNode   EventCategory = "node"   // the node process itself
+	CategoryBackup EventCategory = "backup" // backups, snapshots and their stores
+	CategoryChain  EventCategory = "chain"  // the chain data the node serves
+	CategoryDisk   EventCategory = "disk"
+)
+
+// Category of the event type, `CategoryNode` for unknown ones.
+func (t EventType) Category() EventCategory {
+	switch t {
+	case EventBackupStarted, EventBackupCompleted, EventBackupFailed, EventBackupAuditFailed, EventBackupStoreFull:
+		return CategoryBackup
+	case EventChainIDMismatch, EventContinuityGap, EventBlockProgressStalled:
+		return CategoryChain
+	case EventDiskLow:
+		return CategoryDisk
+	default:
+		return CategoryNode
+	}
+}
+
+// EventCategories are all the categories events are in
+var EventCategories = []EventCategory{CategoryNode, CategoryBackup, CategoryChain, CategoryDisk}
+
+type Event struct {
+	Type     EventType         `json:"type"`
+	Category EventCategory     `json:"category"`
+	Time     time.Time         `json:"time"`
+	Message  string            `json:"message,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// Notifier receives the operator's lifecycle events, it is how alerting
+// (Slack, PagerDuty, ...) gets plugged in.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+type NoopNotifier struct{}
+
+func (NoopNotifier) Notify(_ context.Context, _ Event) error { return nil }
+
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, event Event) error {
+	n.logger.Info("operator event",
+		zap.String("type", string(event.Type)),
+		zap.Time("time", event.Time),
+		zap.String("message", event.Message),
+		zap.Reflect("fields", event.Fields),
+	)
+	return nil
+}
+
+// WebhookNotifier POSTs each event as JSON to `URL`.
+type WebhookNotifier struct {
+	URL    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, client: http.DefaultClient}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("unable to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RoutingNotifier sends each event to the notifier of its category, or to
+// `Default` (if set) when its category has none.
+type RoutingNotifier struct {
+	Routes  map[EventCategory]Notifier
+	Default Notifier
+}
+
+func (n *RoutingNotifier) Notify(ctx context.Context, event Event) error {
+	if notifier, ok := n.Routes[event.Category]; ok {
+		return notifier.Notify(ctx, event)
+	}
+	if n.Default != nil {
+		return n.Default.Notify(ctx, event)
+	}
+	return nil
+}
+
+const notificationQueueSize = 100
+const notificationTimeout = 10 * time.Second
+
+// SetNotifier makes the operator send its lifecycle events to `notifier`.
+// Events are queued and delivered in the background, one at a time, each
+// bounded by a timeout; they are dropped when the queue is full.
+func (o *Operator) SetNotifier(notifier Notifier) {
+	events := make(chan Event, notificationQueueSize)
+	o.notifications = events
+
+	go func() {
+		for {
+			select {
+			case <-o.Terminated():
+				return
+			case event := <-events:
+				ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+				if err := notifier.Notify(ctx, event); err != nil {
+					o.zlogger.Warn("unable to deliver notification", zap.String("type", string(event.Type)), zap.Error(err))
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// Notify sends an event through the operator's notifier and to the `/v1/events`
+// subscribers, for the events detected outside of the operator. It never blocks.
+func (o *Operator) Notify(eventType EventType, message string, fields map[string]string) {
+	o.notify(eventType, message, fields)
+}
+
+// notify never blocks
+func (o *Operator) notify(eventType EventType, message string, fields map[string]string) {
+	event := Event{Type: eventType, Category: eventType.Category(), Time: time.Now(), Message: message, Fields: fields}
+	o.events.publish(event)
+
+	if o.notifications == nil {
+		return
+	}
+
+	select {
+	case o.notifications <- event:
+	default:
+		o.zlogger.Warn("notification queue is full, dropping event", zap.String("type", string(eventType)))
+	}
+}
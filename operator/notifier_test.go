@@ -0,0 +1,57 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRecordingNotifier struct {
+	events []Event
+}
+
+func (n *testRecordingNotifier) Notify(_ context.Context, event Event) error {
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestRoutingNotifier(t *testing.T) {
+	backup := &testRecordingNotifier{}
+	fallback := &testRecordingNotifier{}
+	notifier := &RoutingNotifier{
+		Routes:  map[EventCategory]Notifier{CategoryBackup: backup},
+		Default: fallback,
+	}
+
+	for _, eventType := range []EventType{EventBackupFailed, EventNodeCrashed, EventBackupStoreFull, EventContinuityGap} {
+		require.NoError(t, notifier.Notify(context.Background(), Event{Type: eventType, Category: eventType.Category()}))
+	}
+
+	assert.Len(t, backup.events, 2)
+	assert.Equal(t, EventBackupFailed, backup.events[0].Type)
+	assert.Equal(t, EventBackupStoreFull, backup.events[1].Type)
+
+	require.Len(t, fallback.events, 2)
+	assert.Equal(t, CategoryNode, fallback.events[0].Category)
+	assert.Equal(t, CategoryChain, fallback.events[1].Category)
+
+	// without a default, the other categories are dropped
+	notifier.Default = nil
+	assert.NoError(t, notifier.Notify(context.Background(), Event{Type: EventNodeCrashed, Category: CategoryNode}))
+}
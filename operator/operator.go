@@ -16,9 +16,13 @@ package operator
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +30,7 @@ import (
 	"github.com/dfuse-io/derr"
 	"github.com/dfuse-io/dstore"
 	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/dfuse-io/shutter"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
@@ -46,14 +51,87 @@ type Operator struct {
 	Superviser     nodeManager.ChainSuperviser
 	chainReadiness nodeManager.Readiness
 	aboutToStop    *atomic.Bool
+	startedAt      *atomic.Int64 // unix nanoseconds of the last successful start of the chain
+	nodeStarts     *atomic.Uint64
+	standby        *atomic.Bool
+	lastProgress   *atomic.Int64 // unix nanoseconds of the last iteration of the main loop
+	readySince     *atomic.Int64 // unix nanoseconds of the last transition to ready, 0 while not ready
 	snapshotStore  dstore.Store
+	stagger        *operationStagger
 	zlogger        *zap.Logger
+
+	backupPrefix         *atomic.String // set once the chain id it may depend on is known
+	backupPrefixResolved bool
+
+	chainIDAppliers      []func(chainID string) // waiting for the chain id, see `whenChainIDKnown`
+	backupChainIDPending *atomic.Bool           // backups are refused while the chain id they depend on is unknown
+
+	notifications chan Event
+	events        *eventBroadcaster // `/v1/events` subscribers
+
+	logRingBuffer *LogRingBuffer
+
+	disabledEndpoints map[string]bool // path templates not served, see `SetDisabledEndpoints`
+
+	beforeBlocksLogRotation func() error                       // see `SetBeforeBlocksLogRotation`
+	readinessFunc           func() (ready bool, reason string) // see `SetReadinessFunc`
+
+	backupSigningKey     ed25519.PrivateKey // see `ConfigureBackupSigning`
+	backupVerifyKey      ed25519.PublicKey
+	requireSignedBackups bool
+
+	operationLock     sync.Mutex
+	operation         *OperationStatus // nil when idle
+	operationLoggedAt time.Time
+	operationCancel   context.CancelFunc // set while the operation in progress can be aborted, see `Abort`
+	operationAborted  bool
+	operationModule   LoggingBackupModule          // logging through the operation's logger, see `beginOperation`
+	globalLockWaits   map[*Command]*globalLockWait // backups waiting for a global operation lock slot, also abortable
+
+	crashLoop *crashLoopLimiter // nil unless `MaxRestartsInWindow` is set
+
+	diagnosticsDir    string // see `ConfigureCrashDiagnostics`
+	uploadDiagnostics bool
+
+	blockProgress *blockProgressMonitor // nil unless configured, see `ConfigureBlockProgressMonitor`
+
+	blackoutWindows []*BlackoutWindow
+
+	chainIDLock sync.Mutex
+	chainID     string // cached once fetched from the superviser
+
+	lastRunsLock sync.Mutex
+	lastRuns     map[string]*OperationRun // keyed by backup module name
+
+	snapshotsSincePromotion int // see `Options.PromoteEveryNthSnapshotToBackup`
+
+	lastShutdownReason  *ShutdownReason // left by the previous run, see `Options.ShutdownReasonFile`
+	nodeStoppedShutdown *atomic.Bool    // shutting down because the node stopped unexpectedly
+
+	lastResultsLock sync.Mutex
+	lastResults     map[string]*OperationResult // keyed by operation and module, see `LastResults`
+
+	describeLock     sync.Mutex
+	describeConfig   interface{}
+	describeSections map[string]func() interface{}
 }
 
 type Bootstrapper interface {
 	Bootstrap() error
 }
 
+// CleanShutdownChecker inspects the chain's state files after it was stopped
+// to tell whether it shut down cleanly.
+type CleanShutdownChecker interface {
+	IsCleanShutdown() (bool, error)
+}
+
+// NodePhaseReporter tells whether the node is live, as opposed to still
+// starting up (like when replaying), see `Options.NodePhase`.
+type NodePhaseReporter interface {
+	IsLive() bool
+}
+
 type Options struct {
 	Bootstrapper Bootstrapper
 
@@ -61,6 +139,108 @@ type Options struct {
 
 	// Delay before sending Stop() to superviser, during which we return NotReady
 	ShutdownDelay time.Duration
+
+	// Minimum delay between two scheduled operations, the one with the lowest
+	// priority is deferred when two of them are due within that window (0 disables it)
+	OperationStaggerWindow time.Duration
+	// Backup module names from highest to lowest priority, defaults to `DefaultOperationPriority`
+	OperationPriority []string
+
+	// If set, checked after stopping the chain for a backup, backups taken after a dirty shutdown are labelled `dirty`
+	CleanShutdownMarkerCheck CleanShutdownChecker
+
+	// Prefix of the backup names, `{chain_id}` is replaced by the chain's id. When empty, the chain
+	// id is used if known (names are left unprefixed, with a warning, if it cannot be determined)
+	BackupPrefix string
+
+	// Overrides the chain id otherwise fetched from the superviser (see `ChainIDChainSuperviser`), for offline setups
+	ChainID string
+	// For how long fetching the chain id from the superviser is retried while the node starts, defaults to 1 minute
+	ChainIDDetectionTimeout time.Duration
+	// If set, the chain id is verified after each (re)start once the node answers, the operator shuts down on mismatch
+	ExpectedChainID string
+
+	// Scheduled backups are skipped until the chain has been running for at least that long since its last (re)start
+	MinUptimeBeforeBackup time.Duration
+
+	// In standby, the chain runs and syncs but scheduled backups are skipped and the node never reports ready, until promoted
+	StandbyMode bool
+
+	// If set, a JSON manifest describing each backup is written to this store under the backup's name
+	BackupManifestStore dstore.Store
+
+	// If non-zero, a crashed node is restarted instead of shutting the operator down, unless it was already
+	// restarted that many times within `RestartWindow`
+	MaxRestartsInWindow int
+	RestartWindow       time.Duration
+	// Crashes within that long after launch are restarted without counting toward `MaxRestartsInWindow`
+	CrashLoopWarmup time.Duration
+
+	// If set, readiness fails while the node is not live yet (like when replaying), see `logplugin.NodePhaseLogPlugin`
+	NodePhase NodePhaseReporter
+
+	// `/live` fails when the main loop did not progress for that long (0 disables the check), it must
+	// be longer than the slowest operation since the loop waits for each command to complete
+	LivenessMaxStall time.Duration
+
+	// If set and the node is an active producer, operations stopping the node (backups requiring a stop,
+	// restores, reloads, maintenance) wait for the end of its production round, up to `ProducingDeferTimeout`
+	// (defaults to 3 minutes), the operation is not performed if that times out
+	DeferOperationsWhileProducing bool
+	ProducingDeferTimeout         time.Duration
+
+	// Checked at launch, a prominent warning is logged when the open files limit (RLIMIT_NOFILE) or the available
+	// memory (in bytes, capped by the container's limit) are below them, `Launch` fails instead with `FailOnLowLimits`
+	MinOpenFiles       uint64
+	MinAvailableMemory uint64
+	FailOnLowLimits    bool
+
+	// If set, the `*.lock` and `*.pid` files of `StaleLocksDir` (like the chain's data directory) that no live
+	// process owns are removed at launch and before restarting a crashed node
+	CleanStaleLocks bool
+	StaleLocksDir   string
+
+	// Daily windows during which scheduled backups and snapshots are skipped, like `22:30-01:00 America/Montreal`
+	// (see `ParseBlackoutWindow`), operations requested manually still run, with a warning
+	OperationBlackoutWindows []string
+
+	// If set, one of the `BackupAuditRecentCount` (defaults to 5) most recent backups of each module supporting
+	// it is verified, in turn, against its manifest at that interval, reads are throttled to
+	// `BackupAuditMaxBytesPerSecond` (0 does not throttle them)
+	BackupAuditInterval          time.Duration
+	BackupAuditRecentCount       int
+	BackupAuditMaxBytesPerSecond int64
+
+	// If set, every backup and snapshot first takes one of `GlobalOperationLockHolders` (defaults to 1) slots
+	// shared by the node-managers of the host given the same path, the files `<path>.<index>` are locked with
+	// `flock`. An operation waiting longer than `GlobalOperationLockTimeout` (defaults to 10 minutes) for a slot
+	// is not performed, a scheduled one runs again when it is next due. Other commands keep running meanwhile.
+	GlobalOperationLockPath    string
+	GlobalOperationLockHolders int
+	GlobalOperationLockTimeout time.Duration
+
+	// If non-zero, a snapshot (of the `snapshot`, `volume_snapshot` or `chain_snapshot` module) is skipped when
+	// fewer blocks than that have elapsed since that module's last successful run, whatever triggered it
+	MinBlocksBetweenSnapshots uint64
+
+	// If non-zero, every that many successful snapshots (of the `snapshot` module), a full backup (of the `backup`
+	// module) is taken right after, while the node is still stopped by the snapshot, instead of stopping it again
+	PromoteEveryNthSnapshotToBackup int
+
+	// If set, a full restore fails (leaving the node stopped) unless the restored blocks log is contiguous and
+	// its last block is at most `RestoredBlocksLogMaxLag` blocks below the block recorded in the backup's manifest
+	// (nodeos only logs irreversible blocks), see `nodeManager.BlocksLogVerifierChainSuperviser`
+	VerifyRestoredBlocksLog bool
+	RestoredBlocksLogMaxLag uint64
+
+	// Directory the dry-run restores (`/v1/restore?dry_run=true`) extract backups into, in a temporary
+	// directory removed once verified, defaults to the system's temporary directory
+	RestoreDryRunDir string
+
+	// If set, why the operator terminated (see `ShutdownReason`) is written to this file as it does, the one
+	// left by the previous run is served on `/v1/last_shutdown_reason`. While running, it holds an `unknown`
+	// reason, kept when the process is killed before recording one
+	ShutdownReasonFile string
 }
 
 type Command struct {
@@ -69,6 +249,14 @@ type Command struct {
 	returnch chan error
 	closer   sync.Once
 	logger   *zap.Logger
+
+	scheduled bool // sent by a backup schedule rather than requested
+
+	globalLockRelease func() // global operation lock slot acquired off the command loop, see `requeueWithGlobalOperationLock`
+
+	// result is optionally set by the command on success, it is
+	// sent back as JSON to synchronous HTTP callers
+	result interface{}
 }
 
 func (c *Command) MarshalLogObject(encoder zapcore.ObjectEncoder) error {
@@ -81,13 +269,26 @@ func New(zlogger *zap.Logger, chainSuperviser nodeManager.ChainSuperviser, chain
 	zlogger.Info("creating operator", zap.Reflect("options", options))
 
 	o := &Operator{
-		Shutter:        shutter.New(),
-		chainReadiness: chainReadiness,
-		commandChan:    make(chan *Command, 10),
-		options:        options,
-		Superviser:     chainSuperviser,
-		aboutToStop:    atomic.NewBool(false),
-		zlogger:        zlogger,
+		Shutter:              shutter.New(),
+		chainReadiness:       chainReadiness,
+		commandChan:          make(chan *Command, 10),
+		options:              options,
+		Superviser:           chainSuperviser,
+		aboutToStop:          atomic.NewBool(false),
+		startedAt:            atomic.NewInt64(0),
+		nodeStarts:           atomic.NewUint64(0),
+		standby:              atomic.NewBool(options.StandbyMode),
+		nodeStoppedShutdown:  atomic.NewBool(false),
+		lastProgress:         atomic.NewInt64(time.Now().UnixNano()),
+		readySince:           atomic.NewInt64(0),
+		backupPrefix:         atomic.NewString(""),
+		backupChainIDPending: atomic.NewBool(false),
+		stagger:              newOperationStagger(options.OperationStaggerWindow, options.OperationPriority),
+		lastRuns:             make(map[string]*OperationRun),
+		lastResults:          make(map[string]*OperationResult),
+		globalLockWaits:      make(map[*Command]*globalLockWait),
+		events:               newEventBroadcaster(zlogger),
+		zlogger:              zlogger,
 	}
 
 	chainSuperviser.OnTerminated(func(err error) {
@@ -97,6 +298,22 @@ func New(zlogger *zap.Logger, chainSuperviser nodeManager.ChainSuperviser, chain
 		}
 	})
 
+	if options.ShutdownReasonFile != "" {
+		lastReason, err := loadLastShutdownReason(options.ShutdownReasonFile)
+		if err != nil {
+			zlogger.Warn("unable to read the shutdown reason of the previous run", zap.String("shutdown_reason_file", options.ShutdownReasonFile), zap.Error(err))
+		} else if lastReason != nil {
+			zlogger.Info("previous run shut down", zap.String("kind", lastReason.Kind), zap.Time("time", lastReason.Time), zap.String("error", lastReason.Error))
+			o.lastShutdownReason = lastReason
+		}
+		if err := writeShutdownReason(options.ShutdownReasonFile, runningShutdownReason()); err != nil {
+			zlogger.Warn("unable to mark the shutdown reason as unknown while running", zap.String("shutdown_reason_file", options.ShutdownReasonFile), zap.Error(err))
+		}
+
+		// first, the process may be killed while waiting for the superviser
+		o.OnTerminating(o.recordShutdownReason)
+	}
+
 	o.OnTerminating(func(err error) {
 		//wait for supervisor to terminate, supervisor will wait for plugins to terminate
 		if !o.Superviser.IsTerminating() {
@@ -109,10 +326,83 @@ func New(zlogger *zap.Logger, chainSuperviser nodeManager.ChainSuperviser, chain
 		zlogger.Info("operator done waiting for superviser to shutdown", zap.Error(err))
 	})
 
+	if options.ExpectedChainID != "" {
+		if _, ok := chainSuperviser.(nodeManager.ChainIDChainSuperviser); !ok {
+			return nil, fmt.Errorf("expected chain id is set but the chain superviser cannot report its chain id")
+		}
+	}
+
+	if options.VerifyRestoredBlocksLog {
+		if _, ok := chainSuperviser.(nodeManager.BlocksLogVerifierChainSuperviser); !ok {
+			return nil, fmt.Errorf("restored blocks log verification is set but the chain superviser cannot verify the blocks log")
+		}
+		if options.BackupManifestStore == nil {
+			return nil, fmt.Errorf("restored blocks log verification requires a backup manifest store")
+		}
+	}
+
+	for _, spec := range options.OperationBlackoutWindows {
+		window, err := ParseBlackoutWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		o.blackoutWindows = append(o.blackoutWindows, window)
+	}
+
+	if options.StandbyMode {
+		zlogger.Info("operator starting in standby mode, scheduled backups are disabled and node will not report ready until promoted")
+	}
+
 	return o, nil
 }
 
+func (o *Operator) setLastRun(backuperName string, run *OperationRun) {
+	o.lastRunsLock.Lock()
+	defer o.lastRunsLock.Unlock()
+	o.lastRuns[backuperName] = run
+}
+
+// LastRun returns the last completed run of the backup module registered
+// under `backuperName`, nil if it never ran.
+func (o *Operator) LastRun(backuperName string) *OperationRun {
+	o.lastRunsLock.Lock()
+	defer o.lastRunsLock.Unlock()
+	return o.lastRuns[backuperName]
+}
+
+// TriggerBackup queues a backup with the module registered under
+// `backuperName`, like the HTTP API does. It does not wait for its completion.
+func (o *Operator) TriggerBackup(backuperName string) {
+	o.zlogger.Info("backup triggered", zap.String("backuper_name", backuperName))
+	o.commandChan <- &Command{cmd: "backup", logger: o.zlogger, params: map[string]string{"name": backuperName}}
+}
+
+// Maintenance stops the chain through the command queue, like
+// `POST /v1/maintenance`, and waits for it to be done.
+func (o *Operator) Maintenance() error {
+	return o.sendCommandAndWait(&Command{cmd: "maintenance", logger: o.zlogger})
+}
+
+// Promote leaves standby mode, enabling scheduled backups and readiness.
+// It returns false if the node was not in standby.
+func (o *Operator) Promote() bool {
+	if !o.standby.CAS(true, false) {
+		return false
+	}
+
+	o.zlogger.Info("promoted from standby mode, scheduled backups and readiness are now enabled")
+	return true
+}
+
 func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
+	if err := o.checkResourceLimits(); err != nil {
+		return err
+	}
+
+	if err := o.cleanStaleLocks(); err != nil {
+		return err
+	}
+
 	o.zlogger.Info("launching operator HTTP server", zap.String("http_listen_addr", httpListenAddr))
 	o.httpServer = o.RunHTTPServer(httpListenAddr, options...)
 
@@ -125,6 +415,18 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 
 	o.LaunchBackupSchedules()
 
+	if o.options.BackupAuditInterval != 0 {
+		if o.options.BackupManifestStore == nil {
+			o.zlogger.Warn("backup audits require backup manifests, they are disabled")
+		} else {
+			go o.runBackupAudits()
+		}
+	}
+
+	if producer, ok := o.Superviser.(nodeManager.ProducerChainSuperviser); ok {
+		go o.reportProducing(producer)
+	}
+
 	if o.options.Bootstrapper != nil {
 		o.zlogger.Info("Operator calling bootstrap function")
 		err := o.options.Bootstrapper.Bootstrap()
@@ -132,11 +434,33 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 			return fmt.Errorf("unable to bootstrap chain: %w", err)
 		}
 	}
+	if o.options.MaxRestartsInWindow > 0 {
+		o.crashLoop = &crashLoopLimiter{
+			maxRestarts: o.options.MaxRestartsInWindow,
+			window:      o.options.RestartWindow,
+			warmupUntil: time.Now().Add(o.options.CrashLoopWarmup),
+		}
+	}
+
 	o.commandChan <- &Command{cmd: "start", logger: o.zlogger}
 
+	heartbeat := time.NewTicker(livenessHeartbeat)
+	defer heartbeat.Stop()
+
+	go o.reportLoopStall()
+	go o.trackReadiness()
+	go o.reportNodeUptime()
+	if o.blockProgress != nil {
+		go o.monitorBlockProgress()
+	}
+
+	o.zlogger.Info("operator ready to receive commands")
 	for {
-		o.zlogger.Info("operator ready to receive commands")
+		o.lastProgress.Store(time.Now().UnixNano())
 		select {
+		case <-heartbeat.C:
+			continue
+
 		case <-o.Superviser.Stopped(): // the chain stopped outside of a command that was expecting it.
 			if o.Superviser.IsTerminating() || o.IsTerminating() { // This is the natural way of exiting this loop on global shutdown.
 				return nil
@@ -153,7 +477,14 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 			} else {
 				shutdownErr = fmt.Errorf(baseFormat, o.Superviser.GetName(), o.Superviser.LastExitCode())
 			}
+			o.notify(EventNodeCrashed, shutdownErr.Error(), map[string]string{"exit_code": strconv.Itoa(o.Superviser.LastExitCode())})
 
+			if shutdownErr = o.handleCrash(shutdownErr); shutdownErr == nil {
+				o.zlogger.Info("operator ready to receive commands")
+				continue
+			}
+
+			o.nodeStoppedShutdown.Store(true)
 			o.Shutdown(shutdownErr)
 			break
 
@@ -161,7 +492,12 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 			if cmd.cmd == "start" { // start 'sub' commands after a restore do NOT come through here
 				o.lastStartCommand = time.Now()
 			}
+			iterationStart := time.Now()
 			err := o.runCommand(cmd)
+			metrics.OperatorLoopDuration.ObserveSince(iterationStart)
+			if err == errCommandRequeued {
+				continue
+			}
 			cmd.Return(err)
 			if err != nil {
 				if err == ErrCleanExit {
@@ -169,10 +505,37 @@ func (o *Operator) Launch(httpListenAddr string, options ...HTTPOption) error {
 				}
 				return fmt.Errorf("command %v execution failed: %v", cmd.cmd, err)
 			}
+			o.zlogger.Info("operator ready to receive commands")
+		}
+	}
+}
+
+const livenessHeartbeat = 5 * time.Second
+
+// reportLoopStall keeps `node_manager_operator_loop_stall_seconds` up to date,
+// it keeps growing while a command blocks the main loop.
+func (o *Operator) reportLoopStall() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			metrics.OperatorLoopStall.SetFloat64(time.Since(time.Unix(0, o.lastProgress.Load())).Seconds())
 		}
 	}
 }
 
+// IsLive tells if the main loop progressed within `Options.LivenessMaxStall`.
+func (o *Operator) IsLive() bool {
+	if o.options.LivenessMaxStall == 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, o.lastProgress.Load())) <= o.options.LivenessMaxStall
+}
+
 func formatLogLines(lines []string) string {
 	formattedLines := make([]string, len(lines))
 	for i, line := range lines {
@@ -233,11 +596,69 @@ func (o *Operator) cleanSuperviserStop() error {
 	return err
 }
 
+// cancelOnUnexpectedStop cancels a backup running alongside the chain if the
+// chain process exits before the backup is done.
+func (o *Operator) cancelOnUnexpectedStop(ctx context.Context, cancel context.CancelFunc, crashed *atomic.Bool) {
+	stopped := o.Superviser.Stopped()
+	if stopped == nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-stopped:
+		o.zlogger.Warn("chain stopped unexpectedly during backup, aborting it", zap.Int("exit_code", o.Superviser.LastExitCode()))
+		crashed.Store(true)
+		cancel()
+	}
+}
+
+// uptime returns for how long the chain has been running since its last
+// (re)start, zero when it is not running.
+func (o *Operator) uptime() time.Duration {
+	startedAt := o.startedAt.Load()
+	if startedAt == 0 || !o.Superviser.IsRunning() {
+		return 0
+	}
+	return time.Since(time.Unix(0, startedAt))
+}
+
+// checkCleanShutdown returns false only when the configured checker positively
+// reports a dirty shutdown, a failing check is logged and considered clean.
+func (o *Operator) checkCleanShutdown() bool {
+	if o.options.CleanShutdownMarkerCheck == nil {
+		return true
+	}
+
+	clean, err := o.options.CleanShutdownMarkerCheck.IsCleanShutdown()
+	if err != nil {
+		o.zlogger.Warn("unable to verify if chain was shut down cleanly", zap.Error(err))
+		return true
+	}
+
+	if !clean {
+		o.zlogger.Warn("chain was not shut down cleanly, backup will be labelled as dirty")
+		metrics.DirtyShutdowns.Inc()
+	}
+	return clean
+}
+
 // runCommand does its work, and returns an error for irrecoverable states.
 func (o *Operator) runCommand(cmd *Command) error {
 	o.zlogger.Info("received operator command", zap.String("command", cmd.cmd), zap.Reflect("params", cmd.params))
+	if !cmd.scheduled && (cmd.cmd == "backup" || cmd.cmd == "restore") {
+		if window := o.activeBlackoutWindow(time.Now()); window != nil {
+			o.zlogger.Warn("running requested operation within an operation blackout window", zap.String("command", cmd.cmd), zap.Stringer("blackout_window", window))
+		}
+	}
+
 	switch cmd.cmd {
 	case "maintenance":
+		if err := o.deferWhileProducing(cmd.cmd); err != nil {
+			cmd.Return(err)
+			return nil
+		}
+
 		o.zlogger.Info("preparing to stop process")
 
 		if err := o.cleanSuperviserStop(); err != nil {
@@ -254,23 +675,77 @@ func (o *Operator) runCommand(cmd *Command) error {
 			return nil
 		}
 
-		o.zlogger.Info("Stopping to restore a backup")
+		if cmd.params["dry_run"] == "true" {
+			return o.restoreDryRun(cmd, restoreMod)
+		}
+
+		if restoreComponents(cmd.params) != nil {
+			if _, ok := restoreMod.(ComponentRestorableBackupModule); !ok {
+				cmd.Return(fmt.Errorf("restore module cannot restore only some components"))
+				return nil
+			}
+		}
+
+		backupName, err := restoreBackupName(restoreMod, cmd.params)
+		if err != nil {
+			cmd.Return(err)
+			return nil
+		}
+		if err := o.checkBackupSignature(backupName); err != nil {
+			cmd.Return(err)
+			return nil
+		}
+		if err := o.checkSignedRestore(restoreMod, restoreComponents(cmd.params)); err != nil {
+			cmd.Return(err)
+			return nil
+		}
+
 		if restoreMod.RequiresStop() {
-			if err := o.cleanSuperviserStop(); err != nil {
-				return err
+			if err := o.deferWhileProducing(cmd.cmd); err != nil {
+				cmd.Return(err)
+				return nil
 			}
 		}
 
-		backupName := "latest"
-		if b, ok := cmd.params["backupName"]; ok {
-			backupName = b
+		restorerName := backupModuleName(o.backupModules, cmd.params["name"])
+		cmd.logger = o.beginOperation("restore", restorerName, restoreMod, cmd.logger)
+		defer o.endOperation()
+
+		cmd.logger.Info("Stopping to restore a backup")
+		if restoreMod.RequiresStop() {
+			if err := o.cleanSuperviserStop(); err != nil {
+				return err
+			}
 		}
 
-		if err := restoreMod.Restore(backupName); err != nil {
-			return err
+		startedAt := time.Now()
+		if components := restoreComponents(cmd.params); components != nil {
+			if err := restoreMod.(ComponentRestorableBackupModule).RestoreComponents(backupName, components); err != nil {
+				o.recordResult("restore", restorerName, startedAt, 0, backupName, err)
+				return err
+			}
+			// the files left in place are not the backup's, the manifest cannot match
+			cmd.logger.Info("restored some components only, skipping manifest verification", zap.Strings("components", components))
+		} else {
+			if o.canRestoreVerified(restoreMod) {
+				backupName, err = o.restoreVerified(restoreMod, backupName)
+			} else if err = restoreMod.Restore(backupName); err == nil {
+				err = o.verifyRestoredBackup(restoreMod, backupName)
+			}
+			if err == nil {
+				err = o.verifyRestoredBlocksLog(backupName)
+			}
+			if err != nil {
+				// the operator keeps running, the node staying stopped as in maintenance
+				o.recordResult("restore", restorerName, startedAt, 0, backupName, err)
+				cmd.logger.Error("restore failed, not restarting the node", zap.String("backup_name", backupName), zap.Error(err))
+				cmd.Return(err)
+				return nil
+			}
 		}
+		o.recordResult("restore", restorerName, startedAt, 0, backupName, nil)
 
-		o.zlogger.Info("Restarting after restore")
+		cmd.logger.Info("Restarting after restore")
 		if restoreMod.RequiresStop() {
 			return o.runSubCommand("start", cmd)
 		}
@@ -283,26 +758,198 @@ func (o *Operator) runCommand(cmd *Command) error {
 			return nil
 		}
 
-		o.zlogger.Info("Stopping to perform a backup")
+		if o.backupChainIDPending.Load() {
+			cmd.Return(fmt.Errorf("chain id not known yet, backups are refused until the node reports it since their names depend on it"))
+			return nil
+		}
+
+		if last := o.recentSnapshot(backupModuleName(o.backupModules, cmd.params["name"]), o.Superviser.LastSeenBlockNum()); last != nil {
+			cmd.result = &backupResult{Name: last.BackupName, BlockNum: last.BlockNum, Skipped: "too few blocks since last snapshot"}
+			cmd.Return(nil)
+			return nil
+		}
+
+		// When in maintenance, the chain is already stopped and must stay that way after the backup
+		wasRunning := o.Superviser.IsRunning()
+
+		labels := backupLabels(cmd.params)
+
+		backuperName := backupModuleName(o.backupModules, cmd.params["name"])
+		releaseGlobalLock := cmd.globalLockRelease
+		if releaseGlobalLock == nil {
+			releaseGlobalLock, err = o.tryGlobalOperationLock(backuperName)
+			if err != nil {
+				cmd.Return(err)
+				return nil
+			}
+			if releaseGlobalLock == nil {
+				go o.requeueWithGlobalOperationLock(cmd, backuperName)
+				return errCommandRequeued
+			}
+		}
+		defer releaseGlobalLock()
+
 		if backupMod.RequiresStop() {
-			if err := o.cleanSuperviserStop(); err != nil {
-				return err
+			if err := o.deferWhileProducing(cmd.cmd); err != nil {
+				cmd.Return(err)
+				return nil
+			}
+		}
+
+		cmd.logger = o.beginOperation("backup", backuperName, backupMod, cmd.logger)
+		defer o.endOperation()
+
+		cmd.logger.Info("Stopping to perform a backup")
+		if backupMod.RequiresStop() {
+			// already stopped in maintenance, or by the snapshot this backup is promoted from
+			if wasRunning {
+				if err := o.cleanSuperviserStop(); err != nil {
+					return err
+				}
+			}
+
+			if !o.checkCleanShutdown() {
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels["dirty"] = "true"
 			}
 		}
 
-		backupName, err := backupMod.Backup(uint32(o.Superviser.LastSeenBlockNum()))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if _, ok := backupMod.(CancelableBackupModule); ok {
+			o.setOperationCancel(cancel)
+		}
+
+		crashed := atomic.NewBool(false)
+		if wasRunning && !backupMod.RequiresStop() {
+			go o.cancelOnUnexpectedStop(ctx, cancel, crashed)
+		}
+
+		result, err := o.takeBackup(ctx, cmd.logger, backupMod, backuperName, labels, func(backupName string) error {
+			if crashed.Load() {
+				if _, ok := backupMod.(CancelableBackupModule); !ok {
+					cmd.logger.Warn("backup module cannot be canceled, a partial backup may have been left behind", zap.String("backup_name", backupName))
+				}
+				metrics.BackupsAborted.Inc()
+				return fmt.Errorf("backup aborted, chain stopped unexpectedly while it was running")
+			}
+			if o.isOperationAborted() {
+				return fmt.Errorf("backup aborted on request")
+			}
+			return nil
+		})
+		if crashed.Load() {
+			// The chain stopped under our feet, the operator's main loop handles it once this command returns
+			cmd.Return(err)
+			return nil
+		}
+		if o.isOperationAborted() {
+			cmd.Return(err)
+			if backupMod.RequiresStop() && wasRunning {
+				cmd.logger.Info("Restarting after aborted backup")
+				return o.runSubCommand("start", cmd)
+			}
+			return nil
+		}
 		if err != nil {
-			return err
+			if !errors.Is(err, ErrBackupStoreFull) {
+				return err
+			}
+
+			// shutting down to retry would fail the same way, the node is kept running instead
+			metrics.BackupStoreFull.Inc()
+			o.notify(EventBackupStoreFull, err.Error(), map[string]string{"module": backuperName})
+			cmd.Return(err)
+			if backupMod.RequiresStop() && wasRunning {
+				cmd.logger.Info("Restarting after backup failed on a full store")
+				return o.runSubCommand("start", cmd)
+			}
+			return nil
 		}
-		cmd.logger.Info("Completed backup", zap.String("backup_name", backupName))
+		result.Tag = cmd.params["tag"]
+		cmd.result = result
 
-		o.zlogger.Info("Restarting after backup")
-		if backupMod.RequiresStop() {
+		restart := backupMod.RequiresStop() && wasRunning
+		if backuperName == SnapshotModuleName {
+			stopped, err := o.promoteSnapshot(ctx, cancel, cmd.logger, result.Name)
+			restart = restart || stopped
+			if err != nil {
+				cmd.Return(err)
+			}
+		}
+
+		if restart {
+			cmd.logger.Info("Restarting after backup")
 			return o.runSubCommand("start", cmd)
 		}
 		return nil
 
+	case "list":
+		listMod, err := selectListableBackupModule(o.backupModules, cmd.params["name"])
+		if err != nil {
+			cmd.Return(err)
+			return nil
+		}
+
+		names, err := listMod.List(cmd.params)
+		if err != nil {
+			cmd.Return(fmt.Errorf("unable to list backups: %w", err))
+			return nil
+		}
+
+		pinned := make(map[string]bool)
+		if pinnableMod, ok := listMod.(PinnableBackupModule); ok {
+			pinnedNames, err := pinnableMod.PinnedBackups()
+			if err != nil {
+				cmd.Return(fmt.Errorf("unable to list pinned backups: %w", err))
+				return nil
+			}
+			for _, name := range pinnedNames {
+				pinned[name] = true
+			}
+		}
+
+		backups := []*BackupInfo{}
+		for _, name := range o.filterPrefixedBackups(names) {
+			backups = append(backups, &BackupInfo{Name: name, Pinned: pinned[name]})
+		}
+		cmd.result = backups
+
+	case "pin", "unpin":
+		pinnableMod, err := selectPinnableBackupModule(o.backupModules, cmd.params["name"])
+		if err != nil {
+			cmd.Return(err)
+			return nil
+		}
+
+		backupName := cmd.params["backupName"]
+		if backupName == "" {
+			cmd.Return(fmt.Errorf("missing backupName"))
+			return nil
+		}
+
+		if cmd.cmd == "pin" {
+			err = pinnableMod.Pin(backupName)
+		} else {
+			err = pinnableMod.Unpin(backupName)
+		}
+		if err != nil {
+			cmd.Return(fmt.Errorf("unable to %s backup %q: %w", cmd.cmd, backupName, err))
+			return nil
+		}
+		o.zlogger.Info("backup pin updated", zap.String("backup_name", backupName), zap.Bool("pinned", cmd.cmd == "pin"))
+
+	case "rotate_blocks_log":
+		return o.rotateBlocksLog(cmd)
+
 	case "reload":
+		if err := o.deferWhileProducing(cmd.cmd); err != nil {
+			cmd.Return(err)
+			return nil
+		}
+
 		o.zlogger.Info("preparing for reload")
 		if err := o.cleanSuperviserStop(); err != nil {
 			return err
@@ -378,7 +1025,7 @@ func (o *Operator) runCommand(cmd *Command) error {
 	case "safely_reload":
 		o.zlogger.Info("preparing for safely reload")
 		producer, ok := o.Superviser.(nodeManager.ProducerChainSuperviser)
-		if ok && producer.IsActiveProducer() {
+		if ok && producer.IsActiveProducer() && !o.options.DeferOperationsWhileProducing { // otherwise, `reload` waits for it
 			o.zlogger.Info("waiting right after production round")
 			err := producer.WaitUntilEndOfNextProductionRound(3 * time.Minute)
 			if err != nil {
@@ -422,7 +1069,23 @@ func (o *Operator) runCommand(cmd *Command) error {
 			return fmt.Errorf("error starting chain superviser: %w", err)
 		}
 
+		if !o.backupPrefixResolved {
+			if err := o.resolveBackupPrefix(); err != nil {
+				return err
+			}
+			o.setBackupChainID()
+			o.launchChainIDResolution()
+			o.backupPrefixResolved = true
+		}
+
+		startedAt := time.Now().UnixNano()
+		o.startedAt.Store(startedAt)
+		o.recordNodeStart()
+		if o.options.ExpectedChainID != "" {
+			go o.verifyChainID(startedAt)
+		}
 		o.zlogger.Info("successfully start service")
+		o.notify(EventNodeStarted, "", nil)
 
 	}
 
@@ -447,6 +1110,7 @@ func (o *Operator) LaunchBackupSchedules() {
 			hostname, err := os.Hostname()
 			if err != nil {
 				o.zlogger.Error("Disabling automatic backup schedule because requiredHostname is set and cannot retrieve hostname", zap.Error(err))
+				sched.setDisabled()
 				continue
 			}
 			if sched.RequiredHostnameMatch != hostname {
@@ -454,50 +1118,79 @@ func (o *Operator) LaunchBackupSchedules() {
 					zap.String("hostname", hostname),
 					zap.String("required_hostname", sched.RequiredHostnameMatch),
 					zap.String("backuper_name", sched.BackuperName))
+				sched.setDisabled()
 				continue
 			}
 		}
 
 		cmdParams := map[string]string{"name": sched.BackuperName}
 
-		if sched.TimeBetweenRuns > time.Second { //loose validation of not-zero (I've seen issues with .IsZero())
+		if sched.Cron != nil {
+			o.zlogger.Info("starting cron schedule for backup",
+				zap.Stringer("cron", sched.Cron),
+				zap.String("backuper_name", sched.BackuperName),
+			)
+			go o.RunOnCron(sched, "backup", cmdParams)
+		} else if sched.TimeBetweenRuns > time.Second { //loose validation of not-zero (I've seen issues with .IsZero())
 			o.zlogger.Info("starting time-based schedule for backup",
 				zap.Duration("time_between_runs", sched.TimeBetweenRuns),
 				zap.String("backuper_name", sched.BackuperName),
 			)
-			go o.RunEveryPeriod(sched.TimeBetweenRuns, "backup", cmdParams)
+			go o.RunEveryPeriod(sched, "backup", cmdParams)
 		}
 		if sched.BlocksBetweenRuns > 0 {
 			o.zlogger.Info("starting block-based schedule for backup",
 				zap.Int("blocks_between_runs", sched.BlocksBetweenRuns),
 				zap.String("backuper_name", sched.BackuperName),
 			)
-			go o.RunEveryXBlock(uint32(sched.BlocksBetweenRuns), "backup", cmdParams)
+			go o.RunEveryXBlock(sched, "backup", cmdParams)
+		}
+		if len(sched.SpecificBlocks) > 0 {
+			o.zlogger.Info("starting specific blocks schedule for backup",
+				zap.Uint64s("specific_blocks", sched.SpecificBlocks),
+				zap.String("backuper_name", sched.BackuperName),
+			)
+			go o.RunAtBlocks(sched, "backup", cmdParams)
+		}
+		if sched.BlockTimeBoundary > 0 {
+			reporter, ok := o.chainReadiness.(nodeManager.HeadBlockReporter)
+			if !ok {
+				o.zlogger.Error("disabling block time based schedule for backup, the chain readiness does not report head block times", zap.String("backuper_name", sched.BackuperName))
+				sched.setDisabled()
+				continue
+			}
+
+			o.zlogger.Info("starting block time based schedule for backup",
+				zap.Duration("block_time_boundary", sched.BlockTimeBoundary),
+				zap.String("backuper_name", sched.BackuperName),
+			)
+			go o.RunAtBlockTimeBoundaries(sched, reporter, "backup", cmdParams)
 		}
 	}
 }
 
-func (o *Operator) RunEveryPeriod(period time.Duration, commandName string, params map[string]string) {
+func (o *Operator) RunEveryPeriod(sched *BackupSchedule, commandName string, params map[string]string) {
 	for {
-		time.Sleep(1)
+		time.Sleep(1 * time.Second)
 		if o.Superviser.IsRunning() {
 			break
 		}
 	}
 
-	ticker := time.NewTicker(period)
+	ticker := time.NewTicker(sched.TimeBetweenRuns)
+	sched.setNextRunTime(time.Now().Add(sched.TimeBetweenRuns))
 	for {
 		select {
-		case <-ticker.C:
+		case now := <-ticker.C:
+			sched.setNextRunTime(now.Add(sched.TimeBetweenRuns))
 			if o.Superviser.IsRunning() {
-				o.commandChan <- &Command{cmd: commandName, logger: o.zlogger, params: params}
+				o.sendScheduledCommand(sched, commandName, params)
 			}
 		}
 	}
 }
 
-func (o *Operator) RunEveryXBlock(freq uint32, commandName string, params map[string]string) {
-	var lastHeadReference uint64
+func (o *Operator) RunEveryXBlock(sched *BackupSchedule, commandName string, params map[string]string) {
 	for {
 		time.Sleep(1 * time.Second)
 		lastSeenBlockNum := o.Superviser.LastSeenBlockNum()
@@ -505,13 +1198,81 @@ func (o *Operator) RunEveryXBlock(freq uint32, commandName string, params map[st
 			continue
 		}
 
-		if lastHeadReference == 0 {
-			lastHeadReference = lastSeenBlockNum
+		if sched.dueAtBlock(lastSeenBlockNum) {
+			o.sendScheduledCommand(sched, commandName, params)
+		}
+	}
+}
+
+// RunAtBlocks sends the command once for each of the schedule's specific
+// blocks, as soon as the chain has reached (or passed) it.
+func (o *Operator) RunAtBlocks(sched *BackupSchedule, commandName string, params map[string]string) {
+	pending := make([]uint64, len(sched.SpecificBlocks))
+	copy(pending, sched.SpecificBlocks)
+	sort.Slice(pending, func(i, j int) bool { return pending[i] < pending[j] })
+	sched.setNextSpecificBlock(pending[0])
+
+	for len(pending) > 0 {
+		time.Sleep(1 * time.Second)
+		lastSeenBlockNum := o.Superviser.LastSeenBlockNum()
+		if lastSeenBlockNum == 0 || lastSeenBlockNum < pending[0] {
+			continue
+		}
+
+		for len(pending) > 0 && lastSeenBlockNum >= pending[0] {
+			pending = pending[1:]
 		}
 
-		if lastSeenBlockNum > lastHeadReference+uint64(freq) {
-			o.commandChan <- &Command{cmd: commandName, logger: o.zlogger, params: params}
-			lastHeadReference = lastSeenBlockNum
+		var next uint64
+		if len(pending) > 0 {
+			next = pending[0]
 		}
+		sched.setNextSpecificBlock(next)
+		o.sendScheduledCommand(sched, commandName, params)
 	}
 }
+
+// RunAtBlockTimeBoundaries sends the command at the first head block whose
+// timestamp crosses one of the schedule's block time boundaries.
+func (o *Operator) RunAtBlockTimeBoundaries(sched *BackupSchedule, reporter nodeManager.HeadBlockReporter, commandName string, params map[string]string) {
+	for {
+		time.Sleep(1 * time.Second)
+		blockNum, _, blockTime := reporter.HeadBlock()
+		if blockNum == 0 || blockTime.IsZero() {
+			continue
+		}
+
+		if sched.dueAtBlockTime(blockTime) {
+			o.zlogger.Info("head block crossed a block time boundary", zap.Uint64("block_num", blockNum), zap.Time("block_time", blockTime), zap.String("backuper_name", sched.BackuperName))
+			o.sendScheduledCommand(sched, commandName, params)
+		}
+	}
+}
+
+func (o *Operator) sendScheduledCommand(sched *BackupSchedule, commandName string, params map[string]string) {
+	if o.standby.Load() {
+		o.zlogger.Info("skipping scheduled backup, node is in standby", zap.String("backuper_name", sched.BackuperName))
+		return
+	}
+
+	if uptime := o.uptime(); uptime < o.options.MinUptimeBeforeBackup {
+		o.zlogger.Info("skipping scheduled backup, chain has not been running for long enough",
+			zap.String("backuper_name", sched.BackuperName),
+			zap.Duration("uptime", uptime),
+			zap.Duration("min_uptime_before_backup", o.options.MinUptimeBeforeBackup),
+		)
+		return
+	}
+
+	if window := o.activeBlackoutWindow(time.Now()); window != nil {
+		o.zlogger.Info("skipping scheduled backup, within an operation blackout window",
+			zap.String("backuper_name", sched.BackuperName),
+			zap.Stringer("blackout_window", window),
+		)
+		return
+	}
+
+	o.stagger.wait(params["name"], o.zlogger)
+	sched.setLastRun(time.Now(), o.Superviser.LastSeenBlockNum())
+	o.commandChan <- &Command{cmd: commandName, logger: o.zlogger, params: params, scheduled: true}
+}
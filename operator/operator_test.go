@@ -0,0 +1,1120 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dfuse-io/dstore"
+	nodeManager "github.com/dfuse-io/node-manager"
+	logplugin "github.com/dfuse-io/node-manager/log_plugin"
+	"github.com/dfuse-io/shutter"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+type testSuperviser struct {
+	*shutter.Shutter
+	running          bool
+	stops            int
+	lastSeenBlockNum uint64
+}
+
+func newTestSuperviser() *testSuperviser {
+	return &testSuperviser{Shutter: shutter.New(), running: true}
+}
+
+func (s *testSuperviser) GetCommand() string                           { return "test" }
+func (s *testSuperviser) GetName() string                              { return "test" }
+func (s *testSuperviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {}
+func (s *testSuperviser) Start(options ...nodeManager.StartOption) error {
+	s.running = true
+	return nil
+}
+func (s *testSuperviser) Stop() error               { s.running = false; s.stops++; return nil }
+func (s *testSuperviser) IsRunning() bool           { return s.running }
+func (s *testSuperviser) Stopped() <-chan struct{}  { return nil }
+func (s *testSuperviser) ServerID() (string, error) { return "test", nil }
+func (s *testSuperviser) LastExitCode() int         { return 0 }
+func (s *testSuperviser) LastLogLines() []string    { return nil }
+func (s *testSuperviser) LastSeenBlockNum() uint64  { return s.lastSeenBlockNum }
+
+type testReadiness struct{}
+
+func (testReadiness) IsReady() bool { return true }
+
+type testBackupModule struct {
+	name  string
+	count int
+}
+
+func (m *testBackupModule) RequiresStop() bool { return false }
+func (m *testBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	m.count++
+	return fmt.Sprintf("%s-%d", m.name, lastSeenBlockNum), nil
+}
+
+func newTestOperator(t *testing.T) (*Operator, *testSuperviser) {
+	t.Helper()
+
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, &testBackupModule{name: BackupModuleName}))
+	require.NoError(t, o.RegisterBackupModule(SnapshotModuleName, &testBackupModule{name: SnapshotModuleName}))
+	return o, superviser
+}
+
+func TestOperator_ManualBackupDoesNotShiftSnapshotSchedule(t *testing.T) {
+	o, superviser := newTestOperator(t)
+
+	backupSched := &BackupSchedule{BlocksBetweenRuns: 100, BackuperName: BackupModuleName}
+	snapshotSched := &BackupSchedule{BlocksBetweenRuns: 200, TimeBetweenRuns: time.Hour, BackuperName: SnapshotModuleName}
+	o.backupSchedules = append(o.backupSchedules, backupSched, snapshotSched)
+
+	// primed like the schedule run loops do once the chain is running
+	nextRunTime := time.Now().Add(time.Hour)
+	snapshotSched.setNextRunTime(nextRunTime)
+	assert.False(t, backupSched.dueAtBlock(1000))
+	assert.False(t, snapshotSched.dueAtBlock(1000))
+
+	superviser.lastSeenBlockNum = 1050
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, logger: o.zlogger}))
+	superviser.lastSeenBlockNum = 1060
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": SnapshotModuleName}, logger: o.zlogger}))
+
+	statuses := o.scheduleStatuses()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, uint64(1101), statuses[0].NextRunBlock)
+	assert.Equal(t, uint64(1050), statuses[0].LastCompletedBlock)
+	assert.Equal(t, "backup-1050", statuses[0].LastBackupName)
+	assert.Nil(t, statuses[0].LastRun, "a manual backup is not a scheduled run")
+
+	assert.Equal(t, uint64(1201), statuses[1].NextRunBlock)
+	require.NotNil(t, statuses[1].NextRunTime)
+	assert.True(t, nextRunTime.Equal(*statuses[1].NextRunTime))
+	assert.Equal(t, uint64(1060), statuses[1].LastCompletedBlock)
+
+	// the schedules still fire where they would have without the manual backups
+	assert.False(t, backupSched.dueAtBlock(1100))
+	assert.True(t, backupSched.dueAtBlock(1101))
+	assert.False(t, snapshotSched.dueAtBlock(1200))
+	assert.True(t, snapshotSched.dueAtBlock(1201))
+	assert.Equal(t, uint64(1402), snapshotSched.Status().NextRunBlock)
+}
+
+func TestOperator_LastRunTrackedPerOperation(t *testing.T) {
+	o, superviser := newTestOperator(t)
+
+	superviser.lastSeenBlockNum = 10
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": SnapshotModuleName}, logger: o.zlogger}))
+	superviser.lastSeenBlockNum = 20
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, logger: o.zlogger}))
+
+	assert.Equal(t, uint64(10), o.LastRun(SnapshotModuleName).BlockNum)
+	assert.Equal(t, uint64(20), o.LastRun(BackupModuleName).BlockNum)
+	assert.True(t, superviser.IsRunning())
+}
+
+type testChainIDSuperviser struct {
+	*testSuperviser
+	failures int
+	calls    int
+}
+
+func (s *testChainIDSuperviser) ChainID() (string, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return "", fmt.Errorf("node api not up yet")
+	}
+	return "abcdef", nil
+}
+
+func TestOperator_ChainIDRetriedThenCached(t *testing.T) {
+	superviser := &testChainIDSuperviser{testSuperviser: newTestSuperviser(), failures: 1}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, o.resolveBackupPrefix())
+	o.launchChainIDResolution()
+	require.Eventually(t, func() bool { return o.backupPrefix.Load() == "abcdef" }, time.Second, 5*time.Millisecond)
+	assert.False(t, o.backupChainIDPending.Load())
+	assert.Equal(t, 2, superviser.calls)
+
+	chainID, err := o.ChainID()
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", chainID)
+	assert.Equal(t, 2, superviser.calls)
+}
+
+func TestOperator_ChainIDDetectionFailureRefusesBackups(t *testing.T) {
+	superviser := &testChainIDSuperviser{testSuperviser: newTestSuperviser(), failures: 1000}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{BackupPrefix: "eos/{chain_id}", ChainIDDetectionTimeout: time.Millisecond})
+	require.NoError(t, err)
+	defer o.Shutdown(nil)
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, &testBackupModule{name: BackupModuleName}))
+
+	require.NoError(t, o.resolveBackupPrefix())
+	o.launchChainIDResolution()
+	assert.True(t, o.backupChainIDPending.Load())
+	assert.Equal(t, "", o.backupPrefix.Load())
+
+	cmd := &Command{cmd: "backup", returnch: make(chan error, 1), logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	assert.Error(t, <-cmd.returnch)
+	assert.True(t, superviser.IsRunning())
+}
+
+type testChainIDAwareBackupModule struct {
+	testBackupModule
+	chainID *atomic.String
+}
+
+func (m *testChainIDAwareBackupModule) SetChainID(chainID string) { m.chainID.Store(chainID) }
+
+func TestOperator_BackupChainIDSetOnceKnown(t *testing.T) {
+	superviser := &testChainIDSuperviser{testSuperviser: newTestSuperviser(), failures: 1}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+	defer o.Shutdown(nil)
+	mod := &testChainIDAwareBackupModule{testBackupModule: testBackupModule{name: BackupModuleName}, chainID: atomic.NewString("")}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, mod))
+
+	o.setBackupChainID()
+	o.launchChainIDResolution()
+
+	cmd := &Command{cmd: "backup", returnch: make(chan error, 1), logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	assert.Error(t, <-cmd.returnch, "backups must wait for the chain id of their path")
+	assert.Equal(t, 0, mod.count)
+
+	require.Eventually(t, func() bool { return !o.backupChainIDPending.Load() }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "abcdef", mod.chainID.Load())
+}
+
+func TestOperator_ChainIDOverride(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupPrefix: "eos/{chain_id}", ChainID: "offline"})
+	require.NoError(t, err)
+
+	require.NoError(t, o.resolveBackupPrefix())
+	o.launchChainIDResolution()
+	assert.Equal(t, "eos/offline", o.backupPrefix.Load())
+	assert.False(t, o.backupChainIDPending.Load())
+}
+
+func TestOperator_ExpectedChainIDMismatchShutsDown(t *testing.T) {
+	superviser := &testChainIDSuperviser{testSuperviser: newTestSuperviser()}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{ExpectedChainID: "abcdef"})
+	require.NoError(t, err)
+
+	o.verifyChainID(o.startedAt.Load())
+	assert.False(t, o.IsTerminating())
+
+	o.options.ExpectedChainID = "other"
+	o.verifyChainID(o.startedAt.Load())
+	assert.True(t, o.IsTerminating())
+	assert.Error(t, o.Err())
+}
+
+func TestOperator_ExpectedChainIDRequiresChainIDSuperviser(t *testing.T) {
+	_, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{ExpectedChainID: "abcdef"})
+	assert.Error(t, err)
+}
+
+type testProducerSuperviser struct {
+	*testSuperviser
+	active       bool
+	waitErr      error
+	roundsWaited int
+}
+
+func (s *testProducerSuperviser) IsProducing() (bool, error) { return s.active, nil }
+func (s *testProducerSuperviser) IsActiveProducer() bool     { return s.active }
+func (s *testProducerSuperviser) ResumeProduction() error    { return nil }
+func (s *testProducerSuperviser) PauseProduction() error     { return nil }
+func (s *testProducerSuperviser) WaitUntilEndOfNextProductionRound(timeout time.Duration) error {
+	s.roundsWaited++
+	return s.waitErr
+}
+
+func TestOperator_DeferOperationsWhileProducing(t *testing.T) {
+	superviser := &testProducerSuperviser{testSuperviser: newTestSuperviser(), active: true}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{DeferOperationsWhileProducing: true})
+	require.NoError(t, err)
+
+	require.NoError(t, o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger}))
+	assert.Equal(t, 1, superviser.roundsWaited)
+	assert.False(t, superviser.IsRunning())
+
+	superviser.running = true
+	superviser.waitErr = fmt.Errorf("timeout")
+	cmd := &Command{cmd: "maintenance", returnch: make(chan error, 1), logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	assert.Error(t, <-cmd.returnch)
+	assert.True(t, superviser.IsRunning(), "operation must not be performed when the production round wait times out")
+
+	superviser.active = false
+	require.NoError(t, o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger}))
+	assert.Equal(t, 2, superviser.roundsWaited)
+	assert.False(t, superviser.IsRunning())
+}
+
+type testFailingBackupModule struct{}
+
+func (testFailingBackupModule) RequiresStop() bool { return false }
+func (testFailingBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	return "", fmt.Errorf("store unreachable")
+}
+
+type testFullStoreBackupModule struct{}
+
+func (testFullStoreBackupModule) RequiresStop() bool { return false }
+func (testFullStoreBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	return "", WrapStoreFullError(fmt.Errorf("write failed: %w", syscall.ENOSPC))
+}
+
+func TestOperator_BackupStoreFull(t *testing.T) {
+	o, _ := newTestOperator(t)
+	require.NoError(t, o.RegisterBackupModule("full", testFullStoreBackupModule{}))
+	events := o.events.subscribe()
+
+	cmd := &Command{cmd: "backup", params: map[string]string{"name": "full"}, logger: o.zlogger, returnch: make(chan error, 1)}
+	require.NoError(t, o.runCommand(cmd), "a full store must not shut the operator down")
+	assert.True(t, errors.Is(<-cmd.returnch, ErrBackupStoreFull))
+
+	assert.Equal(t, EventBackupStarted, (<-events).Type)
+	assert.Equal(t, EventBackupFailed, (<-events).Type)
+	assert.Equal(t, EventBackupStoreFull, (<-events).Type)
+}
+
+func TestWrapStoreFullError(t *testing.T) {
+	assert.Nil(t, WrapStoreFullError(nil))
+
+	other := fmt.Errorf("connection reset")
+	assert.Equal(t, other, WrapStoreFullError(other))
+
+	for _, err := range []error{
+		fmt.Errorf("writing object: %w", syscall.ENOSPC),
+		fmt.Errorf("googleapi: Error 403: Quota exceeded for quota metric"),
+		fmt.Errorf("QuotaExceeded: the bucket quota was exceeded"),
+		WrapStoreFullError(fmt.Errorf("no space left on device")),
+	} {
+		wrapped := WrapStoreFullError(err)
+		assert.True(t, errors.Is(wrapped, ErrBackupStoreFull), err.Error())
+		assert.True(t, errors.Is(wrapped, err), "original error is kept")
+	}
+}
+
+func TestOperator_LastResults(t *testing.T) {
+	o, superviser := newTestOperator(t)
+	require.NoError(t, o.RegisterBackupModule("failing", testFailingBackupModule{}))
+	assert.Len(t, o.LastResults(), 0)
+
+	superviser.lastSeenBlockNum = 100
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, logger: o.zlogger}))
+	assert.Error(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": "failing"}, logger: o.zlogger}))
+
+	results := o.LastResults()
+	require.Len(t, results, 2)
+
+	assert.Equal(t, "backup", results[0].Module)
+	assert.True(t, results[0].Success)
+	assert.Equal(t, uint64(100), results[0].BlockNum)
+	assert.Equal(t, "backup-100", results[0].BackupName)
+
+	assert.Equal(t, "failing", results[1].Module)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, "store unreachable", results[1].Error)
+}
+
+func TestBackupSchedule_DueAtBlockTime(t *testing.T) {
+	sched := &BackupSchedule{BlockTimeBoundary: 24 * time.Hour, BackuperName: SnapshotModuleName}
+	at := func(value string) time.Time {
+		blockTime, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		return blockTime
+	}
+
+	assert.False(t, sched.dueAtBlockTime(at("2020-01-01T23:59:58Z")), "first block only sets the reference")
+	assert.False(t, sched.dueAtBlockTime(at("2020-01-01T23:59:59Z")))
+	assert.True(t, sched.dueAtBlockTime(at("2020-01-02T00:00:00Z")))
+	assert.Equal(t, at("2020-01-03T00:00:00Z"), *sched.Status().NextRunBlockTime)
+
+	// reorg back before midnight, then crossing it again
+	assert.False(t, sched.dueAtBlockTime(at("2020-01-01T23:59:59Z")))
+	assert.False(t, sched.dueAtBlockTime(at("2020-01-02T00:00:00Z")))
+	assert.False(t, sched.dueAtBlockTime(at("2020-01-02T13:00:00Z")))
+
+	// aligned to UTC whatever the block time's zone
+	montreal := time.FixedZone("EST", -5*3600)
+	assert.False(t, sched.dueAtBlockTime(time.Date(2020, 1, 2, 18, 59, 59, 0, montreal)))
+	assert.True(t, sched.dueAtBlockTime(time.Date(2020, 1, 2, 19, 0, 0, 0, montreal)))
+}
+
+func TestOperator_MinBlocksBetweenSnapshots(t *testing.T) {
+	o, superviser := newTestOperator(t)
+	o.options.MinBlocksBetweenSnapshots = 100
+	snapshot := func() *backupResult {
+		cmd := &Command{cmd: "backup", params: map[string]string{"name": SnapshotModuleName}, logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd))
+		return cmd.result.(*backupResult)
+	}
+
+	superviser.lastSeenBlockNum = 1000
+	assert.Equal(t, "", snapshot().Skipped)
+
+	superviser.lastSeenBlockNum = 1099
+	res := snapshot()
+	assert.NotEmpty(t, res.Skipped)
+	assert.Equal(t, "snapshot-1000", res.Name)
+	assert.Equal(t, uint64(1000), o.LastRun(SnapshotModuleName).BlockNum)
+
+	// backups are not guarded
+	require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, logger: o.zlogger}))
+	assert.Equal(t, uint64(1099), o.LastRun(BackupModuleName).BlockNum)
+
+	superviser.lastSeenBlockNum = 1100
+	assert.Equal(t, "snapshot-1100", snapshot().Name)
+}
+
+type testStoppingBackupModule struct {
+	testBackupModule
+	superviser  *testSuperviser
+	stoppedRuns int
+}
+
+func (m *testStoppingBackupModule) RequiresStop() bool { return true }
+func (m *testStoppingBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	if !m.superviser.running {
+		m.stoppedRuns++
+	}
+	return m.testBackupModule.Backup(lastSeenBlockNum)
+}
+
+func TestOperator_PromoteEveryNthSnapshotToBackup(t *testing.T) {
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{PromoteEveryNthSnapshotToBackup: 2})
+	require.NoError(t, err)
+
+	snapshotMod := &testStoppingBackupModule{testBackupModule: testBackupModule{name: SnapshotModuleName}, superviser: superviser}
+	backupMod := &testStoppingBackupModule{testBackupModule: testBackupModule{name: BackupModuleName}, superviser: superviser}
+	require.NoError(t, o.RegisterBackupModule(SnapshotModuleName, snapshotMod))
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, backupMod))
+
+	for i := 1; i <= 4; i++ {
+		superviser.lastSeenBlockNum = uint64(i * 100)
+		require.NoError(t, o.runCommand(&Command{cmd: "backup", params: map[string]string{"name": SnapshotModuleName}, logger: o.zlogger}))
+		assert.True(t, superviser.IsRunning())
+	}
+
+	assert.Equal(t, 4, snapshotMod.count)
+	assert.Equal(t, 2, backupMod.count)
+	assert.Equal(t, 2, backupMod.stoppedRuns, "promoted backups run while the node is stopped")
+	assert.Equal(t, 4, superviser.stops, "promoted backups share the snapshot's stop")
+	assert.Equal(t, "backup-400", o.LastRun(BackupModuleName).BackupName)
+}
+
+type testOperationCheckingBackupModule struct {
+	testFailingBackupModule
+	o             *Operator
+	seenOperation string
+}
+
+func (m *testOperationCheckingBackupModule) Backup(lastSeenBlockNum uint32) (string, error) {
+	m.seenOperation = m.o.currentOperationID()
+	return m.testFailingBackupModule.Backup(lastSeenBlockNum)
+}
+
+func TestOperator_PromotedBackupFailureKeepsOperator(t *testing.T) {
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{PromoteEveryNthSnapshotToBackup: 1})
+	require.NoError(t, err)
+
+	snapshotMod := &testStoppingBackupModule{testBackupModule: testBackupModule{name: SnapshotModuleName}, superviser: superviser}
+	backupMod := &testOperationCheckingBackupModule{o: o}
+	require.NoError(t, o.RegisterBackupModule(SnapshotModuleName, snapshotMod))
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, backupMod))
+
+	cmd := &Command{cmd: "backup", params: map[string]string{"name": SnapshotModuleName}, returnch: make(chan error, 1), logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd), "a failed promotion must not kill the operator")
+	cmd.Return(nil)
+	assert.Error(t, <-cmd.returnch)
+
+	assert.True(t, superviser.IsRunning(), "node restarted after the failed promotion")
+	assert.Equal(t, 1, superviser.stops)
+	assert.NotEmpty(t, backupMod.seenOperation, "promoted backup runs as part of the snapshot operation")
+	assert.Equal(t, 1, snapshotMod.count)
+
+	results := o.LastResults()
+	require.Len(t, results, 2)
+	assert.Equal(t, BackupModuleName, results[0].Module)
+	assert.False(t, results[0].Success)
+	assert.True(t, results[1].Success)
+	assert.Equal(t, results[1].OperationID, results[0].OperationID)
+}
+
+func TestOperator_ReadySince(t *testing.T) {
+	o, _ := newTestOperator(t)
+	assert.True(t, o.ReadySince().IsZero())
+
+	o.updateReadySince(true)
+	since := o.ReadySince()
+	require.False(t, since.IsZero())
+
+	// stays the time of the transition while ready
+	time.Sleep(time.Millisecond)
+	o.updateReadySince(true)
+	assert.Equal(t, since, o.ReadySince())
+
+	rec := httptest.NewRecorder()
+	o.readySinceHandler(rec, httptest.NewRequest("GET", "/v1/ready_since", nil))
+	resp := &readySinceResponse{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+	assert.True(t, resp.Ready)
+	assert.True(t, since.Equal(*resp.ReadySince))
+
+	o.updateReadySince(false)
+	assert.True(t, o.ReadySince().IsZero())
+
+	rec = httptest.NewRecorder()
+	o.readySinceHandler(rec, httptest.NewRequest("GET", "/v1/ready_since", nil))
+	assert.JSONEq(t, `{"ready":false}`, rec.Body.String())
+}
+
+type testBlocksLogSuperviser struct {
+	*testSuperviser
+	lastBlockNum uint64
+}
+
+func (s *testBlocksLogSuperviser) VerifyBlocksLog() (uint64, error) { return s.lastBlockNum, nil }
+
+type testRestorableBackupModule struct {
+	testBackupModule
+}
+
+func (m *testRestorableBackupModule) Restore(name string) error { return nil }
+
+type testResolvingRestorableBackupModule struct {
+	testRestorableBackupModule
+	root     string
+	restored []string
+}
+
+func (m *testResolvingRestorableBackupModule) BackupRoot() string { return m.root }
+func (m *testResolvingRestorableBackupModule) BackupFiles(string) ([]*ManifestFile, error) {
+	return nil, nil
+}
+func (m *testResolvingRestorableBackupModule) ResolveBackupName(name string) (string, error) {
+	if name == "latest" {
+		return "0000001000", nil
+	}
+	return name, nil
+}
+func (m *testResolvingRestorableBackupModule) Restore(name string) error {
+	m.restored = append(m.restored, name)
+	return ioutil.WriteFile(filepath.Join(m.root, "blocks.log"), []byte("blocks"), 0644)
+}
+
+func TestOperator_RestoreLatestVerifiedUnderResolvedName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+filepath.Join(dir, "manifests"), "", "", false)
+	require.NoError(t, err)
+	manifest, err := json.Marshal(&BackupManifest{BackupName: "0000001000", BlockNum: 1000, Files: []*ManifestFile{
+		{Path: "blocks.log", Size: 6, SHA256: "0000000000000000000000000000000000000000000000000000000000000000"},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000001000", bytes.NewReader(manifest)))
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupManifestStore: manifestStore})
+	require.NoError(t, err)
+	mod := &testResolvingRestorableBackupModule{root: dir}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, mod))
+
+	cmd := &Command{cmd: "restore", params: map[string]string{}, returnch: make(chan error, 1), logger: o.zlogger}
+	require.NoError(t, o.runCommand(cmd))
+	cmd.Return(nil)
+	err = <-cmd.returnch
+	require.Error(t, err, "verified against the manifest of the backup `latest` designates")
+	assert.Contains(t, err.Error(), "does not match its manifest")
+	assert.Equal(t, []string{"0000001000"}, mod.restored)
+}
+
+// testListedBackupModule took a backup of `files`, its root does not exist anymore
+type testListedBackupModule struct {
+	testBackupModule
+	files []*ManifestFile
+}
+
+func (m *testListedBackupModule) BackupRoot() string { return "/nonexistent" }
+func (m *testListedBackupModule) BackupFiles(string) ([]*ManifestFile, error) {
+	return m.files, nil
+}
+
+func TestOperator_ManifestListsUploadedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+dir, "", "", false)
+	require.NoError(t, err)
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupManifestStore: manifestStore})
+	require.NoError(t, err)
+
+	files := []*ManifestFile{{Path: "blocks.log", Size: 6, SHA256: "0000000000000000000000000000000000000000000000000000000000000000"}}
+	o.writeBackupManifest(&testListedBackupModule{files: files}, BackupModuleName, "0000001000", 1000, nil)
+
+	manifest, err := o.loadBackupManifest(context.Background(), "0000001000")
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Equal(t, files, manifest.Files)
+}
+
+// testStagingBackupModule backs up `root` as is and restores `files` into
+// the directory it is given
+type testStagingBackupModule struct {
+	testRestorableBackupModule
+	root  string
+	files map[string]string
+}
+
+func (m *testStagingBackupModule) RequiresStop() bool { return true }
+func (m *testStagingBackupModule) BackupRoot() string { return m.root }
+func (m *testStagingBackupModule) BackupFiles(string) ([]*ManifestFile, error) {
+	return ListManifestFiles(m.root)
+}
+func (m *testStagingBackupModule) RestoreTo(name, dir string) (string, error) {
+	for path, content := range m.files {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+func TestOperator_RestoreVerifiedInStaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+filepath.Join(dir, "manifests"), "", "", false)
+	require.NoError(t, err)
+	root := filepath.Join(dir, "data")
+	require.NoError(t, os.MkdirAll(root, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "blocks.log"), []byte("blocks"), 0644))
+
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{BackupManifestStore: manifestStore})
+	require.NoError(t, err)
+	mod := &testStagingBackupModule{root: root, files: map[string]string{"blocks.log": "blocks", "injected": "evil"}}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, mod))
+	o.writeBackupManifest(mod, BackupModuleName, "0000001000", 1000, nil)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "blocks.log"), []byte("live blocks"), 0644))
+	restore := func() error {
+		cmd := &Command{cmd: "restore", params: map[string]string{"backupName": "0000001000"}, returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd), "a failed restore does not stop the operator")
+		cmd.Return(nil)
+		return <-cmd.returnch
+	}
+
+	// a file not in the manifest fails the restore, the live data is left in place and the node stopped
+	err = restore()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `file "injected" is not part of backup`)
+	assert.False(t, superviser.running)
+	content, err := ioutil.ReadFile(filepath.Join(root, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "live blocks", string(content))
+
+	delete(mod.files, "injected")
+	require.NoError(t, restore())
+	assert.True(t, superviser.running)
+	files, err := ListManifestFiles(root)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "blocks.log", files[0].Path)
+	content, err = ioutil.ReadFile(filepath.Join(root, "blocks.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "blocks", string(content))
+}
+
+// testLatestBackupModule restores with the node stopped, `latest` being backup `0000001000`
+type testLatestBackupModule struct {
+	testRestorableBackupModule
+}
+
+func (m *testLatestBackupModule) RequiresStop() bool { return true }
+func (m *testLatestBackupModule) ResolveBackupName(name string) (string, error) {
+	if name == "latest" {
+		return "0000001000", nil
+	}
+	return name, nil
+}
+
+func TestOperator_VerifyRestoredBlocksLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+dir, "", "", false)
+	require.NoError(t, err)
+	manifest, err := json.Marshal(&BackupManifest{BackupName: "0000001000", BlockNum: 1000})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000001000", bytes.NewReader(manifest)))
+
+	superviser := &testBlocksLogSuperviser{testSuperviser: newTestSuperviser()}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{BackupManifestStore: manifestStore, VerifyRestoredBlocksLog: true, RestoredBlocksLogMaxLag: 300})
+	require.NoError(t, err)
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, &testLatestBackupModule{}))
+
+	restore := func() error {
+		cmd := &Command{cmd: "restore", params: map[string]string{}, returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd), "a failed restore does not stop the operator")
+		cmd.Return(nil)
+		return <-cmd.returnch
+	}
+
+	superviser.lastBlockNum = 800 // irreversible blocks only
+	assert.NoError(t, restore())
+	assert.True(t, superviser.running)
+
+	superviser.lastBlockNum = 500 // truncated
+	err = restore()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "restored blocks log ends at block 500")
+	assert.False(t, superviser.running, "left stopped")
+
+	superviser.lastBlockNum = 1200 // not this backup's
+	assert.Error(t, restore())
+
+	_, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{BackupManifestStore: manifestStore, VerifyRestoredBlocksLog: true})
+	assert.Error(t, err)
+}
+
+func TestOperator_DisabledEndpoints(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+	o.SetDisabledEndpoints([]string{"/v1/restore", "/v1/backup_manifest/{name:.+}", "/v1/extra"})
+
+	srv := o.RunHTTPServer("127.0.0.1:0", func(r *mux.Router) {
+		r.HandleFunc("/v1/extra", o.pingHandler).Methods("POST")
+	})
+	defer srv.Close()
+
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve("POST", "/v1/restore"))
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/v1/restore"))
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/v1/backup_manifest/bk-1"))
+	assert.Equal(t, http.StatusNotFound, serve("POST", "/v1/extra"))
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/ping"))
+}
+
+type testRotatingSuperviser struct {
+	*testSuperviser
+	lastBlockNum uint64
+	rotations    int
+}
+
+func (s *testRotatingSuperviser) RotateBlocksLog() (uint64, error) {
+	if s.running {
+		return 0, fmt.Errorf("node is running")
+	}
+	s.rotations++
+	return s.lastBlockNum, nil
+}
+
+func TestOperator_RotateBlocksLog(t *testing.T) {
+	superviser := &testRotatingSuperviser{testSuperviser: newTestSuperviser(), lastBlockNum: 1200}
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	var hookErr error
+	o.SetBeforeBlocksLogRotation(func() error { return hookErr })
+
+	rotate := func() (*Command, error) {
+		cmd := &Command{cmd: "rotate_blocks_log", returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd))
+		cmd.Return(nil)
+		return cmd, <-cmd.returnch
+	}
+
+	cmd, err := rotate()
+	require.NoError(t, err)
+	assert.Equal(t, &blocksLogRotationResult{BoundaryBlockNum: 1200}, cmd.result)
+	assert.Equal(t, 1, superviser.rotations)
+	assert.True(t, superviser.running, "node is restarted")
+
+	// not rotated when the hook fails, but restarted anyway
+	hookErr = errors.New("mindreader did not drain")
+	_, err = rotate()
+	assert.Error(t, err)
+	assert.Equal(t, 1, superviser.rotations)
+	assert.True(t, superviser.running)
+
+	o, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+	_, err = rotate()
+	assert.Error(t, err)
+}
+
+func TestOperator_GlobalOperationLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "global_lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	lockPath := filepath.Join(dir, "operations")
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{
+		GlobalOperationLockPath:    lockPath,
+		GlobalOperationLockHolders: 2,
+		GlobalOperationLockTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	module := &testBackupModule{name: BackupModuleName}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, module))
+
+	defer func(interval time.Duration) { globalOperationLockRetryInterval = interval }(globalOperationLockRetryInterval)
+	globalOperationLockRetryInterval = 10 * time.Millisecond
+
+	// plays the command loop for a backup, returning its outcome
+	backup := func(whileWaiting func()) error {
+		cmd := &Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, returnch: make(chan error, 1), logger: o.zlogger}
+		if err := o.runCommand(cmd); err == errCommandRequeued {
+			whileWaiting()
+			select {
+			case err := <-cmd.returnch:
+				return err
+			case requeued := <-o.commandChan:
+				require.Equal(t, cmd, requeued)
+				require.NoError(t, o.runCommand(requeued))
+			}
+		} else {
+			require.NoError(t, err)
+		}
+		cmd.Return(nil)
+		return <-cmd.returnch
+	}
+	noop := func() {}
+
+	// other node-managers hold one slot, one is left
+	other, err := tryLockFile(lockPath + ".0")
+	require.NoError(t, err)
+	require.NotNil(t, other)
+	require.NoError(t, backup(noop))
+	assert.Equal(t, 1, module.count)
+
+	// the slot taken by the backup was released, the wait for one happens off the command loop
+	other2, err := tryLockFile(lockPath + ".1")
+	require.NoError(t, err)
+	require.NotNil(t, other2)
+	assert.True(t, errors.Is(backup(func() {
+		require.NoError(t, o.runCommand(&Command{cmd: "maintenance", logger: o.zlogger}), "other commands are not blocked by the wait")
+	}), ErrGlobalOperationLockTimeout))
+	assert.Equal(t, 1, module.count)
+
+	o.options.GlobalOperationLockTimeout = time.Minute
+	assert.Error(t, backup(func() {
+		require.Eventually(t, func() bool { _, err := o.Abort(); return err == nil }, time.Second, 5*time.Millisecond)
+	}), "waits are abortable")
+	assert.Equal(t, 1, module.count)
+
+	require.NoError(t, backup(func() { other.Close() }), "requeued once a slot is free")
+	assert.Equal(t, 2, module.count)
+	other2.Close()
+}
+
+type testCancelableBackupModule struct {
+	testBackupModule
+	started chan struct{}
+}
+
+func (m *testCancelableBackupModule) BackupWithContext(ctx context.Context, _ uint32, _ map[string]string) (string, error) {
+	close(m.started)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestOperator_Abort(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+	module := &testCancelableBackupModule{started: make(chan struct{})}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, module))
+
+	w := httptest.NewRecorder()
+	o.abortHandler(w, httptest.NewRequest("POST", "/v1/abort", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	cmd := &Command{cmd: "backup", params: map[string]string{"name": BackupModuleName}, returnch: make(chan error, 1), logger: o.zlogger}
+	done := make(chan error)
+	go func() { done <- o.runCommand(cmd) }()
+	<-module.started
+
+	status, err := o.Abort()
+	require.NoError(t, err)
+	assert.Equal(t, "backup", status.Operation)
+	assert.Equal(t, BackupModuleName, status.Module)
+
+	require.NoError(t, <-done, "an aborted backup is not fatal")
+	assert.Error(t, <-cmd.returnch)
+
+	_, err = o.Abort()
+	assert.Equal(t, ErrNoOperation, err)
+}
+
+func TestOperator_ReadinessFunc(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	healthz := func() (int, string) {
+		w := httptest.NewRecorder()
+		o.healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, _ := healthz()
+	require.Equal(t, http.StatusOK, code)
+
+	ready, reason := false, "peers below minimum"
+	o.SetReadinessFunc(func() (bool, string) { return ready, reason })
+	code, body := healthz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "not ready: peers below minimum")
+
+	reason = ""
+	_, body = healthz()
+	assert.Contains(t, body, "not ready: custom readiness check failed")
+
+	ready = true
+	code, _ = healthz()
+	assert.Equal(t, http.StatusOK, code)
+}
+
+type testDirRestorableBackupModule struct {
+	testRestorableBackupModule
+	restored int
+}
+
+func (m *testDirRestorableBackupModule) BackupRoot() string { return "/nonexistent" }
+func (m *testDirRestorableBackupModule) BackupFiles(string) ([]*ManifestFile, error) {
+	return nil, nil
+}
+func (m *testDirRestorableBackupModule) Restore(name string) error {
+	m.restored++
+	return nil
+}
+func (m *testDirRestorableBackupModule) RestoreTo(name, dir string) (string, error) {
+	return "0000001000", ioutil.WriteFile(filepath.Join(dir, "blocks.log"), []byte("blocks"), 0644)
+}
+
+func TestOperator_RestoreDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore_dry_run")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+filepath.Join(dir, "manifests"), "", "", false)
+	require.NoError(t, err)
+	manifest, err := json.Marshal(&BackupManifest{BackupName: "0000001000", BlockNum: 1000, Files: []*ManifestFile{
+		{Path: "blocks.log", Size: 6, SHA256: "ef0e4a4d5ab4bad4bd7a4c7d8f9e4c5a3d0bdde1aa4e9b4f3e9c0d6c5a3bb6a0"},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000001000", bytes.NewReader(manifest)))
+
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{BackupManifestStore: manifestStore, RestoreDryRunDir: dir})
+	require.NoError(t, err)
+	mod := &testDirRestorableBackupModule{}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, mod))
+
+	dryRun := func() (*Command, error) {
+		cmd := &Command{cmd: "restore", params: map[string]string{"dry_run": "true"}, returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd))
+		cmd.Return(nil)
+		return cmd, <-cmd.returnch
+	}
+
+	// the restored file does not match the manifest checksum
+	_, err = dryRun()
+	assert.Error(t, err)
+
+	sum := sha256.Sum256([]byte("blocks"))
+	manifest, err = json.Marshal(&BackupManifest{BackupName: "0000001000", BlockNum: 1000, Files: []*ManifestFile{
+		{Path: "blocks.log", Size: 6, SHA256: hex.EncodeToString(sum[:])},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000001000", bytes.NewReader(manifest)))
+
+	cmd, err := dryRun()
+	require.NoError(t, err)
+	assert.Equal(t, &restoreDryRunResult{BackupName: "0000001000", FileCount: 1, TotalBytes: 6, EndBlockNum: 1000, VerifiedWithManifest: true}, cmd.result)
+
+	// never touching the node nor its data
+	assert.Equal(t, 0, superviser.stops)
+	assert.Equal(t, 0, mod.restored)
+	left, err := filepath.Glob(filepath.Join(dir, "restore_dry_run*"))
+	require.NoError(t, err)
+	assert.Len(t, left, 0)
+}
+
+func TestMatchExcludePattern(t *testing.T) {
+	patterns := []string{"*.sock", "state/*.tmp", "scratch"}
+
+	assert.True(t, MatchExcludePattern(patterns, "node.sock"))
+	assert.True(t, MatchExcludePattern(patterns, "state/sub/node.sock"))
+	assert.True(t, MatchExcludePattern(patterns, "state/shared.tmp"))
+	assert.True(t, MatchExcludePattern(patterns, "reversible/scratch"))
+	assert.False(t, MatchExcludePattern(patterns, "state/sub/shared.tmp"))
+	assert.False(t, MatchExcludePattern(patterns, "blocks/blocks.log"))
+	assert.False(t, MatchExcludePattern(nil, "node.sock"))
+}
+
+func TestOperator_BlockProgressMonitor(t *testing.T) {
+	defer func(interval time.Duration) { blockProgressCheckInterval = interval }(blockProgressCheckInterval)
+	blockProgressCheckInterval = 10 * time.Millisecond
+
+	superviser := newTestSuperviser()
+	superviser.lastSeenBlockNum = 100
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+	defer o.Shutdown(nil)
+
+	o.ConfigureBlockProgressMonitor(50*time.Millisecond, true)
+	go o.monitorBlockProgress()
+
+	select {
+	case cmd := <-o.commandChan:
+		assert.Equal(t, "reload", cmd.cmd)
+	case <-time.After(5 * time.Second):
+		t.Fatal("node not restarted while its head block was stalled")
+	}
+	assert.Contains(t, o.Describe().Degraded, "head block stuck at #100")
+
+	o.ConfigureBlockProgressMonitor(0, true)
+	assert.Nil(t, o.blockProgress)
+}
+
+func TestOperator_BackupSigning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_signing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifestStore, err := dstore.NewStore("file://"+dir, "", "", false)
+	require.NoError(t, err)
+
+	signingKey, err := ParseBackupSigningKey(strings.Repeat("01", 32))
+	require.NoError(t, err)
+
+	superviser := newTestSuperviser()
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{BackupManifestStore: manifestStore})
+	require.NoError(t, err)
+	require.NoError(t, o.ConfigureBackupSigning(signingKey, nil, true))
+	root := filepath.Join(dir, "data")
+	require.NoError(t, os.MkdirAll(root, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "blocks.log"), []byte("blocks"), 0644))
+	mod := &testStagingBackupModule{root: root, files: map[string]string{"blocks.log": "blocks"}}
+	require.NoError(t, o.RegisterBackupModule(BackupModuleName, mod))
+
+	restore := func(backupName string, params ...string) error {
+		cmdParams := map[string]string{"backupName": backupName}
+		for i := 0; i+1 < len(params); i += 2 {
+			cmdParams[params[i]] = params[i+1]
+		}
+		cmd := &Command{cmd: "restore", params: cmdParams, returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd))
+		cmd.Return(nil)
+		return <-cmd.returnch
+	}
+
+	o.writeBackupManifest(mod, BackupModuleName, "0000001000", 1000, nil)
+	exists, err := manifestStore.FileExists(context.Background(), "0000001000"+ManifestSignatureSuffix)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, restore("0000001000"))
+	stops := superviser.stops
+
+	// substituted manifest
+	manifest, err := json.Marshal(&BackupManifest{BackupName: "0000001000", BlockNum: 2000})
+	require.NoError(t, err)
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000001000", bytes.NewReader(manifest)))
+	assert.Error(t, restore("0000001000"))
+
+	// unsigned, or without manifest
+	require.NoError(t, manifestStore.WriteObject(context.Background(), "0000002000", bytes.NewReader(manifest)))
+	assert.Error(t, restore("0000002000"))
+	assert.Error(t, restore("0000003000"))
+	assert.Error(t, restore("latest"))
+
+	// the files cannot be verified before being put in place
+	assert.Error(t, restore("0000001000", "components", "state"))
+	assert.Error(t, o.checkSignedRestore(&testRestorableBackupModule{}, nil))
+
+	// refused before stopping the node
+	assert.Equal(t, stops, superviser.stops)
+
+	o, err = New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+	assert.Error(t, o.ConfigureBackupSigning(signingKey, nil, false))
+}
+
+func TestOperator_NodeStatus(t *testing.T) {
+	superviser := newTestSuperviser()
+	superviser.running = false
+	o, err := New(zap.NewNop(), superviser, testReadiness{}, &Options{})
+	require.NoError(t, err)
+
+	start := func() {
+		cmd := &Command{cmd: "start", returnch: make(chan error, 1), logger: o.zlogger}
+		require.NoError(t, o.runCommand(cmd))
+	}
+
+	assert.Equal(t, &NodeStatus{}, o.NodeStatus())
+
+	start()
+	require.NoError(t, superviser.Stop())
+	start()
+
+	w := httptest.NewRecorder()
+	o.nodeStatusHandler(w, httptest.NewRequest("GET", "/v1/node_status", nil))
+	status := &NodeStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+	assert.True(t, status.Running)
+	assert.NotNil(t, status.StartedAt)
+	assert.Equal(t, uint64(1), status.RestartCount)
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultProducingDeferTimeout = 3 * time.Minute
+	producingReportInterval      = 5 * time.Second
+)
+
+// reportProducing keeps the `node_manager_is_producing` gauge up to date
+// when the superviser manages a block producer.
+func (o *Operator) reportProducing(producer nodeManager.ProducerChainSuperviser) {
+	ticker := time.NewTicker(producingReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			if !o.Superviser.IsRunning() {
+				metrics.IsProducing.SetUint64(0)
+				continue
+			}
+			if producer.IsActiveProducer() {
+				metrics.IsProducing.SetUint64(1)
+			} else {
+				metrics.IsProducing.SetUint64(0)
+			}
+		}
+	}
+}
+
+// deferWhileProducing waits for the end of the node's production round
+// before a disruptive operation, when `Options.DeferOperationsWhileProducing`
+// is set and the node is an active producer.
+func (o *Operator) deferWhileProducing(operation string) error {
+	if !o.options.DeferOperationsWhileProducing || !o.Superviser.IsRunning() {
+		return nil
+	}
+
+	producer, ok := o.Superviser.(nodeManager.ProducerChainSuperviser)
+	if !ok || !producer.IsActiveProducer() {
+		return nil
+	}
+
+	timeout := o.options.ProducingDeferTimeout
+	if timeout == 0 {
+		timeout = defaultProducingDeferTimeout
+	}
+
+	o.zlogger.Info("node is an active producer, deferring operation until the end of its production round", zap.String("operation", operation), zap.Duration("timeout", timeout))
+	start := time.Now()
+	if err := producer.WaitUntilEndOfNextProductionRound(timeout); err != nil {
+		o.zlogger.Warn("timeout waiting for production round, operation not performed", zap.String("operation", operation), zap.Error(err))
+		return fmt.Errorf("%s deferred while producing: timeout waiting for production round: %w", operation, err)
+	}
+
+	o.zlogger.Info("production round over, resuming deferred operation", zap.String("operation", operation), zap.Duration("deferred_for", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+	"go.uber.org/zap"
+)
+
+const progressLogInterval = 10 * time.Second
+
+// ProgressReporter is called by backup modules as they upload, with the
+// number of bytes done so far out of the total.
+type ProgressReporter func(doneBytes, totalBytes int64)
+
+// ProgressReportingBackupModule is implemented by modules able to report the
+// progress of their uploads, the operator sets the reporter on registration.
+type ProgressReportingBackupModule interface {
+	BackupModule
+	SetProgressReporter(reporter ProgressReporter)
+}
+
+// OperationStatus describes the operation currently blocking the operator,
+// served on `/v1/operation_status`.
+type OperationStatus struct {
+	InProgress    bool      `json:"in_progress"`
+	ID            string    `json:"id,omitempty"` // tags the operation's log lines, see `/v1/operation/{id}/logs`
+	Operation     string    `json:"operation,omitempty"`
+	Module        string    `json:"module,omitempty"`
+	StartedAt     time.Time `json:"started_at,omitempty"`
+	DoneBytes     int64     `json:"done_bytes,omitempty"`
+	TotalBytes    int64     `json:"total_bytes,omitempty"`
+	ProgressRatio float64   `json:"progress_ratio,omitempty"`
+}
+
+// beginOperation tracks `operation` as the one in progress and returns
+// `logger` tagged with its id, the operation must log through it. Until
+// `endOperation`, `mod` logs through it too when it is a `LoggingBackupModule`.
+func (o *Operator) beginOperation(operation, module string, mod BackupModule, logger *zap.Logger) *zap.Logger {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	startedAt := time.Now()
+	id := fmt.Sprintf("%s-%d", operation, startedAt.UnixNano())
+	o.operation = &OperationStatus{InProgress: true, ID: id, Operation: operation, Module: module, StartedAt: startedAt}
+	o.operationLoggedAt = startedAt
+	metrics.BackupProgressRatio.SetFloat64(0)
+
+	logger = logger.With(zap.String(operationIDField, id))
+	if logging, ok := mod.(LoggingBackupModule); ok {
+		logging.SetOperationLogger(logger)
+		o.operationModule = logging
+	}
+	return logger
+}
+
+func (o *Operator) endOperation() {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	o.operation = nil
+	o.operationCancel = nil
+	o.operationAborted = false
+	if o.operationModule != nil {
+		o.operationModule.SetOperationLogger(nil)
+		o.operationModule = nil
+	}
+}
+
+// currentOperationID returns the id of the operation in progress, empty if none
+func (o *Operator) currentOperationID() string {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	if o.operation == nil {
+		return ""
+	}
+	return o.operation.ID
+}
+
+// OperationStatus returns the status of the operation in progress, if any.
+func (o *Operator) OperationStatus() *OperationStatus {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	if o.operation == nil {
+		return &OperationStatus{}
+	}
+
+	status := *o.operation
+	return &status
+}
+
+func (o *Operator) reportProgress(module string, doneBytes, totalBytes int64) {
+	o.operationLock.Lock()
+	defer o.operationLock.Unlock()
+
+	if o.operation == nil || o.operation.Module != module {
+		return
+	}
+
+	o.operation.DoneBytes = doneBytes
+	o.operation.TotalBytes = totalBytes
+	if totalBytes > 0 {
+		o.operation.ProgressRatio = float64(doneBytes) / float64(totalBytes)
+	}
+	metrics.BackupProgressRatio.SetFloat64(o.operation.ProgressRatio)
+
+	if doneBytes == totalBytes || time.Since(o.operationLoggedAt) >= progressLogInterval {
+		o.operationLoggedAt = time.Now()
+		o.zlogger.Info("backup upload progress",
+			zap.String("module", module),
+			zap.Int64("done_bytes", doneBytes),
+			zap.Int64("total_bytes", totalBytes),
+			zap.Float64("progress_ratio", o.operation.ProgressRatio),
+			zap.Duration("elapsed", time.Since(o.operation.StartedAt)),
+		)
+	}
+}
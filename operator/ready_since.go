@@ -0,0 +1,80 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+)
+
+const readinessTrackingInterval = time.Second
+
+// trackReadiness keeps `ReadySince` up to date between readiness checks.
+func (o *Operator) trackReadiness() {
+	ticker := time.NewTicker(readinessTrackingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.Terminating():
+			return
+		case <-ticker.C:
+			o.updateReadySince(o.readinessProblem() == "")
+		}
+	}
+}
+
+func (o *Operator) updateReadySince(ready bool) {
+	if !ready {
+		o.readySince.Store(0)
+		metrics.ReadySince.SetFloat64(0)
+		return
+	}
+
+	if o.readySince.CAS(0, time.Now().UnixNano()) {
+		metrics.ReadySince.SetFloat64(float64(o.readySince.Load()) / float64(time.Second))
+	}
+}
+
+// ReadySince returns when the node last became ready, the zero time if it is
+// not ready. Readiness is checked every second.
+func (o *Operator) ReadySince() time.Time {
+	since := o.readySince.Load()
+	if since == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, since)
+}
+
+type readySinceResponse struct {
+	Ready           bool       `json:"ready"`
+	ReadySince      *time.Time `json:"ready_since,omitempty"`
+	ReadyForSeconds float64    `json:"ready_for_seconds,omitempty"`
+}
+
+func (o *Operator) readySinceHandler(w http.ResponseWriter, _ *http.Request) {
+	resp := &readySinceResponse{}
+	if since := o.ReadySince(); !since.IsZero() {
+		resp.Ready = true
+		resp.ReadySince = &since
+		resp.ReadyForSeconds = time.Since(since).Seconds()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// cgroupMemoryLimitFiles hold the memory limit of the container we run in, cgroup v2 first
+var cgroupMemoryLimitFiles = []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}
+
+// checkResourceLimits compares the limits the node inherits from us against
+// `Options.MinOpenFiles` and `Options.MinAvailableMemory`. Problems are logged,
+// they are only returned when `Options.FailOnLowLimits` is set.
+func (o *Operator) checkResourceLimits() error {
+	var problems []string
+
+	if o.options.MinOpenFiles != 0 {
+		var limit syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+			o.zlogger.Warn("unable to check open files limit", zap.Error(err))
+		} else if limit.Cur < o.options.MinOpenFiles {
+			problems = append(problems, fmt.Sprintf("open files limit (ulimit -n) is %d, below the required %d", limit.Cur, o.options.MinOpenFiles))
+		}
+	}
+
+	if o.options.MinAvailableMemory != 0 {
+		available, err := availableMemory()
+		if err != nil {
+			o.zlogger.Warn("unable to check available memory", zap.Error(err))
+		} else if available < o.options.MinAvailableMemory {
+			problems = append(problems, fmt.Sprintf("available memory is %d bytes, below the required %d", available, o.options.MinAvailableMemory))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	for _, problem := range problems {
+		o.zlogger.Warn("!!! RESOURCE LIMIT TOO LOW, the node may crash or degrade later on !!!", zap.String("problem", problem))
+	}
+	if o.options.FailOnLowLimits {
+		return fmt.Errorf("resource limits too low: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// availableMemory returns the memory available to the system, capped by the
+// container's memory limit when there is one.
+func availableMemory() (uint64, error) {
+	available, err := memAvailable("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range cgroupMemoryLimitFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		// "max" (v2) or a huge value (v1) when there is no limit
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err == nil && limit < available {
+			available = limit
+		}
+		break
+	}
+	return available, nil
+}
+
+func memAvailable(meminfo string) (uint64, error) {
+	f, err := os.Open(meminfo)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable in %q: %w", meminfo, err)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemAvailable in %q", meminfo)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_CheckResourceLimits(t *testing.T) {
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{MinOpenFiles: 1})
+	require.NoError(t, err)
+	assert.NoError(t, o.checkResourceLimits())
+
+	o.options.MinOpenFiles = math.MaxUint64
+	assert.NoError(t, o.checkResourceLimits(), "only a warning without FailOnLowLimits")
+
+	o.options.FailOnLowLimits = true
+	assert.Error(t, o.checkResourceLimits())
+}
+
+func TestMemAvailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meminfo")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	meminfo := filepath.Join(dir, "meminfo")
+	require.NoError(t, ioutil.WriteFile(meminfo, []byte("MemTotal:       16318284 kB\nMemFree:         1228460 kB\nMemAvailable:    8162444 kB\n"), 0644))
+
+	available, err := memAvailable(meminfo)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8162444*1024), available)
+
+	require.NoError(t, ioutil.WriteFile(meminfo, []byte("MemTotal:       16318284 kB\n"), 0644))
+	_, err = memAvailable(meminfo)
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// restoreDryRunResult is what a restore of the backup would put in place
+type restoreDryRunResult struct {
+	BackupName           string `json:"backup_name"`
+	FileCount            int    `json:"file_count"`
+	TotalBytes           int64  `json:"total_bytes"`
+	EndBlockNum          uint64 `json:"end_block_num,omitempty"` // block the backup was taken at, from its manifest
+	VerifiedWithManifest bool   `json:"verified_with_manifest"`
+}
+
+// restoreDryRun extracts the backup into a temporary directory, verifies it
+// against its manifest and reports what would be restored, then discards it.
+// The node is never stopped.
+func (o *Operator) restoreDryRun(cmd *Command, mod RestorableBackupModule) error {
+	restorer, ok := mod.(DirRestorableBackupModule)
+	if !ok {
+		cmd.Return(fmt.Errorf("restore module cannot restore to another directory, dry-run is not supported"))
+		return nil
+	}
+	if restoreComponents(cmd.params) != nil {
+		cmd.Return(fmt.Errorf("dry-run restores the whole backup, it cannot be limited to some components"))
+		return nil
+	}
+
+	restorerName := backupModuleName(o.backupModules, cmd.params["name"])
+	cmd.logger = o.beginOperation("restore_dry_run", restorerName, mod, cmd.logger)
+	defer o.endOperation()
+
+	backupName, err := restoreBackupName(mod, cmd.params)
+	if err != nil {
+		cmd.Return(err)
+		return nil
+	}
+	if err := o.checkBackupSignature(backupName); err != nil {
+		cmd.Return(err)
+		return nil
+	}
+
+	startedAt := time.Now()
+	result, err := o.dryRunRestore(restorer, backupName)
+	if err != nil {
+		o.recordResult("restore_dry_run", restorerName, startedAt, 0, backupName, err)
+		cmd.Return(err)
+		return nil
+	}
+	o.recordResult("restore_dry_run", restorerName, startedAt, result.EndBlockNum, result.BackupName, nil)
+
+	cmd.result = result
+	cmd.Return(nil)
+	return nil
+}
+
+func (o *Operator) dryRunRestore(restorer DirRestorableBackupModule, backupName string) (*restoreDryRunResult, error) {
+	dir, err := ioutil.TempDir(o.options.RestoreDryRunDir, "restore_dry_run")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create dry-run restore directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			o.zlogger.Warn("unable to discard dry-run restore directory", zap.String("dir", dir), zap.Error(err))
+		}
+	}()
+
+	o.zlogger.Info("dry-run restoring backup", zap.String("backup_name", backupName), zap.String("dir", dir))
+	restoredName, err := restorer.RestoreTo(backupName, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &restoreDryRunResult{BackupName: restoredName}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			result.FileCount++
+			result.TotalBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk dry-run restore directory: %w", err)
+	}
+
+	if _, ok := restorer.(ManifestBackupModule); ok && o.options.BackupManifestStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+
+		manifest, err := o.loadBackupManifest(ctx, restoredName)
+		if err != nil {
+			return nil, err
+		}
+		if manifest != nil {
+			if err := manifest.Verify(dir); err != nil {
+				return nil, fmt.Errorf("restored backup does not match its manifest: %w", err)
+			}
+			result.EndBlockNum = manifest.BlockNum
+			result.VerifiedWithManifest = true
+		}
+	}
+
+	o.zlogger.Info("dry-run restore succeeded", zap.String("backup_name", restoredName), zap.Int("file_count", result.FileCount), zap.Int64("total_bytes", result.TotalBytes), zap.Uint64("end_block_num", result.EndBlockNum), zap.Bool("verified_with_manifest", result.VerifiedWithManifest))
+	return result, nil
+}
+
+// restoreDryRunHandler always runs synchronously since the caller is
+// interested in what would be restored.
+func (o *Operator) restoreDryRunHandler(params map[string]string, w http.ResponseWriter) {
+	c := &Command{cmd: "restore", params: params, logger: o.zlogger}
+	if err := o.sendCommandAndWait(c); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(fmt.Sprintf("ERROR: dry-run restore failed: %s \n", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.result)
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dfuse-io/node-manager/metrics"
+)
+
+// OperationResult is the outcome of the last run of an operation with a
+// given module, successful or not, served on `/v1/last_results`.
+type OperationResult struct {
+	Operation       string    `json:"operation"`
+	OperationID     string    `json:"operation_id,omitempty"` // see `/v1/operation/{id}/logs`
+	Module          string    `json:"module"`
+	Success         bool      `json:"success"`
+	CompletedAt     time.Time `json:"completed_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	BlockNum        uint64    `json:"block_num,omitempty"`
+	BackupName      string    `json:"backup_name,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// recordResult keeps the outcome of an operation started at `startedAt`, `err` being nil on success.
+func (o *Operator) recordResult(operation, module string, startedAt time.Time, blockNum uint64, backupName string, err error) {
+	result := &OperationResult{
+		Operation:       operation,
+		OperationID:     o.currentOperationID(),
+		Module:          module,
+		Success:         err == nil,
+		CompletedAt:     time.Now(),
+		DurationSeconds: time.Since(startedAt).Seconds(),
+		BlockNum:        blockNum,
+		BackupName:      backupName,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else if operation == "backup" {
+		metrics.LastBackupDuration.SetFloat64(result.DurationSeconds)
+	}
+
+	o.lastResultsLock.Lock()
+	defer o.lastResultsLock.Unlock()
+	o.lastResults[operation+"/"+module] = result
+}
+
+// LastResults returns the last result of each operation and module, sorted by operation then module.
+func (o *Operator) LastResults() []*OperationResult {
+	o.lastResultsLock.Lock()
+	defer o.lastResultsLock.Unlock()
+
+	results := make([]*OperationResult, 0, len(o.lastResults))
+	for _, result := range o.lastResults {
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Operation != results[j].Operation {
+			return results[i].Operation < results[j].Operation
+		}
+		return results[i].Module < results[j].Module
+	})
+	return results
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Kinds of `ShutdownReason`
+const (
+	ShutdownClean       = "clean"        // requested, like on SIGTERM
+	ShutdownCrashLoop   = "crash_loop"   // the crash-loop limiter tripped
+	ShutdownNodeStopped = "node_stopped" // the node stopped unexpectedly and was not restarted
+	ShutdownError       = "error"        // an operation failed
+	ShutdownUnknown     = "unknown"      // killed before recording a reason, like on SIGKILL or OOM kill
+)
+
+// ShutdownReason is why the operator terminated, written to
+// `Options.ShutdownReasonFile` as it does.
+type ShutdownReason struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"`
+	Clean bool      `json:"clean"`
+	Error string    `json:"error,omitempty"`
+}
+
+func newShutdownReason(err error, nodeStopped bool) *ShutdownReason {
+	reason := &ShutdownReason{Time: time.Now()}
+	switch {
+	case err == nil || err == ErrCleanExit:
+		reason.Kind = ShutdownClean
+		reason.Clean = true
+		return reason
+	case errors.Is(err, ErrCrashLoop):
+		reason.Kind = ShutdownCrashLoop
+	case nodeStopped:
+		reason.Kind = ShutdownNodeStopped
+	default:
+		reason.Kind = ShutdownError
+	}
+
+	reason.Error = err.Error()
+	return reason
+}
+
+// loadLastShutdownReason reads the reason the previous run terminated for,
+// nil if it was not recorded.
+func loadLastShutdownReason(path string) (*ShutdownReason, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	reason := &ShutdownReason{}
+	if err := json.Unmarshal(data, reason); err != nil {
+		return nil, fmt.Errorf("invalid shutdown reason file %q: %w", path, err)
+	}
+	return reason, nil
+}
+
+// writeShutdownReason replaces the file atomically, the process may be
+// killed while it writes.
+func writeShutdownReason(path string, reason *ShutdownReason) error {
+	data, err := json.Marshal(reason)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runningShutdownReason is written as the run starts, so a run killed before
+// recording its reason reads as unclean. Its time is that of the start.
+func runningShutdownReason() *ShutdownReason {
+	return &ShutdownReason{
+		Time:  time.Now(),
+		Kind:  ShutdownUnknown,
+		Error: "terminated without recording a shutdown reason, it was likely killed",
+	}
+}
+
+func (o *Operator) recordShutdownReason(err error) {
+	reason := newShutdownReason(err, o.nodeStoppedShutdown.Load())
+	o.zlogger.Info("recording shutdown reason", zap.String("kind", reason.Kind), zap.String("shutdown_reason_file", o.options.ShutdownReasonFile))
+	if err := writeShutdownReason(o.options.ShutdownReasonFile, reason); err != nil {
+		o.zlogger.Error("unable to write shutdown reason", zap.String("shutdown_reason_file", o.options.ShutdownReasonFile), zap.Error(err))
+	}
+}
+
+// LastShutdownReason returns why the previous run terminated, nil if it is
+// not known.
+func (o *Operator) LastShutdownReason() *ShutdownReason {
+	return o.lastShutdownReason
+}
+
+func (o *Operator) lastShutdownReasonHandler(w http.ResponseWriter, _ *http.Request) {
+	reason := o.LastShutdownReason()
+	if reason == nil {
+		http.Error(w, "no shutdown reason recorded by the previous run", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reason)
+}
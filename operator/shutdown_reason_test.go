@@ -0,0 +1,75 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewShutdownReason(t *testing.T) {
+	assert.Equal(t, ShutdownClean, newShutdownReason(nil, false).Kind)
+	assert.True(t, newShutdownReason(ErrCleanExit, false).Clean)
+	assert.Equal(t, ShutdownCrashLoop, newShutdownReason(fmt.Errorf("%w: too many restarts", ErrCrashLoop), true).Kind)
+	assert.Equal(t, ShutdownNodeStopped, newShutdownReason(fmt.Errorf("instance stopped"), true).Kind)
+
+	reason := newShutdownReason(fmt.Errorf("command backup execution failed"), false)
+	assert.Equal(t, ShutdownError, reason.Kind)
+	assert.False(t, reason.Clean)
+	assert.Equal(t, "command backup execution failed", reason.Error)
+}
+
+func TestOperator_ShutdownReasonPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown_reason")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	options := &Options{ShutdownReasonFile: filepath.Join(dir, "shutdown_reason.json")}
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, options)
+	require.NoError(t, err)
+	assert.Nil(t, o.LastShutdownReason())
+
+	o.Shutdown(fmt.Errorf("%w: more than 3 restarts", ErrCrashLoop))
+	<-o.Terminated()
+
+	o, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, options)
+	require.NoError(t, err)
+	reason := o.LastShutdownReason()
+	require.NotNil(t, reason)
+	assert.Equal(t, ShutdownCrashLoop, reason.Kind)
+	assert.Contains(t, reason.Error, "more than 3 restarts")
+
+	rec := httptest.NewRecorder()
+	o.lastShutdownReasonHandler(rec, httptest.NewRequest("GET", "/v1/last_shutdown_reason", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"kind":"crash_loop"`)
+
+	// killed without recording a reason
+	o, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, options)
+	require.NoError(t, err)
+	reason = o.LastShutdownReason()
+	require.NotNil(t, reason)
+	assert.Equal(t, ShutdownUnknown, reason.Kind)
+	assert.False(t, reason.Clean)
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var DefaultOperationPriority = []string{BackupModuleName, SnapshotModuleName, VolumeSnapshotModuleName}
+
+// operationStagger keeps scheduled operations at least `window` apart from
+// each other. When two of them collide within the window, the one with the
+// lowest priority (highest index in `priority`) is deferred, whatever the
+// order they came in: a higher priority operation never waits behind a lower
+// priority one.
+type operationStagger struct {
+	window   time.Duration
+	priority []string
+
+	lock      sync.Mutex
+	lastFired map[string]time.Time
+	pending   map[string]bool
+}
+
+func newOperationStagger(window time.Duration, priority []string) *operationStagger {
+	if len(priority) == 0 {
+		priority = DefaultOperationPriority
+	}
+
+	return &operationStagger{
+		window:    window,
+		priority:  priority,
+		lastFired: make(map[string]time.Time),
+		pending:   make(map[string]bool),
+	}
+}
+
+// wait blocks until the operation `name` can fire without overlapping another one
+func (s *operationStagger) wait(name string, logger *zap.Logger) {
+	if s.window == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	s.pending[name] = true
+	s.lock.Unlock()
+
+	for {
+		s.lock.Lock()
+		delay, cause := s.delayFor(name, time.Now())
+		if delay == 0 {
+			delete(s.pending, name)
+			s.lastFired[name] = time.Now()
+			s.lock.Unlock()
+			return
+		}
+		s.lock.Unlock()
+
+		logger.Info("deferring scheduled operation to stagger it with another one",
+			zap.String("operation", name),
+			zap.String("because_of", cause),
+			zap.Duration("delay", delay),
+		)
+		time.Sleep(delay)
+	}
+}
+
+// delayFor assumes the lock is held
+func (s *operationStagger) delayFor(name string, now time.Time) (time.Duration, string) {
+	var delay time.Duration
+	var cause string
+	for other, firedAt := range s.lastFired {
+		if other == name || s.rank(other) > s.rank(name) {
+			continue
+		}
+
+		if elapsed := now.Sub(firedAt); elapsed < s.window && s.window-elapsed > delay {
+			delay = s.window - elapsed
+			cause = other
+		}
+	}
+	if delay != 0 {
+		return delay, cause
+	}
+
+	for other := range s.pending {
+		if other != name && s.rank(other) < s.rank(name) {
+			return time.Second, other
+		}
+	}
+
+	return 0, ""
+}
+
+func (s *operationStagger) rank(name string) int {
+	for i, n := range s.priority {
+		if n == name {
+			return i
+		}
+	}
+	return len(s.priority)
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestOperationStagger_DelayFor(t *testing.T) {
+	now := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		lastFired     map[string]time.Time
+		pending       []string
+		operation     string
+		expectedDelay time.Duration
+		expectedCause string
+	}{
+		{
+			name:      "nothing else fired",
+			operation: SnapshotModuleName,
+		},
+		{
+			name:          "another one just fired",
+			lastFired:     map[string]time.Time{BackupModuleName: now},
+			operation:     SnapshotModuleName,
+			expectedDelay: 10 * time.Minute,
+			expectedCause: BackupModuleName,
+		},
+		{
+			name:          "another one fired within the window",
+			lastFired:     map[string]time.Time{BackupModuleName: now.Add(-4 * time.Minute)},
+			operation:     SnapshotModuleName,
+			expectedDelay: 6 * time.Minute,
+			expectedCause: BackupModuleName,
+		},
+		{
+			name:      "another one fired exactly a window ago",
+			lastFired: map[string]time.Time{BackupModuleName: now.Add(-10 * time.Minute)},
+			operation: SnapshotModuleName,
+		},
+		{
+			name:      "another one fired before the window",
+			lastFired: map[string]time.Time{BackupModuleName: now.Add(-time.Hour)},
+			operation: SnapshotModuleName,
+		},
+		{
+			name:      "lower priority one fired within the window",
+			lastFired: map[string]time.Time{VolumeSnapshotModuleName: now},
+			operation: SnapshotModuleName,
+		},
+		{
+			name:      "itself fired within the window",
+			lastFired: map[string]time.Time{SnapshotModuleName: now},
+			operation: SnapshotModuleName,
+		},
+		{
+			name: "longest remaining delay wins",
+			lastFired: map[string]time.Time{
+				BackupModuleName:   now.Add(-8 * time.Minute),
+				SnapshotModuleName: now.Add(-time.Minute),
+			},
+			operation:     VolumeSnapshotModuleName,
+			expectedDelay: 9 * time.Minute,
+			expectedCause: SnapshotModuleName,
+		},
+		{
+			name:          "higher priority one pending",
+			pending:       []string{BackupModuleName},
+			operation:     SnapshotModuleName,
+			expectedDelay: time.Second,
+			expectedCause: BackupModuleName,
+		},
+		{
+			name:      "lower priority one pending",
+			pending:   []string{VolumeSnapshotModuleName},
+			operation: SnapshotModuleName,
+		},
+		{
+			name:          "unknown operation ranks last",
+			pending:       []string{VolumeSnapshotModuleName},
+			operation:     "custom",
+			expectedDelay: time.Second,
+			expectedCause: VolumeSnapshotModuleName,
+		},
+		{
+			name:      "unknown operation fired does not defer known ones",
+			lastFired: map[string]time.Time{"custom": now},
+			operation: VolumeSnapshotModuleName,
+		},
+		{
+			name:          "equal priority ones defer the latest",
+			lastFired:     map[string]time.Time{"custom": now.Add(-time.Minute)},
+			operation:     "other",
+			expectedDelay: 9 * time.Minute,
+			expectedCause: "custom",
+		},
+		{
+			name:          "fired ones take precedence over pending ones",
+			lastFired:     map[string]time.Time{SnapshotModuleName: now.Add(-5 * time.Minute)},
+			pending:       []string{BackupModuleName},
+			operation:     VolumeSnapshotModuleName,
+			expectedDelay: 5 * time.Minute,
+			expectedCause: SnapshotModuleName,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newOperationStagger(10*time.Minute, nil)
+			for name, firedAt := range test.lastFired {
+				s.lastFired[name] = firedAt
+			}
+			for _, name := range test.pending {
+				s.pending[name] = true
+			}
+			s.pending[test.operation] = true
+
+			delay, cause := s.delayFor(test.operation, now)
+			assert.Equal(t, test.expectedDelay, delay)
+			assert.Equal(t, test.expectedCause, cause)
+			assert.True(t, delay >= 0 && delay <= s.window)
+		})
+	}
+}
+
+func TestOperationStagger_Wait(t *testing.T) {
+	s := newOperationStagger(0, nil)
+	start := time.Now()
+	s.wait(BackupModuleName, zap.NewNop())
+	s.wait(SnapshotModuleName, zap.NewNop())
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "no window never defers")
+
+	window := 100 * time.Millisecond
+	s = newOperationStagger(window, []string{SnapshotModuleName, BackupModuleName})
+	start = time.Now()
+	s.wait(BackupModuleName, zap.NewNop())
+	s.wait(SnapshotModuleName, zap.NewNop())
+	elapsed := time.Since(start)
+	assert.True(t, elapsed < window/2, "higher priority operation deferred by %s", elapsed)
+
+	start = time.Now()
+	s.wait(BackupModuleName, zap.NewNop())
+	elapsed = time.Since(start)
+	assert.True(t, elapsed >= window-10*time.Millisecond, "lower priority operation deferred by %s", elapsed)
+	assert.True(t, elapsed < 2*window, "lower priority operation deferred by %s", elapsed)
+	assert.Empty(t, s.pending)
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// cleanStaleLocks removes the `*.lock` and `*.pid` files of `Options.StaleLocksDir`
+// that no live process owns anymore, left behind by a node that crashed and
+// that would prevent it from starting again.
+func (o *Operator) cleanStaleLocks() error {
+	if !o.options.CleanStaleLocks || o.options.StaleLocksDir == "" {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(o.options.StaleLocksDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to list %q for stale locks: %w", o.options.StaleLocksDir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !(strings.HasSuffix(entry.Name(), ".lock") || strings.HasSuffix(entry.Name(), ".pid")) {
+			continue
+		}
+
+		path := filepath.Join(o.options.StaleLocksDir, entry.Name())
+		stale, reason, err := isStaleLock(path)
+		if err != nil {
+			o.zlogger.Warn("unable to tell if lock file is stale, leaving it", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		if !stale {
+			o.zlogger.Info("lock file is held by a live process, leaving it", zap.String("path", path), zap.String("reason", reason))
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove stale lock file %q: %w", path, err)
+		}
+		o.zlogger.Warn("removed stale lock file", zap.String("path", path), zap.String("reason", reason))
+	}
+	return nil
+}
+
+// isStaleLock tells if no live process owns the lock file at `path`. A file
+// holding a pid is stale when that process does not exist, any other file is
+// stale when no process holds a lock on it.
+func isStaleLock(path string) (stale bool, reason string, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, "", err
+	}
+
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil && pid > 0 {
+		if processExists(pid) {
+			return false, fmt.Sprintf("process %d is running", pid), nil
+		}
+		return true, fmt.Sprintf("process %d does not exist", pid), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, "", err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return false, "file is locked", nil
+		}
+		return false, "", err
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return true, "file is not locked by any process", nil
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_CleanStaleLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stalelocks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	livePid := write("live.pid", fmt.Sprintf("%d\n", os.Getpid()))
+	deadPid := write("dead.pid", "999999999")
+	unlocked := write("unlocked.lock", "")
+	locked := write("locked.lock", "")
+	other := write("shared_memory.bin", "123")
+
+	f, err := os.Open(locked)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX))
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{CleanStaleLocks: true, StaleLocksDir: dir})
+	require.NoError(t, err)
+	require.NoError(t, o.cleanStaleLocks())
+
+	for path, exists := range map[string]bool{livePid: true, deadPid: false, unlocked: false, locked: true, other: true} {
+		_, err := os.Stat(path)
+		assert.Equal(t, exists, err == nil, path)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	nodeManager "github.com/dfuse-io/node-manager"
+	"go.uber.org/zap"
+)
+
+// StartupReportPrefix is where the startup reports are written in
+// `Options.BackupManifestStore`, one `<hostname>.json` per host
+const StartupReportPrefix = "startup_reports/"
+
+// StartupReport is what a node-manager booted with, see `UploadStartupReport`
+type StartupReport struct {
+	Hostname           string          `json:"hostname"`
+	Time               time.Time       `json:"time"`
+	NodeManagerVersion string          `json:"node_manager_version"`
+	ChainID            string          `json:"chain_id,omitempty"`
+	StandbyMode        bool            `json:"standby_mode"`
+	LastShutdownReason *ShutdownReason `json:"last_shutdown_reason,omitempty"`
+	Config             interface{}     `json:"config,omitempty"` // redacted, see `SetDescribeConfig`
+}
+
+// UploadStartupReport writes the startup report of this host to
+// `Options.BackupManifestStore`, replacing the one of its previous boot.
+func (o *Operator) UploadStartupReport(ctx context.Context) error {
+	if o.options.BackupManifestStore == nil {
+		return fmt.Errorf("uploading the startup report requires a backup manifest store")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to get hostname: %w", err)
+	}
+
+	report := &StartupReport{
+		Hostname:           hostname,
+		Time:               time.Now().UTC(),
+		NodeManagerVersion: nodeManager.Version,
+		ChainID:            o.options.ChainID,
+		StandbyMode:        o.options.StandbyMode,
+		LastShutdownReason: o.lastShutdownReason,
+	}
+
+	o.describeLock.Lock()
+	if o.describeConfig != nil {
+		report.Config = redactConfig(o.describeConfig)
+	}
+	o.describeLock.Unlock()
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	key := StartupReportPrefix + hostname + ".json"
+	if err := o.options.BackupManifestStore.WriteObject(ctx, key, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("unable to write startup report %q: %w", key, err)
+	}
+
+	o.zlogger.Info("uploaded startup report", zap.String("key", key))
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dfuse-io/dstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOperator_UploadStartupReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "startup_report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := dstore.NewStore("file://"+dir, "", "", false)
+	require.NoError(t, err)
+
+	o, err := New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{ChainID: "abcdef", BackupManifestStore: store})
+	require.NoError(t, err)
+	o.SetDescribeConfig(&struct {
+		HTTPAddr string
+		APIToken string
+	}{HTTPAddr: ":8080", APIToken: "abc123"})
+
+	// replaced on each boot
+	require.NoError(t, o.UploadStartupReport(context.Background()))
+	require.NoError(t, o.UploadStartupReport(context.Background()))
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(dir, StartupReportPrefix+hostname+".json"))
+	require.NoError(t, err)
+
+	report := &StartupReport{}
+	require.NoError(t, json.Unmarshal(data, report))
+	assert.Equal(t, hostname, report.Hostname)
+	assert.Equal(t, "abcdef", report.ChainID)
+	assert.Equal(t, map[string]interface{}{"HTTPAddr": ":8080", "APIToken": "REDACTED"}, report.Config)
+
+	o, err = New(zap.NewNop(), newTestSuperviser(), testReadiness{}, &Options{})
+	require.NoError(t, err)
+	assert.Error(t, o.UploadStartupReport(context.Background()))
+}
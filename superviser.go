@@ -53,6 +53,65 @@ type ChainSuperviser interface {
 	LastSeenBlockNum() uint64
 }
 
+// ChainIDChainSuperviser is implemented by supervisers able to tell which
+// chain the managed node belongs to, it may block until the node answers.
+type ChainIDChainSuperviser interface {
+	ChainID() (string, error)
+}
+
+// BlocksLogTrimmerChainSuperviser is implemented by supervisers able to drop
+// the blocks below `beforeBlockNum` from the node's local blocks log.
+type BlocksLogTrimmerChainSuperviser interface {
+	TrimBlocksLog(beforeBlockNum uint64) error
+}
+
+// BlocksLogRotatorChainSuperviser is implemented by supervisers able to
+// finalize the node's current blocks log segment and start a new one, it
+// returns the last block of the finalized segment.
+// The node is stopped while it is called.
+type BlocksLogRotatorChainSuperviser interface {
+	RotateBlocksLog() (lastBlockNum uint64, err error)
+}
+
+// LogPluginRemovalChainSuperviser is implemented by supervisers able to stop
+// feeding the node output to a registered log plugin.
+type LogPluginRemovalChainSuperviser interface {
+	UnregisterLogPlugin(plugin logplugin.LogPlugin)
+}
+
+// WedgedChainSuperviser is implemented by supervisers able to tell that the
+// node process survived being killed.
+type WedgedChainSuperviser interface {
+	IsWedged() bool
+}
+
+// BlocksLogVerifierChainSuperviser is implemented by supervisers able to check
+// that the node's local blocks log is contiguous (like `eosio-blocklog
+// --smoke-test` does), it returns the last block it holds.
+// The node is stopped while it is called.
+type BlocksLogVerifierChainSuperviser interface {
+	VerifyBlocksLog() (lastBlockNum uint64, err error)
+}
+
+// VersionedChainSuperviser is implemented by supervisers able to tell the
+// version of the managed node software.
+type VersionedChainSuperviser interface {
+	ChainVersion() (string, error)
+}
+
+// PeerCountChainSuperviser is implemented by supervisers tracking how many
+// peers the managed node is connected to, it must not block: it returns the
+// last known count, -1 if unknown.
+type PeerCountChainSuperviser interface {
+	ConnectedPeers() int
+}
+
+// PIDChainSuperviser is implemented by supervisers able to tell the process
+// id of the managed node, 0 when it is not running.
+type PIDChainSuperviser interface {
+	PID() int
+}
+
 type MonitorableChainSuperviser interface {
 	Monitor()
 }
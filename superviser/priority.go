@@ -0,0 +1,88 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package superviser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ShinyTrinkets/overseer"
+	"go.uber.org/zap"
+)
+
+// cgroupAssignTimeout bounds the wait for the process to be started before
+// it can be moved to its cgroup
+const cgroupAssignTimeout = 5 * time.Second
+
+// command returns the binary and arguments to launch, run through `nice`
+// when `Nice` is set. The process is launched as is, with a warning, when
+// `nice` is not available on this platform.
+func (s *Superviser) command() (string, []string) {
+	if s.Nice == 0 {
+		return s.Binary, s.Arguments
+	}
+
+	nice, err := exec.LookPath("nice")
+	if err != nil {
+		s.Logger.Warn("cannot find the nice command, launching node process with the default priority", zap.Int("nice", s.Nice), zap.Error(err))
+		return s.Binary, s.Arguments
+	}
+
+	s.Logger.Info("launching node process with a nice value", zap.Int("nice", s.Nice))
+	return nice, append([]string{"-n", strconv.Itoa(s.Nice), s.Binary}, s.Arguments...)
+}
+
+// assignCgroup moves the process of `cmd` to `CgroupPath` as soon as it is
+// started, problems are logged and the process left where it is.
+func (s *Superviser) assignCgroup(cmd *overseer.Cmd) {
+	if s.CgroupPath == "" {
+		return
+	}
+
+	deadline := time.After(cgroupAssignTimeout)
+	for {
+		if pid := cmd.Status().PID; pid != 0 {
+			if err := moveToCgroup(s.CgroupPath, pid); err != nil {
+				s.Logger.Warn("unable to move node process to its cgroup, leaving it in ours", zap.String("cgroup", s.CgroupPath), zap.Int("pid", pid), zap.Error(err))
+				return
+			}
+			s.Logger.Info("moved node process to its cgroup", zap.String("cgroup", s.CgroupPath), zap.Int("pid", pid))
+			return
+		}
+
+		select {
+		case <-cmd.Done():
+			return
+		case <-deadline:
+			s.Logger.Warn("node process did not start in time to be moved to its cgroup", zap.String("cgroup", s.CgroupPath))
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// moveToCgroup moves every thread of process `pid` to the cgroup at `path`,
+// like `/sys/fs/cgroup/nodeos` (cgroup v2) or `/sys/fs/cgroup/cpu/nodeos` (v1).
+func moveToCgroup(path string, pid int) error {
+	procs := filepath.Join(path, "cgroup.procs")
+	if err := ioutil.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("unable to write to %q: %w", procs, err)
+	}
+	return nil
+}
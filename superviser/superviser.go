@@ -18,24 +18,54 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ShinyTrinkets/overseer"
 	nodeManager "github.com/dfuse-io/node-manager"
 	logplugin "github.com/dfuse-io/node-manager/log_plugin"
+	"github.com/dfuse-io/node-manager/metrics"
 	"github.com/dfuse-io/shutter"
 	"go.uber.org/zap"
 )
 
+// outputDrainTimeout bounds how long `Stop` waits for the output of the
+// stopped process to reach the log plugins
+var outputDrainTimeout = 30 * time.Second
+
+// forceKillGracePeriod is how long a node process sent SIGKILL has to disappear
+// before it is considered wedged
+const forceKillGracePeriod = 5 * time.Second
+
 type Superviser struct {
 	*shutter.Shutter
 	Binary    string
 	Arguments []string
 	Logger    *zap.Logger
 
+	// Nice is the niceness the node process is launched with through `nice`, like 10 to keep
+	// it from starving us (and our backups) of CPU, 0 launches it as is
+	Nice int
+	// CgroupPath is a cgroup directory (like `/sys/fs/cgroup/nodeos`) the node process is moved
+	// to once started, to bound its resources, empty leaves it in ours
+	CgroupPath string
+	// StopSignal is sent to the node process to stop it, 0 sends SIGTERM
+	StopSignal syscall.Signal
+	// StopGracePeriod is how long the node process has to exit once signaled before it is
+	// sent SIGKILL, 0 waits for it forever
+	StopGracePeriod time.Duration
+
 	cmd     *overseer.Cmd
 	cmdLock sync.Mutex
 
+	// outputDrained is closed once the read loop of `cmd` fed all of its output to the
+	// log plugins, the read loop of the next command waits on it so a restart never
+	// interleaves the output of two processes
+	outputDrained chan struct{}
+	starts        int
+
+	killedCmd *overseer.Cmd // last command sent SIGKILL, see `IsWedged`
+
 	logPlugins     []logplugin.LogPlugin
 	logPluginsLock sync.RWMutex
 
@@ -83,6 +113,21 @@ func (s *Superviser) RegisterLogPlugin(plugin logplugin.LogPlugin) {
 	s.Logger.Info("registered log plugin", zap.Int("plugin count", len(s.logPlugins)))
 }
 
+// UnregisterLogPlugin stops feeding `plugin`, it is not stopped by the
+// superviser anymore either.
+func (s *Superviser) UnregisterLogPlugin(plugin logplugin.LogPlugin) {
+	s.logPluginsLock.Lock()
+	defer s.logPluginsLock.Unlock()
+
+	for i, registered := range s.logPlugins {
+		if registered == plugin {
+			s.logPlugins = append(s.logPlugins[:i:i], s.logPlugins[i+1:]...)
+			s.Logger.Info("unregistered log plugin", zap.String("plugin_name", plugin.Name()), zap.Int("plugin count", len(s.logPlugins)))
+			return
+		}
+	}
+}
+
 func (s *Superviser) GetLogPlugins() []logplugin.LogPlugin {
 	s.logPluginsLock.RLock()
 	defer s.logPluginsLock.RUnlock()
@@ -158,14 +203,15 @@ func (s *Superviser) Start(options ...nodeManager.StartOption) error {
 	}
 
 	s.Logger.Info("creating new command instance and launch read loop", zap.String("binary", s.Binary), zap.Strings("arguments", s.Arguments))
-	var args []interface{}
-	for _, a := range s.Arguments {
-		args = append(args, a)
-	}
+	binary, arguments := s.command()
+	s.cmd = overseer.NewCmd(binary, arguments, overseer.Options{Streaming: true})
 
-	s.cmd = overseer.NewCmd(s.Binary, s.Arguments, overseer.Options{Streaming: true})
+	previousDrained := s.outputDrained
+	s.outputDrained = make(chan struct{})
+	s.starts++
 
-	go s.start(s.cmd)
+	go s.start(s.cmd, s.starts, previousDrained, s.outputDrained)
+	go s.assignCgroup(s.cmd)
 
 	return nil
 }
@@ -182,8 +228,13 @@ func (s *Superviser) Stop() error {
 	}
 
 	if s.cmd.State == overseer.STARTING || s.cmd.State == overseer.RUNNING {
-		s.Logger.Info("stopping underlying process")
-		err := s.cmd.Stop()
+		s.Logger.Info("stopping underlying process", zap.Stringer("signal", s.stopSignal()))
+		var err error
+		if s.stopSignal() == syscall.SIGTERM {
+			err = s.cmd.Stop()
+		} else {
+			err = s.cmd.Signal(s.stopSignal())
+		}
 		if err != nil {
 			s.Logger.Error("failed to stop overseer cmd", zap.Error(err))
 			return err
@@ -192,13 +243,9 @@ func (s *Superviser) Stop() error {
 
 	// Blocks until command finished completely
 	s.Logger.Debug("blocking until command actually ends")
-nodeProcessDone:
-	for {
-		select {
-		case <-s.cmd.Done():
-			break nodeProcessDone
-		case <-time.After(500 * time.Millisecond):
-			s.Logger.Debug("still blocking until command actually ends")
+	if !s.waitForCommandEnd(s.StopGracePeriod) {
+		if err := s.forceKill(); err != nil {
+			return err
 		}
 	}
 
@@ -206,15 +253,19 @@ nodeProcessDone:
 	s.cmd = nil
 
 	s.Logger.Info("waiting for std out and err to drain")
-	sleepTime := time.Duration(0)
+	deadline := time.After(outputDrainTimeout)
+outputDrained:
 	for {
-		time.Sleep(sleepTime)
-		sleepTime = 500 * time.Millisecond
-		if s.isBufferEmpty() {
-			s.Logger.Info("buffer is empty. done waiting")
-			break
+		select {
+		case <-s.outputDrained:
+			break outputDrained
+		case <-deadline:
+			// A log plugin is not consuming anymore, we cannot hold the stop on it
+			s.Logger.Warn("std out and err did not drain in time, continuing without them", zap.Duration("timeout", outputDrainTimeout))
+			return nil
+		case <-time.After(500 * time.Millisecond):
+			s.Logger.Debug("still draining std out and err")
 		}
-		s.Logger.Debug("draining std out and err", zap.Int("stdout_len", len(s.cmd.Stdout)), zap.Int("stderr_len", len(s.cmd.Stderr)))
 	}
 
 	s.Logger.Info("std out and err are now drain")
@@ -222,6 +273,70 @@ nodeProcessDone:
 	return nil
 }
 
+func (s *Superviser) stopSignal() syscall.Signal {
+	if s.StopSignal == 0 {
+		return syscall.SIGTERM
+	}
+	return s.StopSignal
+}
+
+// waitForCommandEnd tells if the command ended within `timeout`, 0 waits forever
+func (s *Superviser) waitForCommandEnd(timeout time.Duration) bool {
+	var deadline <-chan time.Time
+	if timeout != 0 {
+		deadline = time.After(timeout)
+	}
+
+	for {
+		select {
+		case <-s.cmd.Done():
+			return true
+		case <-deadline:
+			return false
+		case <-time.After(500 * time.Millisecond):
+			s.Logger.Debug("still blocking until command actually ends")
+		}
+	}
+}
+
+// forceKill escalates to SIGKILL a stop the node process ignored, it fails
+// if the node process survives it: it is wedged and remains so until it exits.
+func (s *Superviser) forceKill() error {
+	pid := s.cmd.Status().PID
+	s.Logger.Warn("node process did not exit within its stop grace period, sending SIGKILL", zap.Duration("stop_grace_period", s.StopGracePeriod), zap.Int("pid", pid))
+	metrics.ForceKills.Inc()
+	s.killedCmd = s.cmd
+
+	if err := s.cmd.Signal(syscall.SIGKILL); err != nil {
+		s.Logger.Error("failed to send SIGKILL to node process", zap.Int("pid", pid), zap.Error(err))
+		return err
+	}
+
+	if !s.waitForCommandEnd(forceKillGracePeriod) {
+		s.Logger.Error("node process is wedged, it survived SIGKILL", zap.Int("pid", pid), zap.Duration("waited", forceKillGracePeriod))
+		return fmt.Errorf("node process %d is wedged, still there %s after SIGKILL", pid, forceKillGracePeriod)
+	}
+	return nil
+}
+
+// IsWedged tells if the node process survived SIGKILL, see `StopGracePeriod`
+func (s *Superviser) IsWedged() bool {
+	s.cmdLock.Lock()
+	killed := s.killedCmd
+	s.cmdLock.Unlock()
+
+	if killed == nil {
+		return false
+	}
+
+	select {
+	case <-killed.Done():
+		return false
+	default:
+		return true
+	}
+}
+
 func (s *Superviser) IsRunning() bool {
 	s.cmdLock.Lock()
 	defer s.cmdLock.Unlock()
@@ -229,6 +344,17 @@ func (s *Superviser) IsRunning() bool {
 	return s.isRunning()
 }
 
+// PID returns the process id of the node, 0 when it is not running.
+func (s *Superviser) PID() int {
+	s.cmdLock.Lock()
+	defer s.cmdLock.Unlock()
+
+	if !s.isRunning() {
+		return 0
+	}
+	return s.cmd.Status().PID
+}
+
 // This one assuming the lock is properly held already
 func (s *Superviser) isRunning() bool {
 	if s.cmd == nil {
@@ -239,16 +365,31 @@ func (s *Superviser) isRunning() bool {
 	return s.cmd.State == overseer.STARTING || s.cmd.State == overseer.RUNNING || s.cmd.State == overseer.STOPPING
 }
 
-func (s *Superviser) isBufferEmpty() bool {
-	if s.cmd == nil {
-		return true
-	}
-	return len(s.cmd.Stdout) == 0 && len(s.cmd.Stderr) == 0
+func isBufferEmpty(cmd *overseer.Cmd) bool {
+	return len(cmd.Stdout) == 0 && len(cmd.Stderr) == 0
 }
 
-func (s *Superviser) start(cmd *overseer.Cmd) {
+// start launches `cmd` and feeds its output to the log plugins, only once the
+// previous command's output (`previousDrained`) was fully fed to them.
+//
+// The output of `cmd` is buffered by overseer in the meantime. A trailing
+// partial line is still held back by overseer when the process dies, it has
+// no way to flush it to us.
+func (s *Superviser) start(cmd *overseer.Cmd, startCount int, previousDrained <-chan struct{}, drained chan struct{}) {
+	defer close(drained)
+
 	statusChan := cmd.Start()
 
+	if previousDrained != nil {
+		select {
+		case <-previousDrained:
+		default:
+			s.Logger.Info("waiting for previous node process output to drain before reading the new one")
+			<-previousDrained
+		}
+		s.Logger.Info("re-wired node process output to log plugins", zap.Int("start_count", startCount))
+	}
+
 	processTerminated := false
 	for {
 		select {
@@ -266,8 +407,8 @@ func (s *Superviser) start(cmd *overseer.Cmd) {
 			s.processLogLine(line)
 		}
 		if processTerminated {
-			s.Logger.Info("node process terminated", zap.Bool("buffer_empty", s.isBufferEmpty()))
-			if s.isBufferEmpty() {
+			s.Logger.Info("node process terminated", zap.Bool("buffer_empty", isBufferEmpty(cmd)))
+			if isBufferEmpty(cmd) {
 				return
 			}
 		}
@@ -15,13 +15,19 @@
 package superviser
 
 import (
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dfuse-io/logging"
 	logplugin "github.com/dfuse-io/node-manager/log_plugin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -107,6 +113,107 @@ func TestSuperviser_CapturesStdoutCorrectly(t *testing.T) {
 	assert.Equal(t, []string{"first", "second"}, lines)
 }
 
+func TestSuperviser_LaunchesWithNice(t *testing.T) {
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice is not available")
+	}
+
+	superviser := testSuperviserSh("nice; sleep 1")
+	superviser.Nice = 7
+	defer superviser.Stop()
+
+	lineChan := make(chan string)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		lineChan <- line
+	}))
+
+	go superviser.Start()
+	waitForSuperviserTaskCompletion(superviser)
+
+	niceness, err := strconv.Atoi(waitForOutput(t, lineChan, waitDefaultTimeout))
+	require.NoError(t, err)
+	assert.True(t, niceness >= 7, "niceness %d", niceness) // unless already running niced
+}
+
+func TestSuperviser_RestartKeepsOutputContinuous(t *testing.T) {
+	dir, err := ioutil.TempDir("", "superviser")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	counterFile := filepath.Join(dir, "head")
+
+	// Each run "produces" the next 200 blocks then crashes, like a node would
+	superviser := testSuperviserSh(`
+		n=$(cat ` + counterFile + ` 2>/dev/null || echo 0)
+		end=$((n + 200))
+		while [ $n -lt $end ]; do
+			n=$((n + 1))
+			echo $n
+		done
+		echo $n > ` + counterFile + `
+		exit 1
+	`)
+	defer superviser.Stop()
+
+	var lock sync.Mutex
+	var blocks []int
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		// A slow consumer, the first process is long gone before its output is
+		time.Sleep(100 * time.Microsecond)
+
+		num, err := strconv.Atoi(line)
+		require.NoError(t, err)
+
+		lock.Lock()
+		blocks = append(blocks, num)
+		lock.Unlock()
+	}))
+
+	require.NoError(t, superviser.Start())
+	select {
+	case <-superviser.Stopped():
+	case <-time.After(5 * time.Second):
+		t.Fatal("node process did not stop")
+	}
+	require.NoError(t, superviser.Start())
+
+	received := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(blocks)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for received() < 400 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, blocks, 400)
+	for i, num := range blocks {
+		require.Equal(t, i+1, num, "block at index %d", i)
+	}
+}
+
+func TestSuperviser_StopEscalatesToSIGKILL(t *testing.T) {
+	superviser := testSuperviserSh(`trap '' TERM; echo ready; while true; do sleep 0.1; done`)
+	superviser.StopGracePeriod = 200 * time.Millisecond
+	defer superviser.Stop()
+
+	lineChan := make(chan string, 10)
+	superviser.RegisterLogPlugin(logplugin.LogPluginFunc(func(line string) {
+		lineChan <- line
+	}))
+
+	require.NoError(t, superviser.Start())
+	waitForOutput(t, lineChan, waitDefaultTimeout)
+
+	start := time.Now()
+	require.NoError(t, superviser.Stop())
+	assert.True(t, time.Since(start) < forceKillGracePeriod, "stop took %s", time.Since(start))
+	assert.False(t, superviser.IsRunning())
+	assert.False(t, superviser.IsWedged())
+}
+
 func testSuperviserBash(script string) *Superviser {
 	return New(zlog, "bash", []string{"-c", script})
 }
@@ -0,0 +1,91 @@
+// Copyright 2019 dfuse Platform Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node_manager
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// upstreamFailures tracks the failed attempts of the connection watchdog to
+// reach the upstream node, to summarize them in the logs
+type upstreamFailures struct {
+	logInterval time.Duration
+
+	attempts     int // since the upstream node got disconnected
+	lastError    string
+	lastLoggedAt time.Time
+	notLogged    int // attempts since `lastLoggedAt`
+}
+
+// SetWatchdogLogInterval makes the failures reported with
+// `ReportUpstreamFailure` logged at most once per `interval`, as a summary,
+// while they keep failing the same way. 0 logs each of them.
+func (m *MetricsAndReadinessManager) SetWatchdogLogInterval(interval time.Duration) {
+	m.upstreamLock.Lock()
+	defer m.upstreamLock.Unlock()
+
+	m.upstreamFailures.logInterval = interval
+}
+
+// ReportUpstreamFailure is called by the connection watchdog on each failed
+// attempt to reach the upstream node, which is then disconnected (see
+// `SetUpstreamConnected`). The first failure, and any failing differently
+// than the previous one, is logged in full, the others are summarized every
+// `SetWatchdogLogInterval`.
+func (m *MetricsAndReadinessManager) ReportUpstreamFailure(err error) {
+	m.upstreamLock.Lock()
+	defer m.upstreamLock.Unlock()
+
+	now := time.Now()
+	if m.upstreamDisconnectedAt.IsZero() {
+		m.upstreamDisconnectedAt = now
+	}
+
+	f := &m.upstreamFailures
+	f.attempts++
+	f.notLogged++
+	message := err.Error()
+	repeated := message == f.lastError
+	f.lastError = message
+
+	switch {
+	case !repeated || f.logInterval == 0:
+		m.logger.Warn("connection watchdog unable to reach upstream node", zap.Int("attempt", f.attempts), zap.Error(err))
+	case now.Sub(f.lastLoggedAt) >= f.logInterval:
+		m.logger.Warn("connection watchdog still unable to reach upstream node",
+			zap.Int("attempts", f.notLogged),
+			zap.Duration("in_last", now.Sub(f.lastLoggedAt)),
+			zap.Duration("disconnected_for", now.Sub(m.upstreamDisconnectedAt)),
+			zap.Error(err),
+		)
+	default:
+		return
+	}
+	f.lastLoggedAt = now
+	f.notLogged = 0
+}
+
+// logUpstreamRecovered must be called with `upstreamLock` held
+func (m *MetricsAndReadinessManager) logUpstreamRecovered() {
+	f := &m.upstreamFailures
+	if f.attempts == 0 {
+		return
+	}
+
+	m.logger.Info("connection watchdog reached upstream node again", zap.Int("failed_attempts", f.attempts), zap.Duration("disconnected_for", time.Since(m.upstreamDisconnectedAt)))
+	m.upstreamFailures = upstreamFailures{logInterval: f.logInterval}
+}